@@ -0,0 +1,239 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultCacheBudget is the memory the asset cache may use unless configured otherwise
+const defaultCacheBudget = 256 << 20
+
+// cacheEntryOverhead approximates the memory an asset takes beyond its keys and
+// values: the map itself, its list element and the cache's bookkeeping
+const cacheEntryOverhead = 256
+
+// cacheValueOverhead approximates the memory of one map entry beyond the bytes
+// of its column name and value
+const cacheValueOverhead = 48
+
+// CacheStats describes the asset cache
+type CacheStats struct {
+	Entries      int     `json:"entries"`       // Assets held in memory
+	DirtyEntries int     `json:"dirty_entries"` // Assets changed in memory and not yet written
+	Bytes        int64   `json:"bytes"`         // Estimated memory used by the cached assets
+	BudgetBytes  int64   `json:"budget_bytes"`  // Memory the cache may use
+	Hits         int64   `json:"hits"`          // Lookups answered from memory
+	Misses       int64   `json:"misses"`        // Lookups that had to read the asset file
+	HitRate      float64 `json:"hit_rate"`      // Hits as a fraction of all lookups
+	Evictions    int64   `json:"evictions"`     // Assets dropped to stay within the budget
+	Writes       int64   `json:"writes"`        // Asset files written, on eviction or flush
+	Flushes      int64   `json:"flushes"`       // Times every changed asset was written
+}
+
+// cacheEntry is an asset held in memory
+type cacheEntry struct {
+	id    string
+	asset map[string]string
+	size  int64
+	dirty bool
+}
+
+// cacheShard is the part of the cache holding the assets of one asset lock.
+// Its own mutex guards the list and map, so readers holding the asset lock
+// only for reading can still record their use of an entry.
+type cacheShard struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used first
+	bytes   int64
+}
+
+// AssetCache is a write-back LRU cache of assets. It is split into one shard per
+// asset lock, each with an equal part of the memory budget, and every change to
+// a shard's assets is made while holding their asset lock, so evicted assets can
+// be written without another goroutine writing the same file.
+type AssetCache struct {
+	shards [assetLockCount]cacheShard
+	budget atomic.Int64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+	writes    atomic.Int64
+	flushes   atomic.Int64
+}
+
+// NewAssetCache creates an empty cache that may use budget bytes
+func NewAssetCache(budget int64) *AssetCache {
+	cache := &AssetCache{}
+	cache.budget.Store(budget)
+	for i := range cache.shards {
+		cache.shards[i].entries = make(map[string]*list.Element)
+		cache.shards[i].lru = list.New()
+	}
+	return cache
+}
+
+// SetBudget changes the memory the cache may use. Shards over their new share
+// shrink the next time an asset is added to them.
+func (c *AssetCache) SetBudget(budget int64) {
+	c.budget.Store(budget)
+}
+
+// assetSize estimates the memory used by an asset
+func assetSize(asset map[string]string) int64 {
+	size := int64(cacheEntryOverhead)
+	for column, value := range asset {
+		size += int64(len(column) + len(value) + cacheValueOverhead)
+	}
+	return size
+}
+
+// get returns the cached asset of an ID and records the lookup. The asset is
+// the cached map itself: the caller must hold the asset's lock, and only a
+// caller holding it for writing may change the map, then put it back.
+func (c *AssetCache) get(id string) (map[string]string, bool) {
+	shard := &c.shards[assetStripe(id)]
+	shard.Lock()
+	defer shard.Unlock()
+
+	element, exists := shard.entries[id]
+	if !exists {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	shard.lru.MoveToFront(element)
+	return element.Value.(*cacheEntry).asset, true
+}
+
+// put stores an asset, marking it dirty if it differs from its file. Clean assets
+// are evicted to stay within the budget; the dirty assets that must go too are
+// returned, still cached, and the caller must write each and then evict it before
+// releasing the asset lock. A clean put never clears the dirty mark of an asset
+// changed earlier.
+func (c *AssetCache) put(id string, asset map[string]string, dirty bool) []*cacheEntry {
+	shard := &c.shards[assetStripe(id)]
+	shard.Lock()
+	defer shard.Unlock()
+
+	size := assetSize(asset)
+	if element, exists := shard.entries[id]; exists {
+		entry := element.Value.(*cacheEntry)
+		shard.bytes += size - entry.size
+		entry.asset = asset
+		entry.size = size
+		entry.dirty = entry.dirty || dirty
+		shard.lru.MoveToFront(element)
+	} else {
+		entry := &cacheEntry{id: id, asset: asset, size: size, dirty: dirty}
+		shard.entries[id] = shard.lru.PushFront(entry)
+		shard.bytes += size
+	}
+
+	// Evict the least recently used assets, possibly including this one. Dirty
+	// assets stay cached until they are written, so a failed write loses nothing.
+	var evicted []*cacheEntry
+	limit := c.budget.Load() / assetLockCount
+	pending := int64(0)
+	for element := shard.lru.Back(); element != nil && shard.bytes-pending > limit; {
+		entry := element.Value.(*cacheEntry)
+		previous := element.Prev()
+		if entry.dirty {
+			evicted = append(evicted, entry)
+			pending += entry.size
+		} else {
+			shard.lru.Remove(element)
+			delete(shard.entries, entry.id)
+			shard.bytes -= entry.size
+			c.evictions.Add(1)
+		}
+		element = previous
+	}
+	return evicted
+}
+
+// evict drops an asset returned by put once it has been written. An asset
+// changed again since then is kept.
+func (c *AssetCache) evict(entry *cacheEntry) {
+	shard := &c.shards[assetStripe(entry.id)]
+	shard.Lock()
+	defer shard.Unlock()
+
+	element, exists := shard.entries[entry.id]
+	if !exists || element.Value.(*cacheEntry) != entry || entry.dirty {
+		return
+	}
+	shard.lru.Remove(element)
+	delete(shard.entries, entry.id)
+	shard.bytes -= entry.size
+	c.evictions.Add(1)
+}
+
+// remove drops an asset from the cache without writing it
+func (c *AssetCache) remove(id string) {
+	shard := &c.shards[assetStripe(id)]
+	shard.Lock()
+	defer shard.Unlock()
+
+	if element, exists := shard.entries[id]; exists {
+		entry := shard.lru.Remove(element).(*cacheEntry)
+		delete(shard.entries, id)
+		shard.bytes -= entry.size
+	}
+}
+
+// dirtyEntries returns the dirty assets of a shard. The caller must hold the
+// shard's asset lock: for reading to copy them, or for writing to write them
+// and then call markClean.
+func (c *AssetCache) dirtyEntries(stripe int) []*cacheEntry {
+	shard := &c.shards[stripe]
+	shard.Lock()
+	defer shard.Unlock()
+
+	var dirty []*cacheEntry
+	for element := shard.lru.Front(); element != nil; element = element.Next() {
+		if entry := element.Value.(*cacheEntry); entry.dirty {
+			dirty = append(dirty, entry)
+		}
+	}
+	return dirty
+}
+
+// markClean records that an asset was written
+func (c *AssetCache) markClean(entry *cacheEntry) {
+	shard := &c.shards[assetStripe(entry.id)]
+	shard.Lock()
+	entry.dirty = false
+	shard.Unlock()
+	c.writes.Add(1)
+}
+
+// Stats returns the size of the cache and how well it has been working
+func (c *AssetCache) Stats() CacheStats {
+	stats := CacheStats{
+		BudgetBytes: c.budget.Load(),
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Evictions:   c.evictions.Load(),
+		Writes:      c.writes.Load(),
+		Flushes:     c.flushes.Load(),
+	}
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.Lock()
+		stats.Entries += len(shard.entries)
+		stats.Bytes += shard.bytes
+		for element := shard.lru.Front(); element != nil; element = element.Next() {
+			if element.Value.(*cacheEntry).dirty {
+				stats.DirtyEntries++
+			}
+		}
+		shard.Unlock()
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAssetCachePutEvictsCleanAssetsOnly(t *testing.T) {
+	small := map[string]string{"PX_LAST": "1"}
+	budget := assetSize(small) * assetLockCount
+
+	tests := []struct {
+		name        string
+		dirty       []bool // Dirty flag of each asset put, in order
+		wantEvicted []int  // Assets returned to be written, by position
+		wantCached  []int  // Assets still cached afterwards, by position
+	}{
+		{name: "clean assets are dropped", dirty: []bool{false, false}, wantCached: []int{1}},
+		{name: "dirty assets are returned and kept", dirty: []bool{true, false}, wantEvicted: []int{0}, wantCached: []int{0, 1}},
+		{name: "only as many as needed", dirty: []bool{true, true, false}, wantEvicted: []int{0, 1}, wantCached: []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewAssetCache(budget)
+			// IDs in one stripe share a shard and its part of the budget
+			ids := sameStripeIDs(len(tt.dirty))
+			var evicted []*cacheEntry
+			for i, id := range ids {
+				evicted = cache.put(id, map[string]string{"PX_LAST": "1"}, tt.dirty[i])
+			}
+
+			if len(evicted) != len(tt.wantEvicted) {
+				t.Fatalf("put returned %d assets to write, want %d", len(evicted), len(tt.wantEvicted))
+			}
+			for i, position := range tt.wantEvicted {
+				if evicted[i].id != ids[position] {
+					t.Errorf("asset %d to write is %s, want %s", i, evicted[i].id, ids[position])
+				}
+			}
+			for i, id := range ids {
+				shard := &cache.shards[assetStripe(id)]
+				_, cached := shard.entries[id]
+				if want := containsInt(tt.wantCached, i); cached != want {
+					t.Errorf("asset %s cached = %v, want %v", id, cached, want)
+				}
+			}
+
+			// Once written, evicted assets leave the cache
+			for _, entry := range evicted {
+				cache.markClean(entry)
+				cache.evict(entry)
+				if _, cached := cache.shards[assetStripe(entry.id)].entries[entry.id]; cached {
+					t.Errorf("asset %s still cached after it was written and evicted", entry.id)
+				}
+			}
+		})
+	}
+}
+
+func TestAssetCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	small := map[string]string{"PX_LAST": "1"}
+	// Room for two assets in each shard
+	budget := 2 * assetSize(small) * assetLockCount
+
+	tests := []struct {
+		name       string
+		read       int // Asset read after the first two are put, -1 for none
+		wantCached []int
+	}{
+		{name: "oldest put is evicted", read: -1, wantCached: []int{1, 2}},
+		{name: "reading an asset keeps it", read: 0, wantCached: []int{0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewAssetCache(budget)
+			ids := sameStripeIDs(3)
+			cache.put(ids[0], map[string]string{"PX_LAST": "1"}, false)
+			cache.put(ids[1], map[string]string{"PX_LAST": "1"}, false)
+			if tt.read >= 0 {
+				cache.get(ids[tt.read])
+			}
+			cache.put(ids[2], map[string]string{"PX_LAST": "1"}, false)
+
+			for i, id := range ids {
+				_, cached := cache.shards[assetStripe(id)].entries[id]
+				if want := containsInt(tt.wantCached, i); cached != want {
+					t.Errorf("asset %s cached = %v, want %v", id, cached, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAssetCacheKeepsAssetChangedBeforeEviction(t *testing.T) {
+	cache := NewAssetCache(1)
+	evicted := cache.put("A1", map[string]string{"PX_LAST": "1"}, true)
+	if len(evicted) != 1 {
+		t.Fatalf("put returned %d assets to write, want 1", len(evicted))
+	}
+	// The asset changes again while the evicted version is written
+	cache.markClean(evicted[0])
+	cache.put("A1", map[string]string{"PX_LAST": "2"}, true)
+	cache.evict(evicted[0])
+
+	asset, cached := cache.get("A1")
+	if !cached || asset["PX_LAST"] != "2" {
+		t.Errorf("get(A1) = %v, %v, want the changed asset still cached", asset, cached)
+	}
+}
+
+// sameStripeIDs returns n asset IDs guarded by the same asset lock
+func sameStripeIDs(n int) []string {
+	var ids []string
+	stripe := assetStripe("A0")
+	for i := 0; len(ids) < n; i++ {
+		id := "A" + strconv.Itoa(i)
+		if assetStripe(id) == stripe {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of change a column can have between two snapshots
+const (
+	ChangeAdded   = "added"   // The column has a value only in the later snapshot
+	ChangeRemoved = "removed" // The column has a value only in the earlier snapshot
+	ChangeChanged = "changed" // The column has different values in the two snapshots
+)
+
+// ErrUnknownLoadRun is returned when a diff names a load run that never loaded anything
+var ErrUnknownLoadRun = errors.New("unknown load run")
+
+// CellChange is a column of an asset whose value differs between two snapshots
+type CellChange struct {
+	Column string
+	Type   string        // ChangeAdded, ChangeRemoved or ChangeChanged
+	Old    *ValueVersion // Value in the earlier snapshot, nil if added
+	New    *ValueVersion // Value in the later snapshot, nil if removed
+}
+
+// AssetDiff lists the changed columns of one asset, sorted by column
+type AssetDiff struct {
+	ID      string
+	Changes []CellChange
+}
+
+// DiffFilter limits a diff to some assets and columns. Unset fields match everything.
+type DiffFilter struct {
+	IDs       []string       // Only these assets, if not nil
+	IDPattern *regexp.Regexp // Only assets whose ID matches
+	Columns   []string       // Only these columns
+}
+
+// StreamDiff compares the store at two snapshot points and calls fn for every
+// asset with at least one changed column. Assets are compared one at a time, so
+// a diff of any size needs no more memory than its largest asset. It stops at
+// the first error fn returns or the first asset whose files cannot be read.
+func (j *JSONAssetManager) StreamDiff(from, to SnapshotPoint, filter DiffFilter, fn func(AssetDiff) error) error {
+	return j.streamDiff(from, to, filter, &QueryStats{}, fn)
+}
+
+// streamDiff implements StreamDiff, counting the files it reads in stats
+func (j *JSONAssetManager) streamDiff(from, to SnapshotPoint, filter DiffFilter, stats *QueryStats, fn func(AssetDiff) error) error {
+	for _, point := range []SnapshotPoint{from, to} {
+		if point.LoadRun != "" && !j.index.HasLoadRun(point.LoadRun) {
+			return fmt.Errorf("%w %s", ErrUnknownLoadRun, point.LoadRun)
+		}
+	}
+
+	var columns map[string]bool
+	if len(filter.Columns) > 0 {
+		columns = make(map[string]bool, len(filter.Columns))
+		for _, column := range filter.Columns {
+			columns[column] = true
+		}
+	}
+
+	// No version of an asset is newer than its latest index date, so between two
+	// dates only assets with a version after the earlier one can have changed
+	earliest := ""
+	if from.Date != "" && to.Date != "" {
+		earliest = min(from.Date, to.Date)
+	}
+
+	visit := func(id string) error {
+		if filter.IDPattern != nil && !filter.IDPattern.MatchString(id) {
+			return nil
+		}
+		if earliest != "" && j.index.LatestDate(id) <= earliest {
+			return nil
+		}
+
+		// An asset that cannot be read stops the diff, as leaving it out would
+		// report it as unchanged
+		changes, err := j.diffAsset(id, from, to, columns, stats)
+		if err != nil {
+			return fmt.Errorf("error comparing asset %s: %v", id, err)
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return fn(AssetDiff{ID: id, Changes: changes})
+	}
+
+	if filter.IDs != nil {
+		for _, id := range filter.IDs {
+			if j.checkAssetID(id) != nil {
+				continue
+			}
+			if err := visit(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Asset files are named after their IDs, so the walk needs to read nothing
+	// but the assets that may have changed
+	mark := time.Now()
+	return filepath.Walk(j.jsonDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		mark = stats.timeStage("walk", mark)
+		err = visit(strings.TrimSuffix(filepath.Base(path), ".json"))
+		mark = time.Now()
+		return err
+	})
+}
+
+// diffAsset compares one asset at two snapshot points, limited to some columns if
+// columns is not nil
+func (j *JSONAssetManager) diffAsset(id string, from, to SnapshotPoint, columns map[string]bool, stats *QueryStats) ([]CellChange, error) {
+	mark := time.Now()
+	lock := j.assetLock(id)
+	lock.RLock()
+	records, err := j.readHistory(id)
+	lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only an asset without history needs its current values
+	var current map[string]string
+	if records == nil {
+		lock.RLock()
+		asset, size, err := j.readAsset(id)
+		lock.RUnlock()
+		if errors.Is(err, ErrAssetNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		current = asset
+		stats.BytesParsed += int64(size)
+	}
+	stats.FilesRead++
+	mark = stats.timeStage("read", mark)
+
+	oldValues, oldLineage := j.snapshotOf(id, records, current, from)
+	newValues, newLineage := j.snapshotOf(id, records, current, to)
+
+	var changes []CellChange
+	for column, newValue := range newValues {
+		if columns != nil && !columns[column] {
+			continue
+		}
+		newVersion := &ValueVersion{Value: newValue, CellLineage: newLineage[column]}
+		oldValue, existed := oldValues[column]
+		switch {
+		case !existed:
+			changes = append(changes, CellChange{Column: column, Type: ChangeAdded, New: newVersion})
+		case oldValue != newValue:
+			oldVersion := &ValueVersion{Value: oldValue, CellLineage: oldLineage[column]}
+			changes = append(changes, CellChange{Column: column, Type: ChangeChanged, Old: oldVersion, New: newVersion})
+		}
+	}
+	for column, oldValue := range oldValues {
+		if columns != nil && !columns[column] {
+			continue
+		}
+		if _, exists := newValues[column]; !exists {
+			oldVersion := &ValueVersion{Value: oldValue, CellLineage: oldLineage[column]}
+			changes = append(changes, CellChange{Column: column, Type: ChangeRemoved, Old: oldVersion})
+		}
+	}
+	sort.Slice(changes, func(a, b int) bool { return changes[a].Column < changes[b].Column })
+	stats.timeStage("compare", mark)
+	return changes, nil
+}
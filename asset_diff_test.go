@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Load runs of the diff fixture, named so they sort in the order they ran
+const (
+	diffFirstRun  = "20250101T060000Z-00000001"
+	diffSecondRun = "20250102T060000Z-00000002"
+)
+
+// loadDiffFixture loads two dated files in two load runs. Between them A1's
+// Price changes, A3 is added, with its ID_BB_GLOBAL column, and B1 stays the same. The files are written to the
+// working directory, as digits in the name of a temporary directory would be
+// taken for their effective date.
+func loadDiffFixture(t *testing.T) *JSONAssetManager {
+	t.Helper()
+	m := newTestManager(t, t.TempDir())
+	t.Chdir(t.TempDir())
+	first := writeCSV(t, ".", "assets_20250101.csv", "ID_BB_GLOBAL,Price,Company\nA1,10,Acme\nB1,1,Beta\n")
+	second := writeCSV(t, ".", "assets_20250102.csv", "ID_BB_GLOBAL,Price\nA1,11\nA3,7\nB1,1\n")
+	loadFileInRun(t, m, first, diffFirstRun)
+	loadFileInRun(t, m, second, diffSecondRun)
+	return m
+}
+
+// loadFileInRun loads a file as a load run with the given ID. Runs started within
+// the same second could otherwise sort in either order.
+func loadFileInRun(t *testing.T, m *JSONAssetManager, filePath, runID string) {
+	t.Helper()
+	m.ingestFiles([]string{filePath}, runID, func(file *ingestFile) {
+		if _, err := m.finishIngestFile(file); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// collectDiff runs a diff and describes each change as "ID column type old>new"
+func collectDiff(t *testing.T, m *JSONAssetManager, from, to string, filter DiffFilter) ([]string, error) {
+	t.Helper()
+	fromPoint, err := ParseSnapshotPoint(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toPoint, err := ParseSnapshotPoint(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = m.StreamDiff(fromPoint, toPoint, filter, func(diff AssetDiff) error {
+		for _, change := range diff.Changes {
+			oldValue, newValue := "", ""
+			if change.Old != nil {
+				oldValue = change.Old.Value
+			}
+			if change.New != nil {
+				newValue = change.New.Value
+			}
+			got = append(got, fmt.Sprintf("%s %s %s %s>%s", diff.ID, change.Column, change.Type, oldValue, newValue))
+		}
+		return nil
+	})
+	return got, err
+}
+
+func TestStreamDiff(t *testing.T) {
+	m := loadDiffFixture(t)
+
+	tests := []struct {
+		name     string
+		from, to string
+		filter   DiffFilter
+		want     []string
+	}{
+		{
+			name: "between dates",
+			from: "20250101", to: "2025-01-02",
+			want: []string{"A1 Price changed 10>11", "A3 ID_BB_GLOBAL added >A3", "A3 Price added >7"},
+		},
+		{
+			name: "backwards",
+			from: "20250102", to: "20250101",
+			want: []string{"A1 Price changed 11>10", "A3 ID_BB_GLOBAL removed A3>", "A3 Price removed 7>"},
+		},
+		{
+			name: "between load runs",
+			from: diffFirstRun, to: diffSecondRun,
+			want: []string{"A1 Price changed 10>11", "A3 ID_BB_GLOBAL added >A3", "A3 Price added >7"},
+		},
+		{
+			name: "from before the first load",
+			from: "20241231", to: "20250101",
+			want: []string{
+				"A1 Company added >Acme", "A1 ID_BB_GLOBAL added >A1", "A1 Price added >10",
+				"B1 Company added >Beta", "B1 ID_BB_GLOBAL added >B1", "B1 Price added >1",
+			},
+		},
+		{
+			name: "same point",
+			from: "20250102", to: "20250102",
+		},
+		{
+			name: "columns",
+			from: "20241231", to: "20250102",
+			filter: DiffFilter{Columns: []string{"Company"}},
+			want:   []string{"A1 Company added >Acme", "B1 Company added >Beta"},
+		},
+		{
+			name: "ID pattern",
+			from: "20241231", to: "20250102",
+			filter: DiffFilter{IDPattern: regexp.MustCompile("^B")},
+			want:   []string{"B1 Company added >Beta", "B1 ID_BB_GLOBAL added >B1", "B1 Price added >1"},
+		},
+		{
+			name: "IDs",
+			from: "20250101", to: "20250102",
+			filter: DiffFilter{IDs: []string{"A3", "B1", "A9", "../A1"}},
+			want:   []string{"A3 ID_BB_GLOBAL added >A3", "A3 Price added >7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectDiff(t, m, tt.from, tt.to, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Assets are visited in trie order, which is not ID order
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("changes =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestStreamDiffErrors(t *testing.T) {
+	m := loadDiffFixture(t)
+
+	if _, err := collectDiff(t, m, diffFirstRun, "20250101T000000Z-unknown", DiffFilter{}); !errors.Is(err, ErrUnknownLoadRun) {
+		t.Errorf("diff to an unknown load run: error = %v, want ErrUnknownLoadRun", err)
+	}
+
+	// An asset whose history cannot be read stops the diff instead of looking unchanged
+	history := m.historyFilePath("A1")
+	if err := os.Remove(history); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(history, "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, err := collectDiff(t, m, "20250101", "20250102", DiffFilter{})
+	if err == nil || !strings.Contains(err.Error(), "error comparing asset A1") {
+		t.Errorf("diff with an unreadable history: error = %v, want it to name A1", err)
+	}
+
+	// fn stops the diff with its error
+	stop := errors.New("stop")
+	from, _ := ParseSnapshotPoint("20241231")
+	to, _ := ParseSnapshotPoint("20250101")
+	err = m.StreamDiff(from, to, DiffFilter{IDs: []string{"B1"}}, func(AssetDiff) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("error = %v, want the error of fn", err)
+	}
+}
+
+func TestChangesQuery(t *testing.T) {
+	m := loadDiffFixture(t)
+
+	tests := []struct {
+		name    string
+		sql     string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "all changes",
+			sql:  "SELECT ID_BB_GLOBAL, column_name, change, old_value, new_value FROM CHANGES('20250101', '20250102') WHERE column_name <> 'ID_BB_GLOBAL' ORDER BY ID_BB_GLOBAL",
+			want: []string{
+				"map[ID_BB_GLOBAL:A1 change:changed column_name:Price new_value:11 old_value:10]",
+				"map[ID_BB_GLOBAL:A3 change:added column_name:Price new_value:7]", // An added column has no old value
+			},
+		},
+		{
+			name: "filtered by change and ID",
+			sql:  "SELECT ID_BB_GLOBAL, new_effective_date, new_load_run FROM CHANGES(?, ?) WHERE change = 'added' AND column_name = 'Price' AND ID_BB_GLOBAL = 'A3'",
+			want: []string{"map[ID_BB_GLOBAL:A3 new_effective_date:20250102 new_load_run:" + diffSecondRun + "]"},
+		},
+		{
+			name: "values compare as numbers",
+			sql:  "SELECT ID_BB_GLOBAL FROM CHANGES('20241231', '20250102') WHERE column_name = 'Price' AND new_value > 5 ORDER BY 1",
+			want: []string{"map[ID_BB_GLOBAL:A1]", "map[ID_BB_GLOBAL:A3]"},
+		},
+		{
+			name: "aggregated",
+			sql:  "SELECT change, COUNT(*) AS n FROM CHANGES('" + diffFirstRun + "', '" + diffSecondRun + "') GROUP BY change ORDER BY change",
+			want: []string{"map[change:added n:2]", "map[change:changed n:1]"},
+		},
+		{
+			name:    "unknown load run",
+			sql:     "SELECT * FROM CHANGES('20250101', 'no-such-run')",
+			wantErr: "unknown load run no-such-run",
+		},
+		{
+			name:    "wrong argument count",
+			sql:     "SELECT * FROM CHANGES('20250101')",
+			wantErr: "CHANGES expects 2 arguments",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params *QueryParams
+			if strings.Contains(tt.sql, "?") {
+				params = &QueryParams{Positional: []interface{}{"20250101", "20250102"}}
+			}
+			result, err := m.ExecuteSQLQuery(tt.sql, params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !IsQueryInputError(err) {
+					t.Fatalf("error = %v, want a query input error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range result.Rows {
+				got = append(got, fmt.Sprint(row))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
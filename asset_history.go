@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historySuffix names the file next to an asset's JSON file that keeps every
+// version of the asset's values
+const historySuffix = ".history.jsonl"
+
+// historyRecord is one line of a history file: a value a column of an asset had
+// from its effective date on. Lines are only ever appended, so the short field
+// names keep the file small.
+type historyRecord struct {
+	Column string `json:"c"`
+	Value  string `json:"v"`
+	Date   string `json:"d"`           // Effective date, YYYYMMDD
+	Source uint32 `json:"s,omitempty"` // Load source number in the effective date index
+	Row    int    `json:"r,omitempty"` // Line of the source file
+}
+
+// ValueVersion is a value a column of an asset has had, with where it came from
+type ValueVersion struct {
+	Value string `json:"value"`
+	CellLineage
+}
+
+// historyFilePath computes the path to the history file of an ID_BB_GLOBAL, in
+// the same trie directory as its JSON file
+func (j *JSONAssetManager) historyFilePath(id string) string {
+	return strings.TrimSuffix(j.assetFilePath(id), ".json") + historySuffix
+}
+
+// readHistory reads every version recorded for an asset, in the order they were
+// recorded. It returns nil without an error if the asset has no history file.
+func (j *JSONAssetManager) readHistory(id string) ([]historyRecord, error) {
+	file, err := os.Open(j.historyFilePath(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading history file for ID %s: %v", id, err)
+	}
+	defer file.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var record historyRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			// A line cut short by a crash while appending is skipped, not fatal
+			j.logger.Warn("Skipping unreadable line in history file for ID %s: %v", id, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history file for ID %s: %v", id, err)
+	}
+	return records, nil
+}
+
+// appendHistory adds versions to the end of an asset's history file in a single
+// write, flushed to stable storage with SyncAlways
+func (j *JSONAssetManager) appendHistory(id string, records []historyRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("error encoding history for ID %s: %v", id, err)
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+
+	file, err := os.OpenFile(j.historyFilePath(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history file for ID %s: %v", id, err)
+	}
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing history file for ID %s: %v", id, err)
+	}
+	if j.getSyncPolicy() == SyncAlways {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return fmt.Errorf("error syncing history file for ID %s: %v", id, err)
+		}
+	}
+	return file.Close()
+}
+
+// hasHistory reports whether an asset has a history file
+func (j *JSONAssetManager) hasHistory(id string) bool {
+	_, err := os.Stat(j.historyFilePath(id))
+	return err == nil
+}
+
+// currentVersions describes the values an asset holds now as history records.
+// They start the history of an asset stored before history was kept, so its
+// current values are not lost when they are first replaced.
+func (j *JSONAssetManager) currentVersions(id string, asset map[string]string) []historyRecord {
+	var records []historyRecord
+	for column, value := range asset {
+		entry, exists := j.index.entry(id, column)
+		if !exists {
+			continue
+		}
+		records = append(records, historyRecord{
+			Column: column,
+			Value:  value,
+			Date:   formatIndexDate(entry.date),
+			Source: entry.source,
+			Row:    int(entry.row),
+		})
+	}
+	// Sorting makes the start of the file the same however the map was ordered
+	sort.Slice(records, func(a, b int) bool { return records[a].Column < records[b].Column })
+	return records
+}
+
+// recordedDates returns the effective dates recorded in an asset's history for each column
+func (j *JSONAssetManager) recordedDates(id string) (map[string]map[string]bool, error) {
+	records, err := j.readHistory(id)
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[string]map[string]bool)
+	for _, record := range records {
+		if dates[record.Column] == nil {
+			dates[record.Column] = make(map[string]bool)
+		}
+		dates[record.Column][record.Date] = true
+	}
+	return dates, nil
+}
+
+// versionOf expands a history record into the value and its lineage
+func (j *JSONAssetManager) versionOf(record historyRecord) ValueVersion {
+	return ValueVersion{
+		Value: record.Value,
+		CellLineage: CellLineage{
+			EffectiveDate: record.Date,
+			LoadSource:    j.index.Source(record.Source),
+			Row:           record.Row,
+		},
+	}
+}
+
+// GetAssetHistory returns every value a column of an asset has had, oldest
+// effective date first. An asset stored before history was kept reports only
+// its current value.
+func (j *JSONAssetManager) GetAssetHistory(id, column string) ([]ValueVersion, error) {
+	if j.checkAssetID(id) != nil {
+		return nil, fmt.Errorf("%w for ID %q", ErrAssetNotFound, id)
+	}
+
+	lock := j.assetLock(id)
+	lock.RLock()
+	records, err := j.readHistory(id)
+	lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []ValueVersion
+	for _, record := range records {
+		if record.Column == column {
+			versions = append(versions, j.versionOf(record))
+		}
+	}
+
+	if records == nil {
+		asset, err := j.GetAsset(id)
+		if err != nil {
+			return nil, err
+		}
+		if value, exists := asset[column]; exists {
+			if lineage, known := j.index.Lineage(id, column); known {
+				versions = append(versions, ValueVersion{Value: value, CellLineage: lineage})
+			}
+		}
+	}
+
+	// Files loaded out of order append older versions after newer ones
+	sort.SliceStable(versions, func(a, b int) bool {
+		return versions[a].EffectiveDate < versions[b].EffectiveDate
+	})
+	return versions, nil
+}
+
+// SnapshotPoint selects the versions that make up the state of the store at one
+// point: those effective on or before a date, or those loaded by a load run or an
+// earlier one. Exactly one of the fields is set.
+type SnapshotPoint struct {
+	Date    string // Effective date, YYYYMMDD
+	LoadRun string // Load run ID; IDs start with the time of the run, so they sort in run order
+}
+
+// ParseSnapshotPoint reads a snapshot point written as a date, YYYYMMDD or
+// YYYY-MM-DD, or as a load run ID
+func ParseSnapshotPoint(text string) (SnapshotPoint, error) {
+	if text == "" {
+		return SnapshotPoint{}, fmt.Errorf("missing date or load run")
+	}
+	date := strings.ReplaceAll(text, "-", "")
+	if len(date) == 8 {
+		if _, err := time.Parse("20060102", date); err == nil {
+			return SnapshotPoint{Date: date}, nil
+		}
+	}
+	return SnapshotPoint{LoadRun: text}, nil
+}
+
+// String returns the date or load run of the point
+func (p SnapshotPoint) String() string {
+	if p.LoadRun != "" {
+		return p.LoadRun
+	}
+	return p.Date
+}
+
+// includes reports whether a version belongs to the snapshot. Versions loaded
+// before load runs were recorded belong to every load run snapshot.
+func (p SnapshotPoint) includes(lineage CellLineage) bool {
+	if p.LoadRun != "" {
+		return lineage.LoadRun <= p.LoadRun
+	}
+	return lineage.EffectiveDate <= p.Date
+}
+
+// assetSnapshot reconstructs the values an asset had at a snapshot point: for
+// each column, the version in the snapshot with the latest effective date. Columns
+// with no such version are left out, so the result is empty if the asset did not
+// exist yet. Current values are only used for assets without a history file.
+func (j *JSONAssetManager) assetSnapshot(id string, current map[string]string, point SnapshotPoint) (map[string]string, map[string]CellLineage, error) {
+	records, err := j.readHistory(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, lineage := j.snapshotOf(id, records, current, point)
+	return values, lineage, nil
+}
+
+// snapshotOf reconstructs the values of an asset at a snapshot point from its
+// history records, or from its current values if it has no history
+func (j *JSONAssetManager) snapshotOf(id string, records []historyRecord, current map[string]string, point SnapshotPoint) (map[string]string, map[string]CellLineage) {
+	values := make(map[string]string)
+	lineage := make(map[string]CellLineage)
+
+	// Without a history file the current values are the only versions known
+	if records == nil {
+		for column, value := range current {
+			cell, exists := j.index.Lineage(id, column)
+			if exists && point.includes(cell) {
+				values[column] = value
+				lineage[column] = cell
+			}
+		}
+		return values, lineage
+	}
+
+	for _, record := range records {
+		version := j.versionOf(record)
+		if !point.includes(version.CellLineage) {
+			continue
+		}
+		// Later lines win ties, as the value loaded last is the one stored
+		if known, exists := lineage[record.Column]; exists && known.EffectiveDate > record.Date {
+			continue
+		}
+		values[record.Column] = version.Value
+		lineage[record.Column] = version.CellLineage
+	}
+	return values, lineage
+}
+
+// historyRow is an asset row reconstructed as of a date, reporting the lineage
+// of the versions it holds rather than of the current values
+type historyRow struct {
+	TypedRow
+	lineage map[string]CellLineage
+}
+
+// Lineage implements LineageRow
+func (r historyRow) Lineage(column string) (CellLineage, bool) {
+	lineage, exists := r.lineage[column]
+	return lineage, exists
+}
+
+// size estimates the memory used by the row's values and their lineage
+func (r historyRow) size() int64 {
+	size := r.TypedRow.size()
+	for column, lineage := range r.lineage {
+		size += int64(len(column) + len(lineage.EffectiveDate) + len(lineage.File) + len(lineage.S3Bucket) +
+			len(lineage.S3Key) + len(lineage.S3VersionID) + len(lineage.LoadRun) + cacheValueOverhead)
+	}
+	return size
+}
+
+// queryRow turns an asset read by a query into the row the query sees: the
+// current values, or those of the AS OF date. The second result is false if
+// the asset had no values on that date.
+func (j *JSONAssetManager) queryRow(query *SQLQuery, asset map[string]string, types map[string]ColumnType) (Row, bool, error) {
+	id := j.assetID(asset)
+	if query.AsOf == "" {
+		return assetRow{TypedRow: NewTypedRow(asset, types), id: id, index: j.index}, true, nil
+	}
+
+	values, lineage, err := j.assetSnapshot(id, asset, SnapshotPoint{Date: query.AsOf})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(values) == 0 {
+		return nil, false, nil
+	}
+	return historyRow{TypedRow: NewTypedRow(values, types), lineage: lineage}, true, nil
+}
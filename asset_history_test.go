@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// loadHistoryFixture loads three dated files of one asset with the newest first,
+// so the older two are loaded out of order. The files are written to the working
+// directory, as digits in the name of a temporary directory would be taken for
+// their effective date.
+func loadHistoryFixture(t *testing.T) *JSONAssetManager {
+	t.Helper()
+	m := newTestManager(t, t.TempDir())
+	t.Chdir(t.TempDir())
+	files := []string{
+		writeCSV(t, ".", "assets_20250301.csv", "ID_BB_GLOBAL,Price,Company\nA1,30,Acme\n"),
+		writeCSV(t, ".", "assets_20250101.csv", "ID_BB_GLOBAL,Price\nA1,10\n"),
+		writeCSV(t, ".", "assets_20250201.csv", "ID_BB_GLOBAL,Price\nA1,20\nA2,5\n"),
+	}
+	for _, filePath := range files {
+		if err := m.LoadCSVFile(filePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func TestGetAssetHistory(t *testing.T) {
+	m := loadHistoryFixture(t)
+
+	versions, err := m.GetAssetHistory("A1", "Price")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, version := range versions {
+		got = append(got, fmt.Sprintf("%s=%s from %s", version.EffectiveDate, version.Value, version.File))
+	}
+	want := []string{
+		"20250101=10 from assets_20250101.csv",
+		"20250201=20 from assets_20250201.csv",
+		"20250301=30 from assets_20250301.csv",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("history of Price =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Loading a file again does not record its values twice
+	if err := m.LoadCSVFile("assets_20250101.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := m.GetAssetHistory("A1", "Price"); err != nil || len(again) != 3 {
+		t.Errorf("after reloading a file the history has %d versions, %v, want 3", len(again), err)
+	}
+
+	if _, err := m.GetAssetHistory("A9", "Price"); !errors.Is(err, ErrAssetNotFound) {
+		t.Errorf("history of an unknown asset: error = %v, want ErrAssetNotFound", err)
+	}
+}
+
+func TestAssetWithoutHistoryFile(t *testing.T) {
+	m := loadHistoryFixture(t)
+
+	// An asset stored before history was kept has its current value only
+	if err := os.Remove(m.historyFilePath("A2")); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := m.GetAssetHistory("A2", "Price")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Value != "5" || versions[0].EffectiveDate != "20250201" {
+		t.Errorf("history of an asset without a history file = %+v, want its current value", versions)
+	}
+
+	for _, tt := range []struct {
+		asOf string
+		want int
+	}{
+		{"20250101", 0},
+		{"20250201", 1},
+	} {
+		result, err := m.ExecuteSQLQuery("SELECT Price FROM BB_ASSETS AS OF '"+tt.asOf+"' WHERE ID_BB_GLOBAL = 'A2'", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Rows) != tt.want {
+			t.Errorf("AS OF %s returned %v, want %d rows", tt.asOf, result.Rows, tt.want)
+		}
+	}
+
+	// Replacing the value starts the history with the value it replaces
+	if err := m.LoadCSVFile(writeCSV(t, ".", "assets_20250401.csv", "ID_BB_GLOBAL,Price\nA2,6\n")); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := m.GetAssetHistory("A2", "Price"); err != nil || len(versions) != 2 || versions[0].Value != "5" {
+		t.Errorf("history after the first replacement = %+v, %v, want 5 then 6", versions, err)
+	}
+}
+
+func TestQueryAsOf(t *testing.T) {
+	m := loadHistoryFixture(t)
+
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "before the first load",
+			sql:  "SELECT ID_BB_GLOBAL, Price FROM BB_ASSETS AS OF '20241231'",
+		},
+		{
+			name: "between loaded out of order",
+			sql:  "SELECT ID_BB_GLOBAL, Price FROM BB_ASSETS AS OF '2025-02-15' ORDER BY ID_BB_GLOBAL",
+			want: []string{"map[ID_BB_GLOBAL:A1 Price:20]", "map[ID_BB_GLOBAL:A2 Price:5]"},
+		},
+		{
+			name: "columns not yet loaded are left out",
+			sql:  "SELECT Price, Company FROM BB_ASSETS AS OF '20250101' WHERE ID_BB_GLOBAL = 'A1'",
+			want: []string{"map[Price:10]"},
+		},
+		{
+			name: "lookups and WHERE see the old values",
+			sql:  "SELECT ID_BB_GLOBAL FROM BB_ASSETS AS OF '20250201' WHERE ID_BB_GLOBAL IN ('A1', 'A2') AND Price > 10",
+			want: []string{"map[ID_BB_GLOBAL:A1]"},
+		},
+		{
+			name: "lineage of the old value",
+			sql:  "SELECT _lineage(Price) AS l FROM BB_ASSETS AS OF '20250115' WHERE ID_BB_GLOBAL = 'A1'",
+			want: []string{`map[l:{"effective_date":"20250101","file":"assets_20250101.csv","load_run":"`},
+		},
+		{
+			name: "current values",
+			sql:  "SELECT Price FROM BB_ASSETS WHERE ID_BB_GLOBAL = 'A1'",
+			want: []string{"map[Price:30]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := m.ExecuteSQLQuery(tt.sql, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Rows) != len(tt.want) {
+				t.Fatalf("rows = %v, want %v", result.Rows, tt.want)
+			}
+			for i, row := range result.Rows {
+				if got := fmt.Sprint(row); !strings.HasPrefix(got, tt.want[i]) {
+					t.Errorf("row %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := ParseSQL("SELECT * FROM BB_ASSETS AS OF '2025-13-01'"); err == nil {
+		t.Error("AS OF accepted a date that does not exist")
+	}
+}
+
+func TestHistoryWritesWhileSyncPolicyChanges(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	header := []string{"ID_BB_GLOBAL", "PX_LAST"}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, policy := range []SyncPolicy{SyncAlways, SyncNone, SyncBatch, SyncAlways} {
+			m.SetSyncPolicy(policy)
+		}
+	}()
+	for day := 1; day <= 4; day++ {
+		date := "2025010" + strconv.Itoa(day)
+		if _, err := m.UpdateAssetFromCSVWithDate("A1", header, []string{"A1", strconv.Itoa(day)}, date); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	history, err := m.readHistory("A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := 0
+	for _, version := range history {
+		if version.Column == "PX_LAST" {
+			prices++
+		}
+	}
+	if prices != 4 {
+		t.Fatalf("history holds %d versions of PX_LAST, want 4: %v", prices, history)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// walSuffix names the segment files of the write-ahead log
+const walSuffix = ".wal"
+
+// walRecord is one line of a write-ahead log segment: either a load source used
+// by the updates after it, or an update of one asset
+type walRecord struct {
+	Source *walSource `json:"source,omitempty"`
+	Update *walUpdate `json:"update,omitempty"`
+}
+
+// walSource records the load source behind a source number, so a segment can be
+// replayed after the index that numbered it was lost
+type walSource struct {
+	Number uint32 `json:"number"`
+	LoadSource
+}
+
+// walUpdate is the change one CSV row made to an asset
+type walUpdate struct {
+	ID      string            `json:"id"`
+	Date    string            `json:"date"`              // Effective date of the row, YYYYMMDD
+	Source  uint32            `json:"source,omitempty"`  // Load source number of the row
+	Row     int               `json:"row,omitempty"`     // Line of the source file
+	Values  map[string]string `json:"values,omitempty"`  // Columns whose value the row replaced
+	History []historyRecord   `json:"history,omitempty"` // Versions appended to the asset's history
+}
+
+// AssetWAL is the write-ahead log of the asset store. Every update is logged
+// before it changes the history, index or cached asset, and segments are only
+// removed once the assets and index they describe have been written, so updates
+// lost from memory by a crash can be replayed on the next start.
+type AssetWAL struct {
+	sync.Mutex
+	dir     string
+	policy  SyncPolicy
+	index   *EffectiveDateIndex // Names the load sources of logged updates
+	file    *os.File            // The segment being appended to
+	segment int                 // Number of the segment being appended to
+	written bool                // Whether anything was appended to the segment
+	sources map[uint32]bool     // Load sources already recorded in the segment
+}
+
+// OpenAssetWAL opens the write-ahead log in a directory, starting a segment after
+// any segments left by an earlier run. Those are not removed until replayed.
+func OpenAssetWAL(dir string, policy SyncPolicy, index *EffectiveDateIndex) (*AssetWAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating WAL directory: %v", err)
+	}
+	wal := &AssetWAL{dir: dir, policy: policy, index: index}
+
+	segments, err := wal.Segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		wal.segment = segments[len(segments)-1]
+	}
+	if err := wal.openSegment(wal.segment + 1); err != nil {
+		return nil, err
+	}
+	return wal, nil
+}
+
+// SetPolicy changes when appended updates are flushed to stable storage
+func (w *AssetWAL) SetPolicy(policy SyncPolicy) {
+	w.Lock()
+	defer w.Unlock()
+	w.policy = policy
+}
+
+// SetIndex changes the index that names the load sources of appended updates
+func (w *AssetWAL) SetIndex(index *EffectiveDateIndex) {
+	w.Lock()
+	defer w.Unlock()
+	w.index = index
+	w.sources = make(map[uint32]bool)
+}
+
+// segmentPath returns the path of a numbered segment
+func (w *AssetWAL) segmentPath(number int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%08d%s", number, walSuffix))
+}
+
+// openSegment starts appending to a new segment. The caller must hold the lock.
+func (w *AssetWAL) openSegment(number int) error {
+	file, err := os.OpenFile(w.segmentPath(number), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening WAL segment: %v", err)
+	}
+	w.file = file
+	w.segment = number
+	w.written = false
+	w.sources = make(map[uint32]bool)
+	return nil
+}
+
+// Segments returns the numbers of the segments on disk, oldest first
+func (w *AssetWAL) Segments() ([]int, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading WAL directory: %v", err)
+	}
+	var segments []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		if number, err := strconv.Atoi(strings.TrimSuffix(name, walSuffix)); err == nil {
+			segments = append(segments, number)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// Append logs an update, preceded by any load sources it uses that the segment
+// does not record yet. The update is written before Append returns, and flushed
+// to stable storage with SyncAlways.
+func (w *AssetWAL) Append(update walUpdate) error {
+	w.Lock()
+	defer w.Unlock()
+
+	var buf []byte
+	addSource := func(number uint32) error {
+		if number == unknownSource || w.sources[number] {
+			return nil
+		}
+		line, err := json.Marshal(walRecord{Source: &walSource{Number: number, LoadSource: w.index.Source(number)}})
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+		w.sources[number] = true
+		return nil
+	}
+	if err := addSource(update.Source); err != nil {
+		return fmt.Errorf("error encoding WAL record: %v", err)
+	}
+	for _, record := range update.History {
+		if err := addSource(record.Source); err != nil {
+			return fmt.Errorf("error encoding WAL record: %v", err)
+		}
+	}
+
+	line, err := json.Marshal(walRecord{Update: &update})
+	if err != nil {
+		return fmt.Errorf("error encoding WAL record: %v", err)
+	}
+	buf = append(append(buf, line...), '\n')
+
+	if _, err := w.file.Write(buf); err != nil {
+		return fmt.Errorf("error writing WAL segment: %v", err)
+	}
+	w.written = true
+	if w.policy == SyncAlways {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("error syncing WAL segment: %v", err)
+		}
+	}
+	return nil
+}
+
+// Rotate closes the segment being appended to and starts a new one. It returns
+// the number of the closed segment: once every update logged so far has been
+// written, that segment and all before it can be removed.
+func (w *AssetWAL) Rotate() (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	closed := w.segment
+	if w.policy != SyncNone && w.written {
+		if err := w.file.Sync(); err != nil {
+			return 0, fmt.Errorf("error syncing WAL segment: %v", err)
+		}
+	}
+	if err := w.file.Close(); err != nil {
+		return 0, fmt.Errorf("error closing WAL segment: %v", err)
+	}
+	if err := w.openSegment(closed + 1); err != nil {
+		return 0, err
+	}
+	return closed, nil
+}
+
+// RemoveThrough removes the segments up to and including a number
+func (w *AssetWAL) RemoveThrough(number int) error {
+	segments, err := w.Segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment > number {
+			break
+		}
+		if err := os.Remove(w.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing WAL segment: %v", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the segment being appended to, removing it if nothing was logged
+func (w *AssetWAL) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("error closing WAL segment: %v", err)
+	}
+	if !w.written {
+		os.Remove(w.segmentPath(w.segment))
+	}
+	return nil
+}
+
+// readSegment calls visit for every record of a segment, in the order logged. A
+// line cut short by a crash while appending ends the segment.
+func (w *AssetWAL) readSegment(number int, visit func(record walRecord) error) error {
+	file, err := os.Open(w.segmentPath(number))
+	if err != nil {
+		return fmt.Errorf("error reading WAL segment: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var record walRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil
+		}
+		if err := visit(record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading WAL segment: %v", err)
+	}
+	return nil
+}
+
+// replayWAL applies the updates logged by an earlier run that were not written
+// before it stopped, then saves the store and removes their segments. Updates
+// that were written, entirely or in part, are recognised and not applied twice:
+// history versions already recorded for their column and date are skipped, and
+// values are only set where the index has nothing newer.
+func (j *JSONAssetManager) replayWAL() error {
+	segments, err := j.wal.Segments()
+	if err != nil {
+		return err
+	}
+	var replay []int
+	for _, segment := range segments {
+		if segment < j.wal.segment {
+			replay = append(replay, segment)
+		}
+	}
+	if len(replay) == 0 {
+		return nil
+	}
+
+	updates, skipped := 0, 0
+	for _, segment := range replay {
+		// Source numbers are only meaningful within the segment that recorded them
+		sources := map[uint32]uint32{unknownSource: unknownSource}
+		err := j.wal.readSegment(segment, func(record walRecord) error {
+			if record.Source != nil {
+				sources[record.Source.Number] = j.index.RegisterSource(record.Source.LoadSource)
+			}
+			if record.Update == nil {
+				return nil
+			}
+			// Updates are only logged for valid IDs, so one that is not was not
+			// written by this store; it is left out rather than given a file
+			if j.checkAssetID(record.Update.ID) != nil {
+				j.logger.Warn("Skipping logged update with invalid ID %q in WAL segment %d", record.Update.ID, segment)
+				skipped++
+				return nil
+			}
+			updates++
+			return j.replayUpdate(*record.Update, sources)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Save what was replayed before the segments are removed
+	if err := j.FlushAssets(); err != nil {
+		return fmt.Errorf("error writing cached assets: %v", err)
+	}
+	if err := j.saveIndex(); err != nil {
+		return fmt.Errorf("error saving index file: %v", err)
+	}
+	if err := j.saveSchema(); err != nil {
+		return fmt.Errorf("error saving schema file: %v", err)
+	}
+	if err := j.wal.RemoveThrough(replay[len(replay)-1]); err != nil {
+		return err
+	}
+	if updates > 0 {
+		j.logger.Success("Replayed %d updates from %d write-ahead log segments", updates, len(replay))
+	}
+	if skipped > 0 {
+		j.logger.Warn("Skipped %d logged updates with invalid IDs", skipped)
+	}
+	return nil
+}
+
+// replayUpdate applies a logged update to an asset, mapping the source numbers
+// of the segment to those of the index
+func (j *JSONAssetManager) replayUpdate(update walUpdate, sources map[uint32]uint32) error {
+	date, err := parseIndexDate(update.Date)
+	if err != nil {
+		return err
+	}
+	source := sources[update.Source]
+
+	lock := j.assetLock(update.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	asset, cached := j.cache.get(update.ID)
+	if !cached {
+		if asset, err = j.loadOrCreateAsset(update.ID); err != nil {
+			return fmt.Errorf("error loading asset for ID %s: %v", update.ID, err)
+		}
+	}
+
+	// Append the versions the history file does not hold yet; a column has at
+	// most one version per effective date
+	recorded, err := j.recordedDates(update.ID)
+	if err != nil {
+		return err
+	}
+	var missing []historyRecord
+	for _, record := range update.History {
+		if recorded[record.Column][record.Date] {
+			continue
+		}
+		record.Source = sources[record.Source]
+		missing = append(missing, record)
+	}
+	if err := j.appendHistory(update.ID, missing); err != nil {
+		return err
+	}
+
+	// Set each value unless a newer one replaced it, including values whose
+	// index entry was saved but whose asset file was not
+	columns := make([]string, 0, len(update.Values))
+	for column := range update.Values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	changed := false
+	logged := indexEntry{date: date, source: source, row: uint32(update.Row)}
+	for _, column := range columns {
+		value := update.Values[column]
+		newer, err := j.index.Update(update.ID, column, update.Date, source, update.Row)
+		if err != nil {
+			return err
+		}
+		if !newer {
+			if entry, exists := j.index.entry(update.ID, column); !exists || entry != logged {
+				continue
+			}
+		}
+		j.addColumnIfNotExists(column)
+		if asset[column] != value {
+			asset[column] = value
+			changed = true
+		}
+	}
+
+	return j.writeEvicted(j.cache.put(update.ID, asset, changed))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayAfterCrash(t *testing.T) {
+	header := []string{"ID_BB_GLOBAL", "PX_LAST"}
+	tests := []struct {
+		name     string
+		before   func(t *testing.T, m *JSONAssetManager) // Changes made before the crash
+		wantDate string
+		want     string
+	}{
+		{
+			name: "update not written",
+			before: func(t *testing.T, m *JSONAssetManager) {
+				if _, err := m.UpdateAssetFromCSVWithDate("A1", header, []string{"A1", "10"}, "20250101"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantDate: "20250101",
+			want:     "10",
+		},
+		{
+			name: "update after a checkpoint",
+			before: func(t *testing.T, m *JSONAssetManager) {
+				if _, err := m.UpdateAssetFromCSVWithDate("A1", header, []string{"A1", "10"}, "20250101"); err != nil {
+					t.Fatal(err)
+				}
+				if err := m.checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := m.UpdateAssetFromCSVWithDate("A1", header, []string{"A1", "20"}, "20250102"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantDate: "20250102",
+			want:     "20",
+		},
+		{
+			name: "crash right after the log was appended to",
+			before: func(t *testing.T, m *JSONAssetManager) {
+				if _, err := m.UpdateAssetFromCSVWithDate("A1", header, []string{"A1", "10"}, "20250101"); err != nil {
+					t.Fatal(err)
+				}
+				if err := m.checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+				// The update is logged, but neither the index, the history nor
+				// the cached asset changed before the crash
+				update := walUpdate{ID: "A1", Date: "20250103", Values: map[string]string{"PX_LAST": "30"}}
+				if err := m.wal.Append(update); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantDate: "20250103",
+			want:     "30",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			crashed := newTestManager(t, dataDir)
+			tt.before(t, crashed)
+
+			// The crashed manager is abandoned without being closed
+			for round := 1; round <= 2; round++ {
+				m := newTestManager(t, dataDir)
+				asset, err := m.GetAsset("A1")
+				if err != nil || asset["PX_LAST"] != tt.want {
+					t.Errorf("open %d: GetAsset(A1) = %v, %v, want PX_LAST %s", round, asset, err, tt.want)
+				}
+				if date := m.index.Get("A1", "PX_LAST"); date != tt.wantDate {
+					t.Errorf("open %d: PX_LAST has effective date %s, want %s", round, date, tt.wantDate)
+				}
+				// What was replayed is written for good by closing
+				if err := m.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReplaySkipsUpdatesWithInvalidIDs(t *testing.T) {
+	root := t.TempDir()
+	dataDir := filepath.Join(root, "data")
+	crashed := newTestManager(t, dataDir)
+	// A log not written by this store can hold IDs the store never accepts
+	for _, update := range []walUpdate{
+		{ID: "../../A1", Date: "20250101", Values: map[string]string{"PX_LAST": "99"}},
+		{ID: "A1", Date: "20250101", Values: map[string]string{"PX_LAST": "10"}},
+	} {
+		if err := crashed.wal.Append(update); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The invalid update is skipped and the rest of the segment still replayed
+	m := newTestManager(t, dataDir)
+	if asset, err := m.GetAsset("A1"); err != nil || asset["PX_LAST"] != "10" {
+		t.Errorf("GetAsset(A1) = %v, %v, want PX_LAST 10", asset, err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("replay created %d entries next to the data directory, want none", len(entries)-1)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Block markers of a Bloomberg Data License output file
+const (
+	bloombergStartOfFile   = "START-OF-FILE"
+	bloombergStartOfFields = "START-OF-FIELDS"
+	bloombergEndOfFields   = "END-OF-FIELDS"
+	bloombergStartOfData   = "START-OF-DATA"
+	bloombergEndOfData     = "END-OF-DATA"
+)
+
+// bloombergExtensions are the extensions of Data License output files, which
+// are also found when gzipped
+var bloombergExtensions = []string{".out", ".px", ".rpx", ".dif"}
+
+// isBloombergFileName reports whether a file is named like a Data License output file
+func isBloombergFileName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".gz")
+	for _, extension := range bloombergExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBloombergData reports whether data starts with the START-OF-FILE line of a
+// Data License output file, without consuming anything
+func isBloombergData(reader *bufio.Reader) bool {
+	start, _ := reader.Peek(len(bloombergStartOfFile))
+	return bytes.Equal(start, []byte(bloombergStartOfFile))
+}
+
+// bloombergReader reads the securities of a Data License getdata output file:
+//
+//	START-OF-FILE
+//	PROGRAMNAME=getdata
+//	DATEFORMAT=yyyymmdd
+//	START-OF-FIELDS
+//	ID_BB_GLOBAL
+//	PX_LAST
+//	END-OF-FIELDS
+//	TIMESTARTED=Thu Apr 10 18:02:11 EDT 2025
+//	START-OF-DATA
+//	AAPL US Equity|0|2|BBG000B9XRY4|190.42|
+//	END-OF-DATA
+//	TIMEFINISHED=Thu Apr 10 18:04:37 EDT 2025
+//	END-OF-FILE
+//
+// Each data row is the security, an error code, the number of values and one
+// value per field, and becomes a record with the fields as its header. When
+// ID_BB_GLOBAL is not one of the fields, the security, as it was requested,
+// is added as the ID_BB_GLOBAL of the record.
+type bloombergReader struct {
+	scanner     *bufio.Scanner
+	line        int               // Line of the file last read
+	headers     map[string]string // Header lines, such as PROGRAMNAME and DATEFORMAT
+	fields      []string          // Names of the values of every row
+	header      []string          // Names of the values of every record
+	securityKey bool              // Whether the security is added as the ID_BB_GLOBAL of each record
+	dateLayout  string            // Layout of DATEFORMAT, empty if the file has none
+	ended       bool              // Whether END-OF-DATA was read
+}
+
+// newBloombergReader reads the header and fields of a Data License file, leaving
+// the reader at the first data row. Only getdata files, with one row per
+// security, can be read.
+func newBloombergReader(reader io.Reader) (*bloombergReader, error) {
+	b := &bloombergReader{scanner: bufio.NewScanner(reader), headers: make(map[string]string)}
+	b.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	inFields := false
+	for {
+		line, ok := b.next()
+		if !ok {
+			if err := b.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no %s block", bloombergStartOfData)
+		}
+		if line == bloombergStartOfData {
+			break
+		}
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || line == bloombergStartOfFile:
+		case line == bloombergStartOfFields:
+			inFields = true
+		case line == bloombergEndOfFields:
+			inFields = false
+		case inFields:
+			b.fields = append(b.fields, line)
+		default:
+			if key, value, found := strings.Cut(line, "="); found {
+				b.headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	if len(b.fields) == 0 {
+		return nil, fmt.Errorf("no fields in the %s block", bloombergStartOfFields)
+	}
+	b.header = b.fields
+	b.securityKey = true
+	for _, field := range b.fields {
+		if field == "ID_BB_GLOBAL" {
+			b.securityKey = false
+			break
+		}
+	}
+	if b.securityKey {
+		b.header = append(append([]string(nil), b.fields...), "ID_BB_GLOBAL")
+	}
+	if program := b.headers["PROGRAMNAME"]; program != "" && !strings.EqualFold(program, "getdata") {
+		return nil, fmt.Errorf("unsupported PROGRAMNAME %s, only getdata files can be loaded", program)
+	}
+	if format := b.headers["DATEFORMAT"]; format != "" {
+		layout, err := bloombergDateLayout(format)
+		if err != nil {
+			return nil, err
+		}
+		b.dateLayout = layout
+	}
+	return b, nil
+}
+
+// bloombergDateLayout converts a DATEFORMAT such as yyyymmdd or mm/dd/yyyy to a time layout
+func bloombergDateLayout(format string) (string, error) {
+	layout := strings.ToLower(format)
+	layout = strings.Replace(layout, "yyyy", "2006", 1)
+	layout = strings.Replace(layout, "mm", "01", 1)
+	layout = strings.Replace(layout, "dd", "02", 1)
+	if strings.ContainsAny(layout, "abcdefghijklmnopqrstuvwxyz") || !strings.Contains(layout, "2006") ||
+		!strings.Contains(layout, "01") || !strings.Contains(layout, "02") {
+		return "", fmt.Errorf("unsupported DATEFORMAT %s", format)
+	}
+	return layout, nil
+}
+
+// next returns the next line of the file without surrounding space
+func (b *bloombergReader) next() (string, bool) {
+	if !b.scanner.Scan() {
+		return "", false
+	}
+	b.line++
+	return strings.TrimSpace(b.scanner.Text()), true
+}
+
+// Header returns the fields of the file, which name the values of every record,
+// followed by ID_BB_GLOBAL if the security is added as the ID
+func (b *bloombergReader) Header() []string {
+	return b.header
+}
+
+// Program returns the PROGRAMNAME of the file
+func (b *bloombergReader) Program() string {
+	return b.headers["PROGRAMNAME"]
+}
+
+// EffectiveDate returns the date the data was requested for as YYYYMMDD: the
+// RUNDATE of the file if it has one, otherwise the day of TIMESTARTED. It is
+// empty if neither can be read.
+func (b *bloombergReader) EffectiveDate() string {
+	if runDate := b.headers["RUNDATE"]; runDate != "" {
+		layouts := []string{"20060102"}
+		if b.dateLayout != "" {
+			layouts = append([]string{b.dateLayout}, layouts...)
+		}
+		for _, layout := range layouts {
+			if date, err := time.Parse(layout, runDate); err == nil {
+				return date.Format("20060102")
+			}
+		}
+	}
+	if started := b.headers["TIMESTARTED"]; started != "" {
+		for _, layout := range []string{time.UnixDate, time.ANSIC} {
+			if date, err := time.Parse(layout, started); err == nil {
+				return date.Format("20060102")
+			}
+		}
+	}
+	return ""
+}
+
+// Read returns the values of the next security and the line they are on, and
+// io.EOF after END-OF-DATA. A security Bloomberg returned an error code for, or
+// whose row does not have one value per field, is a skippedRecord.
+func (b *bloombergReader) Read() ([]string, int, error) {
+	for !b.ended {
+		line, ok := b.next()
+		if !ok {
+			if err := b.scanner.Err(); err != nil {
+				return nil, 0, err
+			}
+			return nil, 0, fmt.Errorf("file ends without %s: %w", bloombergEndOfData, io.ErrUnexpectedEOF)
+		}
+		if line == "" {
+			continue
+		}
+		if line == bloombergEndOfData {
+			b.ended = true
+			break
+		}
+
+		parts := strings.Split(line, "|")
+		if len(parts) < 3 {
+			return nil, b.line, &skippedRecord{fmt.Errorf("line %d is not a data row", b.line)}
+		}
+		security := strings.TrimSpace(parts[0])
+		if code := strings.TrimSpace(parts[1]); code != "0" {
+			return nil, b.line, &skippedRecord{fmt.Errorf("line %d: security %s returned error code %s", b.line, security, code)}
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+		if err != nil || count != len(b.fields) || len(parts) < 3+count {
+			return nil, b.line, &skippedRecord{fmt.Errorf("line %d: security %s does not have one value for each of the %d fields", b.line, security, len(b.fields))}
+		}
+
+		record := make([]string, count, len(b.header))
+		for i := range record {
+			record[i] = b.value(strings.TrimSpace(parts[3+i]))
+		}
+		if b.securityKey {
+			record = append(record, security)
+		}
+		return record, b.line, nil
+	}
+	return nil, 0, io.EOF
+}
+
+// value normalises a value of a data row. Dates in a DATEFORMAT with separators
+// become YYYY-MM-DD, so day-first dates are not read as month-first. Dates in
+// yyyymmdd are already recognised, and converting them could change numbers
+// that happen to look like dates.
+func (b *bloombergReader) value(value string) string {
+	if !strings.ContainsAny(b.dateLayout, "/-.") {
+		return value
+	}
+	if date, err := time.Parse(b.dateLayout, value); err == nil {
+		return date.Format("2006-01-02")
+	}
+	return value
+}
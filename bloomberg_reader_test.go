@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// bloombergFile builds a getdata output file with the given header lines, fields and data rows
+func bloombergFile(headers, fields, rows []string) string {
+	var file strings.Builder
+	file.WriteString("START-OF-FILE\n")
+	for _, line := range headers {
+		file.WriteString(line + "\n")
+	}
+	file.WriteString("START-OF-FIELDS\n")
+	for _, field := range fields {
+		file.WriteString(field + "\n")
+	}
+	file.WriteString("END-OF-FIELDS\nTIMESTARTED=Thu Apr 10 18:02:11 EDT 2025\nSTART-OF-DATA\n")
+	for _, row := range rows {
+		file.WriteString(row + "\n")
+	}
+	file.WriteString("END-OF-DATA\nTIMEFINISHED=Thu Apr 10 18:04:37 EDT 2025\nEND-OF-FILE\n")
+	return file.String()
+}
+
+// readBloombergRecords reads every record of a file, describing each skipped one by its line
+func readBloombergRecords(t *testing.T, b *bloombergReader) (records []string, skipped []int) {
+	t.Helper()
+	for {
+		record, line, err := b.Read()
+		if err == io.EOF {
+			return records, skipped
+		}
+		var skip *skippedRecord
+		if errors.As(err, &skip) {
+			skipped = append(skipped, line)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, strings.Join(record, ","))
+	}
+}
+
+func TestBloombergReaderRead(t *testing.T) {
+	file := bloombergFile(
+		[]string{"PROGRAMNAME=getdata", "DATEFORMAT=yyyymmdd", "# a comment"},
+		[]string{"ID_BB_GLOBAL", "PX_LAST", "NAME"},
+		[]string{
+			"AAPL US Equity|0|3|BBG000B9XRY4|190.42|APPLE INC|",
+			"XXXX US Equity|10|1|N.A.|",
+			"MSFT US Equity|0|2|BBG000BPH459|410.1|",
+			"",
+			"IBM US Equity|0|3| BBG000BLNNH6 | 181.7 | IBM |",
+			"not a data row",
+		},
+	)
+	b, err := newBloombergReader(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(b.Header(), ","); got != "ID_BB_GLOBAL,PX_LAST,NAME" {
+		t.Errorf("header = %s, want the fields of the file", got)
+	}
+	if b.Program() != "getdata" {
+		t.Errorf("program = %s, want getdata", b.Program())
+	}
+
+	records, skipped := readBloombergRecords(t, b)
+	wantRecords := []string{"BBG000B9XRY4,190.42,APPLE INC", "BBG000BLNNH6,181.7,IBM"}
+	if strings.Join(records, "\n") != strings.Join(wantRecords, "\n") {
+		t.Errorf("records =\n%s\nwant\n%s", strings.Join(records, "\n"), strings.Join(wantRecords, "\n"))
+	}
+	// The error code, the short row and the line that is not a row are skipped
+	// on the lines they are on
+	if len(skipped) != 3 || skipped[0] != 13 || skipped[1] != 14 || skipped[2] != 17 {
+		t.Errorf("skipped lines = %v, want 13, 14 and 17", skipped)
+	}
+}
+
+func TestBloombergReaderSecurityKey(t *testing.T) {
+	b, err := newBloombergReader(strings.NewReader(bloombergFile(nil,
+		[]string{"PX_LAST"},
+		[]string{"BBG000B9XRY4|0|1|190.42|"},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without an ID_BB_GLOBAL field the security is the ID of each record
+	if got := strings.Join(b.Header(), ","); got != "PX_LAST,ID_BB_GLOBAL" {
+		t.Errorf("header = %s, want PX_LAST,ID_BB_GLOBAL", got)
+	}
+	records, _ := readBloombergRecords(t, b)
+	if len(records) != 1 || records[0] != "190.42,BBG000B9XRY4" {
+		t.Errorf("records = %v, want the value followed by the security", records)
+	}
+}
+
+func TestBloombergReaderEffectiveDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    string
+	}{
+		{name: "RUNDATE", headers: []string{"RUNDATE=20250409"}, want: "20250409"},
+		{name: "RUNDATE in DATEFORMAT", headers: []string{"DATEFORMAT=dd/mm/yyyy", "RUNDATE=09/04/2025"}, want: "20250409"},
+		{name: "TIMESTARTED without RUNDATE", want: "20250410"},
+		{name: "unreadable RUNDATE", headers: []string{"RUNDATE=soon"}, want: "20250410"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newBloombergReader(strings.NewReader(bloombergFile(tt.headers, []string{"ID_BB_GLOBAL"}, nil)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.EffectiveDate(); got != tt.want {
+				t.Errorf("effective date = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// A file without either date leaves it to the name of the file
+	b, err := newBloombergReader(strings.NewReader("START-OF-FILE\nSTART-OF-FIELDS\nPX_LAST\nEND-OF-FIELDS\nSTART-OF-DATA\nEND-OF-DATA\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.EffectiveDate(); got != "" {
+		t.Errorf("effective date of a file without dates = %s, want none", got)
+	}
+}
+
+func TestBloombergReaderDateValues(t *testing.T) {
+	b, err := newBloombergReader(strings.NewReader(bloombergFile(
+		[]string{"DATEFORMAT=dd/mm/yyyy"},
+		[]string{"ID_BB_GLOBAL", "MATURITY", "NAME"},
+		[]string{"BBG1|0|3|BBG1|09/04/2030|04/05 NOTE|"},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, _ := readBloombergRecords(t, b)
+	if len(records) != 1 || records[0] != "BBG1,2030-04-09,04/05 NOTE" {
+		t.Errorf("records = %v, want day-first dates as YYYY-MM-DD and other values as they are", records)
+	}
+}
+
+func TestBloombergReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{
+			name:    "other program",
+			file:    bloombergFile([]string{"PROGRAMNAME=gethistory"}, []string{"PX_LAST"}, nil),
+			wantErr: "unsupported PROGRAMNAME gethistory",
+		},
+		{
+			name:    "no fields",
+			file:    bloombergFile(nil, nil, nil),
+			wantErr: "no fields",
+		},
+		{
+			name:    "no data block",
+			file:    "START-OF-FILE\nSTART-OF-FIELDS\nPX_LAST\nEND-OF-FIELDS\n",
+			wantErr: "no START-OF-DATA block",
+		},
+		{
+			name:    "unsupported date format",
+			file:    bloombergFile([]string{"DATEFORMAT=yy-mm-dd"}, []string{"PX_LAST"}, nil),
+			wantErr: "unsupported DATEFORMAT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newBloombergReader(strings.NewReader(tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A file cut off in the data block is an error, not the end of the records
+	b, err := newBloombergReader(strings.NewReader("START-OF-FILE\nSTART-OF-FIELDS\nPX_LAST\nEND-OF-FIELDS\nSTART-OF-DATA\nA|0|1|1|\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Read(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error at the end of a truncated file = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestLoadBloombergFile(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "prices.out", bloombergFile(
+		[]string{"PROGRAMNAME=getdata", "RUNDATE=20250409"},
+		[]string{"PX_LAST"},
+		[]string{"BBG000B9XRY4|0|1|190.42|", "BAD/ID|0|1|1|", "XXXX|10|1|N.A.|"},
+	))
+	run := m.loadFiles([]string{filePath}, func(string) bool { return false })
+	if run.FilesLoaded != 1 {
+		t.Fatalf("run loaded %d files, want 1", run.FilesLoaded)
+	}
+	load, _ := m.manifest.File(filePath)
+	if load.EffectiveDate != "20250409" || load.RowsLoaded != 1 || load.RowsSkipped != 2 {
+		t.Errorf("manifest records %+v, want 1 row loaded and 2 skipped on 20250409", load)
+	}
+	asset, err := m.GetAsset("BBG000B9XRY4")
+	if err != nil || asset["PX_LAST"] != "190.42" || asset["ID_BB_GLOBAL"] != "BBG000B9XRY4" {
+		t.Errorf("GetAsset = %v, %v, want PX_LAST 190.42 keyed by the security", asset, err)
+	}
+	if date := m.index.Get("BBG000B9XRY4", "PX_LAST"); date != "20250409" {
+		t.Errorf("PX_LAST has effective date %s, want the RUNDATE", date)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ways a column mapping can change the case of header names
+const (
+	columnCaseUpper = "upper"
+	columnCaseLower = "lower"
+)
+
+// ColumnMapping describes how the header names of the files matching a glob
+// become column names, so the same field sent by different vendors lands in one
+// column. Names in drop and rename match a header ignoring case and the
+// whitespace around it. A header that is neither dropped nor renamed is trimmed,
+// has its case changed and is prefixed, as far as each is set.
+type ColumnMapping struct {
+	Match  string            `json:"match"`            // Glob of the file paths, or the directories, it applies to
+	Rename map[string]string `json:"rename,omitempty"` // Header names to the column names they are loaded as
+	Drop   []string          `json:"drop,omitempty"`   // Header names of the columns not to load
+	Trim   bool              `json:"trim,omitempty"`   // Remove the whitespace around header names
+	Case   string            `json:"case,omitempty"`   // upper or lower to change the case of header names (default: kept)
+	Prefix string            `json:"prefix,omitempty"` // Added to header names, such as the name of their source; never to key columns
+}
+
+// columnMappingRule is a configured column mapping ready to apply
+type columnMappingRule struct {
+	match  string
+	rename map[string]string // Lower-cased, trimmed header name to column name
+	drop   map[string]bool   // Lower-cased, trimmed header names
+	trim   bool
+	caseTo string
+	prefix string
+}
+
+// headerKey is how rename and drop entries are matched with header names
+func headerKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// rule checks a column mapping and converts it for applying
+func (m ColumnMapping) rule() (columnMappingRule, error) {
+	rule := columnMappingRule{
+		match:  m.Match,
+		rename: make(map[string]string, len(m.Rename)),
+		drop:   make(map[string]bool, len(m.Drop)),
+		trim:   m.Trim,
+		prefix: m.Prefix,
+	}
+	if m.Match == "" {
+		return rule, errors.New("a column mapping needs a match glob")
+	}
+	if _, err := path.Match(filepath.ToSlash(m.Match), ""); err != nil {
+		return rule, fmt.Errorf("invalid match glob %q: %v", m.Match, err)
+	}
+
+	switch strings.ToLower(m.Case) {
+	case "":
+	case columnCaseUpper, columnCaseLower:
+		rule.caseTo = strings.ToLower(m.Case)
+	default:
+		return rule, fmt.Errorf("unsupported case %q, expected upper or lower", m.Case)
+	}
+	for _, header := range m.Drop {
+		rule.drop[headerKey(header)] = true
+	}
+	for header, column := range m.Rename {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			return rule, fmt.Errorf("header %q cannot be renamed to an empty column name", header)
+		}
+		if rule.drop[headerKey(header)] {
+			return rule, fmt.Errorf("header %q is both renamed and dropped", header)
+		}
+		if existing, exists := rule.rename[headerKey(header)]; exists && existing != column {
+			return rule, fmt.Errorf("header %q is renamed to both %s and %s", header, existing, column)
+		}
+		rule.rename[headerKey(header)] = column
+	}
+	return rule, nil
+}
+
+// column returns the column a header is loaded as, and false if it is dropped
+func (r columnMappingRule) column(header string, keyColumns []string) (string, bool) {
+	key := headerKey(header)
+	if r.drop[key] {
+		return "", false
+	}
+	if column, renamed := r.rename[key]; renamed {
+		return column, true
+	}
+
+	column := header
+	if r.trim {
+		column = strings.TrimSpace(column)
+	}
+	switch r.caseTo {
+	case columnCaseUpper:
+		column = strings.ToUpper(column)
+	case columnCaseLower:
+		column = strings.ToLower(column)
+	}
+	if column == "" {
+		return "", false
+	}
+	if r.prefix != "" && !containsString(keyColumns, column) {
+		column = r.prefix + column
+	}
+	return column, true
+}
+
+// mappedRecords reads the records of a file with its columns mapped: dropped
+// columns are left out and the others are named as the mapping says
+type mappedRecords struct {
+	records recordReader
+	header  []string // Column names of the columns kept
+	sources []string // Header name each column kept was read from
+	keep    []int    // Position of each column kept in the file's records
+}
+
+// apply maps the header of a file. A column mapped to the name of a column
+// before it is left out, and reported with the others left out.
+func (r columnMappingRule) apply(records recordReader, keyColumns []string) (*mappedRecords, []string) {
+	mapped := &mappedRecords{records: records}
+	var warnings []string
+	for i, header := range records.Header() {
+		column, kept := r.column(header, keyColumns)
+		if !kept {
+			continue
+		}
+		if k := indexOfString(mapped.header, column); k >= 0 {
+			warnings = append(warnings, fmt.Sprintf("header %q is loaded as %s like %q before it, so it is left out",
+				header, column, mapped.sources[k]))
+			continue
+		}
+		mapped.header = append(mapped.header, column)
+		mapped.sources = append(mapped.sources, header)
+		mapped.keep = append(mapped.keep, i)
+	}
+	return mapped, warnings
+}
+
+// Header returns the mapped column names
+func (m *mappedRecords) Header() []string {
+	return m.header
+}
+
+// Sources returns the header name each column was read from, in column order
+func (m *mappedRecords) Sources() []string {
+	return m.sources
+}
+
+// Read returns the next record with the values of the columns kept, in the
+// order of the mapped header
+func (m *mappedRecords) Read() ([]string, int, error) {
+	record, line, err := m.records.Read()
+	if err != nil {
+		return record, line, err
+	}
+	values := make([]string, len(m.keep))
+	for i, k := range m.keep {
+		if k < len(record) {
+			values[i] = record[k]
+		}
+	}
+	return values, line, nil
+}
+
+// indexOfString returns the position of a value in a list, or -1 if it is not in it
+func indexOfString(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetColumnMappings sets how the headers of files become column names. The
+// first mapping whose glob matches a file applies to it; the headers of files
+// no mapping matches are used as they are.
+func (j *JSONAssetManager) SetColumnMappings(mappings []ColumnMapping) error {
+	rules := make([]columnMappingRule, 0, len(mappings))
+	for _, mapping := range mappings {
+		rule, err := mapping.rule()
+		if err != nil {
+			return fmt.Errorf("column mapping %q: %v", mapping.Match, err)
+		}
+		rules = append(rules, rule)
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.columnMappings = rules
+	return nil
+}
+
+// columnMappingFor returns the first configured column mapping matching a file
+func (j *JSONAssetManager) columnMappingFor(filePath string) (columnMappingRule, bool) {
+	j.RLock()
+	defer j.RUnlock()
+	for _, rule := range j.columnMappings {
+		if matchesFile(rule.match, filePath) {
+			return rule, true
+		}
+	}
+	return columnMappingRule{}, false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestColumnMappingRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping ColumnMapping
+		wantErr string
+	}{
+		{name: "valid", mapping: ColumnMapping{Match: "vendor_a", Rename: map[string]string{"Px": "PX_LAST"}, Drop: []string{"Notes"}, Case: "Upper"}},
+		{name: "no glob", mapping: ColumnMapping{}, wantErr: "needs a match glob"},
+		{name: "bad glob", mapping: ColumnMapping{Match: "[a"}, wantErr: "invalid match glob"},
+		{name: "bad case", mapping: ColumnMapping{Match: "a", Case: "title"}, wantErr: "unsupported case"},
+		{name: "empty rename", mapping: ColumnMapping{Match: "a", Rename: map[string]string{"Px": " "}}, wantErr: "empty column name"},
+		{name: "renamed and dropped", mapping: ColumnMapping{Match: "a", Rename: map[string]string{"Px": "PX_LAST"}, Drop: []string{" px "}}, wantErr: "both renamed and dropped"},
+		{name: "renamed twice", mapping: ColumnMapping{Match: "a", Rename: map[string]string{"Px": "PX_LAST", "PX": "PX_MID"}}, wantErr: "renamed to both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.mapping.rule()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("rule() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("rule() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestColumnMappingColumn(t *testing.T) {
+	rule, err := ColumnMapping{
+		Match:  "vendor_a",
+		Rename: map[string]string{"Last Price": "PX_LAST"},
+		Drop:   []string{"notes"},
+		Trim:   true,
+		Case:   "upper",
+		Prefix: "VA_",
+	}.rule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyColumns := []string{"ID_BB_GLOBAL"}
+
+	tests := []struct {
+		header   string
+		want     string
+		wantKept bool
+	}{
+		{header: " last price ", want: "PX_LAST", wantKept: true},
+		{header: "Notes", wantKept: false},
+		{header: " volume ", want: "VA_VOLUME", wantKept: true},
+		{header: "id_bb_global", want: "ID_BB_GLOBAL", wantKept: true},
+		{header: "   ", wantKept: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, kept := rule.column(tt.header, keyColumns)
+			if got != tt.want || kept != tt.wantKept {
+				t.Errorf("column(%q) = %q, %v, want %q, %v", tt.header, got, kept, tt.want, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestColumnMappingApply(t *testing.T) {
+	rule, err := ColumnMapping{Match: "a", Rename: map[string]string{"Px": "PX_LAST"}, Drop: []string{"Notes"}}.rule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "ID_BB_GLOBAL,Px,Notes,PX_LAST,Volume\nA1,10,ignore,11,500\nA2,20,ignore\n"
+	records, err := newCSVRecordReader(bufio.NewReaderSize(strings.NewReader(data), csvSniffBytes), defaultCSVFormat())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapped, warnings := rule.apply(records, []string{"ID_BB_GLOBAL"})
+	if want := []string{"ID_BB_GLOBAL", "PX_LAST", "Volume"}; !reflect.DeepEqual(mapped.Header(), want) {
+		t.Errorf("header = %q, want %q", mapped.Header(), want)
+	}
+	if want := []string{"ID_BB_GLOBAL", "Px", "Volume"}; !reflect.DeepEqual(mapped.Sources(), want) {
+		t.Errorf("sources = %q, want %q", mapped.Sources(), want)
+	}
+	// PX_LAST is already the name Px is loaded as
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `header "PX_LAST"`) {
+		t.Errorf("warnings = %q, want one about PX_LAST", warnings)
+	}
+
+	var got [][]string
+	for {
+		record, _, err := mapped.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record)
+	}
+	if want := [][]string{{"A1", "10", "500"}, {"A2", "20", ""}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
+
+func TestLoadWithColumnMappings(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	if err := m.SetColumnMappings([]ColumnMapping{
+		{Match: "vendor_a_*", Rename: map[string]string{"Last Price": "PX_LAST"}},
+		{Match: "vendor_*", Trim: true, Case: "upper"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := []string{
+		writeCSV(t, dir, "vendor_a_20250101.csv", "ID_BB_GLOBAL,Last Price\nA1,10\n"),
+		writeCSV(t, dir, "vendor_b_20250101.csv", "ID_BB_GLOBAL, px_last \nA2,20\n"),
+	}
+	for _, filePath := range files {
+		if err := m.LoadCSVFile(filePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string]string{"A1": "10", "A2": "20"} {
+		if asset, err := m.GetAsset(id); err != nil || asset["PX_LAST"] != want {
+			t.Errorf("GetAsset(%s) = %v, %v, want PX_LAST %s", id, asset, err, want)
+		}
+	}
+	for _, def := range m.GetColumnDefinitions() {
+		if def.Name != "PX_LAST" {
+			continue
+		}
+		if want := []string{"Last Price", " px_last "}; !reflect.DeepEqual(def.Headers, want) {
+			t.Errorf("PX_LAST was loaded from headers %q, want %q", def.Headers, want)
+		}
+		return
+	}
+	t.Error("no PX_LAST column was loaded")
+}
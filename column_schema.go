@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ColumnType is the inferred data type of a column
+type ColumnType string
+
+const (
+	ColumnTypeUnknown  ColumnType = ""         // No non-empty values seen yet
+	ColumnTypeInteger  ColumnType = "integer"  // Whole numbers
+	ColumnTypeDecimal  ColumnType = "decimal"  // Numbers with a fractional part or exponent
+	ColumnTypeBoolean  ColumnType = "boolean"  // true/false or Y/N flags
+	ColumnTypeDate     ColumnType = "date"     // Calendar dates in any of dateLayouts
+	ColumnTypeDateTime ColumnType = "datetime" // Timestamps in any of dateTimeLayouts
+	ColumnTypeString   ColumnType = "string"   // Anything else
+)
+
+// schemaSampleRows is the number of rows per file used to infer column types
+const schemaSampleRows = 1000
+
+// dateLayouts are the date formats recognised when inferring and comparing dates
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"01/02/2006",
+	"20060102",
+}
+
+// dateTimeLayouts are the timestamp formats recognised when inferring and comparing datetimes
+var dateTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+}
+
+// ColumnDefinition describes a single column in the schema file
+type ColumnDefinition struct {
+	Name    string     `json:"name"`
+	Type    ColumnType `json:"type"`
+	Headers []string   `json:"headers,omitempty"` // Header names the column was loaded from, where a column mapping renamed them
+}
+
+// ColumnSchema tracks the ordered list of columns and their inferred types
+type ColumnSchema struct {
+	sync.RWMutex
+	order    []string              // Columns in the order they were first seen
+	types    map[string]ColumnType // Inferred type per column
+	headers  map[string][]string   // Original header names of mapped columns, in the order they were seen
+	modified bool                  // Flag to track if the schema was modified since the last save
+}
+
+// columnSchemaFile is the on-disk representation of a ColumnSchema
+type columnSchemaFile struct {
+	Columns []ColumnDefinition `json:"columns"`
+}
+
+// NewColumnSchema creates an empty column schema
+func NewColumnSchema() *ColumnSchema {
+	return &ColumnSchema{
+		order:   []string{},
+		types:   make(map[string]ColumnType),
+		headers: make(map[string][]string),
+	}
+}
+
+// Load reads the schema from a file if it exists
+func (s *ColumnSchema) Load(filePath string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// A missing schema file just means nothing has been loaded yet
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading schema file: %v", err)
+	}
+
+	var file columnSchemaFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("error parsing schema file: %v", err)
+	}
+
+	s.order = s.order[:0]
+	s.types = make(map[string]ColumnType, len(file.Columns))
+	s.headers = make(map[string][]string)
+	for _, col := range file.Columns {
+		if _, exists := s.types[col.Name]; exists {
+			continue
+		}
+		s.order = append(s.order, col.Name)
+		s.types[col.Name] = col.Type
+		if len(col.Headers) > 0 {
+			s.headers[col.Name] = col.Headers
+		}
+	}
+	s.modified = false
+	return nil
+}
+
+// Save writes the schema to a file if it was modified, replacing the old file
+// only once the new one is complete. With sync set it is flushed to stable
+// storage first.
+func (s *ColumnSchema) Save(filePath string, sync bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.modified {
+		return nil
+	}
+
+	file := columnSchemaFile{Columns: make([]ColumnDefinition, 0, len(s.order))}
+	for _, name := range s.order {
+		file.Columns = append(file.Columns, ColumnDefinition{Name: name, Type: s.types[name], Headers: s.headers[name]})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error converting schema to JSON: %v", err)
+	}
+
+	if err := writeFileAtomic(filePath, data, sync); err != nil {
+		return fmt.Errorf("error writing schema file: %v", err)
+	}
+
+	s.modified = false
+	return nil
+}
+
+// AddColumn registers a column with no type information if it is not already known
+func (s *ColumnSchema) AddColumn(name string) {
+	s.Lock()
+	defer s.Unlock()
+	s.addColumnLocked(name)
+}
+
+// addColumnLocked registers a column; the caller must hold the write lock
+func (s *ColumnSchema) addColumnLocked(name string) {
+	if _, exists := s.types[name]; exists {
+		return
+	}
+	s.order = append(s.order, name)
+	s.types[name] = ColumnTypeUnknown
+	s.modified = true
+}
+
+// AddHeader records that a column was loaded from a header of another name
+func (s *ColumnSchema) AddHeader(name, header string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.addColumnLocked(name)
+	if header == name || containsString(s.headers[name], header) {
+		return
+	}
+	s.headers[name] = append(s.headers[name], header)
+	s.modified = true
+}
+
+// MergeType widens the stored type of a column with a type observed in a new file
+func (s *ColumnSchema) MergeType(name string, observed ColumnType) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.addColumnLocked(name)
+	merged := mergeColumnTypes(s.types[name], observed)
+	if merged != s.types[name] {
+		s.types[name] = merged
+		s.modified = true
+	}
+}
+
+// Columns returns the known column names in the order they were first seen
+func (s *ColumnSchema) Columns() []string {
+	s.RLock()
+	defer s.RUnlock()
+	return append([]string(nil), s.order...)
+}
+
+// Definitions returns every column with its type, in column order.
+// Columns without any sampled values are reported as strings.
+func (s *ColumnSchema) Definitions() []ColumnDefinition {
+	s.RLock()
+	defer s.RUnlock()
+
+	defs := make([]ColumnDefinition, 0, len(s.order))
+	for _, name := range s.order {
+		colType := s.types[name]
+		if colType == ColumnTypeUnknown {
+			colType = ColumnTypeString
+		}
+		defs = append(defs, ColumnDefinition{Name: name, Type: colType, Headers: append([]string(nil), s.headers[name]...)})
+	}
+	return defs
+}
+
+// Types returns a snapshot of the column types for use while executing a query
+func (s *ColumnSchema) Types() map[string]ColumnType {
+	s.RLock()
+	defer s.RUnlock()
+
+	types := make(map[string]ColumnType, len(s.types))
+	for name, colType := range s.types {
+		types[name] = colType
+	}
+	return types
+}
+
+// typeInference accumulates the type of each column while sampling rows from one file
+type typeInference struct {
+	types   map[string]ColumnType
+	samples int
+}
+
+// newTypeInference creates an empty per-file type inference
+func newTypeInference() *typeInference {
+	return &typeInference{types: make(map[string]ColumnType)}
+}
+
+// Observe folds a data row into the inferred types, up to schemaSampleRows rows
+func (t *typeInference) Observe(header []string, record []string) {
+	if t.samples >= schemaSampleRows {
+		return
+	}
+	t.samples++
+
+	for i, value := range record {
+		if i >= len(header) || isMissingValue(value) {
+			continue
+		}
+		t.types[header[i]] = mergeColumnTypes(t.types[header[i]], inferValueType(value))
+	}
+}
+
+// isMissingValue reports whether a CSV cell should be treated as having no value
+func isMissingValue(value string) bool {
+	return value == "" || strings.ToLower(value) == "null" || value == "N.A."
+}
+
+// inferValueType returns the narrowest type that can represent a single value
+func inferValueType(value string) ColumnType {
+	value = strings.TrimSpace(value)
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ColumnTypeInteger
+	}
+	if _, ok := parseDecimal(value); ok {
+		return ColumnTypeDecimal
+	}
+	if _, ok := parseBoolean(value); ok {
+		return ColumnTypeBoolean
+	}
+	if _, ok := parseDate(value); ok {
+		return ColumnTypeDate
+	}
+	if _, ok := parseDateTime(value); ok {
+		return ColumnTypeDateTime
+	}
+	return ColumnTypeString
+}
+
+// mergeColumnTypes returns the narrowest type that can represent values of both types
+func mergeColumnTypes(a, b ColumnType) ColumnType {
+	switch {
+	case a == b:
+		return a
+	case a == ColumnTypeUnknown:
+		return b
+	case b == ColumnTypeUnknown:
+		return a
+	case isNumericColumnType(a) && isNumericColumnType(b):
+		return ColumnTypeDecimal
+	case isTemporalColumnType(a) && isTemporalColumnType(b):
+		return ColumnTypeDateTime
+	}
+	return ColumnTypeString
+}
+
+// isNumericColumnType reports whether t is integer or decimal
+func isNumericColumnType(t ColumnType) bool {
+	return t == ColumnTypeInteger || t == ColumnTypeDecimal
+}
+
+// isTemporalColumnType reports whether t is date or datetime
+func isTemporalColumnType(t ColumnType) bool {
+	return t == ColumnTypeDate || t == ColumnTypeDateTime
+}
+
+// parseDecimal parses a finite floating point number
+func parseDecimal(value string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// parseBoolean parses true/false and the Y/N flags used in Bloomberg files
+func parseBoolean(value string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "y":
+		return true, true
+	case "false", "n":
+		return false, true
+	}
+	return false, false
+}
+
+// parseDate parses a date in any of dateLayouts
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseDateTime parses a timestamp in any of dateTimeLayouts
+func parseDateTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// typedValue converts a stored string to a Value of the given column type.
+// Values that do not fit the inferred type are returned as strings.
+func typedValue(raw string, colType ColumnType) Value {
+	switch colType {
+	case ColumnTypeInteger:
+		if i, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64); err == nil {
+			return IntValue(i)
+		}
+		if f, ok := parseDecimal(raw); ok {
+			return FloatValue(f)
+		}
+	case ColumnTypeDecimal:
+		if f, ok := parseDecimal(raw); ok {
+			return FloatValue(f)
+		}
+	case ColumnTypeBoolean:
+		if b, ok := parseBoolean(raw); ok {
+			return BoolValue(b)
+		}
+	case ColumnTypeDate:
+		if t, ok := parseDate(raw); ok {
+			return TimeValue(ValueDate, t, raw)
+		}
+	case ColumnTypeDateTime:
+		if t, ok := parseDateTime(raw); ok {
+			return TimeValue(ValueDateTime, t, raw)
+		}
+		if t, ok := parseDate(raw); ok {
+			return TimeValue(ValueDateTime, t, raw)
+		}
+	}
+	return StringValue(raw)
+}
+
+// TypedRow adapts an asset map to the Row interface, converting values to their column types
+type TypedRow struct {
+	values map[string]string
+	types  map[string]ColumnType
+}
+
+// NewTypedRow wraps an asset using a snapshot of the column types
+func NewTypedRow(values map[string]string, types map[string]ColumnType) TypedRow {
+	return TypedRow{values: values, types: types}
+}
+
+// Get implements Row
+func (r TypedRow) Get(column string) (Value, bool) {
+	raw, exists := r.values[column]
+	if !exists {
+		return NullValue(), false
+	}
+	return typedValue(raw, r.types[column]), true
+}
+
+// Columns implements Row
+func (r TypedRow) Columns() []string {
+	return StringRow(r.values).Columns()
+}
+
+// size estimates the memory used by the row's values
+func (r TypedRow) size() int64 {
+	return assetSize(r.values)
+}
+
+// JSON converts every value of the row to its JSON representation
+func (r TypedRow) JSON() map[string]interface{} {
+	result := make(map[string]interface{}, len(r.values))
+	for column := range r.values {
+		value, _ := r.Get(column)
+		result[column] = value.JSON()
+	}
+	return result
+}
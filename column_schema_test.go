@@ -0,0 +1,156 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInferValueType(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ColumnType
+	}{
+		{"42", ColumnTypeInteger},
+		{"-7", ColumnTypeInteger},
+		{"3.14", ColumnTypeDecimal},
+		{"1e6", ColumnTypeDecimal},
+		{"NaN", ColumnTypeString},
+		{"Y", ColumnTypeBoolean},
+		{"false", ColumnTypeBoolean},
+		{"2024-03-15", ColumnTypeDate},
+		{"03/15/2024", ColumnTypeDate},
+		{"2024-03-15 09:30:00", ColumnTypeDateTime},
+		{"2024-03-15T09:30:00Z", ColumnTypeDateTime},
+		{"Apple Inc", ColumnTypeString},
+	}
+	for _, tt := range tests {
+		if got := inferValueType(tt.value); got != tt.want {
+			t.Errorf("inferValueType(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMergeColumnTypes(t *testing.T) {
+	tests := []struct {
+		a, b, want ColumnType
+	}{
+		{ColumnTypeUnknown, ColumnTypeInteger, ColumnTypeInteger},
+		{ColumnTypeInteger, ColumnTypeInteger, ColumnTypeInteger},
+		{ColumnTypeInteger, ColumnTypeDecimal, ColumnTypeDecimal},
+		{ColumnTypeDate, ColumnTypeDateTime, ColumnTypeDateTime},
+		{ColumnTypeInteger, ColumnTypeBoolean, ColumnTypeString},
+		{ColumnTypeDate, ColumnTypeDecimal, ColumnTypeString},
+	}
+	for _, tt := range tests {
+		if got := mergeColumnTypes(tt.a, tt.b); got != tt.want {
+			t.Errorf("mergeColumnTypes(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+		if got := mergeColumnTypes(tt.b, tt.a); got != tt.want {
+			t.Errorf("mergeColumnTypes(%q, %q) = %q, want %q", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestTypeInferenceSkipsMissingValues(t *testing.T) {
+	header := []string{"Revenue", "Listed", "Name"}
+	inference := newTypeInference()
+	inference.Observe(header, []string{"100", "", "Acme"})
+	inference.Observe(header, []string{"N.A.", "NULL", "Globex"})
+	inference.Observe(header, []string{"2.5", "Y", "Initech"})
+
+	want := map[string]ColumnType{
+		"Revenue": ColumnTypeDecimal,
+		"Listed":  ColumnTypeBoolean,
+		"Name":    ColumnTypeString,
+	}
+	if !reflect.DeepEqual(inference.types, want) {
+		t.Errorf("inferred types = %v, want %v", inference.types, want)
+	}
+}
+
+func TestColumnSchemaSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+
+	schema := NewColumnSchema()
+	schema.MergeType("Revenue", ColumnTypeInteger)
+	schema.MergeType("Revenue", ColumnTypeDecimal)
+	schema.AddColumn("Notes")
+	schema.MergeType("Founded", ColumnTypeDate)
+	if err := schema.Save(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewColumnSchema()
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	want := []ColumnDefinition{
+		{Name: "Revenue", Type: ColumnTypeDecimal},
+		{Name: "Notes", Type: ColumnTypeString},
+		{Name: "Founded", Type: ColumnTypeDate},
+	}
+	if got := loaded.Definitions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Definitions() = %v, want %v", got, want)
+	}
+}
+
+func TestTypedComparison(t *testing.T) {
+	types := map[string]ColumnType{
+		"Revenue": ColumnTypeInteger,
+		"Founded": ColumnTypeDate,
+		"Listed":  ColumnTypeBoolean,
+		"Name":    ColumnTypeString,
+	}
+	row := NewTypedRow(map[string]string{
+		"Revenue": "900",
+		"Founded": "03/15/1999",
+		"Listed":  "Y",
+		"Name":    "Acme",
+	}, types)
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		// Numbers compare numerically, not as text
+		{"Revenue > 1000", false},
+		{"Revenue < 1000", true},
+		{"Revenue = 900.0", true},
+		{"Revenue > '1000'", false},
+		// Dates compare across formats
+		{"Founded < '2000-01-01'", true},
+		{"Founded = '1999-03-15'", true},
+		{"Listed = 'true'", true},
+		{"Name > 'Ab'", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			query, err := ParseSQL("SELECT * FROM BB_ASSETS WHERE " + tt.where)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := EvalCondition(query.Where, row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.where, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedRowKeepsValuesThatDoNotFitTheType(t *testing.T) {
+	row := NewTypedRow(map[string]string{"Revenue": "#N/A", "Founded": "2024-03-15"},
+		map[string]ColumnType{"Revenue": ColumnTypeInteger, "Founded": ColumnTypeDate})
+
+	revenue, _ := row.Get("Revenue")
+	if revenue.Kind != ValueString || revenue.JSON() != "#N/A" {
+		t.Errorf("Revenue = %#v, want the string #N/A", revenue)
+	}
+	founded, _ := row.Get("Founded")
+	if founded.Kind != ValueDate || founded.JSON() != "2024-03-15" {
+		t.Errorf("Founded = %#v, want a date shown as 2024-03-15", founded)
+	}
+}
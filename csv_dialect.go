@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// csvSniffBytes is the amount of a CSV file examined to guess its dialect
+const csvSniffBytes = 64 * 1024
+
+// csvSniffLines is the number of lines examined to guess the delimiter
+const csvSniffLines = 20
+
+// sniffDelimiters are the delimiters a CSV file without a configured dialect may
+// use, in order of preference when several fit equally well
+var sniffDelimiters = []rune{',', '\t', ';', '|'}
+
+// Byte order marks recognised at the start of a CSV file
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// Text encodings CSV files can be read in
+const (
+	encodingUTF8    = "utf-8"
+	encodingLatin1  = "latin-1"
+	encodingUTF16   = "utf-16" // Byte order taken from the byte order mark, little-endian without one
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+)
+
+// CSVDialect describes how the CSV files matching a glob are written. Every
+// setting left out has the value of a plain comma-separated UTF-8 file.
+type CSVDialect struct {
+	Match       string `json:"match"`                  // Glob of the file paths, or the directories, it applies to
+	Delimiter   string `json:"delimiter,omitempty"`    // Character separating fields, "\t" or "tab" for tabs (default: ",")
+	Quote       string `json:"quote,omitempty"`        // Character quoting fields, "none" if fields are never quoted (default: "\"")
+	Escape      string `json:"escape,omitempty"`       // Character escaping a quote or itself inside a field (default: a quote is escaped by doubling it)
+	Comment     string `json:"comment,omitempty"`      // Prefix of lines to ignore
+	Encoding    string `json:"encoding,omitempty"`     // utf-8, latin-1, utf-16, utf-16le or utf-16be (default: utf-8)
+	HeaderRow   int    `json:"header_row,omitempty"`   // Number of lines before the header row, which are ignored
+	TrailerRows int    `json:"trailer_rows,omitempty"` // Number of records at the end of the file to ignore, such as totals
+	LazyQuotes  bool   `json:"lazy_quotes,omitempty"`  // Accept quotes inside unquoted fields and stray quotes in quoted ones
+}
+
+// csvFormat is a dialect ready for reading
+type csvFormat struct {
+	delimiter   rune
+	quote       rune // 0 if fields are never quoted
+	escape      rune // 0 if a quote is escaped by doubling it
+	comment     string
+	encoding    string
+	headerRow   int
+	trailerRows int
+	lazyQuotes  bool
+}
+
+// defaultCSVFormat is the format of a plain comma-separated UTF-8 file
+func defaultCSVFormat() csvFormat {
+	return csvFormat{delimiter: ',', quote: '"', encoding: encodingUTF8}
+}
+
+// String describes a format for the log
+func (f csvFormat) String() string {
+	return fmt.Sprintf("delimiter %q, encoding %s", f.delimiter, f.encoding)
+}
+
+// csvDialectRule is a configured dialect with the files it applies to
+type csvDialectRule struct {
+	match  string
+	format csvFormat
+}
+
+// dialectRune parses a setting that must be a single character
+func dialectRune(name, value string) (rune, error) {
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("%s %q must be a single character", name, value)
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return r, nil
+}
+
+// normalizeEncoding returns the canonical name of an encoding
+func normalizeEncoding(name string) (string, error) {
+	switch strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(name)) {
+	case "", "utf8":
+		return encodingUTF8, nil
+	case "latin1", "iso88591", "l1":
+		return encodingLatin1, nil
+	case "utf16":
+		return encodingUTF16, nil
+	case "utf16le":
+		return encodingUTF16LE, nil
+	case "utf16be":
+		return encodingUTF16BE, nil
+	}
+	return "", fmt.Errorf("unsupported encoding %q, expected utf-8, latin-1, utf-16, utf-16le or utf-16be", name)
+}
+
+// format checks a dialect and converts it for reading
+func (d CSVDialect) format() (csvFormat, error) {
+	format := defaultCSVFormat()
+	if d.Match == "" {
+		return format, errors.New("a CSV dialect needs a match glob")
+	}
+	if _, err := path.Match(filepath.ToSlash(d.Match), ""); err != nil {
+		return format, fmt.Errorf("invalid match glob %q: %v", d.Match, err)
+	}
+
+	var err error
+	switch d.Delimiter {
+	case "":
+	case "\\t", "tab":
+		format.delimiter = '\t'
+	default:
+		if format.delimiter, err = dialectRune("delimiter", d.Delimiter); err != nil {
+			return format, err
+		}
+	}
+	switch d.Quote {
+	case "":
+	case "none":
+		format.quote = 0
+	default:
+		if format.quote, err = dialectRune("quote", d.Quote); err != nil {
+			return format, err
+		}
+	}
+	if d.Escape != "" {
+		if format.escape, err = dialectRune("escape", d.Escape); err != nil {
+			return format, err
+		}
+	}
+	if format.delimiter == format.quote || format.delimiter == format.escape || format.delimiter == '\r' || format.delimiter == '\n' {
+		return format, fmt.Errorf("delimiter %q cannot be a quote, escape or line break", format.delimiter)
+	}
+	if format.encoding, err = normalizeEncoding(d.Encoding); err != nil {
+		return format, err
+	}
+	if d.HeaderRow < 0 || d.TrailerRows < 0 {
+		return format, errors.New("header_row and trailer_rows cannot be negative")
+	}
+	format.comment = d.Comment
+	format.headerRow = d.HeaderRow
+	format.trailerRows = d.TrailerRows
+	format.lazyQuotes = d.LazyQuotes
+	return format, nil
+}
+
+// matchesFile reports whether a glob names a file or one of the directories it
+// is in. A glob without a slash is compared with every part of the path, so
+// "*_eu.csv" matches by file name and "europe" any file under a directory of that name.
+func matchesFile(glob, filePath string) bool {
+	glob = filepath.ToSlash(filepath.Clean(glob))
+	filePath = filepath.ToSlash(filepath.Clean(filePath))
+	if !strings.Contains(glob, "/") {
+		for _, part := range strings.Split(filePath, "/") {
+			if matched, _ := path.Match(glob, part); matched {
+				return true
+			}
+		}
+		return false
+	}
+	for p := filePath; p != "." && p != "/"; p = path.Dir(p) {
+		if matched, _ := path.Match(glob, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCSVDialects sets the dialects of CSV files. The first dialect whose glob
+// matches a file is used to read it; files no dialect matches have their
+// delimiter and encoding guessed from their first lines.
+func (j *JSONAssetManager) SetCSVDialects(dialects []CSVDialect) error {
+	rules := make([]csvDialectRule, 0, len(dialects))
+	for _, dialect := range dialects {
+		format, err := dialect.format()
+		if err != nil {
+			return fmt.Errorf("CSV dialect %q: %v", dialect.Match, err)
+		}
+		rules = append(rules, csvDialectRule{match: dialect.Match, format: format})
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.csvDialects = rules
+	return nil
+}
+
+// csvFormatFor returns the format of the first configured dialect matching a file
+func (j *JSONAssetManager) csvFormatFor(filePath string) (csvFormat, string, bool) {
+	j.RLock()
+	defer j.RUnlock()
+	for _, rule := range j.csvDialects {
+		if matchesFile(rule.match, filePath) {
+			return rule.format, rule.match, true
+		}
+	}
+	return csvFormat{}, "", false
+}
+
+// sniffCSVFormat guesses the encoding and delimiter of a CSV file from its first
+// bytes without consuming them. The reader's buffer must hold csvSniffBytes.
+func sniffCSVFormat(reader *bufio.Reader) csvFormat {
+	format := defaultCSVFormat()
+	sample, _ := reader.Peek(csvSniffBytes)
+	truncated := len(sample) == csvSniffBytes
+
+	switch {
+	case bytes.HasPrefix(sample, utf8BOM):
+	case bytes.HasPrefix(sample, utf16LEBOM), bytes.HasPrefix(sample, utf16BEBOM):
+		format.encoding = encodingUTF16
+	default:
+		// Text in UTF-16 without a byte order mark is mostly zero bytes on one side
+		var zeros [2]int
+		for i := 0; i < len(sample) && i < 1024; i++ {
+			if sample[i] == 0 {
+				zeros[i%2]++
+			}
+		}
+		units := min(len(sample), 1024) / 2
+		switch {
+		case units > 0 && zeros[1] > units/2:
+			format.encoding = encodingUTF16LE
+		case units > 0 && zeros[0] > units/2:
+			format.encoding = encodingUTF16BE
+		case !validUTF8Prefix(sample):
+			format.encoding = encodingLatin1
+		}
+	}
+
+	text := string(decodeText(sample, format.encoding))
+	lines := strings.Split(text, "\n")
+	if truncated && len(lines) > 1 {
+		// The last line may be cut short
+		lines = lines[:len(lines)-1]
+	}
+	format.delimiter = sniffDelimiter(lines, format.quote)
+	return format
+}
+
+// validUTF8Prefix reports whether data is valid UTF-8, allowing a character cut
+// short at its end
+func validUTF8Prefix(data []byte) bool {
+	for cut := 0; cut < utf8.UTFMax && cut <= len(data); cut++ {
+		if utf8.Valid(data[:len(data)-cut]) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffDelimiter picks the delimiter that splits the first lines of a file into
+// the same number of fields, preferring the one giving the most fields. Without
+// one, the delimiter found most often in the header is used, and a comma if
+// the header has none.
+func sniffDelimiter(lines []string, quote rune) rune {
+	var sample []string
+	for _, line := range lines {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			sample = append(sample, line)
+		}
+		if len(sample) == csvSniffLines {
+			break
+		}
+	}
+	if len(sample) == 0 {
+		return ','
+	}
+
+	// Count each delimiter outside quoted fields
+	count := func(line string, delimiter rune) int {
+		n, quoted := 0, false
+		for _, r := range line {
+			switch {
+			case r == quote && quote != 0:
+				quoted = !quoted
+			case r == delimiter && !quoted:
+				n++
+			}
+		}
+		return n
+	}
+
+	best, bestCount := rune(0), 0
+	for _, delimiter := range sniffDelimiters {
+		first := count(sample[0], delimiter)
+		consistent := first > 0
+		for _, line := range sample[1:] {
+			if count(line, delimiter) != first {
+				consistent = false
+				break
+			}
+		}
+		if consistent && first > bestCount {
+			best, bestCount = delimiter, first
+		}
+	}
+	if best != 0 {
+		return best
+	}
+
+	best, bestCount = ',', 0
+	for _, delimiter := range sniffDelimiters {
+		if n := count(sample[0], delimiter); n > bestCount {
+			best, bestCount = delimiter, n
+		}
+	}
+	return best
+}
+
+// decodeText converts a complete piece of text in an encoding to UTF-8
+func decodeText(data []byte, encoding string) []byte {
+	decode, order := textDecoder(encoding, data)
+	if decode == nil {
+		return bytes.TrimPrefix(data, utf8BOM)
+	}
+	if order != nil {
+		data = bytes.TrimPrefix(bytes.TrimPrefix(data, utf16LEBOM), utf16BEBOM)
+	}
+	text, _ := decode(data)
+	return text
+}
+
+// textDecoder returns the function converting text in an encoding to UTF-8, nil
+// for UTF-8 itself, and for UTF-16 the byte order, taken from the byte order
+// mark at the start of data when the encoding does not name one
+func textDecoder(encoding string, start []byte) (func([]byte) ([]byte, int), binary.ByteOrder) {
+	switch encoding {
+	case encodingLatin1:
+		return decodeLatin1, nil
+	case encodingUTF16, encodingUTF16LE, encodingUTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		if encoding == encodingUTF16BE || (encoding == encodingUTF16 && bytes.HasPrefix(start, utf16BEBOM)) {
+			order = binary.BigEndian
+		}
+		return func(src []byte) ([]byte, int) { return decodeUTF16(src, order) }, order
+	}
+	return nil, nil
+}
+
+// decodeLatin1 converts Latin-1 text to UTF-8. Every byte is a character.
+func decodeLatin1(src []byte) ([]byte, int) {
+	text := make([]byte, 0, len(src)+len(src)/4)
+	for _, b := range src {
+		text = utf8.AppendRune(text, rune(b))
+	}
+	return text, len(src)
+}
+
+// decodeUTF16 converts as much UTF-16 text as is complete to UTF-8 and returns
+// the number of bytes converted; a trailing partial character is left for later
+func decodeUTF16(src []byte, order binary.ByteOrder) ([]byte, int) {
+	text := make([]byte, 0, len(src))
+	i := 0
+	for i+1 < len(src) {
+		unit := rune(order.Uint16(src[i:]))
+		if utf16.IsSurrogate(unit) {
+			if i+3 >= len(src) {
+				break
+			}
+			text = utf8.AppendRune(text, utf16.DecodeRune(unit, rune(order.Uint16(src[i+2:]))))
+			i += 4
+			continue
+		}
+		text = utf8.AppendRune(text, unit)
+		i += 2
+	}
+	return text, i
+}
+
+// decodingReader converts text to UTF-8 as it is read
+type decodingReader struct {
+	source io.Reader
+	decode func([]byte) ([]byte, int)
+	buf    []byte
+	in     []byte // Bytes read but not yet converted
+	out    []byte // Converted text not yet returned
+	err    error
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			if len(d.in) > 0 {
+				// A partial character at the end of the file
+				d.in = nil
+				d.out = []byte(string(utf8.RuneError))
+				break
+			}
+			return 0, d.err
+		}
+		n, err := d.source.Read(d.buf)
+		d.in = append(d.in, d.buf[:n]...)
+		d.err = err
+		text, used := d.decode(d.in)
+		d.out = text
+		d.in = append(d.in[:0], d.in[used:]...)
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// openCSVText returns the text of a CSV file in UTF-8, without its byte order
+// mark and the lines before its header row
+func openCSVText(reader *bufio.Reader, format csvFormat) (*bufio.Reader, error) {
+	start, _ := reader.Peek(len(utf8BOM))
+	decode, order := textDecoder(format.encoding, start)
+	var text io.Reader = reader
+	switch {
+	case decode == nil:
+		if bytes.HasPrefix(start, utf8BOM) {
+			reader.Discard(len(utf8BOM))
+		}
+	case order != nil && (bytes.HasPrefix(start, utf16LEBOM) || bytes.HasPrefix(start, utf16BEBOM)):
+		reader.Discard(len(utf16LEBOM))
+	}
+	if decode != nil {
+		text = &decodingReader{source: reader, decode: decode, buf: make([]byte, 32*1024)}
+	}
+
+	lines := bufio.NewReader(text)
+	for i := 0; i < format.headerRow; i++ {
+		if _, err := lines.ReadString('\n'); err != nil {
+			return nil, fmt.Errorf("file ends before header row %d", format.headerRow)
+		}
+	}
+	return lines, nil
+}
+
+// csvRecord is a record read ahead to tell whether it is a trailer row
+type csvRecord struct {
+	fields []string
+	line   int
+	err    error
+}
+
+// csvRecordReader reads the records of a CSV file after its header row
+type csvRecordReader struct {
+	header  []string
+	read    func() ([]string, int, error) // Reads the next record with the line of the file it starts on
+	trailer int                           // Records at the end of the file to ignore
+	ahead   []csvRecord                   // Records read but held back as possible trailer rows
+}
+
+// newCSVRecordReader reads the header of a CSV file in a format. A file in the
+// standard quoting style is read by encoding/csv, anything else by splitCSVRecord.
+func newCSVRecordReader(reader *bufio.Reader, format csvFormat) (*csvRecordReader, error) {
+	text, err := openCSVText(reader, format)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %v", err)
+	}
+	records := &csvRecordReader{trailer: format.trailerRows}
+
+	if format.quote == '"' && (format.escape == 0 || format.escape == '"') && utf8.RuneCountInString(format.comment) <= 1 {
+		csvReader := csv.NewReader(text)
+		csvReader.Comma = format.delimiter
+		if format.comment != "" {
+			csvReader.Comment, _ = utf8.DecodeRuneInString(format.comment)
+		}
+		csvReader.LazyQuotes = format.lazyQuotes
+		// Rows with missing or extra fields are checked against the header below
+		csvReader.FieldsPerRecord = -1
+		records.read = func() ([]string, int, error) {
+			fields, err := csvReader.Read()
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				parseErr.StartLine += format.headerRow
+				parseErr.Line += format.headerRow
+				return nil, parseErr.StartLine, &skippedRecord{err}
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			line, _ := csvReader.FieldPos(0)
+			return fields, line + format.headerRow, nil
+		}
+	} else {
+		splitter := &csvSplitter{reader: text, format: format, line: format.headerRow}
+		records.read = splitter.Read
+	}
+
+	header, _, err := records.read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %v", err)
+	}
+	records.header = header
+	return records, nil
+}
+
+// Header returns the column names in the header row of the file
+func (c *csvRecordReader) Header() []string {
+	return c.header
+}
+
+// Read returns the next record of the file. A record that cannot be parsed, or
+// has values past the last column of the header, is a skippedRecord. A record
+// with fewer fields than the header just has no values for the last columns.
+func (c *csvRecordReader) Read() ([]string, int, error) {
+	// Hold back as many records as the file has trailer rows
+	for len(c.ahead) <= c.trailer {
+		fields, line, err := c.read()
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		var skipped *skippedRecord
+		if err != nil && !errors.As(err, &skipped) {
+			return nil, 0, err
+		}
+		c.ahead = append(c.ahead, csvRecord{fields: fields, line: line, err: err})
+	}
+	record := c.ahead[0]
+	c.ahead = c.ahead[1:]
+	if record.err != nil {
+		return nil, record.line, record.err
+	}
+
+	for _, extra := range record.fields[min(len(record.fields), len(c.header)):] {
+		if extra != "" {
+			return nil, record.line, &skippedRecord{fmt.Errorf("record on line %d has %d fields, the header has %d",
+				record.line, len(record.fields), len(c.header))}
+		}
+	}
+	return record.fields, record.line, nil
+}
+
+// csvSplitter splits CSV records with any quote and escape character, which
+// encoding/csv cannot. Quoted fields may span lines.
+type csvSplitter struct {
+	reader *bufio.Reader
+	format csvFormat
+	line   int // Line of the file last read
+}
+
+// readLine returns the next line without its line break
+func (s *csvSplitter) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	s.line++
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// Read returns the fields of the next record and the line it starts on,
+// skipping empty lines and comments
+func (s *csvSplitter) Read() ([]string, int, error) {
+	var line string
+	for {
+		var err error
+		if line, err = s.readLine(); err != nil {
+			return nil, 0, err
+		}
+		if line != "" && (s.format.comment == "" || !strings.HasPrefix(line, s.format.comment)) {
+			break
+		}
+	}
+	start := s.line
+	quote, escape, delimiter := s.format.quote, s.format.escape, s.format.delimiter
+
+	var fields []string
+	var field strings.Builder
+	quoted := false // Inside a quoted field
+	closed := false // After the closing quote of a field
+	for {
+		runes := []rune(line)
+		for i := 0; i < len(runes); i++ {
+			r := runes[i]
+			switch {
+			case quoted && escape != 0 && r == escape && i+1 < len(runes) && (runes[i+1] == quote || runes[i+1] == escape):
+				field.WriteRune(runes[i+1])
+				i++
+			case quoted && r == quote && escape == 0 && i+1 < len(runes) && runes[i+1] == quote:
+				field.WriteRune(quote)
+				i++
+			case quoted && r == quote:
+				quoted, closed = false, true
+			case quoted:
+				field.WriteRune(r)
+			case r == delimiter:
+				fields = append(fields, field.String())
+				field.Reset()
+				closed = false
+			case closed && !s.format.lazyQuotes:
+				return nil, start, &skippedRecord{fmt.Errorf("record on line %d: extraneous %q after a quoted field", start, r)}
+			case r == quote && quote != 0 && field.Len() == 0 && !closed:
+				quoted = true
+			case r == quote && quote != 0 && !s.format.lazyQuotes:
+				return nil, start, &skippedRecord{fmt.Errorf("record on line %d: bare %q in an unquoted field", start, r)}
+			case !closed && escape != 0 && escape != quote && r == escape && i+1 < len(runes):
+				field.WriteRune(runes[i+1])
+				i++
+			default:
+				field.WriteRune(r)
+			}
+		}
+		if !quoted {
+			break
+		}
+
+		// The quoted field continues on the next line
+		next, err := s.readLine()
+		if err == io.EOF {
+			return nil, start, &skippedRecord{fmt.Errorf("record on line %d: quoted field is not closed", start)}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		field.WriteByte('\n')
+		line = next
+	}
+	return append(fields, field.String()), start, nil
+}
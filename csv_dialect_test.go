@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// utf16LE encodes text as UTF-16 little-endian
+func utf16LE(text string) []byte {
+	var buf bytes.Buffer
+	for _, unit := range utf16.Encode([]rune(text)) {
+		binary.Write(&buf, binary.LittleEndian, unit)
+	}
+	return buf.Bytes()
+}
+
+func TestSniffCSVFormat(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		wantDelimiter rune
+		wantEncoding  string
+	}{
+		{name: "comma", data: []byte("ID,Price\nA,1.5\nB,2.5\n"), wantDelimiter: ',', wantEncoding: encodingUTF8},
+		{name: "semicolon with decimal commas", data: []byte("ID;Price\nA;1,5\nB;2,5\n"), wantDelimiter: ';', wantEncoding: encodingUTF8},
+		{name: "tab", data: []byte("ID\tName\tPrice\nA\tX, Y\t1\n"), wantDelimiter: '\t', wantEncoding: encodingUTF8},
+		{name: "pipe", data: []byte("ID|Price\r\nA|1\r\n"), wantDelimiter: '|', wantEncoding: encodingUTF8},
+		{name: "delimiters inside quotes", data: []byte("ID;Name\nA;\"Smith, J\"\nB;\"Jones, K\"\n"), wantDelimiter: ';', wantEncoding: encodingUTF8},
+		{name: "inconsistent lines use the header", data: []byte("ID;Name;Price\nA;B\nC\n"), wantDelimiter: ';', wantEncoding: encodingUTF8},
+		{name: "no delimiter", data: []byte("ID\nA\n"), wantDelimiter: ',', wantEncoding: encodingUTF8},
+		{name: "UTF-8 byte order mark", data: append([]byte{0xEF, 0xBB, 0xBF}, "ID;Price\nA;1\n"...), wantDelimiter: ';', wantEncoding: encodingUTF8},
+		{name: "UTF-16 with byte order mark", data: append([]byte{0xFF, 0xFE}, utf16LE("ID\tPrice\nA\t1\n")...), wantDelimiter: '\t', wantEncoding: encodingUTF16},
+		{name: "UTF-16 without byte order mark", data: utf16LE("ID;Price\nA;1\n"), wantDelimiter: ';', wantEncoding: encodingUTF16LE},
+		{name: "latin-1", data: []byte("ID,Name\nA,Soci\xe9t\xe9\n"), wantDelimiter: ',', wantEncoding: encodingLatin1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(bytes.NewReader(tt.data), csvSniffBytes)
+			format := sniffCSVFormat(reader)
+			if format.delimiter != tt.wantDelimiter || format.encoding != tt.wantEncoding {
+				t.Errorf("sniffed %s, want delimiter %q, encoding %s", format, tt.wantDelimiter, tt.wantEncoding)
+			}
+			// Sniffing leaves the data to be read
+			if rest, _ := io.ReadAll(reader); !bytes.Equal(rest, tt.data) {
+				t.Error("sniffing consumed the start of the file")
+			}
+		})
+	}
+}
+
+func TestMatchesFile(t *testing.T) {
+	tests := []struct {
+		glob     string
+		filePath string
+		want     bool
+	}{
+		{glob: "*_eu.csv", filePath: "data/prices_eu.csv", want: true},
+		{glob: "*_eu.csv", filePath: "data/prices_us.csv", want: false},
+		{glob: "europe", filePath: "data/europe/prices.csv", want: true},
+		{glob: "europe", filePath: "data/european/prices.csv", want: false},
+		{glob: "data/europe", filePath: "data/europe/2025/prices.csv", want: true},
+		{glob: "data/*/prices.csv", filePath: "data/europe/prices.csv", want: true},
+		{glob: "other/europe", filePath: "data/europe/prices.csv", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.filePath, func(t *testing.T) {
+			if got := matchesFile(tt.glob, tt.filePath); got != tt.want {
+				t.Errorf("matchesFile(%q, %q) = %v, want %v", tt.glob, tt.filePath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVRecordReader(t *testing.T) {
+	tests := []struct {
+		name       string
+		dialect    CSVDialect
+		data       string
+		wantHeader []string
+		want       [][]string
+	}{
+		{
+			name:       "plain",
+			data:       "ID,Name\nA,\"Smith, J\"\nB,\"say \"\"hi\"\"\"\n",
+			wantHeader: []string{"ID", "Name"},
+			want:       [][]string{{"A", "Smith, J"}, {"B", `say "hi"`}},
+		},
+		{
+			name:       "title lines, comments and a total",
+			dialect:    CSVDialect{Delimiter: ";", Comment: "#", HeaderRow: 2, TrailerRows: 1},
+			data:       "Daily prices\nGenerated today\nID;Price\n# no prices for C\nA;1,5\nB;2,5\nTotal;4\n",
+			wantHeader: []string{"ID", "Price"},
+			want:       [][]string{{"A", "1,5"}, {"B", "2,5"}},
+		},
+		{
+			name:       "escape character",
+			dialect:    CSVDialect{Delimiter: "tab", Escape: "\\"},
+			data:       "ID\tName\nA\t\"say \\\"hi\\\"\"\n",
+			wantHeader: []string{"ID", "Name"},
+			want:       [][]string{{"A", `say "hi"`}},
+		},
+		{
+			name:       "unquoted",
+			dialect:    CSVDialect{Delimiter: "|", Quote: "none"},
+			data:       "ID|Name\nA|\"quoted\"\n",
+			wantHeader: []string{"ID", "Name"},
+			want:       [][]string{{"A", `"quoted"`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.dialect.Match = "*.csv"
+			format, err := tt.dialect.format()
+			if err != nil {
+				t.Fatal(err)
+			}
+			records, err := newCSVRecordReader(bufio.NewReaderSize(strings.NewReader(tt.data), csvSniffBytes), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header := records.Header(); !reflect.DeepEqual(header, tt.wantHeader) {
+				t.Errorf("header = %q, want %q", header, tt.wantHeader)
+			}
+			var got [][]string
+			for {
+				record, _, err := records.Read()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, record)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"compress/gzip"
 	"path/filepath"
+	"time"
 )
 
 // DataDictionary represents the in-memory data structure for BB_ASSETS
@@ -194,19 +195,54 @@ func (d *DataDictionary) LoadFiles(filePaths []string) error {
 	return nil
 }
 
-// ExecuteSQLQuery executes a SQL query against the data dictionary
-func (d *DataDictionary) ExecuteSQLQuery(sqlQuery string) ([]map[string]string, error) {
-	// Parse the SQL query
-	query, err := ParseSQL(sqlQuery)
+// ExecuteSQLQuery executes a SQL query with optional placeholder params against the data dictionary.
+// EXPLAIN and EXPLAIN ANALYZE report the plan as JSONAssetManager.ExecuteSQLQuery does.
+func (d *DataDictionary) ExecuteSQLQuery(sqlQuery string, params *QueryParams) (*QueryResult, error) {
+	stats := &QueryStats{}
+	start := time.Now()
+	
+	// Parse the SQL query, binding any placeholders to their values
+	query, err := ParseSQLWithParams(sqlQuery, params)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing SQL query: %v", err)
+		return nil, fmt.Errorf("error parsing SQL query: %w", err)
 	}
+	mark := stats.timeStage("parse", start)
 	
 	// Check if the table is BB_ASSETS
 	if query.FromTable != "BB_ASSETS" {
 		return nil, fmt.Errorf("unknown table: %s", query.FromTable)
 	}
 	
+	// The data dictionary keeps only the current values
+	if query.AsOf != "" {
+		return nil, queryErrorf("AS OF is not supported by the in-memory data dictionary")
+	}
+	
+	// Resolve column names case-insensitively to their canonical spelling
+	if err := query.Bind(NewColumnCatalog(append([]string{"ID_BB_GLOBAL"}, d.Columns...))); err != nil {
+		return nil, err
+	}
+	stats.timeStage("bind", mark)
+	
+	plan := newQueryPlan(sqlQuery, query, AccessPath{
+		Type:   AccessMemoryScan,
+		Detail: "examine every row of the in-memory data dictionary",
+	}, int64(len(d.Data)))
+	if query.Explain && !query.Analyze {
+		return &QueryResult{Rows: []map[string]interface{}{}, Plan: plan}, nil
+	}
+	
 	// Execute the query
-	return ExecuteQuery(query, d.Data)
+	result, err := ExecuteQuery(query, d.Data, stats)
+	if err != nil {
+		return nil, err
+	}
+	
+	stats.RowsReturned = len(result.Rows)
+	stats.ElapsedMs = durationMs(time.Since(start))
+	if query.Explain {
+		plan.Stats = stats
+		result.Plan = plan
+	}
+	return result, nil
 }
@@ -15,9 +15,261 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/api/assets/{id}": {
+            "get": {
+                "description": "Returns a single asset by its ID_BB_GLOBAL, reading only its own file.\nUse the columns parameter to return only some columns; requested columns that do not exist are listed in warnings.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assets"
+                ],
+                "summary": "Get an asset",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID_BB_GLOBAL of the asset",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated list of columns to return",
+                        "name": "columns",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Table the asset is in, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.AssetResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "None of the requested columns exist, or unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Asset not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error reading the asset",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/assets/{id}/history": {
+            "get": {
+                "description": "Returns every value a column of an asset has had, oldest effective date first, each with the lineage of the file it was loaded from.\nAssets stored before history was kept report their current value until it is first replaced.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assets"
+                ],
+                "summary": "Get the history of a column of an asset",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID_BB_GLOBAL of the asset",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Column to return the history of",
+                        "name": "column",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Table the asset is in, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.AssetHistoryResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Missing or unknown column, or unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Asset not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error reading the history",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/assets/{id}/lineage": {
+            "get": {
+                "description": "Returns, for each column of an asset, where its current value came from: the effective date,\nthe CSV file, the S3 bucket, key and version it was downloaded from, the line of the file and the load run.\nValues loaded before lineage was recorded only report their effective date.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assets"
+                ],
+                "summary": "Get the lineage of an asset",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID_BB_GLOBAL of the asset",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated list of columns to report",
+                        "name": "columns",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Table the asset is in, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.AssetLineageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "None of the requested columns exist, or unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Asset not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/assets:batchGet": {
+            "post": {
+                "description": "Returns the assets with the given ID_BB_GLOBAL values in a single call, reading only their files.\nIDs without an asset are listed in missing. At most 10000 IDs can be requested at once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assets"
+                ],
+                "summary": "Get several assets",
+                "parameters": [
+                    {
+                        "description": "IDs and columns to return",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchGetRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchGetResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body, none of the requested columns exist or unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error reading an asset",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/cache": {
+            "get": {
+                "description": "Returns the number and estimated size of the assets held in memory, how many are waiting to be written,\nand how many lookups were answered from memory rather than by reading an asset file",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "index"
+                ],
+                "summary": "Get asset cache statistics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Table to report the cache of, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CacheStats"
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/api/columns": {
             "get": {
-                "description": "Returns the list of all columns available in the data_matrix table",
+                "description": "Returns the list of all columns available in the data_matrix table\nalong with the type inferred for each column (integer, decimal, boolean, date, datetime or string)\nColumns a column mapping renamed list the header names they were loaded from.",
                 "produces": [
                     "application/json"
                 ],
@@ -25,6 +277,216 @@ const docTemplate = `{
                     "columns"
                 ],
                 "summary": "Get all available columns",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Table to list the columns of, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/diff": {
+            "get": {
+                "description": "Lists, for every asset that differs, the columns added, removed and changed between two points,\neach a date (YYYYMMDD or YYYY-MM-DD, comparing the values as of that date) or a load run ID\n(comparing the values loaded by that run and earlier ones).\nThe response is streamed as it is computed, so large diffs start arriving at once.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assets"
+                ],
+                "summary": "Compare two dates or load runs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Earlier date or load run",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Later date or load run",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated list of columns to compare",
+                        "name": "columns",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Regular expression the ID_BB_GLOBAL of compared assets must match",
+                        "name": "id_pattern",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Table to compare, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DiffResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Missing or invalid parameter, unknown load run or unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/index": {
+            "get": {
+                "description": "Returns information about the asset index including effective dates",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "index"
+                ],
+                "summary": "Get index information",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Table to describe the index of, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/loads": {
+            "get": {
+                "description": "Returns the recorded load runs, newest first, with the files each loaded and the rows read,\nupdated and skipped. Files that had not changed since an earlier run are counted in files_unchanged.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "loads"
+                ],
+                "summary": "List load runs",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of runs to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Table to list the load runs of, BB_ASSETS if omitted",
+                        "name": "table",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LoadsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid limit or unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/loads:reload": {
+            "post": {
+                "description": "Processes files loaded by an earlier run again as a new load run, even if they have not changed.\nOnly files recorded by an earlier run can be reloaded. Queries wait until the run finishes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "loads"
+                ],
+                "summary": "Reload files",
+                "parameters": [
+                    {
+                        "description": "Files to reload",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ReloadRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LoadRunSummary"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body, a file that was never loaded or files of different tables",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/progress": {
+            "get": {
+                "description": "Returns the current progress status of file processing, row enumeration, and idle status",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "progress"
+                ],
+                "summary": "Get progress information",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -36,57 +498,550 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/query": {
-            "post": {
-                "description": "Execute a SQL query against the data_matrix table with optional filtering and pagination\nTo select all columns (equivalent to SELECT * FROM data_matrix), you can either:\n1) Omit the columns field entirely\n2) Set columns to an empty array\n3) Explicitly use [\"*\"] as the columns value\nAll three approaches will return all columns for the matching rows.\nColumn names are case-insensitive, so you can use \"revenue\", \"REVENUE\", or \"Revenue\" interchangeably.",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "query"
-                ],
-                "summary": "Query the data_matrix table",
-                "parameters": [
-                    {
-                        "description": "Query parameters",
-                        "name": "query",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.QueryRequest"
-                        }
+        "/api/query": {
+            "post": {
+                "description": "Execute a SQL query against the data_matrix table with optional filtering and pagination\nTo select all columns (equivalent to SELECT * FROM data_matrix), you can either:\n1) Omit the columns field entirely\n2) Set columns to an empty array\n3) Explicitly use [\"*\"] as the columns value\nAll three approaches will return all columns for the matching rows.\nColumn names are case-insensitive, so you can use \"revenue\", \"REVENUE\", or \"Revenue\" interchangeably.\nRequested columns that do not exist are left out and listed in the warnings field of the response.\nUse order_by, limit and offset to sort and page through results; total is the number of matching rows before paging.\nUse group_by and having with aggregate columns such as COUNT(*) or AVG(MarketCap) to summarise groups of rows.\nSet explain to true to also get the query plan: the parsed query, access path, estimated rows and, per stage, where the time went.\nUse join to add other tables with JOIN or LEFT JOIN clauses; columns of every table can then be selected, filtered and sorted on.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "query"
+                ],
+                "summary": "Query the data_matrix table",
+                "parameters": [
+                    {
+                        "description": "Query parameters",
+                        "name": "query",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.QueryRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.QueryResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body, syntax error, unknown column or unknown table",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Query error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/tables": {
+            "get": {
+                "description": "Returns the tables that can be queried: BB_ASSETS and those defined in the configuration,\neach with its key columns, the directories its files are loaded from, where it is stored and its size.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "columns"
+                ],
+                "summary": "List tables",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TablesResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "main.AccessPath": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "description": "Human-readable description of the path",
+                    "type": "string"
+                },
+                "type": {
+                    "description": "Kind of path, e.g. full_scan",
+                    "type": "string"
+                }
+            }
+        },
+        "main.AssetHistoryResponse": {
+            "type": "object",
+            "properties": {
+                "column": {
+                    "type": "string"
+                },
+                "history": {
+                    "description": "Every value the column has had, oldest effective date first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HistoryPoint"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.AssetLineageResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "lineage": {
+                    "description": "Lineage of each column's current value, keyed by column",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.CellLineage"
+                    }
+                },
+                "warnings": {
+                    "description": "Requested columns that do not exist and were left out",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.AssetResponse": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "description": "The asset, with values converted to their column types",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "warnings": {
+                    "description": "Requested columns that do not exist and were left out",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.BatchGetRequest": {
+            "type": "object",
+            "properties": {
+                "columns": {
+                    "description": "Optional list of columns to return, all columns if empty or omitted. The key columns are always returned. Column names are case-insensitive",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "Company",
+                        "Revenue"
+                    ]
+                },
+                "ids": {
+                    "description": "IDs of the assets to return: ID_BB_GLOBAL values, or the key values of the table joined by \"|\"",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "BBG000B9XRY4",
+                        "BBG000BPH459"
+                    ]
+                },
+                "table": {
+                    "description": "Optional table the assets are in, as listed by /api/tables. Omitted reads BB_ASSETS",
+                    "type": "string",
+                    "example": "BB_ASSETS"
+                }
+            }
+        },
+        "main.BatchGetResponse": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "Number of assets returned",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "The assets that were found, in the order requested",
+                    "type": "array",
+                    "items": {
+                        "type": "object",
+                        "additionalProperties": true
+                    }
+                },
+                "missing": {
+                    "description": "Requested IDs that have no asset",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "warnings": {
+                    "description": "Requested columns that do not exist and were left out",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.CacheStats": {
+            "type": "object",
+            "properties": {
+                "budget_bytes": {
+                    "description": "Memory the cache may use",
+                    "type": "integer"
+                },
+                "bytes": {
+                    "description": "Estimated memory used by the cached assets",
+                    "type": "integer"
+                },
+                "dirty_entries": {
+                    "description": "Assets changed in memory and not yet written",
+                    "type": "integer"
+                },
+                "entries": {
+                    "description": "Assets held in memory",
+                    "type": "integer"
+                },
+                "evictions": {
+                    "description": "Assets dropped to stay within the budget",
+                    "type": "integer"
+                },
+                "flushes": {
+                    "description": "Times every changed asset was written",
+                    "type": "integer"
+                },
+                "hit_rate": {
+                    "description": "Hits as a fraction of all lookups",
+                    "type": "number"
+                },
+                "hits": {
+                    "description": "Lookups answered from memory",
+                    "type": "integer"
+                },
+                "misses": {
+                    "description": "Lookups that had to read the asset file",
+                    "type": "integer"
+                },
+                "writes": {
+                    "description": "Asset files written, on eviction or flush",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.CellLineage": {
+            "type": "object",
+            "properties": {
+                "effective_date": {
+                    "type": "string"
+                },
+                "file": {
+                    "description": "Local path of the CSV file",
+                    "type": "string"
+                },
+                "load_run": {
+                    "description": "ID of the load run that read the file",
+                    "type": "string"
+                },
+                "row": {
+                    "description": "Line of the file the value was read from, the header being line 1",
+                    "type": "integer"
+                },
+                "s3_bucket": {
+                    "description": "Bucket the file was downloaded from",
+                    "type": "string"
+                },
+                "s3_key": {
+                    "description": "Key of the S3 object",
+                    "type": "string"
+                },
+                "s3_version_id": {
+                    "description": "Version of the S3 object, if the bucket is versioned",
+                    "type": "string"
+                }
+            }
+        },
+        "main.DiffEntry": {
+            "type": "object",
+            "properties": {
+                "added": {
+                    "description": "Columns with a value only at the later point",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.HistoryPoint"
+                    }
+                },
+                "changed": {
+                    "description": "Columns whose value differs",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.ValueChange"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "removed": {
+                    "description": "Columns with a value only at the earlier point",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.HistoryPoint"
+                    }
+                }
+            }
+        },
+        "main.DiffResponse": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "Number of entries in diffs",
+                    "type": "integer"
+                },
+                "diffs": {
+                    "description": "One entry per changed asset",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DiffEntry"
                     }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/main.QueryResponse"
-                        }
-                    },
-                    "400": {
-                        "description": "Invalid request body",
-                        "schema": {
-                            "type": "string"
+                },
+                "error": {
+                    "description": "Why the diff stopped early, if it did",
+                    "type": "string"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.FailedFile": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.FileLoad": {
+            "type": "object",
+            "properties": {
+                "effective_date": {
+                    "type": "string"
+                },
+                "load_run": {
+                    "type": "string"
+                },
+                "loaded_at": {
+                    "type": "string"
+                },
+                "mtime": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "rows_loaded": {
+                    "description": "Rows with an ID_BB_GLOBAL",
+                    "type": "integer"
+                },
+                "rows_skipped": {
+                    "description": "Rows without an ID or that could not be read",
+                    "type": "integer"
+                },
+                "rows_updated": {
+                    "description": "Rows that changed at least one value",
+                    "type": "integer"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.HistoryPoint": {
+            "type": "object",
+            "properties": {
+                "effective_date": {
+                    "type": "string"
+                },
+                "file": {
+                    "description": "Local path of the CSV file",
+                    "type": "string"
+                },
+                "load_run": {
+                    "description": "ID of the load run that read the file",
+                    "type": "string"
+                },
+                "row": {
+                    "description": "Line of the file the value was read from, the header being line 1",
+                    "type": "integer"
+                },
+                "s3_bucket": {
+                    "description": "Bucket the file was downloaded from",
+                    "type": "string"
+                },
+                "s3_key": {
+                    "description": "Key of the S3 object",
+                    "type": "string"
+                },
+                "s3_version_id": {
+                    "description": "Version of the S3 object, if the bucket is versioned",
+                    "type": "string"
+                },
+                "value": {}
+            }
+        },
+        "main.LoadRunSummary": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "description": "The files that could not be processed",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.FailedFile"
+                    }
+                },
+                "files": {
+                    "description": "The files processed, in the order they were loaded",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.FileLoad"
+                    }
+                },
+                "files_failed": {
+                    "type": "integer"
+                },
+                "files_loaded": {
+                    "type": "integer"
+                },
+                "files_unchanged": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "rows_loaded": {
+                    "type": "integer"
+                },
+                "rows_skipped": {
+                    "type": "integer"
+                },
+                "rows_updated": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.LoadsResponse": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "Number of runs returned",
+                    "type": "integer"
+                },
+                "runs": {
+                    "description": "Load runs, newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.LoadRunSummary"
+                    }
+                },
+                "total": {
+                    "description": "Number of runs recorded",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.PlanNode": {
+            "type": "object",
+            "properties": {
+                "children": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.PlanNode"
+                    }
+                },
+                "text": {
+                    "description": "The node as SQL text",
+                    "type": "string"
+                },
+                "type": {
+                    "description": "Kind of node, e.g. \"where\", \"operator\" or \"column\"",
+                    "type": "string"
+                },
+                "value": {
+                    "description": "Operator, function, column or table name, or literal value",
+                    "type": "string"
+                }
+            }
+        },
+        "main.QueryPlan": {
+            "type": "object",
+            "properties": {
+                "access_path": {
+                    "description": "How the assets to examine are found",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.AccessPath"
                         }
-                    },
-                    "500": {
-                        "description": "Query error",
-                        "schema": {
-                            "type": "string"
+                    ]
+                },
+                "ast": {
+                    "description": "Parsed query after column names were resolved",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.PlanNode"
                         }
-                    }
+                    ]
+                },
+                "estimated_rows": {
+                    "description": "Assets the access path is expected to examine",
+                    "type": "integer"
+                },
+                "sql": {
+                    "description": "Query text as given",
+                    "type": "string"
+                },
+                "stats": {
+                    "$ref": "#/definitions/main.QueryStats"
                 }
             }
-        }
-    },
-    "definitions": {
+        },
         "main.QueryRequest": {
             "type": "object",
             "properties": {
+                "as_of": {
+                    "description": "Optional date, YYYYMMDD or YYYY-MM-DD, to query the values as they were on that date instead of the current ones",
+                    "type": "string",
+                    "example": "20250401"
+                },
                 "columns": {
                     "description": "Optional list of columns to return. If empty or omitted, all columns will be returned (equivalent to SELECT *)\nTo select all columns, you can either: 1) omit this field, 2) provide an empty array, or 3) use [\"*\"]\nColumn names are case-insensitive, so you can use \"revenue\", \"REVENUE\", or \"Revenue\" interchangeably",
                     "type": "array",
@@ -99,8 +1054,33 @@ const docTemplate = `{
                         "\"Revenue\"]"
                     ]
                 },
+                "distinct": {
+                    "description": "Optional flag to return only distinct rows",
+                    "type": "boolean",
+                    "example": false
+                },
+                "explain": {
+                    "description": "Optional flag to also return the query plan with the files read, rows matched and time spent in each stage",
+                    "type": "boolean",
+                    "example": false
+                },
+                "group_by": {
+                    "description": "Optional SQL GROUP BY terms (e.g., \"Industry\"). Columns may then include aggregates such as \"COUNT(*)\" or \"AVG(MarketCap)\"",
+                    "type": "string",
+                    "example": "Industry"
+                },
+                "having": {
+                    "description": "Optional SQL HAVING condition applied to each group (e.g., \"COUNT(*) \u003e 5\")",
+                    "type": "string",
+                    "example": "COUNT(*) \u003e 5"
+                },
+                "join": {
+                    "description": "Optional JOIN clauses adding other tables (e.g., \"LEFT JOIN ISSUERS i ON ID_BB_ULT_PARENT = i.ID\")\nThe queried table is referred to by its name. Columns may then be qualified, as in \"i.Rating\"",
+                    "type": "string",
+                    "example": "LEFT JOIN ISSUERS i ON ID_BB_ULT_PARENT = i.ID"
+                },
                 "limit": {
-                    "description": "Optional limit for the number of results to return",
+                    "description": "Optional limit for the number of results to return. Omitted or 0 returns all matching rows",
                     "type": "integer",
                     "example": 10
                 },
@@ -109,10 +1089,23 @@ const docTemplate = `{
                     "type": "integer",
                     "example": 0
                 },
+                "order_by": {
+                    "description": "Optional SQL ORDER BY terms (e.g., \"MarketCap DESC NULLS LAST, Company\")",
+                    "type": "string",
+                    "example": "MarketCap DESC"
+                },
+                "params": {
+                    "description": "Optional values for placeholders: an array for ? placeholders, in the order they appear,\nor an object for :name placeholders. An array value expands to a list inside IN (?)"
+                },
+                "table": {
+                    "description": "Optional table to query, as listed by /api/tables. Omitted queries BB_ASSETS",
+                    "type": "string",
+                    "example": "BB_ASSETS"
+                },
                 "where": {
-                    "description": "Optional SQL WHERE clause to filter results (e.g., \"Revenue \u003e 200 AND Industry = 'Technology'\")",
+                    "description": "Optional SQL WHERE clause to filter results (e.g., \"Revenue \u003e 200 AND Industry = 'Technology'\")\nValues can be written as ? or :name placeholders and supplied in params instead of in the SQL text",
                     "type": "string",
-                    "example": "Revenue \u003e 200"
+                    "example": "Revenue \u003e ? AND ID_BB_GLOBAL IN (?)"
                 }
             }
         },
@@ -131,9 +1124,146 @@ const docTemplate = `{
                         "additionalProperties": true
                     }
                 },
+                "plan": {
+                    "description": "How the query was executed, only when explain was requested",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.QueryPlan"
+                        }
+                    ]
+                },
                 "total": {
-                    "description": "Total number of records in the database",
+                    "description": "Total number of matching records before limit and offset",
+                    "type": "integer"
+                },
+                "warnings": {
+                    "description": "Requested columns that do not exist and were left out",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.QueryStats": {
+            "type": "object",
+            "properties": {
+                "bytes_parsed": {
+                    "description": "Bytes of JSON decoded",
+                    "type": "integer"
+                },
+                "elapsed_ms": {
+                    "description": "Total time from parsing to the final result",
+                    "type": "number"
+                },
+                "files_read": {
+                    "description": "Asset files opened and read",
+                    "type": "integer"
+                },
+                "rows_examined": {
+                    "description": "Rows tested against the WHERE clause",
+                    "type": "integer"
+                },
+                "rows_matched": {
+                    "description": "Rows that passed the WHERE clause",
+                    "type": "integer"
+                },
+                "rows_returned": {
+                    "description": "Rows in the result after grouping and paging",
+                    "type": "integer"
+                },
+                "stages": {
+                    "description": "Time spent in each stage, in execution order",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.StageStats"
+                    }
+                }
+            }
+        },
+        "main.ReloadRequest": {
+            "type": "object",
+            "properties": {
+                "files": {
+                    "description": "Files to process again, as listed in the files of a load run",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "example-data/financial_data_20250410.csv"
+                    ]
+                }
+            }
+        },
+        "main.StageStats": {
+            "type": "object",
+            "properties": {
+                "elapsed_ms": {
+                    "type": "number"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TableInfo": {
+            "type": "object",
+            "properties": {
+                "columns": {
+                    "description": "Number of columns",
+                    "type": "integer"
+                },
+                "directories": {
+                    "description": "Globs of the files loaded into the table; BB_ASSETS takes every other file",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "key_columns": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                },
+                "root": {
+                    "description": "Directory of the table's files",
+                    "type": "string"
+                },
+                "rows": {
+                    "description": "Number of assets in the effective date index",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.TablesResponse": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "Number of tables",
                     "type": "integer"
+                },
+                "tables": {
+                    "description": "BB_ASSETS first, then the configured tables",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.TableInfo"
+                    }
+                }
+            }
+        },
+        "main.ValueChange": {
+            "type": "object",
+            "properties": {
+                "new": {
+                    "$ref": "#/definitions/main.HistoryPoint"
+                },
+                "old": {
+                    "$ref": "#/definitions/main.HistoryPoint"
                 }
             }
         }
@@ -147,7 +1277,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/",
 	Schemes:          []string{},
 	Title:            "DataMatrix API",
-	Description:      "A Go service that loads CSV files into an in-memory DuckDB database and provides an HTTP API for querying the data using SQL.",
+	Description:      "A Go service that loads CSV files into a JSON-based file store and provides an HTTP API for querying the data using a minimal SQL dialect.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
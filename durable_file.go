@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SyncPolicy says when files written by the store are flushed to stable storage
+type SyncPolicy string
+
+const (
+	// SyncNone never flushes. Files are still replaced atomically and the
+	// write-ahead log survives the process being killed, but not a power loss.
+	SyncNone SyncPolicy = "none"
+	// SyncBatch flushes every asset, index, schema and manifest file before it
+	// replaces the old one, and the write-ahead log whenever a file finishes
+	// loading. A power loss can only lose the rows of the files being loaded,
+	// which are loaded again on the next start.
+	SyncBatch SyncPolicy = "batch"
+	// SyncAlways also flushes the write-ahead log and history files after every
+	// update, so no update that was logged is ever lost.
+	SyncAlways SyncPolicy = "always"
+)
+
+// ParseSyncPolicy parses the name of a sync policy. An empty name is SyncBatch.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(name); policy {
+	case "":
+		return SyncBatch, nil
+	case SyncNone, SyncBatch, SyncAlways:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown sync policy %q, expected none, batch or always", name)
+}
+
+// writeFileAtomic replaces a file with data by writing it under a temporary name
+// and renaming it, so a crash never leaves a partly written file. With sync set
+// the data and the rename are flushed to stable storage before it returns.
+func writeFileAtomic(filePath string, data []byte, sync bool) error {
+	tempPath := filePath + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if sync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			os.Remove(tempPath)
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, filePath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if sync {
+		return syncDir(filepath.Dir(filePath))
+	}
+	return nil
+}
+
+// syncDir flushes a directory so files renamed into it survive a power loss
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Some platforms cannot sync a directory; the rename has happened either way
+	file.Sync()
+	return nil
+}
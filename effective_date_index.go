@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// indexShardCount is the number of independently locked shards of the effective date index
+const indexShardCount = 256
+
+// indexFileMagic identifies an effective date index file and its format version.
+// Version 01 files, written before lineage was recorded, can still be read.
+var (
+	indexFileMagic   = [8]byte{'D', 'M', 'X', 'I', 'D', 'X', '0', '2'}
+	indexFileMagicV1 = [8]byte{'D', 'M', 'X', 'I', 'D', 'X', '0', '1'}
+)
+
+// unknownSource is the number of the empty load source, used for values whose
+// origin was not recorded, such as entries migrated from older index files
+const unknownSource = 0
+
+// EffectiveDateIndex records, for every (ID_BB_GLOBAL, column) pair, the effective
+// date of the value currently stored in the asset and where that value came from.
+// Entries are spread over shards by a hash of the ID, each with its own lock, so
+// lookups and updates cost the same however large the index grows and loads of
+// different assets do not contend.
+type EffectiveDateIndex struct {
+	shards   [indexShardCount]indexShard
+	modified atomic.Bool // Set by any change since the index was last loaded or saved
+
+	columnsMu   sync.RWMutex
+	columns     []string          // Interned column names, indexed by column number
+	columnIndex map[string]uint32 // Column name to column number
+
+	sourcesMu   sync.RWMutex
+	sources     []LoadSource          // Interned load sources, indexed by source number
+	sourceIndex map[LoadSource]uint32 // Load source to source number
+}
+
+// indexShard holds the entries of the IDs that hash to it. Columns and sources are
+// stored by number to keep the per-entry cost small.
+type indexShard struct {
+	sync.RWMutex
+	entries map[string]map[uint32]indexEntry // ID to column number to entry
+}
+
+// indexEntry is the effective date and lineage of one value
+type indexEntry struct {
+	date   uint32 // Effective date as a YYYYMMDD integer
+	source uint32 // Number of the load source the value was read from
+	row    uint32 // Line of the source file the value was read from, 0 if unknown
+}
+
+// LoadSource identifies a file read by one load run. Every value loaded from the
+// file shares it, so the index stores it once and refers to it by number.
+type LoadSource struct {
+	File        string `json:"file,omitempty"`          // Local path of the CSV file
+	S3Bucket    string `json:"s3_bucket,omitempty"`     // Bucket the file was downloaded from
+	S3Key       string `json:"s3_key,omitempty"`        // Key of the S3 object
+	S3VersionID string `json:"s3_version_id,omitempty"` // Version of the S3 object, if the bucket is versioned
+	LoadRun     string `json:"load_run,omitempty"`      // ID of the load run that read the file
+}
+
+// CellLineage describes where the current value of a column of an asset came from
+type CellLineage struct {
+	EffectiveDate string `json:"effective_date"`
+	LoadSource
+	Row int `json:"row,omitempty"` // Line of the file the value was read from, the header being line 1
+}
+
+// NewEffectiveDateIndex creates an empty index
+func NewEffectiveDateIndex() *EffectiveDateIndex {
+	index := &EffectiveDateIndex{
+		columnIndex: make(map[string]uint32),
+		sourceIndex: make(map[LoadSource]uint32),
+	}
+	for i := range index.shards {
+		index.shards[i].entries = make(map[string]map[uint32]indexEntry)
+	}
+	index.RegisterSource(LoadSource{})
+	return index
+}
+
+// shard returns the shard that holds the entries of an ID
+func (x *EffectiveDateIndex) shard(id string) *indexShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &x.shards[h.Sum32()%indexShardCount]
+}
+
+// columnNumber returns the number of a column, interning it if create is set.
+// The second result is false if the column is unknown and was not created.
+func (x *EffectiveDateIndex) columnNumber(column string, create bool) (uint32, bool) {
+	x.columnsMu.RLock()
+	number, exists := x.columnIndex[column]
+	x.columnsMu.RUnlock()
+	if exists || !create {
+		return number, exists
+	}
+
+	x.columnsMu.Lock()
+	defer x.columnsMu.Unlock()
+	if number, exists := x.columnIndex[column]; exists {
+		return number, true
+	}
+	number = uint32(len(x.columns))
+	x.columns = append(x.columns, column)
+	x.columnIndex[column] = number
+	return number, true
+}
+
+// RegisterSource returns the number of a load source, adding it to the index if
+// it is new. The number is passed to Update for every value read from the source.
+func (x *EffectiveDateIndex) RegisterSource(source LoadSource) uint32 {
+	x.sourcesMu.RLock()
+	number, exists := x.sourceIndex[source]
+	x.sourcesMu.RUnlock()
+	if exists {
+		return number
+	}
+
+	x.sourcesMu.Lock()
+	defer x.sourcesMu.Unlock()
+	if number, exists := x.sourceIndex[source]; exists {
+		return number
+	}
+	number = uint32(len(x.sources))
+	x.sources = append(x.sources, source)
+	x.sourceIndex[source] = number
+	return number
+}
+
+// parseIndexDate converts a YYYYMMDD effective date to its stored form
+func parseIndexDate(date string) (uint32, error) {
+	if len(date) != 8 {
+		return 0, fmt.Errorf("invalid effective date %q, expected YYYYMMDD", date)
+	}
+	value, err := strconv.ParseUint(date, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid effective date %q, expected YYYYMMDD", date)
+	}
+	return uint32(value), nil
+}
+
+// formatIndexDate converts a stored effective date back to YYYYMMDD
+func formatIndexDate(value uint32) string {
+	return fmt.Sprintf("%08d", value)
+}
+
+// Get returns the effective date of a column of an asset, or an empty string
+// if the column has never been set for the asset
+func (x *EffectiveDateIndex) Get(id, column string) string {
+	number, exists := x.columnNumber(column, false)
+	if !exists {
+		return ""
+	}
+
+	shard := x.shard(id)
+	shard.RLock()
+	entry, exists := shard.entries[id][number]
+	shard.RUnlock()
+	if !exists {
+		return ""
+	}
+	return formatIndexDate(entry.date)
+}
+
+// Lineage returns the effective date and origin of the value of a column of an
+// asset. The second result is false if the column has never been set for the asset.
+func (x *EffectiveDateIndex) Lineage(id, column string) (CellLineage, bool) {
+	entry, exists := x.entry(id, column)
+	if !exists {
+		return CellLineage{}, false
+	}
+	return x.cellLineage(entry), true
+}
+
+// entry returns the entry of a column of an asset
+func (x *EffectiveDateIndex) entry(id, column string) (indexEntry, bool) {
+	number, exists := x.columnNumber(column, false)
+	if !exists {
+		return indexEntry{}, false
+	}
+
+	shard := x.shard(id)
+	shard.RLock()
+	entry, exists := shard.entries[id][number]
+	shard.RUnlock()
+	return entry, exists
+}
+
+// LatestDate returns the newest effective date of any column of an asset, or an
+// empty string if the asset has no entries. No version of the asset is newer.
+func (x *EffectiveDateIndex) LatestDate(id string) string {
+	shard := x.shard(id)
+	shard.RLock()
+	var latest uint32
+	for _, entry := range shard.entries[id] {
+		if entry.date > latest {
+			latest = entry.date
+		}
+	}
+	shard.RUnlock()
+	if latest == 0 {
+		return ""
+	}
+	return formatIndexDate(latest)
+}
+
+// HasLoadRun reports whether any source in the index was read by a load run
+func (x *EffectiveDateIndex) HasLoadRun(runID string) bool {
+	x.sourcesMu.RLock()
+	defer x.sourcesMu.RUnlock()
+	for _, source := range x.sources {
+		if source.LoadRun == runID {
+			return true
+		}
+	}
+	return false
+}
+
+// Source returns a load source by number, or the empty source if the number is unknown
+func (x *EffectiveDateIndex) Source(number uint32) LoadSource {
+	x.sourcesMu.RLock()
+	defer x.sourcesMu.RUnlock()
+	if int(number) >= len(x.sources) {
+		return LoadSource{}
+	}
+	return x.sources[number]
+}
+
+// AssetLineage returns the lineage of every column recorded for an asset, keyed
+// by column name. The map is empty if the asset has never been loaded.
+func (x *EffectiveDateIndex) AssetLineage(id string) map[string]CellLineage {
+	// Copy the entries so the column and source names are looked up without the shard lock
+	shard := x.shard(id)
+	shard.RLock()
+	entries := make(map[uint32]indexEntry, len(shard.entries[id]))
+	for number, entry := range shard.entries[id] {
+		entries[number] = entry
+	}
+	shard.RUnlock()
+
+	x.columnsMu.RLock()
+	names := make(map[uint32]string, len(entries))
+	for number := range entries {
+		names[number] = x.columns[number]
+	}
+	x.columnsMu.RUnlock()
+
+	lineage := make(map[string]CellLineage, len(entries))
+	for number, entry := range entries {
+		lineage[names[number]] = x.cellLineage(entry)
+	}
+	return lineage
+}
+
+// cellLineage expands an entry into its effective date and load source
+func (x *EffectiveDateIndex) cellLineage(entry indexEntry) CellLineage {
+	return CellLineage{
+		EffectiveDate: formatIndexDate(entry.date),
+		LoadSource:    x.Source(entry.source),
+		Row:           int(entry.row),
+	}
+}
+
+// Update records the effective date and lineage of a column of an asset if the
+// date is newer than the one already recorded. The source is a number returned by
+// RegisterSource and row the line of the file the value was read from. It reports
+// whether the entry changed, which is when the new value should replace the stored
+// one. The check and the change are a single step, so concurrent loads of the same
+// asset cannot both win, and the lineage always belongs to the value that won.
+func (x *EffectiveDateIndex) Update(id, column, effectiveDate string, source uint32, row int) (bool, error) {
+	date, err := parseIndexDate(effectiveDate)
+	if err != nil {
+		return false, err
+	}
+	number, _ := x.columnNumber(column, true)
+
+	shard := x.shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+
+	columns := shard.entries[id]
+	if columns == nil {
+		columns = make(map[uint32]indexEntry)
+		shard.entries[id] = columns
+	}
+	if current, exists := columns[number]; exists && date <= current.date {
+		return false, nil
+	}
+	columns[number] = indexEntry{date: date, source: source, row: uint32(row)}
+	x.modified.Store(true)
+	return true, nil
+}
+
+// Modified reports whether the index changed since it was last loaded or saved
+func (x *EffectiveDateIndex) Modified() bool {
+	return x.modified.Load()
+}
+
+// IDCount returns the number of assets with at least one entry
+func (x *EffectiveDateIndex) IDCount() int {
+	count := 0
+	for i := range x.shards {
+		shard := &x.shards[i]
+		shard.RLock()
+		count += len(shard.entries)
+		shard.RUnlock()
+	}
+	return count
+}
+
+// ForEachID calls visit with the ID of every asset with at least one entry. The
+// IDs of one shard are copied before any is visited, so visit may use the index.
+func (x *EffectiveDateIndex) ForEachID(visit func(id string)) {
+	for i := range x.shards {
+		shard := &x.shards[i]
+		shard.RLock()
+		ids := make([]string, 0, len(shard.entries))
+		for id := range shard.entries {
+			ids = append(ids, id)
+		}
+		shard.RUnlock()
+		for _, id := range ids {
+			visit(id)
+		}
+	}
+}
+
+// Stats returns the number of entries, assets and columns in the index
+func (x *EffectiveDateIndex) Stats() (entries, ids, columns int) {
+	used := make(map[uint32]bool)
+	for i := range x.shards {
+		shard := &x.shards[i]
+		shard.RLock()
+		ids += len(shard.entries)
+		for _, assetColumns := range shard.entries {
+			entries += len(assetColumns)
+			for number := range assetColumns {
+				used[number] = true
+			}
+		}
+		shard.RUnlock()
+	}
+	return entries, ids, len(used)
+}
+
+// The index file is a binary file with the layout below, where every integer is
+// an unsigned varint and every string is a varint length followed by its bytes:
+//
+//	magic     8 bytes, "DMXIDX02"
+//	shards    count, then for each shard its ID count, and for each ID: the ID,
+//	          its entry count, and for each entry the column number, the
+//	          YYYYMMDD date, the source number and the row
+//	columns   count, then each column name in column number order
+//	sources   count, then for each source in source number order its file,
+//	          S3 bucket, S3 key, S3 version ID and load run
+//	checksum  4 bytes, big-endian CRC-32 of everything before it
+//
+// Columns and sources come last because they are only ever added: taking them
+// after every shard has been written covers all numbers the shards use, even if
+// loading continues while the file is written. Version 01 files have the same
+// layout without the source number and row of each entry or the sources.
+
+// Save writes the index to a file, replacing it only once the new file is complete.
+// With sync set the file is flushed to stable storage before it replaces the old one.
+func (x *EffectiveDateIndex) Save(filePath string, sync bool) error {
+	// Changes made while saving mark the index as modified again
+	x.modified.Store(false)
+
+	tempPath := filePath + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		x.modified.Store(true)
+		return fmt.Errorf("error creating index file: %v", err)
+	}
+
+	if err := x.write(file); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		x.modified.Store(true)
+		return fmt.Errorf("error writing index file: %v", err)
+	}
+	if sync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			os.Remove(tempPath)
+			x.modified.Store(true)
+			return fmt.Errorf("error syncing index file: %v", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		x.modified.Store(true)
+		return fmt.Errorf("error writing index file: %v", err)
+	}
+
+	if err := os.Rename(tempPath, filePath); err != nil {
+		os.Remove(tempPath)
+		x.modified.Store(true)
+		return fmt.Errorf("error replacing index file: %v", err)
+	}
+	if sync {
+		return syncDir(filepath.Dir(filePath))
+	}
+	return nil
+}
+
+// write encodes the index in the index file format. Only one shard at a time is
+// copied, so saving needs little memory beyond the index itself.
+func (x *EffectiveDateIndex) write(w io.Writer) error {
+	checksum := crc32.NewIEEE()
+	out := bufio.NewWriterSize(io.MultiWriter(w, checksum), 1<<20)
+	var scratch [binary.MaxVarintLen64]byte
+
+	writeUint := func(v uint64) {
+		n := binary.PutUvarint(scratch[:], v)
+		out.Write(scratch[:n])
+	}
+	writeString := func(s string) {
+		writeUint(uint64(len(s)))
+		out.WriteString(s)
+	}
+
+	out.Write(indexFileMagic[:])
+
+	writeUint(indexShardCount)
+	var ids []string
+	var numbers []uint32
+	for i := range x.shards {
+		shard := &x.shards[i]
+
+		// Copy the shard under its lock so loading can continue while it is written
+		shard.RLock()
+		copied := make(map[string]map[uint32]indexEntry, len(shard.entries))
+		for id, assetColumns := range shard.entries {
+			entries := make(map[uint32]indexEntry, len(assetColumns))
+			for number, entry := range assetColumns {
+				entries[number] = entry
+			}
+			copied[id] = entries
+		}
+		shard.RUnlock()
+
+		// Sorting makes the same index always produce the same file
+		ids = ids[:0]
+		for id := range copied {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		writeUint(uint64(len(ids)))
+		for _, id := range ids {
+			entries := copied[id]
+			writeString(id)
+			writeUint(uint64(len(entries)))
+
+			numbers = numbers[:0]
+			for number := range entries {
+				numbers = append(numbers, number)
+			}
+			sort.Slice(numbers, func(a, b int) bool { return numbers[a] < numbers[b] })
+			for _, number := range numbers {
+				entry := entries[number]
+				writeUint(uint64(number))
+				writeUint(uint64(entry.date))
+				writeUint(uint64(entry.source))
+				writeUint(uint64(entry.row))
+			}
+		}
+	}
+
+	x.columnsMu.RLock()
+	columns := append([]string(nil), x.columns...)
+	x.columnsMu.RUnlock()
+	writeUint(uint64(len(columns)))
+	for _, column := range columns {
+		writeString(column)
+	}
+
+	x.sourcesMu.RLock()
+	sources := append([]LoadSource(nil), x.sources...)
+	x.sourcesMu.RUnlock()
+	writeUint(uint64(len(sources)))
+	for _, source := range sources {
+		writeString(source.File)
+		writeString(source.S3Bucket)
+		writeString(source.S3Key)
+		writeString(source.S3VersionID)
+		writeString(source.LoadRun)
+	}
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], checksum.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// LoadEffectiveDateIndex reads an index written by Save
+func LoadEffectiveDateIndex(filePath string) (*EffectiveDateIndex, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file: %v", err)
+	}
+	index, err := decodeEffectiveDateIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing index file %s: %v", filePath, err)
+	}
+	return index, nil
+}
+
+// decodeEffectiveDateIndex decodes the contents of an index file
+func decodeEffectiveDateIndex(data []byte) (*EffectiveDateIndex, error) {
+	if len(data) < len(indexFileMagic)+4 {
+		return nil, errors.New("not an effective date index file")
+	}
+	var hasLineage bool
+	switch string(data[:len(indexFileMagic)]) {
+	case string(indexFileMagic[:]):
+		hasLineage = true
+	case string(indexFileMagicV1[:]):
+		hasLineage = false
+	default:
+		return nil, errors.New("not an effective date index file")
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, errors.New("checksum mismatch, the file is corrupt")
+	}
+
+	r := &indexReader{data: body, pos: len(indexFileMagic)}
+	index := NewEffectiveDateIndex()
+
+	// IDs are placed by hash, so the file may have been written with a different shard count
+	var maxNumber, maxSource uint64
+	hasEntries := false
+	shardCount := r.uint()
+	for i := uint64(0); i < shardCount && r.err == nil; i++ {
+		idCount := r.uint()
+		for n := uint64(0); n < idCount && r.err == nil; n++ {
+			id := r.string()
+			entryCount := r.uint()
+			columns := make(map[uint32]indexEntry)
+			for e := uint64(0); e < entryCount && r.err == nil; e++ {
+				number, date := r.uint(), r.uint()
+				var source, row uint64
+				if hasLineage {
+					source, row = r.uint(), r.uint()
+				}
+				if number > maxNumber {
+					maxNumber = number
+				}
+				if source > maxSource {
+					maxSource = source
+				}
+				hasEntries = true
+				columns[uint32(number)] = indexEntry{date: uint32(date), source: uint32(source), row: uint32(row)}
+			}
+			index.shard(id).entries[id] = columns
+		}
+	}
+
+	columnCount := r.uint()
+	for i := uint64(0); i < columnCount && r.err == nil; i++ {
+		if number, _ := index.columnNumber(r.string(), true); uint64(number) != i {
+			r.err = fmt.Errorf("duplicate column at position %d", i)
+		}
+	}
+
+	// The empty source is always number 0, so a version 01 file needs no sources
+	sourceCount := uint64(1)
+	if hasLineage {
+		sourceCount = r.uint()
+		for i := uint64(0); i < sourceCount && r.err == nil; i++ {
+			source := LoadSource{
+				File:        r.string(),
+				S3Bucket:    r.string(),
+				S3Key:       r.string(),
+				S3VersionID: r.string(),
+				LoadRun:     r.string(),
+			}
+			if r.err == nil && index.RegisterSource(source) != uint32(i) {
+				r.err = fmt.Errorf("unexpected source at position %d", i)
+			}
+		}
+	}
+
+	switch {
+	case r.err != nil:
+	case hasEntries && maxNumber >= columnCount:
+		r.err = fmt.Errorf("column number %d out of range", maxNumber)
+	case hasEntries && maxSource >= sourceCount:
+		r.err = fmt.Errorf("source number %d out of range", maxSource)
+	case r.pos != len(r.data):
+		r.err = errors.New("unexpected data after the sources")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return index, nil
+}
+
+// indexReader decodes varints and strings from an index file, remembering the first error
+type indexReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// uint reads an unsigned varint
+func (r *indexReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = errors.New("truncated file")
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+// string reads a length-prefixed string
+func (r *indexReader) string() string {
+	length := r.uint()
+	if r.err != nil {
+		return ""
+	}
+	if length > uint64(len(r.data)-r.pos) {
+		r.err = errors.New("truncated file")
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+int(length)])
+	r.pos += int(length)
+	return s
+}
+
+// legacyColumnIndex is an entry of the JSON index written by earlier versions
+type legacyColumnIndex struct {
+	ID            string `json:"id"`
+	ColumnName    string `json:"column_name"`
+	EffectiveDate string `json:"effective_date"`
+}
+
+// LoadLegacyEffectiveDateIndex reads the JSON index written by earlier versions,
+// {"entries": [{"id": ..., "column_name": ..., "effective_date": ...}, ...]}.
+// Entries are decoded one at a time so even a very large file can be migrated.
+func LoadLegacyEffectiveDateIndex(filePath string) (*EffectiveDateIndex, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading legacy index file: %v", err)
+	}
+	defer file.Close()
+
+	index := NewEffectiveDateIndex()
+	decoder := json.NewDecoder(bufio.NewReaderSize(file, 1<<20))
+
+	// Find the entries array inside the top-level object
+	if err := expectJSONDelim(decoder, '{'); err != nil {
+		return nil, fmt.Errorf("error parsing legacy index file: %v", err)
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing legacy index file: %v", err)
+		}
+		if key != "entries" {
+			// Skip anything else
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return nil, fmt.Errorf("error parsing legacy index file: %v", err)
+			}
+			continue
+		}
+
+		if err := expectJSONDelim(decoder, '['); err != nil {
+			return nil, fmt.Errorf("error parsing legacy index file: %v", err)
+		}
+		for decoder.More() {
+			var entry legacyColumnIndex
+			if err := decoder.Decode(&entry); err != nil {
+				return nil, fmt.Errorf("error parsing legacy index file: %v", err)
+			}
+			if _, err := index.Update(entry.ID, entry.ColumnName, entry.EffectiveDate, unknownSource, 0); err != nil {
+				return nil, fmt.Errorf("error parsing legacy index file: entry for ID %s column %s: %v", entry.ID, entry.ColumnName, err)
+			}
+		}
+		if err := expectJSONDelim(decoder, ']'); err != nil {
+			return nil, fmt.Errorf("error parsing legacy index file: %v", err)
+		}
+	}
+
+	return index, nil
+}
+
+// expectJSONDelim reads the next JSON token and checks that it is the given delimiter
+func expectJSONDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, found %v", delim, token)
+	}
+	return nil
+}
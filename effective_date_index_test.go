@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// indexFileBody builds the contents of an index file from varints and
+// length-prefixed strings, with the magic in front and without a checksum
+func indexFileBody(magic [8]byte, parts ...interface{}) []byte {
+	var body bytes.Buffer
+	body.Write(magic[:])
+	for _, part := range parts {
+		switch v := part.(type) {
+		case int:
+			body.Write(binary.AppendUvarint(nil, uint64(v)))
+		case string:
+			body.Write(binary.AppendUvarint(nil, uint64(len(v))))
+			body.WriteString(v)
+		}
+	}
+	return body.Bytes()
+}
+
+// withChecksum appends the CRC-32 trailer of an index file to its body
+func withChecksum(body []byte) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), body...), crc32.ChecksumIEEE(body))
+}
+
+func TestEffectiveDateIndexRoundTrip(t *testing.T) {
+	index := NewEffectiveDateIndex()
+	updates := []struct {
+		id, column, date string
+		want             bool
+	}{
+		{"BBG000B9XRY4", "Price", "20250101", true},
+		{"BBG000B9XRY4", "Price", "20250101", false}, // Same date does not replace the value
+		{"BBG000B9XRY4", "Price", "20241231", false}, // Older date does not either
+		{"BBG000B9XRY4", "Price", "20250102", true},
+		{"BBG000B9XRY4", "Company", "20250101", true},
+		{"BBG000BPH459", "Company", "20240601", true},
+	}
+	for _, u := range updates {
+		changed, err := index.Update(u.id, u.column, u.date, unknownSource, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != u.want {
+			t.Errorf("Update(%s, %s, %s) = %v, want %v", u.id, u.column, u.date, changed, u.want)
+		}
+	}
+	if _, err := index.Update("BBG000B9XRY4", "Price", "2025-01-03", unknownSource, 0); err == nil {
+		t.Error("Update accepted a date that is not YYYYMMDD")
+	}
+
+	path := filepath.Join(t.TempDir(), "asset_index.bin")
+	if err := index.Save(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if index.Modified() {
+		t.Error("index is still modified after saving")
+	}
+
+	loaded, err := LoadEffectiveDateIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range []struct{ id, column, want string }{
+		{"BBG000B9XRY4", "Price", "20250102"},
+		{"BBG000B9XRY4", "Company", "20250101"},
+		{"BBG000BPH459", "Company", "20240601"},
+		{"BBG000BPH459", "Price", ""},
+		{"UNKNOWN", "Price", ""},
+	} {
+		if got := loaded.Get(check.id, check.column); got != check.want {
+			t.Errorf("Get(%s, %s) = %q, want %q", check.id, check.column, got, check.want)
+		}
+	}
+	if entries, ids, columns := loaded.Stats(); entries != 3 || ids != 2 || columns != 2 {
+		t.Errorf("Stats() = %d entries, %d IDs, %d columns, want 3, 2, 2", entries, ids, columns)
+	}
+
+	// The same index always produces the same file
+	again := filepath.Join(t.TempDir(), "asset_index.bin")
+	if err := loaded.Save(again, false); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(path)
+	second, _ := os.ReadFile(again)
+	if !bytes.Equal(first, second) {
+		t.Error("saving a loaded index produced a different file")
+	}
+}
+
+func TestDecodeCorruptEffectiveDateIndex(t *testing.T) {
+	index := NewEffectiveDateIndex()
+	if _, err := index.Update("A", "Price", "20250101", unknownSource, 0); err != nil {
+		t.Fatal(err)
+	}
+	var valid bytes.Buffer
+	if err := index.write(&valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeEffectiveDateIndex(valid.Bytes()); err != nil {
+		t.Fatalf("decoding a valid file: %v", err)
+	}
+
+	flipped := append([]byte(nil), valid.Bytes()...)
+	flipped[len(indexFileMagic)+3] ^= 0xff
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"empty", nil, "not an effective date index file"},
+		{"wrong magic", append([]byte("DMXIDX99"), valid.Bytes()[8:]...), "not an effective date index file"},
+		{"flipped byte", flipped, "checksum mismatch"},
+		{"truncated", valid.Bytes()[:valid.Len()-6], "checksum mismatch"},
+		{"truncated with a valid checksum", withChecksum(indexFileBody(indexFileMagic, 1, 1, "A", 2, 0, 20250101, 0, 0)), "truncated file"},
+		{"string past the end", withChecksum(indexFileBody(indexFileMagic, 1, 1, 100)), "truncated file"},
+		{
+			"column number out of range",
+			withChecksum(indexFileBody(indexFileMagic, 1, 1, "A", 1, 5, 20250101, 0, 2, 1, "Price", 1, "", "", "", "", "")),
+			"column number 5 out of range",
+		},
+		{
+			"source number out of range",
+			withChecksum(indexFileBody(indexFileMagic, 1, 1, "A", 1, 0, 20250101, 3, 2, 1, "Price", 1, "", "", "", "", "")),
+			"source number 3 out of range",
+		},
+		{"duplicate column", withChecksum(indexFileBody(indexFileMagic, 0, 2, "Price", "Price")), "duplicate column at position 1"},
+		{
+			"duplicate source",
+			withChecksum(indexFileBody(indexFileMagic, 0, 0, 2, "", "", "", "", "", "", "", "", "", "")),
+			"unexpected source at position 1",
+		},
+		{
+			"trailing data",
+			withChecksum(append(indexFileBody(indexFileMagic, 0, 0, 1, "", "", "", "", ""), 7)),
+			"unexpected data after the sources",
+		},
+		{
+			"version 01 column number out of range",
+			withChecksum(indexFileBody(indexFileMagicV1, 1, 1, "A", 1, 1, 20250101, 1, "Price")),
+			"column number 1 out of range",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeEffectiveDateIndex(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEffectiveDateIndexLineage(t *testing.T) {
+	index := NewEffectiveDateIndex()
+	older := index.RegisterSource(LoadSource{File: "data/assets_20250101.csv", S3Bucket: "bucket", S3Key: "assets_20250101.csv", S3VersionID: "v1", LoadRun: "run1"})
+	newer := index.RegisterSource(LoadSource{File: "data/assets_20250102.csv", LoadRun: "run2"})
+	if again := index.RegisterSource(LoadSource{File: "data/assets_20250102.csv", LoadRun: "run2"}); again != newer {
+		t.Errorf("registering a source twice gave numbers %d and %d", newer, again)
+	}
+
+	index.Update("A", "Price", "20250102", newer, 7)
+	index.Update("A", "Price", "20250101", older, 3) // Older value keeps the newer lineage
+	index.Update("A", "Company", "20250101", older, 3)
+
+	path := filepath.Join(t.TempDir(), "asset_index.bin")
+	if err := index.Save(path, false); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadEffectiveDateIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]CellLineage{
+		"Price":   {EffectiveDate: "20250102", LoadSource: LoadSource{File: "data/assets_20250102.csv", LoadRun: "run2"}, Row: 7},
+		"Company": {EffectiveDate: "20250101", LoadSource: LoadSource{File: "data/assets_20250101.csv", S3Bucket: "bucket", S3Key: "assets_20250101.csv", S3VersionID: "v1", LoadRun: "run1"}, Row: 3},
+	}
+	if got := loaded.AssetLineage("A"); !reflect.DeepEqual(got, want) {
+		t.Errorf("AssetLineage(A) = %+v, want %+v", got, want)
+	}
+	if got, exists := loaded.Lineage("A", "Price"); !exists || got != want["Price"] {
+		t.Errorf("Lineage(A, Price) = %+v, %v, want %+v", got, exists, want["Price"])
+	}
+	if _, exists := loaded.Lineage("A", "Revenue"); exists {
+		t.Error("Lineage(A, Revenue) exists for a column that was never set")
+	}
+	if got := loaded.AssetLineage("B"); len(got) != 0 {
+		t.Errorf("AssetLineage(B) = %+v for an asset that was never loaded", got)
+	}
+}
+
+func TestDecodeVersion01EffectiveDateIndex(t *testing.T) {
+	data := withChecksum(indexFileBody(indexFileMagicV1, 1, 1, "A", 1, 0, 20250101, 1, "Price"))
+	index, err := decodeEffectiveDateIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := index.Get("A", "Price"); got != "20250101" {
+		t.Errorf("Get(A, Price) = %q, want 20250101", got)
+	}
+
+	// Values from before lineage was recorded only have their effective date
+	if got, exists := index.Lineage("A", "Price"); !exists || got != (CellLineage{EffectiveDate: "20250101"}) {
+		t.Errorf("Lineage(A, Price) = %+v, %v, want only the effective date", got, exists)
+	}
+
+	// The index is saved in the current version
+	var saved bytes.Buffer
+	if err := index.write(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(saved.Bytes(), indexFileMagic[:]) {
+		t.Errorf("saved index starts with %q, want %q", saved.Bytes()[:8], indexFileMagic[:])
+	}
+}
+
+func TestStoreStartsEmptyOnCorruptIndex(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataDir, "asset_index.bin"), []byte("DMXIDX01garbage!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestManager(t, dataDir)
+	if count := m.index.IDCount(); count != 0 {
+		t.Errorf("index of a store with a corrupt index file has %d IDs, want 0", count)
+	}
+}
+
+func TestMigrateLegacyEffectiveDateIndex(t *testing.T) {
+	dataDir := t.TempDir()
+	legacy := `{"version": 1, "entries": [
+		{"id": "A", "column_name": "Price", "effective_date": "20250101"},
+		{"id": "A", "column_name": "Price", "effective_date": "20240101"},
+		{"id": "A", "column_name": "Company", "effective_date": "20230101"},
+		{"id": "B", "column_name": "Price", "effective_date": "20250301"}
+	]}`
+	legacyPath := filepath.Join(dataDir, "asset_index.json")
+	if err := os.WriteFile(legacyPath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestManager(t, dataDir)
+	for _, check := range []struct{ id, column, want string }{
+		{"A", "Price", "20250101"}, // The newest of duplicate entries wins
+		{"A", "Company", "20230101"},
+		{"B", "Price", "20250301"},
+	} {
+		if got := m.index.Get(check.id, check.column); got != check.want {
+			t.Errorf("Get(%s, %s) = %q, want %q", check.id, check.column, got, check.want)
+		}
+	}
+
+	// The index is saved in the new format and the old file is kept aside
+	if _, err := os.Stat(filepath.Join(dataDir, "asset_index.bin")); err != nil {
+		t.Errorf("migrated index was not saved: %v", err)
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Errorf("legacy index is still in place: %v", err)
+	}
+	if _, err := os.Stat(legacyPath + ".migrated"); err != nil {
+		t.Errorf("legacy index was not kept: %v", err)
+	}
+
+	// Reopening reads the new file
+	reopened := newTestManager(t, dataDir)
+	if got := reopened.index.Get("B", "Price"); got != "20250301" {
+		t.Errorf("after reopening Get(B, Price) = %q, want 20250301", got)
+	}
+}
+
+func TestLoadLegacyEffectiveDateIndexErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"not an object", `[]`, "expected {"},
+		{"entries not an array", `{"entries": {}}`, "expected ["},
+		{"bad date", `{"entries": [{"id": "A", "column_name": "Price", "effective_date": "2025-01-01"}]}`, "entry for ID A column Price"},
+		{"truncated", `{"entries": [{"id": "A", "column_name": "Pri`, "error parsing legacy index file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "asset_index.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadLegacyEffectiveDateIndex(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ingestBatchSize is the number of rows a reader hands to a writer at once
+const ingestBatchSize = 256
+
+// ingestQueueDepth is the number of batches each writer can have waiting from a
+// file. It bounds the rows held in memory while later files are read ahead.
+const ingestQueueDepth = 8
+
+// ingestRow is a CSV record on its way to the writer of its asset
+type ingestRow struct {
+	id     string
+	record []string
+	line   int // Line of the file the record starts on
+}
+
+// ingestFile is a CSV file moving through the ingestion pipeline. Its reader
+// parses the rows and sends each to the partition of its asset; the writer of
+// each partition applies all the rows of one file before moving on to the next.
+type ingestFile struct {
+	path          string
+	effectiveDate string
+	header        []string
+	source        uint32
+	inference     *typeInference
+	load          FileLoad // The file as the manifest records it, without the rows written
+	err           error    // Why the file could not be read, set before read is closed
+
+	partitions []chan []ingestRow // One queue per writer, closed once the file is read
+	registered chan struct{}      // Closed once the file's columns and load source are registered
+	read       chan struct{}      // Closed once the reader is done
+	written    sync.WaitGroup     // Writers still applying the file's rows
+
+	updated atomic.Int64 // Rows that changed at least one value
+	failed  atomic.Int64 // Rows a writer could not store
+}
+
+// recordReader reads the records of a data file after its header
+type recordReader interface {
+	// Header returns the column names of the records
+	Header() []string
+	// Read returns the next record and the line of the file it starts on. It
+	// returns io.EOF after the last record and a *skippedRecord for a record that
+	// cannot be used; any other error means the file cannot be read further.
+	Read() ([]string, int, error)
+}
+
+// skippedRecord is a record that could not be used. It is skipped without
+// ending its file.
+type skippedRecord struct {
+	err error
+}
+
+func (e *skippedRecord) Error() string {
+	return e.err.Error()
+}
+
+// newIngestFile prepares a file for a pipeline with the given number of writers
+func newIngestFile(path string, writers int) *ingestFile {
+	file := &ingestFile{
+		path:       path,
+		inference:  newTypeInference(),
+		partitions: make([]chan []ingestRow, writers),
+		registered: make(chan struct{}),
+		read:       make(chan struct{}),
+	}
+	for i := range file.partitions {
+		file.partitions[i] = make(chan []ingestRow, ingestQueueDepth)
+	}
+	file.written.Add(writers)
+	return file
+}
+
+// ingestPartition returns the writer that owns the asset with an ID
+func ingestPartition(id string, partitions int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// ingestFiles loads CSV files into the store as part of a load run. Up to
+// ingestWorkers files are read and parsed at once, while as many writers store
+// their rows, each owning the assets whose ID hashes to it so no asset is ever
+// written by two goroutines. Every writer applies the files in the order given
+// and the rows of a file in the order they appear, so each asset sees its
+// updates in the same order as when the files are loaded one by one and
+// effective dates resolve identically. done is called for each file, in order,
+// once all of its rows are stored.
+func (j *JSONAssetManager) ingestFiles(filePaths []string, runID string, done func(file *ingestFile)) {
+	j.RLock()
+	workers := j.ingestWorkers
+	j.RUnlock()
+
+	files := make([]*ingestFile, len(filePaths))
+	for i, filePath := range filePaths {
+		files[i] = newIngestFile(filePath, workers)
+	}
+
+	// Start the writers, each working through its partition of every file in turn
+	for partition := 0; partition < workers; partition++ {
+		go func(partition int) {
+			for _, file := range files {
+				for batch := range file.partitions[partition] {
+					for _, row := range batch {
+						j.writeIngestRow(file, row)
+					}
+				}
+				file.written.Done()
+			}
+		}(partition)
+	}
+
+	// Start the readers in file order, at most one per worker. The earliest file
+	// not yet stored is therefore always being read, so writers waiting for it
+	// never wait on a reader that cannot start.
+	go func() {
+		slots := make(chan struct{}, workers)
+		previous := make(chan struct{})
+		close(previous)
+		for _, file := range files {
+			slots <- struct{}{}
+			go func(file *ingestFile, previous <-chan struct{}) {
+				defer func() { <-slots }()
+				j.readIngestFile(file, runID, previous)
+			}(file, previous)
+			previous = file.registered
+		}
+	}()
+
+	for _, file := range files {
+		<-file.read
+		file.written.Wait()
+		done(file)
+	}
+}
+
+// readIngestFile reads and parses a data file, sending its rows to the writers.
+// Bloomberg Data License files are recognised by their START-OF-FILE line and
+// take their effective date from their header; anything else is read as CSV.
+// Its columns and load source are registered once those of the file before it,
+// whose registered channel is previous, have been, so columns are listed and
+// sources numbered exactly as when the files are loaded one by one.
+func (j *JSONAssetManager) readIngestFile(file *ingestFile, runID string, previous <-chan struct{}) {
+	defer close(file.read)
+	defer func() {
+		for _, partition := range file.partitions {
+			close(partition)
+		}
+	}()
+	registered := false
+	defer func() {
+		if !registered {
+			<-previous
+			close(file.registered)
+		}
+	}()
+
+	fileName := filepath.Base(file.path)
+	j.logger.Info("Loading CSV file: %s", file.path)
+
+	// Extract the effective date from the filename
+	file.effectiveDate = j.getEffectiveDateFromFilename(file.path)
+
+	// Open the file
+	f, err := os.Open(file.path)
+	if err != nil {
+		file.err = fmt.Errorf("error opening file: %v", err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		file.err = fmt.Errorf("error reading file: %v", err)
+		return
+	}
+
+	// Hash the contents as they are read, to identify the file in the manifest
+	hash := sha256.New()
+	raw := io.TeeReader(f, hash)
+	var reader io.Reader = raw
+
+	// If the file is gzipped, use a gzip reader
+	if strings.HasSuffix(strings.ToLower(file.path), ".gz") {
+		gzReader, err := gzip.NewReader(raw)
+		if err != nil {
+			file.err = fmt.Errorf("error creating gzip reader: %v", err)
+			return
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	// Read the header, in the format the file starts with
+	var records recordReader
+	buffered := bufio.NewReaderSize(reader, csvSniffBytes)
+	if isBloombergData(buffered) {
+		bloomberg, err := newBloombergReader(buffered)
+		if err != nil {
+			file.err = fmt.Errorf("error reading Bloomberg header: %v", err)
+			return
+		}
+		j.logger.Info("Reading Bloomberg Data License file %s from program %s", fileName, bloomberg.Program())
+		if date := bloomberg.EffectiveDate(); date != "" {
+			file.effectiveDate = date
+		} else {
+			j.logger.Warn("No RUNDATE or TIMESTARTED in %s, using the date of its name", fileName)
+		}
+		records = bloomberg
+	} else {
+		format, dialect, configured := j.csvFormatFor(file.path)
+		if configured {
+			j.logger.Info("Reading %s with the CSV dialect for %s", fileName, dialect)
+		} else if format = sniffCSVFormat(buffered); format != defaultCSVFormat() {
+			j.logger.Info("Detected CSV dialect of %s: %s", fileName, format)
+		}
+		csvRecords, err := newCSVRecordReader(buffered, format)
+		if err != nil {
+			file.err = err
+			return
+		}
+		records = csvRecords
+	}
+	j.logger.Info("Effective date for file %s: %s", fileName, file.effectiveDate)
+
+	// Rename, drop and normalise the columns as configured for the file
+	var sources []string
+	if rule, configured := j.columnMappingFor(file.path); configured {
+		mapped, warnings := rule.apply(records, j.keyColumns)
+		for _, warning := range warnings {
+			j.logger.Warn("Mapping the columns of %s: %s", fileName, warning)
+		}
+		records, sources = mapped, mapped.Sources()
+	}
+	header := records.Header()
+	file.header = header
+
+	// Check if the file has the key columns of the table
+	keyIndexes, missingKeys := j.keyIndexes(header)
+
+	rowCount := 0
+	skippedCount := 0
+
+	// Skip files without the key columns. They are still recorded as loaded, so
+	// they are not read again until they change.
+	if len(missingKeys) > 0 {
+		j.logger.Warn("Skipping file %s: No %s column found for table %s", file.path, strings.Join(missingKeys, ", "), j.table)
+	} else {
+		<-previous
+		for i, col := range header {
+			j.addColumnIfNotExists(col)
+			if sources != nil && sources[i] != col {
+				j.schema.AddHeader(col, sources[i])
+			}
+		}
+		// Every value updated from this file shares its load source
+		file.source = j.index.RegisterSource(loadSource(file.path, runID))
+		close(file.registered)
+		registered = true
+
+		batches := make([][]ingestRow, len(file.partitions))
+		for {
+			record, line, err := records.Read()
+			if err == io.EOF {
+				break
+			}
+			var skipped *skippedRecord
+			if err != nil && !errors.As(err, &skipped) {
+				// The file itself could not be read any further
+				file.err = fmt.Errorf("error reading file: %v", err)
+				break
+			}
+			if err != nil {
+				j.logger.Warn("Skipping record in %s: %v", fileName, err)
+				skippedCount++
+				continue
+			}
+
+			// Get the ID from the key values. The ID names the asset file, so a
+			// row with an ID that could point anywhere else is skipped like a
+			// row without one.
+			id, err := j.recordID(record, keyIndexes)
+			if err != nil {
+				j.logger.Warn("Skipping record on line %d of %s: %v", line, fileName, err)
+			}
+			if id == "" {
+				skippedCount++
+				continue
+			}
+
+			file.inference.Observe(header, record)
+			rowCount++
+
+			// Record the line the record starts on as the row of its values
+			partition := ingestPartition(id, len(file.partitions))
+			batches[partition] = append(batches[partition], ingestRow{id: id, record: record, line: line})
+			if len(batches[partition]) == ingestBatchSize {
+				file.partitions[partition] <- batches[partition]
+				batches[partition] = nil
+			}
+		}
+
+		// Hand over the rows still waiting in partial batches
+		for partition, batch := range batches {
+			if len(batch) > 0 {
+				file.partitions[partition] <- batch
+			}
+		}
+		if file.err != nil {
+			return
+		}
+	}
+
+	// Hash the rest of a file that was not read to the end
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		file.err = fmt.Errorf("error hashing file: %v", err)
+		return
+	}
+
+	file.load = FileLoad{
+		Path:          file.path,
+		Size:          info.Size(),
+		ModTime:       info.ModTime(),
+		SHA256:        hex.EncodeToString(hash.Sum(nil)),
+		EffectiveDate: file.effectiveDate,
+		RowsLoaded:    rowCount,
+		RowsSkipped:   skippedCount,
+		LoadRun:       runID,
+	}
+}
+
+// writeIngestRow stores a row of a file, counting whether it changed the asset
+func (j *JSONAssetManager) writeIngestRow(file *ingestFile, row ingestRow) {
+	updated, err := j.updateAssetFromRecord(row.id, file.header, row.record, file.effectiveDate, file.source, row.line)
+	if err != nil {
+		j.logger.Warn("Error updating asset for ID %s: %v", row.id, err)
+		file.failed.Add(1)
+		return
+	}
+	if updated {
+		file.updated.Add(1)
+	}
+}
+
+// finishIngestFile completes a file once all of its rows are stored: it widens
+// the column types with what was seen in the file, saves the assets, index and
+// schema, and returns the file as the manifest records it
+func (j *JSONAssetManager) finishIngestFile(file *ingestFile) (FileLoad, error) {
+	if file.err != nil {
+		return FileLoad{}, file.err
+	}
+	// A file is only loaded once every row of it is stored; the rows stored are
+	// kept, and the next run reads the file again
+	if failed := file.failed.Load(); failed > 0 {
+		return FileLoad{}, fmt.Errorf("%d rows could not be stored", failed)
+	}
+	fileName := filepath.Base(file.path)
+
+	// Widen the stored column types with what was seen in this file.
+	// The key columns are always strings, even when every key happens to be numeric.
+	for col, colType := range file.inference.types {
+		if j.isKeyColumn(col) {
+			colType = ColumnTypeString
+		}
+		j.schema.MergeType(col, colType)
+	}
+
+	// Write the assets changed so far with the index and schema, so the file is
+	// only recorded as loaded once everything it changed is saved
+	if err := j.checkpoint(); err != nil {
+		return FileLoad{}, fmt.Errorf("error saving the store: %v", err)
+	}
+
+	load := file.load
+	load.RowsUpdated = int(file.updated.Load())
+	load.LoadedAt = time.Now().UTC()
+
+	j.logger.Success("Loaded %d rows from %s (updated %d, skipped %d rows)",
+		load.RowsLoaded, fileName, load.RowsUpdated, load.RowsSkipped)
+	return load, nil
+}
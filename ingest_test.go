@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnchangedFilesAreSkipped(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "prices_20250101.csv", "ID_BB_GLOBAL,PX_LAST\nA1,1\n")
+	never := func(string) bool { return false }
+
+	if run := m.loadFiles([]string{filePath}, never); run.FilesLoaded != 1 {
+		t.Fatalf("first run loaded %d files, want 1", run.FilesLoaded)
+	}
+	if run := m.loadFiles([]string{filePath}, never); run.FilesLoaded != 0 || run.FilesUnchanged != 1 {
+		t.Fatalf("second run loaded %d files with %d unchanged, want the file skipped", run.FilesLoaded, run.FilesUnchanged)
+	}
+
+	// A new modification time alone does not make the file changed
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if run := m.loadFiles([]string{filePath}, never); run.FilesUnchanged != 1 {
+		t.Fatalf("run after touching the file loaded %d files, want it skipped", run.FilesLoaded)
+	}
+
+	// Forced and changed files are processed again
+	if run := m.loadFiles([]string{filePath}, func(string) bool { return true }); run.FilesLoaded != 1 {
+		t.Fatalf("forced run loaded %d files, want 1", run.FilesLoaded)
+	}
+	writeCSV(t, filepath.Dir(filePath), filepath.Base(filePath), "ID_BB_GLOBAL,PX_LAST\nA1,2\n")
+	if run := m.loadFiles([]string{filePath}, never); run.FilesLoaded != 1 {
+		t.Fatalf("run after changing the file loaded %d files, want 1", run.FilesLoaded)
+	}
+}
+
+func TestLoadFilesReportsFailedFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string // File name to contents
+		wantErr string            // File named by the error, if any
+	}{
+		{
+			name:  "all files loaded",
+			files: map[string]string{"a_20250101.csv": "ID_BB_GLOBAL,PX_LAST\nA1,1\n"},
+		},
+		{
+			name: "one file failed",
+			files: map[string]string{
+				"a_20250101.csv": "ID_BB_GLOBAL,PX_LAST\nA1,1\n",
+				"b_20250101.csv": "", // No header
+			},
+			wantErr: "b_20250101.csv",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, t.TempDir())
+			dir := t.TempDir()
+			var filePaths []string
+			for name, content := range tt.files {
+				filePaths = append(filePaths, writeCSV(t, dir, name, content))
+			}
+
+			err := m.LoadFiles(filePaths)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("LoadFiles: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("LoadFiles error = %v, want one naming %s", err, tt.wantErr)
+			}
+			// The files that loaded are recorded all the same
+			if _, recorded := m.manifest.File(filepath.Join(dir, "a_20250101.csv")); !recorded {
+				t.Error("manifest does not record the file that loaded")
+			}
+			if _, recorded := m.manifest.File(filepath.Join(dir, "b_20250101.csv")); recorded {
+				t.Error("manifest records the file that failed")
+			}
+		})
+	}
+}
+
+func TestFileWithRowsNotStoredIsNotRecorded(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	// Storing the row of A1 fails
+	unblock := blockHistoryFile(t, m, "A1")
+	filePath := writeCSV(t, t.TempDir(), "prices_20250101.csv", "ID_BB_GLOBAL,PX_LAST\nA1,1\nA2,2\n")
+
+	run := m.loadFiles([]string{filePath}, func(string) bool { return false })
+	if run.FilesFailed != 1 || run.FilesLoaded != 0 {
+		t.Fatalf("run loaded %d and failed %d files, want the file failed", run.FilesLoaded, run.FilesFailed)
+	}
+	if _, recorded := m.manifest.File(filePath); recorded {
+		t.Fatal("manifest records a file with a row that could not be stored")
+	}
+
+	unblock()
+	run = m.loadFiles([]string{filePath}, func(string) bool { return false })
+	if run.FilesLoaded != 1 || run.FilesUnchanged != 0 {
+		t.Fatalf("next run loaded %d files with %d unchanged, want the file loaded again", run.FilesLoaded, run.FilesUnchanged)
+	}
+	for id, want := range map[string]string{"A1": "1", "A2": "2"} {
+		if asset, err := m.GetAsset(id); err != nil || asset["PX_LAST"] != want {
+			t.Errorf("GetAsset(%s) = %v, %v, want PX_LAST %s", id, asset, err, want)
+		}
+	}
+}
+
+func TestFileIsNotRecordedWhenTheStoreCannotBeSaved(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "prices_20250101.csv", "ID_BB_GLOBAL,PX_LAST\nA1,1\n")
+	unblock := blockAssetFile(t, m, "A1")
+
+	if err := m.LoadCSVFile(filePath); err == nil {
+		t.Fatal("loading a file whose assets cannot be saved succeeded")
+	}
+	if _, recorded := m.manifest.File(filePath); recorded {
+		t.Fatal("manifest records a file whose assets could not be saved")
+	}
+
+	// The file is loaded by the next run once the store can be saved
+	unblock()
+	if err := m.LoadFiles([]string{filePath}); err != nil {
+		t.Fatal(err)
+	}
+	if _, recorded := m.manifest.File(filePath); !recorded {
+		t.Fatal("manifest does not record the file once it was loaded")
+	}
+}
+
+func TestParallelLoadMatchesSequentialLoad(t *testing.T) {
+	// Effective dates come from the paths of the files, so they are named
+	// relative to a directory without digits in its path
+	t.Chdir(t.TempDir())
+	const assets = 200
+	files := []struct {
+		name   string
+		header string
+		value  func(i int) string
+	}{
+		{name: "prices_20250103.csv", header: "ID_BB_GLOBAL,PX_LAST,Sector", value: func(i int) string { return fmt.Sprintf("%d.3,S%d", i, i%7) }},
+		// Older values do not replace newer ones, but add columns the asset lacks
+		{name: "prices_20250101.csv", header: "ID_BB_GLOBAL,PX_LAST,Volume", value: func(i int) string { return fmt.Sprintf("%d.1,%d", i, i*10) }},
+		{name: "prices_20250102.csv", header: "ID_BB_GLOBAL,Volume,Sector", value: func(i int) string { return fmt.Sprintf("%d,T%d", i*20, i%5) }},
+		// A value of the same date as the current one does not replace it
+		{name: "prices_20250103_late.csv", header: "ID_BB_GLOBAL,PX_LAST", value: func(i int) string { return fmt.Sprintf("%d.9", i) }},
+	}
+	var filePaths []string
+	for _, file := range files {
+		var content strings.Builder
+		content.WriteString(file.header + "\n")
+		for i := 0; i < assets; i++ {
+			fmt.Fprintf(&content, "A%d,%s\n", i, file.value(i))
+		}
+		filePaths = append(filePaths, writeCSV(t, ".", file.name, content.String()))
+	}
+
+	type cell struct {
+		value, date, file string
+	}
+	load := func(t *testing.T, workers int) map[string]cell {
+		t.Helper()
+		m := newTestManager(t, t.TempDir())
+		m.SetIngestWorkers(workers)
+		if err := m.LoadFiles(filePaths); err != nil {
+			t.Fatal(err)
+		}
+		cells := make(map[string]cell)
+		for i := 0; i < assets; i++ {
+			id := fmt.Sprintf("A%d", i)
+			asset, err := m.GetAsset(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, column := range []string{"PX_LAST", "Sector", "Volume"} {
+				lineage, _ := m.index.Lineage(id, column)
+				cells[id+"."+column] = cell{value: asset[column], date: m.index.Get(id, column), file: lineage.File}
+			}
+		}
+		return cells
+	}
+
+	sequential := load(t, 1)
+	for key, want := range map[string]cell{
+		"A4.PX_LAST": {value: "4.3", date: "20250103", file: "prices_20250103.csv"},
+		"A4.Sector":  {value: "S4", date: "20250103", file: "prices_20250103.csv"},
+		"A4.Volume":  {value: "80", date: "20250102", file: "prices_20250102.csv"},
+	} {
+		if got := sequential[key]; got != want {
+			t.Errorf("sequential load has %s = %+v, want %+v", key, got, want)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{name: "two workers", workers: 2},
+		{name: "eight workers", workers: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parallel := load(t, tt.workers)
+			for key, want := range sequential {
+				if got := parallel[key]; got != want {
+					t.Errorf("%s = %+v, want %+v as loaded sequentially", key, got, want)
+				}
+			}
+		})
+	}
+}
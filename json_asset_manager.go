@@ -1,30 +1,24 @@
 package main
 
 import (
-	"encoding/csv"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
-	"compress/gzip"
 )
 
-// ColumnIndex represents the effective date index for a column value
-type ColumnIndex struct {
-	ID           string `json:"id"`           // ID_BB_GLOBAL
-	ColumnName   string `json:"column_name"`  // Column/property name
-	EffectiveDate string `json:"effective_date"` // Effective date in YYYYMMDD format
-}
-
-// AssetIndex holds the index data for all assets
-type AssetIndex struct {
-	Entries []ColumnIndex `json:"entries"`
-}
+// assetLockCount is the number of locks guarding asset files
+const assetLockCount = 256
 
 // JSONAssetManager manages the JSON files for BB_ASSETS
 // It implements the same interface as DataDictionary for compatibility
@@ -33,36 +27,82 @@ type JSONAssetManager struct {
 	logger         *Logger
 	progress       *ProgressTracker
 	jsonDir        string   // Directory for JSON files
-	columns        []string // List of all columns
 	idPrefixFilter []string // Optional ID_BB_GLOBAL prefix filter
+	
+	// Table
+	table      string   // Name of the table the assets are queried as
+	keyColumns []string // Columns whose values, joined by keySeparator, are the ID of an asset
 	// For compatibility with DataDictionary interface
 	Data map[string]map[string]string // This will be empty, just for interface compatibility
 	
 	// Index tracking
-	index         AssetIndex // Index of column effective dates
-	indexFilePath string     // Path to the index file
-	indexModified bool       // Flag to track if index was modified
+	index               *EffectiveDateIndex // Effective date of every column of every asset
+	indexFilePath       string              // Path to the index file
+	legacyIndexFilePath string              // Path to the JSON index of earlier versions, migrated on startup
+	
+	// Column schema tracking
+	schema         *ColumnSchema // Ordered list of all columns and their inferred types
+	schemaFilePath string        // Path to the schema file
+	
+	// Asset files are guarded by one of assetLockCount locks chosen by a hash of
+	// the ID, so loads writing different assets do not wait for each other
+	assetLocks [assetLockCount]sync.RWMutex
+	
+	// Assets recently written, held in memory until evicted or flushed
+	cache *AssetCache
+	
+	// Ingestion
+	ingestWorkers  int                 // Number of files read and partitions written at once
+	csvDialects    []csvDialectRule    // How the CSV files matching each glob are written
+	columnMappings []columnMappingRule // How the headers of the files matching each glob become column names
+	
+	// Durability
+	syncPolicy SyncPolicy // When files are flushed to stable storage
+	wal        *AssetWAL  // Updates not yet written to the asset files and index
+	
+	// Load tracking
+	manifest         *LoadManifest   // Every CSV file merged into the store and the runs that loaded them
+	manifestFilePath string          // Path to the manifest file
+	forceReload      map[string]bool // Files to process on the next load even if they have not changed
 }
 
-// NewJSONAssetManager creates a new JSON asset manager
+// NewJSONAssetManager creates a new JSON asset manager for the BB_ASSETS table
 func NewJSONAssetManager(logger *Logger, progress *ProgressTracker, dataDir string) (*JSONAssetManager, error) {
+	return NewTableAssetManager(logger, progress, dataDir, defaultTable, []string{defaultKeyColumn})
+}
+
+// NewTableAssetManager creates a JSON asset manager for a table whose assets are
+// identified by the given key columns, keeping its files under dataDir
+func NewTableAssetManager(logger *Logger, progress *ProgressTracker, dataDir string, table string, keyColumns []string) (*JSONAssetManager, error) {
 	// Create the JSON directory if it doesn't exist
 	jsonDir := filepath.Join(dataDir, "json")
 	if err := os.MkdirAll(jsonDir, 0755); err != nil {
 		return nil, fmt.Errorf("error creating JSON directory: %v", err)
 	}
 	
-	// Set up the index file path
-	indexFilePath := filepath.Join(dataDir, "asset_index.json")
+	// Set up the index and schema file paths
+	indexFilePath := filepath.Join(dataDir, "asset_index.bin")
+	legacyIndexFilePath := filepath.Join(dataDir, "asset_index.json")
+	schemaFilePath := filepath.Join(dataDir, "column_schema.json")
+	manifestFilePath := filepath.Join(dataDir, "load_manifest.json")
 	
 	manager := &JSONAssetManager{
-		logger:        logger,
-		progress:      progress,
-		jsonDir:       jsonDir,
-		columns:       []string{},
-		Data:          make(map[string]map[string]string), // Empty map for interface compatibility
-		indexFilePath: indexFilePath,
-		indexModified: false,
+		logger:              logger,
+		progress:            progress,
+		jsonDir:             jsonDir,
+		table:               table,
+		keyColumns:          keyColumns,
+		Data:                make(map[string]map[string]string), // Empty map for interface compatibility
+		index:               NewEffectiveDateIndex(),
+		indexFilePath:       indexFilePath,
+		legacyIndexFilePath: legacyIndexFilePath,
+		schema:              NewColumnSchema(),
+		schemaFilePath:      schemaFilePath,
+		cache:               NewAssetCache(defaultCacheBudget),
+		ingestWorkers:       runtime.NumCPU(),
+		syncPolicy:          SyncBatch,
+		manifest:            NewLoadManifest(),
+		manifestFilePath:    manifestFilePath,
 	}
 	
 	// Load the index file if it exists
@@ -70,61 +110,101 @@ func NewJSONAssetManager(logger *Logger, progress *ProgressTracker, dataDir stri
 		logger.Warn("Could not load index file: %v. Creating new index.", err)
 	}
 	
+	// Load the column schema if it exists so columns from earlier runs stay queryable
+	if err := manager.schema.Load(schemaFilePath); err != nil {
+		logger.Warn("Could not load schema file: %v. Creating new schema.", err)
+	} else if count := len(manager.schema.Columns()); count > 0 {
+		logger.Info("Loaded schema file with %d columns", count)
+	}
+	
+	// Load the manifest if it exists so files loaded by earlier runs are not processed again
+	if err := manager.manifest.Load(manifestFilePath); err != nil {
+		logger.Warn("Could not load manifest file: %v. All files will be processed.", err)
+	} else if runs := manager.manifest.Runs(); len(runs) > 0 {
+		logger.Info("Loaded manifest file with %d load runs", len(runs))
+	}
+	
+	// Without an index, values loaded earlier would be overwritten by older files
+	if manager.index.IDCount() == 0 {
+		loaded := 0
+		for _, load := range manager.manifest.Files() {
+			if load.RowsLoaded > 0 {
+				loaded++
+			}
+		}
+		if loaded > 0 {
+			logger.Warn("The index is empty but %d files were loaded before. Run \"datamatrix reindex\" to rebuild it.", loaded)
+		}
+	}
+	
+	// Open the write-ahead log and replay any updates a crash kept from being written
+	wal, err := OpenAssetWAL(filepath.Join(dataDir, "wal"), manager.syncPolicy, manager.index)
+	if err != nil {
+		return nil, err
+	}
+	manager.wal = wal
+	if err := manager.replayWAL(); err != nil {
+		return nil, fmt.Errorf("error replaying write-ahead log: %v", err)
+	}
+	
 	return manager, nil
 }
 
-// loadIndex loads the index file if it exists
+// loadIndex loads the index file if it exists, migrating the JSON index of
+// earlier versions to the current format the first time it is found
 func (j *JSONAssetManager) loadIndex() error {
-	j.Lock()
-	defer j.Unlock()
-	
-	// Check if the index file exists
-	if _, err := os.Stat(j.indexFilePath); os.IsNotExist(err) {
-		// Index file doesn't exist, initialize an empty index
-		j.index = AssetIndex{
-			Entries: []ColumnIndex{},
+	// Load the index file if it exists
+	if _, err := os.Stat(j.indexFilePath); err == nil {
+		index, err := LoadEffectiveDateIndex(j.indexFilePath)
+		if err != nil {
+			return err
 		}
+		j.index = index
+		
+		entries, ids, _ := index.Stats()
+		j.logger.Info("Loaded index file with %d entries for %d assets", entries, ids)
 		return nil
 	}
 	
-	// Read the index file
-	data, err := os.ReadFile(j.indexFilePath)
+	// Without either file, start with an empty index
+	if _, err := os.Stat(j.legacyIndexFilePath); os.IsNotExist(err) {
+		return nil
+	}
+	
+	// Convert the JSON index and save it in the current format
+	j.logger.Info("Migrating index file %s to %s", j.legacyIndexFilePath, j.indexFilePath)
+	index, err := LoadLegacyEffectiveDateIndex(j.legacyIndexFilePath)
 	if err != nil {
-		return fmt.Errorf("error reading index file: %v", err)
+		return err
+	}
+	if err := index.Save(j.indexFilePath, j.getSyncPolicy() != SyncNone); err != nil {
+		return fmt.Errorf("error saving migrated index: %v", err)
 	}
+	j.index = index
 	
-	// Parse the JSON
-	if err := json.Unmarshal(data, &j.index); err != nil {
-		return fmt.Errorf("error parsing index file: %v", err)
+	// Keep the old file, renamed so it is not migrated again
+	if err := os.Rename(j.legacyIndexFilePath, j.legacyIndexFilePath+".migrated"); err != nil {
+		j.logger.Warn("Could not rename migrated index file %s: %v", j.legacyIndexFilePath, err)
 	}
 	
-	j.logger.Info("Loaded index file with %d entries", len(j.index.Entries))
+	entries, ids, _ := index.Stats()
+	j.logger.Success("Migrated index with %d entries for %d assets", entries, ids)
 	return nil
 }
 
 // saveIndex saves the index to the index file
 func (j *JSONAssetManager) saveIndex() error {
-	j.Lock()
-	defer j.Unlock()
-	
 	// Only save if the index was modified
-	if !j.indexModified {
+	if !j.index.Modified() {
 		return nil
 	}
 	
-	// Convert to JSON
-	data, err := json.MarshalIndent(j.index, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error converting index to JSON: %v", err)
+	if err := j.index.Save(j.indexFilePath, j.getSyncPolicy() != SyncNone); err != nil {
+		return err
 	}
 	
-	// Write to file
-	if err := os.WriteFile(j.indexFilePath, data, 0644); err != nil {
-		return fmt.Errorf("error writing index file: %v", err)
-	}
-	
-	j.indexModified = false
-	j.logger.Info("Saved index file with %d entries", len(j.index.Entries))
+	entries, _, _ := j.index.Stats()
+	j.logger.Info("Saved index file with %d entries", entries)
 	return nil
 }
 
@@ -145,56 +225,59 @@ func (j *JSONAssetManager) getEffectiveDateFromFilename(filename string) string
 	return time.Now().Format("20060102")
 }
 
-// getColumnEffectiveDate gets the effective date for a column from the index
-func (j *JSONAssetManager) getColumnEffectiveDate(id, columnName string) string {
-	j.RLock()
-	defer j.RUnlock()
-	
-	for _, entry := range j.index.Entries {
-		if entry.ID == id && entry.ColumnName == columnName {
-			return entry.EffectiveDate
-		}
-	}
-	
-	return "" // No effective date found
+// SetIDPrefixFilter sets the ID_BB_GLOBAL prefix filter
+func (j *JSONAssetManager) SetIDPrefixFilter(prefixes []string) {
+	j.Lock()
+	defer j.Unlock()
+	j.idPrefixFilter = prefixes
 }
 
-// updateColumnEffectiveDate updates the effective date for a column in the index
-func (j *JSONAssetManager) updateColumnEffectiveDate(id, columnName, effectiveDate string) {
+// SetIDPrefixWhitelist is an alias for SetIDPrefixFilter for compatibility with DataDictionary
+func (j *JSONAssetManager) SetIDPrefixWhitelist(prefixes []string) {
+	j.SetIDPrefixFilter(prefixes)
+}
+
+// SetForceReload sets files to process on the next load even if they have not
+// changed since an earlier run
+func (j *JSONAssetManager) SetForceReload(filePaths []string) {
 	j.Lock()
 	defer j.Unlock()
-	
-	// Check if the entry already exists
-	for i, entry := range j.index.Entries {
-		if entry.ID == id && entry.ColumnName == columnName {
-			// Only update if the new date is newer
-			if effectiveDate > entry.EffectiveDate {
-				j.index.Entries[i].EffectiveDate = effectiveDate
-				j.indexModified = true
-			}
-			return
-		}
+	j.forceReload = make(map[string]bool, len(filePaths))
+	for _, filePath := range filePaths {
+		j.forceReload[filepath.Clean(filePath)] = true
 	}
-	
-	// Entry doesn't exist, add it
-	j.index.Entries = append(j.index.Entries, ColumnIndex{
-		ID:           id,
-		ColumnName:   columnName,
-		EffectiveDate: effectiveDate,
-	})
-	j.indexModified = true
 }
 
-// SetIDPrefixFilter sets the ID_BB_GLOBAL prefix filter
-func (j *JSONAssetManager) SetIDPrefixFilter(prefixes []string) {
+// SetIngestWorkers sets the number of CSV files read and asset partitions
+// written at once while loading. Values below 1 load one file at a time.
+func (j *JSONAssetManager) SetIngestWorkers(workers int) {
 	j.Lock()
 	defer j.Unlock()
-	j.idPrefixFilter = prefixes
+	if workers < 1 {
+		workers = 1
+	}
+	j.ingestWorkers = workers
 }
 
-// SetIDPrefixWhitelist is an alias for SetIDPrefixFilter for compatibility with DataDictionary
-func (j *JSONAssetManager) SetIDPrefixWhitelist(prefixes []string) {
-	j.SetIDPrefixFilter(prefixes)
+// SetSyncPolicy sets when the asset, index, schema, manifest, history and
+// write-ahead log files are flushed to stable storage
+func (j *JSONAssetManager) SetSyncPolicy(policy SyncPolicy) {
+	j.Lock()
+	j.syncPolicy = policy
+	j.Unlock()
+	j.wal.SetPolicy(policy)
+}
+
+// getSyncPolicy returns when files are flushed to stable storage
+func (j *JSONAssetManager) getSyncPolicy() SyncPolicy {
+	j.RLock()
+	defer j.RUnlock()
+	return j.syncPolicy
+}
+
+// SetCacheBudget sets the memory, in bytes, the asset cache may use
+func (j *JSONAssetManager) SetCacheBudget(budget int64) {
+	j.cache.SetBudget(budget)
 }
 
 // ShouldIncludeID checks if an ID_BB_GLOBAL should be included based on the filter
@@ -227,8 +310,26 @@ func (j *JSONAssetManager) ShouldIncludeID(id string) bool {
 	return false
 }
 
-// GetJSONFilePath returns the path to the JSON file for an ID_BB_GLOBAL
+// GetJSONFilePath returns the path to the JSON file for an ID_BB_GLOBAL,
+// creating its directory if needed
 func (j *JSONAssetManager) GetJSONFilePath(id string) string {
+	if err := j.checkAssetID(id); err != nil {
+		j.logger.Error("Refusing to create a file: %v", err)
+		return ""
+	}
+	filePath := j.assetFilePath(id)
+	
+	// Ensure the directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		j.logger.Error("Error creating directory for ID %s: %v", id, err)
+		return ""
+	}
+	
+	return filePath
+}
+
+// assetFilePath computes the path to the JSON file for an ID_BB_GLOBAL without touching the disk
+func (j *JSONAssetManager) assetFilePath(id string) string {
 	// Convert ID to lowercase for consistent path generation
 	idLower := strings.ToLower(id)
 	
@@ -240,24 +341,49 @@ func (j *JSONAssetManager) GetJSONFilePath(id string) string {
 		pathParts = append(pathParts, string(idLower[i]))
 	}
 	
-	// Create the directory path
-	dirPath := filepath.Join(j.jsonDir, filepath.Join(pathParts...))
-	
-	// Ensure the directory exists
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		j.logger.Error("Error creating directory for ID %s: %v", id, err)
-		return ""
-	}
-	
 	// Return the full path to the JSON file
+	dirPath := filepath.Join(j.jsonDir, filepath.Join(pathParts...))
 	return filepath.Join(dirPath, id+".json")
 }
 
-// LoadOrCreateAsset loads an asset from its JSON file or creates a new one
+// isValidAssetID reports whether an ID can name an asset file. IDs come from
+// requests as well as CSV files, so anything that could escape the JSON
+// directory or nest an asset below another is rejected, both when a row is
+// loaded and when an asset is read; checkAssetID applies it with the rules of
+// the table's key.
+func isValidAssetID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\\x00") && !strings.Contains(id, "..")
+}
+
+// assetStripe returns the number of the asset lock, and cache shard, of an ID
+func assetStripe(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % assetLockCount)
+}
+
+// assetLock returns the lock guarding the JSON and history files of an ID
+func (j *JSONAssetManager) assetLock(id string) *sync.RWMutex {
+	return &j.assetLocks[assetStripe(id)]
+}
+
+// LoadOrCreateAsset loads an asset from the cache or its JSON file, or creates a new one
 func (j *JSONAssetManager) LoadOrCreateAsset(id string) (map[string]string, error) {
-	j.Lock()
-	defer j.Unlock()
-	
+	lock := j.assetLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	if asset, cached := j.cache.get(id); cached {
+		return copyAsset(asset), nil
+	}
+	return j.loadOrCreateAsset(id)
+}
+
+// loadOrCreateAsset loads an asset from its JSON file or creates a new one.
+// The caller must hold the asset's lock.
+func (j *JSONAssetManager) loadOrCreateAsset(id string) (map[string]string, error) {
+	if err := j.checkAssetID(id); err != nil {
+		return nil, err
+	}
 	filePath := j.GetJSONFilePath(id)
 	if filePath == "" {
 		return nil, fmt.Errorf("error getting JSON file path for ID %s", id)
@@ -278,17 +404,23 @@ func (j *JSONAssetManager) LoadOrCreateAsset(id string) (map[string]string, erro
 		}
 	}
 	
-	// Always add the ID_BB_GLOBAL field
-	asset["ID_BB_GLOBAL"] = id
+	// Always add the key columns
+	j.setKeyValues(id, asset)
 	
 	return asset, nil
 }
 
-// SaveAsset saves an asset to its JSON file
+// SaveAsset saves an asset to its JSON file, replacing any cached copy
 func (j *JSONAssetManager) SaveAsset(id string, asset map[string]string) error {
-	j.Lock()
-	defer j.Unlock()
-	
+	lock := j.assetLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	j.cache.remove(id)
+	return j.saveAsset(id, asset)
+}
+
+// saveAsset saves an asset to its JSON file. The caller must hold the asset's lock.
+func (j *JSONAssetManager) saveAsset(id string, asset map[string]string) error {
 	filePath := j.GetJSONFilePath(id)
 	if filePath == "" {
 		return fmt.Errorf("error getting JSON file path for ID %s", id)
@@ -300,8 +432,8 @@ func (j *JSONAssetManager) SaveAsset(id string, asset map[string]string) error {
 		return fmt.Errorf("error converting asset to JSON for ID %s: %v", id, err)
 	}
 	
-	// Write to file
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	// Replace the file only once the new one is complete
+	if err := writeFileAtomic(filePath, data, j.getSyncPolicy() != SyncNone); err != nil {
 		return fmt.Errorf("error writing JSON file for ID %s: %v", id, err)
 	}
 	
@@ -316,22 +448,64 @@ func (j *JSONAssetManager) UpdateAssetFromCSV(id string, header []string, record
 	return err
 }
 
-// UpdateAssetFromCSVWithDate updates an asset with data from a CSV record with effective date
+// UpdateAssetFromCSVWithDate updates an asset with data from a CSV record with effective date.
+// Values it writes have no recorded source file; use LoadCSVFile to record lineage.
+// The asset is written when it leaves the cache or FlushAssets is called.
 // Returns true if any values were updated, false otherwise
 func (j *JSONAssetManager) UpdateAssetFromCSVWithDate(id string, header []string, record []string, effectiveDate string) (bool, error) {
+	return j.updateAssetFromRecord(id, header, record, effectiveDate, unknownSource, 0)
+}
+
+// updateAssetFromRecord updates an asset with data from a CSV record, recording the
+// load source and line of the file the record came from as the lineage of every
+// value it replaces. Every value not seen before for its effective date is also
+// appended to the asset's history, including values older than the current ones.
+func (j *JSONAssetManager) updateAssetFromRecord(id string, header []string, record []string, effectiveDate string, source uint32, row int) (bool, error) {
 	// Check if the ID should be included based on the prefix filter
 	if !j.ShouldIncludeID(id) {
 		return false, nil
 	}
 	
-	// Load or create the asset
-	asset, err := j.LoadOrCreateAsset(id)
-	if err != nil {
-		return false, fmt.Errorf("error loading asset for ID %s: %v", id, err)
+	// The ID names the asset file, so it must not be able to point anywhere else
+	if err := j.checkAssetID(id); err != nil {
+		return false, err
+	}
+	
+	// Reject a malformed date before anything is changed
+	if _, err := parseIndexDate(effectiveDate); err != nil {
+		return false, err
+	}
+	
+	// The asset's files are read and written as one step
+	lock := j.assetLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	
+	// Load or create the asset, reading its file only if it is not cached
+	asset, cached := j.cache.get(id)
+	if !cached {
+		var err error
+		if asset, err = j.loadOrCreateAsset(id); err != nil {
+			return false, fmt.Errorf("error loading asset for ID %s: %v", id, err)
+		}
+	}
+	
+	// An asset stored before history was kept starts its history with its current
+	// values, taken before the index entries are replaced
+	var versions []historyRecord
+	startHistory := !j.hasHistory(id)
+	if startHistory {
+		versions = j.currentVersions(id, asset)
 	}
+	startVersions := len(versions)
+	
+	// Dates already in the history, read only if an older value turns up
+	var recorded map[string]map[string]bool
 	
-	// Track if any values were updated
-	updated := false
+	// New values, applied to the index and the cached asset once they are in the
+	// write-ahead log and the history, so a failure leaves both as they were and
+	// the row can be loaded again
+	changes := make(map[string]string)
 	
 	// Update the asset with the new data
 	for i, value := range record {
@@ -339,211 +513,300 @@ func (j *JSONAssetManager) UpdateAssetFromCSVWithDate(id string, header []string
 			colName := header[i]
 			
 			// Skip empty, null, or N.A. values
-			if value == "" || strings.ToLower(value) == "null" || value == "N.A." {
+			if isMissingValue(value) {
 				continue
 			}
 			
-			// Check if we should update this column based on effective date
-			currentEffectiveDate := j.getColumnEffectiveDate(id, colName)
+			// A column named twice in the header keeps its first value
+			if _, changing := changes[colName]; changing {
+				continue
+			}
 			
-			// Update if:
+			// The value should be updated if:
 			// 1. No effective date exists for this column (first time seeing it)
 			// 2. The new effective date is newer than the current one
-			if currentEffectiveDate == "" || effectiveDate > currentEffectiveDate {
+			current := j.index.Get(id, colName)
+			version := historyRecord{Column: colName, Value: value, Date: effectiveDate, Source: source, Row: row}
+			if current == "" || effectiveDate > current {
 				// Update the value
-				asset[colName] = value
-				
-				// Update the effective date in the index
-				j.updateColumnEffectiveDate(id, colName, effectiveDate)
-				
-				updated = true
-				
-				// Add to columns list if not already present
-				j.addColumnIfNotExists(colName)
+				changes[colName] = value
+				versions = append(versions, version)
+				continue
+			}
+			
+			// A value older than the current one is still part of the history,
+			// unless it was recorded when its file was loaded before
+			if current == effectiveDate {
+				continue
+			}
+			if recorded == nil {
+				recorded = make(map[string]map[string]bool)
+				if !startHistory {
+					var err error
+					if recorded, err = j.recordedDates(id); err != nil {
+						return false, err
+					}
+				}
+			}
+			if !recorded[colName][effectiveDate] {
+				if recorded[colName] == nil {
+					recorded[colName] = make(map[string]bool)
+				}
+				recorded[colName][effectiveDate] = true
+				versions = append(versions, version)
 			}
 		}
 	}
 	
-	// For compatibility with the existing code, we'll also update the Data map
-	// This is inefficient but ensures compatibility during the transition
-	j.Lock()
-	j.Data[id] = asset
-	j.Unlock()
+	// Log the update, then record the new versions before the asset changes, so
+	// the history always holds at least what the asset does
+	if len(versions) > startVersions {
+		update := walUpdate{ID: id, Date: effectiveDate, Source: source, Row: row, Values: changes, History: versions}
+		if err := j.wal.Append(update); err != nil {
+			return false, err
+		}
+		if err := j.appendHistory(id, versions); err != nil {
+			return false, err
+		}
+	}
 	
-	// Only save if something was updated
-	if updated {
-		// Save the updated asset
-		if err := j.SaveAsset(id, asset); err != nil {
+	// Record the effective date and lineage of each new value in the index
+	for colName, value := range changes {
+		if _, err := j.index.Update(id, colName, effectiveDate, source, row); err != nil {
 			return false, err
 		}
+		j.addColumnIfNotExists(colName)
+		asset[colName] = value
+	}
+	
+	// Keep the asset in memory; a changed asset is written when it is evicted or
+	// the cache is flushed
+	updated := len(changes) > 0
+	if err := j.writeEvicted(j.cache.put(id, asset, updated)); err != nil {
+		return updated, err
 	}
 	
 	return updated, nil
 }
 
-// addColumnIfNotExists adds a column to the list if it doesn't already exist
-func (j *JSONAssetManager) addColumnIfNotExists(colName string) {
-	for _, existingCol := range j.columns {
-		if colName == existingCol {
-			return
+// writeEvicted writes the changed assets the cache is evicting and drops each
+// once it is written. An asset that cannot be written stays cached and dirty,
+// so the next flush writes it, and its update stays in the write-ahead log
+// until then. The caller must hold the assets' lock.
+func (j *JSONAssetManager) writeEvicted(entries []*cacheEntry) error {
+	var firstErr error
+	for _, entry := range entries {
+		if err := j.writeCachedAsset(entry); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error writing evicted asset %s: %v", entry.id, err)
+			}
+			continue
 		}
+		j.cache.evict(entry)
 	}
-	j.columns = append(j.columns, colName)
+	return firstErr
 }
 
-// GetColumns returns the list of all columns
-func (j *JSONAssetManager) GetColumns() []string {
-	j.RLock()
-	defer j.RUnlock()
-	return j.columns
+// writeCachedAsset writes a changed asset held by the cache to its JSON file.
+// The caller must hold the asset's lock.
+func (j *JSONAssetManager) writeCachedAsset(entry *cacheEntry) error {
+	if err := j.saveAsset(entry.id, entry.asset); err != nil {
+		j.logger.Error("Error writing cached asset %s: %v", entry.id, err)
+		return err
+	}
+	j.cache.markClean(entry)
+	return nil
 }
 
-// LoadCSVFile loads a CSV file and updates the JSON assets
-func (j *JSONAssetManager) LoadCSVFile(filePath string) error {
-	fileName := filepath.Base(filePath)
-	j.logger.Info("Loading CSV file: %s", filePath)
-	
-	// Start progress tracking
-	j.progress.StartProgress(fmt.Sprintf("Loading %s", fileName), 0)
-	
-	// Extract the effective date from the filename
-	effectiveDate := j.getEffectiveDateFromFilename(filePath)
-	j.logger.Info("Effective date for file %s: %s", fileName, effectiveDate)
-	
-	// Update progress to show we're opening the file
-	j.progress.SetStatus(fmt.Sprintf("Opening file %s", fileName))
-	
-	// Open the file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
-	}
-	defer file.Close()
-	
-	var reader io.Reader = file
-	
-	// If the file is gzipped, use a gzip reader
-	if strings.HasSuffix(strings.ToLower(filePath), ".gz") {
-		gzReader, err := gzip.NewReader(file)
-		if err != nil {
-			return fmt.Errorf("error creating gzip reader: %v", err)
+// FlushAssets writes every changed asset held by the cache to its JSON file
+func (j *JSONAssetManager) FlushAssets() error {
+	var firstErr error
+	for stripe := range j.assetLocks {
+		lock := &j.assetLocks[stripe]
+		lock.Lock()
+		for _, entry := range j.cache.dirtyEntries(stripe) {
+			if err := j.writeCachedAsset(entry); err != nil && firstErr == nil {
+				firstErr = err
+			}
 		}
-		defer gzReader.Close()
-		reader = gzReader
-	}
-	
-	// Create a CSV reader
-	csvReader := csv.NewReader(reader)
-	
-	// Update progress to show we're reading the CSV header
-	j.progress.SetStatus(fmt.Sprintf("Reading header from %s", fileName))
-	
-	// Read the header
-	header, err := csvReader.Read()
-	if err != nil {
-		return fmt.Errorf("error reading CSV header: %v", err)
+		lock.Unlock()
 	}
-	
-	// Check if the file has an ID_BB_GLOBAL column
-	idIndex := -1
-	for i, col := range header {
-		if col == "ID_BB_GLOBAL" {
-			idIndex = i
-			break
+	j.cache.flushes.Add(1)
+	return firstErr
+}
+
+// GetCacheStats returns the size and effectiveness of the asset cache
+func (j *JSONAssetManager) GetCacheStats() CacheStats {
+	return j.cache.Stats()
+}
+
+// cachedChanges returns copies of the assets changed in the cache and not yet
+// written, whose files a scan would read stale or not find
+func (j *JSONAssetManager) cachedChanges() map[string]map[string]string {
+	changed := make(map[string]map[string]string)
+	for stripe := range j.assetLocks {
+		lock := &j.assetLocks[stripe]
+		lock.RLock()
+		for _, entry := range j.cache.dirtyEntries(stripe) {
+			changed[entry.id] = copyAsset(entry.asset)
 		}
+		lock.RUnlock()
 	}
-	
-	// Skip files without an ID_BB_GLOBAL column
-	if idIndex == -1 {
-		j.logger.Warn("Skipping file %s: No ID_BB_GLOBAL column found", filePath)
-		return nil
+	return changed
+}
+
+// copyAsset returns a copy of an asset that can be changed without changing the cached one
+func copyAsset(asset map[string]string) map[string]string {
+	copied := make(map[string]string, len(asset))
+	for column, value := range asset {
+		copied[column] = value
 	}
-	
-	// Add all columns to the columns list
-	for _, col := range header {
-		j.addColumnIfNotExists(col)
+	return copied
+}
+
+// addColumnIfNotExists adds a column to the list if it doesn't already exist
+func (j *JSONAssetManager) addColumnIfNotExists(colName string) {
+	j.schema.AddColumn(colName)
+}
+
+// GetColumns returns the list of all columns
+func (j *JSONAssetManager) GetColumns() []string {
+	return j.schema.Columns()
+}
+
+// GetColumnDefinitions returns all columns with their inferred types
+func (j *JSONAssetManager) GetColumnDefinitions() []ColumnDefinition {
+	return j.schema.Definitions()
+}
+
+// GetColumnTypes returns a snapshot of the inferred type of every column
+func (j *JSONAssetManager) GetColumnTypes() map[string]ColumnType {
+	return j.schema.Types()
+}
+
+// GetColumnCatalog returns a catalog of all known columns for resolving names in queries
+func (j *JSONAssetManager) GetColumnCatalog() *ColumnCatalog {
+	// The key columns are always present in every asset, even before any file is loaded
+	return NewColumnCatalog(append(j.KeyColumns(), j.schema.Columns()...))
+}
+
+// saveSchema saves the column schema to the schema file
+func (j *JSONAssetManager) saveSchema() error {
+	return j.schema.Save(j.schemaFilePath, j.getSyncPolicy() != SyncNone)
+}
+
+// newLoadRunID returns a unique ID for a load run, starting with the time it began
+func newLoadRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// loadSource describes a CSV file read by a load run, naming the S3 object it was
+// downloaded from when the S3 loader recorded one
+func loadSource(filePath, runID string) LoadSource {
+	source := LoadSource{File: filePath, LoadRun: runID}
+	if object, exists := readS3ObjectMetadata(filePath); exists {
+		source.S3Bucket = object.Bucket
+		source.S3Key = object.Key
+		source.S3VersionID = object.VersionID
 	}
-	
-	// Read and process each row
-	rowCount := 0
-	skippedCount := 0
-	updatedCount := 0
-	
-	// Update progress status
-	j.progress.SetStatus(fmt.Sprintf("Enumerating rows in %s", fileName))
-	
-	for {
-		record, err := csvReader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			j.logger.Warn("Error reading CSV record: %v", err)
-			continue
-		}
-		
-		// Get the ID_BB_GLOBAL value
-		if idIndex >= len(record) {
-			j.logger.Warn("Skipping row: ID_BB_GLOBAL column index out of range")
-			continue
-		}
-		
-		id := record[idIndex]
-		if id == "" {
-			skippedCount++
-			continue
-		}
-		
-		// Update progress with current row count
-		rowCount++
-		if rowCount % 10 == 0 { // Update every 10 rows to keep the progress tracker active
-			j.progress.UpdateProgress(rowCount, fmt.Sprintf("Enumerating %s: %d rows", fileName, rowCount))
-		}
-		
-		// Update the asset with the CSV data and track if updates were made
-		updated, err := j.UpdateAssetFromCSVWithDate(id, header, record, effectiveDate)
-		if err != nil {
-			j.logger.Warn("Error updating asset for ID %s: %v", id, err)
-			skippedCount++
-			continue
-		}
-		
-		if updated {
-			updatedCount++
-		}
+	return source
+}
+
+// LoadCSVFile loads a CSV file and updates the JSON assets as a load run of its
+// own, processing the file even if it has not changed since an earlier run
+func (j *JSONAssetManager) LoadCSVFile(filePath string) error {
+	run := j.loadFiles([]string{filePath}, func(string) bool { return true })
+	if len(run.Failed) > 0 {
+		return errors.New(run.Failed[0].Error)
 	}
+	return nil
+}
+
+// LoadFiles loads multiple CSV files and updates the JSON assets. Files that
+// have not changed since an earlier run are skipped, unless SetForceReload
+// named them. A file that fails to load does not stop the others; the error
+// names the first one once the run is recorded.
+func (j *JSONAssetManager) LoadFiles(filePaths []string) error {
+	// Files are only forced once
+	j.Lock()
+	forceReload := j.forceReload
+	j.forceReload = nil
+	j.Unlock()
 	
-	// Update progress to show we're saving the index
-	j.progress.SetStatus(fmt.Sprintf("Saving index after processing %s", fileName))
-	
-	// Save the index after processing the file
-	if err := j.saveIndex(); err != nil {
-		j.logger.Warn("Error saving index file: %v", err)
+	run := j.loadFiles(filePaths, func(filePath string) bool { return forceReload[filePath] })
+	if run.FilesFailed > 0 {
+		return fmt.Errorf("%d of %d files failed to load, the first %s: %s",
+			run.FilesFailed, len(filePaths), run.Failed[0].Path, run.Failed[0].Error)
 	}
-	
-	// Complete progress tracking
-	j.progress.CompleteProgress(fmt.Sprintf("Completed processing %s", fileName))
-	
-	j.logger.Success("Loaded %d rows from %s (updated %d, skipped %d rows)", 
-		rowCount, filepath.Base(filePath), updatedCount, skippedCount)
 	return nil
 }
 
-// LoadFiles loads multiple CSV files and updates the JSON assets
-func (j *JSONAssetManager) LoadFiles(filePaths []string) error {
+// ReloadFiles processes files loaded by earlier runs again as a new load run,
+// even if they have not changed. Every file must be recorded in the manifest.
+func (j *JSONAssetManager) ReloadFiles(filePaths []string) (LoadRunSummary, error) {
+	for _, filePath := range filePaths {
+		if _, exists := j.manifest.File(filePath); !exists {
+			return LoadRunSummary{}, fmt.Errorf("%w %s", ErrUnknownFile, filePath)
+		}
+	}
+	return j.loadFiles(filePaths, func(string) bool { return true }), nil
+}
+
+// loadFiles processes CSV files as one load run and records the run and the
+// files it loaded in the manifest. Files that have not changed since they were
+// last loaded are skipped unless force reports them.
+func (j *JSONAssetManager) loadFiles(filePaths []string, force func(filePath string) bool) LoadRunSummary {
 	// Start progress tracking for overall file loading
 	j.progress.StartProgress("Loading CSV files", len(filePaths))
-	j.logger.Info("Starting to process %d CSV files", len(filePaths))
 	
-	for i, filePath := range filePaths {
+	// All files loaded together belong to one load run
+	run := LoadRunSummary{ID: newLoadRunID(), StartedAt: time.Now().UTC()}
+	j.logger.Info("Starting load run %s to process %d CSV files", run.ID, len(filePaths))
+	
+	// Skip files merged into the store by an earlier run
+	var pending []string
+	for _, filePath := range filePaths {
+		filePath = filepath.Clean(filePath)
+		if !force(filePath) {
+			unchanged, err := j.fileUnchanged(filePath)
+			if err != nil {
+				j.logger.Warn("Could not check file %s against the manifest: %v", filePath, err)
+			}
+			if unchanged {
+				j.logger.Info("Skipping unchanged file %s", filePath)
+				run.FilesUnchanged++
+				j.progress.UpdateProgress(run.FilesUnchanged, fmt.Sprintf("Skipped unchanged file %s", filepath.Base(filePath)))
+				continue
+			}
+		}
+		pending = append(pending, filePath)
+	}
+	
+	// Files are read in parallel but finish in order
+	processed := run.FilesUnchanged
+	j.ingestFiles(pending, run.ID, func(file *ingestFile) {
 		// Update overall progress with file name
-		fileName := filepath.Base(filePath)
-		j.progress.UpdateProgress(i+1, fmt.Sprintf("Processing file %d of %d: %s", i+1, len(filePaths), fileName))
-		if err := j.LoadCSVFile(filePath); err != nil {
-			j.logger.Error("Error loading file %s: %v", filePath, err)
+		processed++
+		j.progress.UpdateProgress(processed, fmt.Sprintf("Processed file %d of %d: %s", processed, len(filePaths), filepath.Base(file.path)))
+		
+		load, err := j.finishIngestFile(file)
+		if err != nil {
+			j.logger.Error("Error loading file %s: %v", file.path, err)
+			run.Failed = append(run.Failed, FailedFile{Path: file.path, Error: err.Error()})
+			run.FilesFailed++
 			// Continue with other files
+			return
 		}
-	}
+		j.manifest.RecordFile(load)
+		run.addFile(load)
+		
+		// Save the manifest after each file so a restart does not process it again
+		if err := j.saveManifest(); err != nil {
+			j.logger.Warn("Error saving manifest file: %v", err)
+		}
+	})
 	
 	// For compatibility with the existing code, we'll update the Data map
 	// with a placeholder entry. The actual data is stored in JSON files.
@@ -554,9 +817,17 @@ func (j *JSONAssetManager) LoadFiles(filePaths []string) error {
 	// Update progress to show we're saving the final index
 	j.progress.SetStatus("Saving final index after processing all files")
 	
-	// Make sure the index is saved after loading all files
-	if err := j.saveIndex(); err != nil {
-		j.logger.Warn("Error saving index file: %v", err)
+	// Make sure every changed asset, the index and the column schema are saved
+	// after loading all files
+	if err := j.checkpoint(); err != nil {
+		j.logger.Warn("Error saving the store: %v", err)
+	}
+	
+	// Record the run, including one that found nothing new to load
+	run.FinishedAt = time.Now().UTC()
+	j.manifest.AddRun(run)
+	if err := j.saveManifest(); err != nil {
+		j.logger.Warn("Error saving manifest file: %v", err)
 	}
 	
 	// Complete overall progress tracking
@@ -565,61 +836,107 @@ func (j *JSONAssetManager) LoadFiles(filePaths []string) error {
 	// Set system to idle state
 	j.progress.SetStatus("Idle - Ready for queries")
 	
-	j.logger.Success("Processed all files, total columns: %d, index entries: %d", 
-		len(j.columns), len(j.index.Entries))
-	return nil
+	entries, _, _ := j.index.Stats()
+	j.logger.Success("Load run %s processed %d files (%d unchanged, %d failed), total columns: %d, index entries: %d", 
+		run.ID, run.FilesLoaded, run.FilesUnchanged, run.FilesFailed, len(j.GetColumns()), entries)
+	return run
+}
+
+// checkpoint writes the cached assets, index and schema, then removes the
+// write-ahead log segments of the updates they now hold. Updates made meanwhile
+// go to a new segment, which is kept.
+func (j *JSONAssetManager) checkpoint() error {
+	segment, err := j.wal.Rotate()
+	if err != nil {
+		return err
+	}
+	if err := j.FlushAssets(); err != nil {
+		return fmt.Errorf("error writing cached assets: %v", err)
+	}
+	if err := j.saveIndex(); err != nil {
+		return fmt.Errorf("error saving index file: %v", err)
+	}
+	if err := j.saveSchema(); err != nil {
+		return fmt.Errorf("error saving schema file: %v", err)
+	}
+	return j.wal.RemoveThrough(segment)
+}
+
+// Close writes the cached assets, index, schema and manifest so nothing held in
+// memory is lost when the process exits
+func (j *JSONAssetManager) Close() error {
+	if err := j.checkpoint(); err != nil {
+		return err
+	}
+	if err := j.saveManifest(); err != nil {
+		return fmt.Errorf("error saving manifest file: %v", err)
+	}
+	return j.wal.Close()
 }
 
 // GetIndexInfo returns information about the index
 func (j *JSONAssetManager) GetIndexInfo() map[string]interface{} {
-	j.RLock()
-	defer j.RUnlock()
-	
 	// Count unique IDs and columns
-	idMap := make(map[string]bool)
-	colMap := make(map[string]bool)
-	
-	for _, entry := range j.index.Entries {
-		idMap[entry.ID] = true
-		colMap[entry.ColumnName] = true
-	}
+	entries, ids, columns := j.index.Stats()
 	
 	return map[string]interface{}{
-		"total_entries":    len(j.index.Entries),
-		"unique_ids":       len(idMap),
-		"unique_columns":   len(colMap),
+		"total_entries":    entries,
+		"unique_ids":       ids,
+		"unique_columns":   columns,
 		"index_file":       j.indexFilePath,
 	}
 }
 
+// ErrAssetNotFound is returned when no asset exists for an ID_BB_GLOBAL
+var ErrAssetNotFound = errors.New("asset not found")
+
 // GetAsset loads an asset from its JSON file
 func (j *JSONAssetManager) GetAsset(id string) (map[string]string, error) {
-	j.RLock()
-	defer j.RUnlock()
+	lock := j.assetLock(id)
+	lock.RLock()
+	defer lock.RUnlock()
 	
-	filePath := j.GetJSONFilePath(id)
-	if filePath == "" {
-		return nil, fmt.Errorf("error getting JSON file path for ID %s", id)
+	asset, _, err := j.readAsset(id)
+	return asset, err
+}
+
+// GetAssetLineage returns the lineage of every column recorded for an asset
+func (j *JSONAssetManager) GetAssetLineage(id string) (map[string]CellLineage, error) {
+	lineage := j.index.AssetLineage(id)
+	if len(lineage) == 0 {
+		return nil, fmt.Errorf("%w for ID %s", ErrAssetNotFound, id)
+	}
+	return lineage, nil
+}
+
+// readAsset loads an asset from the cache or its JSON file and also returns the
+// size of the file, zero if it was not read. The caller must hold the asset's lock.
+func (j *JSONAssetManager) readAsset(id string) (map[string]string, int, error) {
+	if j.checkAssetID(id) != nil {
+		return nil, 0, fmt.Errorf("%w for ID %q", ErrAssetNotFound, id)
 	}
 	
-	// Check if the file exists
-	if _, err := os.Stat(filePath); err != nil {
-		return nil, fmt.Errorf("asset not found for ID %s", id)
+	// A cached asset may be newer than its file
+	if asset, cached := j.cache.get(id); cached {
+		return copyAsset(asset), 0, nil
 	}
 	
 	// Read the file
-	data, err := os.ReadFile(filePath)
+	data, err := os.ReadFile(j.assetFilePath(id))
+	if os.IsNotExist(err) {
+		return nil, 0, fmt.Errorf("%w for ID %s", ErrAssetNotFound, id)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error reading JSON file for ID %s: %v", id, err)
+		return nil, 0, fmt.Errorf("error reading JSON file for ID %s: %v", id, err)
 	}
 	
 	// Parse the JSON
 	asset := make(map[string]string)
 	if err := json.Unmarshal(data, &asset); err != nil {
-		return nil, fmt.Errorf("error parsing JSON file for ID %s: %v", id, err)
+		return nil, 0, fmt.Errorf("error parsing JSON file for ID %s: %v", id, err)
 	}
 	
-	return asset, nil
+	return asset, len(data), nil
 }
 
 // GetAssetWithColumns loads an asset and returns only the requested columns
@@ -646,27 +963,273 @@ func (j *JSONAssetManager) GetAssetWithColumns(id string, columns []string) (map
 	return result, nil
 }
 
-// ExecuteSQLQuery executes a SQL query against the JSON assets
-func (j *JSONAssetManager) ExecuteSQLQuery(sqlQuery string) ([]map[string]string, error) {
-	// Parse the SQL query
-	query, err := ParseSQL(sqlQuery)
+// ExecuteSQLQuery executes a SQL query with optional placeholder params against the JSON assets.
+// EXPLAIN returns only the query plan; EXPLAIN ANALYZE also runs the query and
+// adds what each stage of it cost to the plan.
+func (j *JSONAssetManager) ExecuteSQLQuery(sqlQuery string, params *QueryParams) (*QueryResult, error) {
+	start := time.Now()
+	
+	// Parse the SQL query, binding any placeholders to their values
+	query, err := ParseSQLWithParams(sqlQuery, params)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SQL query: %w", err)
+	}
+	return j.executeParsedQuery(sqlQuery, query, start)
+}
+
+// executeParsedQuery executes a parsed SQL query against the JSON assets,
+// counting the time since start as spent parsing it
+func (j *JSONAssetManager) executeParsedQuery(sqlQuery string, query *SQLQuery, start time.Time) (*QueryResult, error) {
+	stats := &QueryStats{}
+	mark := stats.timeStage("parse", start)
+	
+	// Check if the table is this manager's table or the CHANGES table function
+	isChanges := query.FromTable == changesTable
+	if query.FromTable != j.table && !isChanges {
+		return nil, queryErrorf("%v: %s", ErrUnknownTable, query.FromTable)
+	}
+	
+	// Resolve column names case-insensitively to their canonical spelling
+	catalog := j.GetColumnCatalog()
+	if isChanges {
+		catalog = NewColumnCatalog(changesColumns)
+	}
+	if err := query.Bind(catalog); err != nil {
+		return nil, err
+	}
+	mark = stats.timeStage("bind", mark)
+	
+	// A WHERE clause that pins the key columns to a few values only needs their
+	// files; anything else has to read all of them
+	keyColumns := j.keyColumns
+	if isChanges {
+		keyColumns = []string{changesID}
+	}
+	ids, isLookup := keyLookupIDs(query.Where, keyColumns)
+	var plan *QueryPlan
+	if isChanges {
+		from, to, err := changesPoints(query)
+		if err != nil {
+			return nil, err
+		}
+		detail := fmt.Sprintf("compare every asset between %s and %s using its history file", from, to)
+		estimated := j.estimateAssetCount()
+		if isLookup {
+			detail = fmt.Sprintf("compare only the assets of the %s values in the WHERE clause between %s and %s", strings.Join(j.keyColumns, ", "), from, to)
+			estimated = int64(len(ids))
+		}
+		plan = newQueryPlan(sqlQuery, query, AccessPath{Type: AccessChangesScan, Detail: detail}, estimated)
+	} else if isLookup {
+		plan = newQueryPlan(sqlQuery, query, AccessPath{
+			Type:   AccessIDLookup,
+			Detail: fmt.Sprintf("read only the asset files of the %s values in the WHERE clause", strings.Join(j.keyColumns, ", ")),
+		}, int64(len(ids)))
+	} else {
+		plan = newQueryPlan(sqlQuery, query, AccessPath{
+			Type:   AccessFullScan,
+			Detail: fmt.Sprintf("read every asset file under %s", j.jsonDir),
+		}, j.estimateAssetCount())
+	}
+	if query.AsOf != "" {
+		plan.AccessPath.Detail += fmt.Sprintf(", then go back to %s using each asset's history file", query.AsOf)
+	}
+	stats.timeStage("plan", mark)
+	
+	if query.Explain && !query.Analyze {
+		return &QueryResult{Rows: []map[string]interface{}{}, Plan: plan}, nil
+	}
+	
+	var result *QueryResult
+	var err error
+	if isChanges {
+		// A non-nil list limits the comparison to its IDs, even when it is empty
+		var lookupIDs []string
+		if isLookup {
+			lookupIDs = append([]string{}, ids...)
+		}
+		result, err = j.executeChangesQuery(query, lookupIDs, stats)
+	} else if isLookup {
+		result, err = j.executeSQLQueryLookup(query, ids, stats)
+	} else {
+		result, err = j.executeSQLQueryScan(query, stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	stats.RowsReturned = len(result.Rows)
+	stats.ElapsedMs = durationMs(time.Since(start))
+	if query.Explain {
+		plan.Stats = stats
+		result.Plan = plan
+	}
+	return result, nil
+}
+
+// estimateAssetCount returns the number of assets in the effective date index,
+// which is the number of files a full scan reads
+func (j *JSONAssetManager) estimateAssetCount() int64 {
+	return int64(j.index.IDCount())
+}
+
+// executeSQLQueryLookup executes a SQL query by reading only the assets with the
+// given IDs. The full WHERE clause is still applied to each of them.
+func (j *JSONAssetManager) executeSQLQueryLookup(query *SQLQuery, ids []string, stats *QueryStats) (*QueryResult, error) {
+	// Matching rows are grouped, counted, ordered and paginated as they arrive
+	sink, err := newRowSink(query)
+	if err != nil {
+		return nil, err
+	}
+	
+	mark, err := j.lookupRows(query, ids, func(row Row) (bool, error) {
+		return EvalCondition(query.Where, row)
+	}, sink.Add, stats)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Grouping, sorting and projection happen once every row has been seen
+	result, err := sink.Result()
+	if err != nil {
+		return nil, err
+	}
+	stats.timeStage("finalize", mark)
+	return result, nil
+}
+
+// lookupRows reads the assets with the given IDs and passes the rows for which
+// match holds to add, recording the files read, rows matched and time spent in
+// each stage in stats. It returns when the last stage ended.
+func (j *JSONAssetManager) lookupRows(query *SQLQuery, ids []string, match func(Row) (bool, error), add func(Row) error, stats *QueryStats) (time.Time, error) {
+	// Snapshot the column types so values compare as numbers, booleans and dates
+	types := j.schema.Types()
+	
+	mark := time.Now()
+	for _, id := range ids {
+		// IDs without an asset file simply do not match
+		lock := j.assetLock(id)
+		lock.RLock()
+		asset, size, err := j.readAsset(id)
+		lock.RUnlock()
+		if errors.Is(err, ErrAssetNotFound) {
+			continue
+		}
+		if err != nil {
+			j.logger.Warn("%v", err)
+			continue
+		}
+		stats.FilesRead++
+		stats.BytesParsed += int64(size)
+		mark = stats.timeStage("read", mark)
+		
+		// Go back to the AS OF date if the query has one
+		row, exists, err := j.queryRow(query, asset, types)
+		if err != nil {
+			j.logger.Warn("%v", err)
+			continue
+		}
+		if query.AsOf != "" {
+			mark = stats.timeStage("history", mark)
+		}
+		if !exists {
+			continue
+		}
+		
+		// Apply the WHERE clause
+		stats.RowsExamined++
+		matches, err := match(row)
+		if err != nil {
+			return mark, fmt.Errorf("error evaluating WHERE clause: %v", err)
+		}
+		mark = stats.timeStage("filter", mark)
+		if !matches {
+			continue
+		}
+		stats.RowsMatched++
+		
+		// Include the asset in the results
+		if err := add(row); err != nil {
+			return mark, err
+		}
+		mark = stats.timeStage("collect", mark)
+	}
+	return stats.timeStage("read", mark), nil
+}
+
+// executeSQLQueryScan scans all JSON files to execute a SQL query, recording
+// the files read, rows matched and time spent in each stage in stats
+func (j *JSONAssetManager) executeSQLQueryScan(query *SQLQuery, stats *QueryStats) (*QueryResult, error) {
+	// Matching rows are grouped, counted, ordered and paginated as they arrive
+	sink, err := newRowSink(query)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing SQL query: %v", err)
+		return nil, err
 	}
 	
-	// Check if the table is BB_ASSETS
-	if query.FromTable != "BB_ASSETS" {
-		return nil, fmt.Errorf("unknown table: %s", query.FromTable)
+	mark, err := j.scanRows(query, func(row Row) (bool, error) {
+		return EvalCondition(query.Where, row)
+	}, sink.Add, stats)
+	if err != nil {
+		return nil, err
 	}
 	
-	// For now, we'll need to scan all JSON files to execute the query
-	// In a future enhancement, we could implement indexing for faster queries
-	return j.executeSQLQueryScan(query)
+	// Grouping, sorting and projection happen once every row has been seen
+	result, err := sink.Result()
+	if err != nil {
+		return nil, err
+	}
+	stats.timeStage("finalize", mark)
+	return result, nil
 }
 
-// executeSQLQueryScan scans all JSON files to execute a SQL query
-func (j *JSONAssetManager) executeSQLQueryScan(query *SQLQuery) ([]map[string]string, error) {
-	var results []map[string]string
+// scanRows reads every JSON file and passes the rows for which match holds to
+// add, recording the files read, rows matched and time spent in each stage in
+// stats. Assets changed in the cache and not yet written are read from the
+// cache instead. It returns when the last stage ended.
+func (j *JSONAssetManager) scanRows(query *SQLQuery, match func(Row) (bool, error), add func(Row) error, stats *QueryStats) (time.Time, error) {
+	// Snapshot the column types so values compare as numbers, booleans and dates
+	types := j.schema.Types()
+	
+	// Assets changed in the cache are examined as cached rather than read from
+	// their files, which are stale or not yet written
+	changed := j.cachedChanges()
+	
+	// Time between visits to asset files is spent walking the directory tree
+	mark := time.Now()
+	
+	// examine passes an asset to add if it matches
+	examine := func(asset map[string]string) error {
+		// Go back to the AS OF date if the query has one
+		row, exists, err := j.queryRow(query, asset, types)
+		if err != nil {
+			j.logger.Warn("%v", err)
+			return nil
+		}
+		if query.AsOf != "" {
+			mark = stats.timeStage("history", mark)
+		}
+		if !exists {
+			return nil
+		}
+		
+		// Apply the WHERE clause if present
+		stats.RowsExamined++
+		matches, err := match(row)
+		if err != nil {
+			return fmt.Errorf("error evaluating WHERE clause: %v", err)
+		}
+		mark = stats.timeStage("filter", mark)
+		if !matches {
+			return nil
+		}
+		stats.RowsMatched++
+		
+		// Include the asset in the results
+		if err := add(row); err != nil {
+			return err
+		}
+		mark = stats.timeStage("collect", mark)
+		return nil
+	}
 	
 	// Walk through the JSON directory
 	err := filepath.Walk(j.jsonDir, func(path string, info os.FileInfo, err error) error {
@@ -683,6 +1246,14 @@ func (j *JSONAssetManager) executeSQLQueryScan(query *SQLQuery) ([]map[string]st
 		if !strings.HasSuffix(strings.ToLower(path), ".json") {
 			return nil
 		}
+		mark = stats.timeStage("walk", mark)
+		
+		// Use the cached version of an asset changed since its file was written
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		if asset, exists := changed[id]; exists {
+			delete(changed, id)
+			return examine(asset)
+		}
 		
 		// Read the JSON file
 		data, err := os.ReadFile(path)
@@ -690,6 +1261,8 @@ func (j *JSONAssetManager) executeSQLQueryScan(query *SQLQuery) ([]map[string]st
 			j.logger.Warn("Error reading JSON file %s: %v", path, err)
 			return nil
 		}
+		stats.FilesRead++
+		mark = stats.timeStage("read", mark)
 		
 		// Parse the JSON
 		asset := make(map[string]string)
@@ -697,57 +1270,32 @@ func (j *JSONAssetManager) executeSQLQueryScan(query *SQLQuery) ([]map[string]st
 			j.logger.Warn("Error parsing JSON file %s: %v", path, err)
 			return nil
 		}
+		stats.BytesParsed += int64(len(data))
+		mark = stats.timeStage("decode", mark)
 		
-		// Apply the WHERE clause if present
-		if query.HasWhere {
-			whereValue, exists := asset[query.WhereColumn]
-			if !exists {
-				return nil
-			}
-			
-			// Check the condition
-			matches := false
-			switch query.WhereOperator {
-			case "=":
-				matches = whereValue == query.WhereValue
-			case ">":
-				matches = whereValue > query.WhereValue
-			case "<":
-				matches = whereValue < query.WhereValue
-			case ">=":
-				matches = whereValue >= query.WhereValue
-			case "<=":
-				matches = whereValue <= query.WhereValue
-			case "!=":
-				matches = whereValue != query.WhereValue
-			}
-			
-			if !matches {
-				return nil
-			}
+		return examine(asset)
+	})
+	
+	// Assets left over have no file yet; they are examined in ID order
+	if err == nil {
+		ids := make([]string, 0, len(changed))
+		for id := range changed {
+			ids = append(ids, id)
 		}
-		
-		// Include the asset in the results
-		if query.SelectColumns[0] == "*" {
-			// Select all columns
-			results = append(results, asset)
-		} else {
-			// Select specific columns
-			selectedAsset := make(map[string]string)
-			for _, col := range query.SelectColumns {
-				if value, exists := asset[col]; exists {
-					selectedAsset[col] = value
-				}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err = examine(changed[id]); err != nil {
+				break
 			}
-			results = append(results, selectedAsset)
 		}
-		
-		return nil
-	})
+	}
 	
 	if err != nil {
-		return nil, fmt.Errorf("error scanning JSON files: %v", err)
+		// A join outgrowing its memory is reported as it is
+		if IsQueryInputError(err) {
+			return mark, err
+		}
+		return mark, fmt.Errorf("error scanning JSON files: %v", err)
 	}
-	
-	return results, nil
+	return stats.timeStage("walk", mark), nil
 }
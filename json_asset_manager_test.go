@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestManager opens a BB_ASSETS store in a temporary directory
+func newTestManager(t *testing.T, dataDir string) *JSONAssetManager {
+	t.Helper()
+	logger := NewLogger()
+	m, err := NewJSONAssetManager(logger, NewProgressTracker(logger), dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// writeCSV writes a CSV file into a directory and returns its path
+func writeCSV(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	filePath := filepath.Join(dir, name)
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestLoadSkipsRowsWithInvalidIDs(t *testing.T) {
+	root := t.TempDir()
+	dataDir := filepath.Join(root, "data")
+	m := newTestManager(t, dataDir)
+	filePath := writeCSV(t, t.TempDir(), "assets_20250101.csv",
+		"ID_BB_GLOBAL,Company\n../../../pwn,Evil\nBRK/B,Berkshire\n..,Dots\nBBG000B9XRY4,Apple\n")
+	if err := m.LoadCSVFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the valid asset was written, and nothing outside its own trie path
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Ext(path) == ".json" && filepath.Dir(path) != dataDir {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, rel)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join("data", "json", "b", "b", "g", "0", "0", "0", "b", "9", "x", "r", "y", "4", "BBG000B9XRY4.json")
+	if len(files) != 1 || files[0] != want {
+		t.Errorf("asset files = %q, want only %s", files, want)
+	}
+	if _, err := os.Stat(filepath.Join(m.jsonDir, "b", "r", "k")); !os.IsNotExist(err) {
+		t.Errorf("BRK/B created trie directories: %v", err)
+	}
+
+	// Reading applies the same rule
+	for _, id := range []string{"../../../pwn", "BRK/B"} {
+		if _, err := m.GetAsset(id); !errors.Is(err, ErrAssetNotFound) {
+			t.Errorf("GetAsset(%q) error = %v, want ErrAssetNotFound", id, err)
+		}
+	}
+	if _, err := m.GetAsset("BBG000B9XRY4"); err != nil {
+		t.Errorf("GetAsset of the valid ID: %v", err)
+	}
+}
+
+// blockAssetFile puts a directory where the temporary file of an asset goes, so
+// writing the asset fails while reading it still works. It returns the function
+// that removes the directory again.
+func blockAssetFile(t *testing.T, m *JSONAssetManager, id string) func() {
+	t.Helper()
+	tempPath := m.GetJSONFilePath(id) + ".tmp"
+	if err := os.MkdirAll(filepath.Join(tempPath, "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if err := os.RemoveAll(tempPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// blockHistoryFile puts a directory in place of the history file of an asset,
+// so storing a change to the asset fails. It returns the function that removes
+// the directory again.
+func blockHistoryFile(t *testing.T, m *JSONAssetManager, id string) func() {
+	t.Helper()
+	m.GetJSONFilePath(id)
+	history := m.historyFilePath(id)
+	if err := os.Remove(history); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(history, "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if err := os.RemoveAll(history); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUpdateKeepsAssetCachedWhenEvictionWriteFails(t *testing.T) {
+	dataDir := t.TempDir()
+	m := newTestManager(t, dataDir)
+	header := []string{"ID_BB_GLOBAL", "PX_LAST"}
+
+	// BAD is cached before its file is blocked, so only writing it fails
+	if _, err := m.UpdateAssetFromCSVWithDate("BAD", header, []string{"BAD", "5"}, "20250101"); err != nil {
+		t.Fatal(err)
+	}
+	unblock := blockAssetFile(t, m, "BAD")
+
+	// Every asset is evicted as soon as it is put
+	m.SetCacheBudget(1)
+	if _, err := m.UpdateAssetFromCSVWithDate("BAD", header, []string{"BAD", "10"}, "20250102"); err == nil {
+		t.Fatal("update of an asset that cannot be written succeeded")
+	}
+	if _, err := m.UpdateAssetFromCSVWithDate("OK", header, []string{"OK", "20"}, "20250101"); err != nil {
+		t.Fatalf("update of a writable asset failed: %v", err)
+	}
+	if stats := m.GetCacheStats(); stats.DirtyEntries != 1 {
+		t.Fatalf("cache holds %d dirty assets, want the one that could not be written", stats.DirtyEntries)
+	}
+	asset, err := m.GetAsset("BAD")
+	if err != nil || asset["PX_LAST"] != "10" {
+		t.Fatalf("GetAsset(BAD) = %v, %v, want the cached value 10", asset, err)
+	}
+
+	// A flush keeps it too while it cannot be written
+	if err := m.FlushAssets(); err == nil {
+		t.Fatal("flush succeeded with an asset it could not write")
+	}
+	if stats := m.GetCacheStats(); stats.DirtyEntries != 1 {
+		t.Fatalf("cache holds %d dirty assets after the failed flush, want 1", stats.DirtyEntries)
+	}
+
+	// A checkpoint cannot drop the logged update while the asset is unwritten
+	if err := m.checkpoint(); err == nil {
+		t.Fatal("checkpoint succeeded with an asset it could not write")
+	}
+
+	unblock()
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close after the asset became writable: %v", err)
+	}
+	reopened := newTestManager(t, dataDir)
+	asset, err = reopened.GetAsset("BAD")
+	if err != nil || asset["PX_LAST"] != "10" {
+		t.Fatalf("GetAsset(BAD) after reopening = %v, %v, want 10", asset, err)
+	}
+}
+
+func TestScanReadsChangesStillInTheCache(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	header := []string{"ID_BB_GLOBAL", "PX_LAST"}
+	update := func(id, value, date string) {
+		t.Helper()
+		if _, err := m.UpdateAssetFromCSVWithDate(id, header, []string{id, value}, date); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update("WRITTEN", "1", "20250101")
+	update("STALE", "1", "20250101")
+	if err := m.FlushAssets(); err != nil {
+		t.Fatal(err)
+	}
+	// Newer values only the cache holds, and an asset without a file
+	update("STALE", "2", "20250102")
+	update("NEW", "3", "20250102")
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "WRITTEN", want: "1"},
+		{id: "STALE", want: "2"},
+		{id: "NEW", want: "3"},
+	}
+	scan, err := m.ExecuteSQLQuery("SELECT ID_BB_GLOBAL, PX_LAST FROM BB_ASSETS", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Rows) != len(tests) {
+		t.Fatalf("full scan returned %d rows, want %d: %v", len(scan.Rows), len(tests), scan.Rows)
+	}
+	scanned := make(map[interface{}]interface{})
+	for _, row := range scan.Rows {
+		scanned[row["ID_BB_GLOBAL"]] = row["PX_LAST"]
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			lookup, err := m.ExecuteSQLQuery("SELECT PX_LAST FROM BB_ASSETS WHERE ID_BB_GLOBAL = ?", &QueryParams{Positional: []interface{}{tt.id}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lookup.Rows) != 1 || lookup.Rows[0]["PX_LAST"] != tt.want {
+				t.Errorf("lookup of %s = %v, want PX_LAST %s", tt.id, lookup.Rows, tt.want)
+			}
+			if scanned[tt.id] != tt.want {
+				t.Errorf("full scan has PX_LAST %v for %s, want %s", scanned[tt.id], tt.id, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedUpdateLeavesIndexUnchanged(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(t *testing.T, m *JSONAssetManager)
+	}{
+		{
+			name: "write-ahead log cannot be appended to",
+			fail: func(t *testing.T, m *JSONAssetManager) {
+				if err := m.wal.Close(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "history cannot be written",
+			fail: func(t *testing.T, m *JSONAssetManager) {
+				blockHistoryFile(t, m, "A1")
+			},
+		},
+	}
+	header := []string{"ID_BB_GLOBAL", "PX_LAST"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, t.TempDir())
+			if _, err := m.UpdateAssetFromCSVWithDate("A1", header, []string{"A1", "1"}, "20250101"); err != nil {
+				t.Fatal(err)
+			}
+			tt.fail(t, m)
+
+			if _, err := m.UpdateAssetFromCSVWithDate("A1", header, []string{"A1", "2"}, "20250102"); err == nil {
+				t.Fatal("update succeeded without its write-ahead log or history")
+			}
+			if date := m.index.Get("A1", "PX_LAST"); date != "20250101" {
+				t.Errorf("index has effective date %s for PX_LAST, want 20250101 from before the failed update", date)
+			}
+			if asset, err := m.GetAsset("A1"); err != nil || asset["PX_LAST"] != "1" {
+				t.Errorf("GetAsset(A1) = %v, %v, want PX_LAST 1", asset, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxManifestRuns is the number of load runs the manifest remembers. Older runs
+// are dropped, but the files they loaded stay recorded.
+const maxManifestRuns = 1000
+
+// ErrUnknownFile is returned when a file to reload was never loaded
+var ErrUnknownFile = errors.New("file was never loaded")
+
+// FileLoad records a CSV file as it was when a load run processed it
+type FileLoad struct {
+	Path          string    `json:"path"`
+	Size          int64     `json:"size"`
+	ModTime       time.Time `json:"mtime"`
+	SHA256        string    `json:"sha256"`
+	EffectiveDate string    `json:"effective_date"`
+	RowsLoaded    int       `json:"rows_loaded"`  // Rows with a value for every key column
+	RowsUpdated   int       `json:"rows_updated"` // Rows that changed at least one value
+	RowsSkipped   int       `json:"rows_skipped"` // Rows without an ID or that could not be read
+	LoadRun       string    `json:"load_run"`
+	LoadedAt      time.Time `json:"loaded_at"`
+}
+
+// FailedFile is a file a load run could not process. It is not recorded in the
+// manifest, so the next run tries it again.
+type FailedFile struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// LoadRunSummary describes one load run: the files it processed, those it skipped
+// because they had not changed since an earlier run, and the rows it read
+type LoadRunSummary struct {
+	ID             string       `json:"id"`
+	StartedAt      time.Time    `json:"started_at"`
+	FinishedAt     time.Time    `json:"finished_at"`
+	FilesLoaded    int          `json:"files_loaded"`
+	FilesUnchanged int          `json:"files_unchanged"`
+	FilesFailed    int          `json:"files_failed"`
+	RowsLoaded     int          `json:"rows_loaded"`
+	RowsUpdated    int          `json:"rows_updated"`
+	RowsSkipped    int          `json:"rows_skipped"`
+	Files          []FileLoad   `json:"files"`            // The files processed, in the order they were loaded
+	Failed         []FailedFile `json:"failed,omitempty"` // The files that could not be processed
+}
+
+// addFile counts a file the run processed
+func (s *LoadRunSummary) addFile(load FileLoad) {
+	s.Files = append(s.Files, load)
+	s.FilesLoaded++
+	s.RowsLoaded += load.RowsLoaded
+	s.RowsUpdated += load.RowsUpdated
+	s.RowsSkipped += load.RowsSkipped
+}
+
+// LoadManifest remembers every CSV file merged into the store and the load runs
+// that did it, so a restart only has to process new or changed files
+type LoadManifest struct {
+	sync.RWMutex
+	files    map[string]FileLoad // The latest load of each file, by path
+	runs     []LoadRunSummary    // Load runs, oldest first
+	modified bool
+}
+
+// loadManifestFile is the on-disk representation of a LoadManifest
+type loadManifestFile struct {
+	Files []FileLoad       `json:"files"`
+	Runs  []LoadRunSummary `json:"runs"`
+}
+
+// NewLoadManifest creates an empty load manifest
+func NewLoadManifest() *LoadManifest {
+	return &LoadManifest{files: make(map[string]FileLoad)}
+}
+
+// Load reads the manifest from a file if it exists
+func (m *LoadManifest) Load(filePath string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	// A missing manifest just means nothing has been loaded yet
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading manifest file: %v", err)
+	}
+
+	var file loadManifestFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("error parsing manifest file: %v", err)
+	}
+
+	m.files = make(map[string]FileLoad, len(file.Files))
+	for _, load := range file.Files {
+		m.files[load.Path] = load
+	}
+	m.runs = file.Runs
+	m.modified = false
+	return nil
+}
+
+// Save writes the manifest to a file if it was modified. The file is written
+// under a temporary name and renamed, so a crash never leaves half a manifest,
+// and with sync set it is flushed to stable storage first.
+func (m *LoadManifest) Save(filePath string, sync bool) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.modified {
+		return nil
+	}
+
+	file := loadManifestFile{Files: make([]FileLoad, 0, len(m.files)), Runs: m.runs}
+	for _, load := range m.files {
+		file.Files = append(file.Files, load)
+	}
+	// Sorting by path keeps the file stable between saves
+	sort.Slice(file.Files, func(a, b int) bool { return file.Files[a].Path < file.Files[b].Path })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error converting manifest to JSON: %v", err)
+	}
+
+	if err := writeFileAtomic(filePath, data, sync); err != nil {
+		return fmt.Errorf("error writing manifest file: %v", err)
+	}
+
+	m.modified = false
+	return nil
+}
+
+// File returns the latest load of a file and whether it was ever loaded
+func (m *LoadManifest) File(path string) (FileLoad, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	load, exists := m.files[filepath.Clean(path)]
+	return load, exists
+}
+
+// Files returns the latest load of every file, sorted by path
+func (m *LoadManifest) Files() []FileLoad {
+	m.RLock()
+	defer m.RUnlock()
+	files := make([]FileLoad, 0, len(m.files))
+	for _, load := range m.files {
+		files = append(files, load)
+	}
+	sort.Slice(files, func(a, b int) bool { return files[a].Path < files[b].Path })
+	return files
+}
+
+// RecordFile records a file a load run processed
+func (m *LoadManifest) RecordFile(load FileLoad) {
+	m.Lock()
+	defer m.Unlock()
+	m.files[load.Path] = load
+	m.modified = true
+}
+
+// touchFile records a new modification time for a file whose contents did not change
+func (m *LoadManifest) touchFile(path string, modTime time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	if load, exists := m.files[path]; exists {
+		load.ModTime = modTime
+		m.files[path] = load
+		m.modified = true
+	}
+}
+
+// AddRun records a finished load run, dropping the oldest runs past maxManifestRuns
+func (m *LoadManifest) AddRun(run LoadRunSummary) {
+	m.Lock()
+	defer m.Unlock()
+	m.runs = append(m.runs, run)
+	if len(m.runs) > maxManifestRuns {
+		m.runs = append([]LoadRunSummary(nil), m.runs[len(m.runs)-maxManifestRuns:]...)
+	}
+	m.modified = true
+}
+
+// Runs returns the recorded load runs, newest first
+func (m *LoadManifest) Runs() []LoadRunSummary {
+	m.RLock()
+	defer m.RUnlock()
+	runs := make([]LoadRunSummary, len(m.runs))
+	for i, run := range m.runs {
+		runs[len(m.runs)-1-i] = run
+	}
+	return runs
+}
+
+// hashFile computes the SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileUnchanged reports whether a file is the same as when it was last loaded.
+// A file with the recorded size and modification time is taken to be unchanged
+// without reading it; otherwise it is unchanged only if its SHA-256 still matches.
+func (j *JSONAssetManager) fileUnchanged(path string) (bool, error) {
+	load, exists := j.manifest.File(path)
+	if !exists {
+		return false, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != load.Size {
+		return false, nil
+	}
+	if info.ModTime().Equal(load.ModTime) {
+		return true, nil
+	}
+
+	// A file copied or downloaded again keeps its contents but not its time
+	sum, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	if sum != load.SHA256 {
+		return false, nil
+	}
+	j.manifest.touchFile(load.Path, info.ModTime())
+	return true, nil
+}
+
+// saveManifest saves the load manifest to the manifest file
+func (j *JSONAssetManager) saveManifest() error {
+	return j.manifest.Save(j.manifestFilePath, j.getSyncPolicy() != SyncNone)
+}
+
+// GetLoadRuns returns the recorded load runs, newest first
+func (j *JSONAssetManager) GetLoadRuns() []LoadRunSummary {
+	return j.manifest.Runs()
+}
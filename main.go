@@ -2,12 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -18,7 +23,7 @@ import (
 // DataMatrix manages the JSON-based asset storage
 type DataMatrix struct {
 	sync.RWMutex
-	assetManager   *JSONAssetManager
+	tables         *TableSet // BB_ASSETS and the configured tables
 	logger         *Logger
 	progress       *ProgressTracker
 	s3Bucket       string   // S3 bucket name (optional)
@@ -30,12 +35,20 @@ type DataMatrix struct {
 
 // DataMatrixConfig holds configuration for DataMatrix initialization
 type DataMatrixConfig struct {
-	S3Bucket       string   `json:"s3_bucket,omitempty"`       // Optional S3 bucket name
-	S3Prefix       string   `json:"s3_prefix,omitempty"`       // Optional S3 prefix/path within the bucket
-	DataDir        string   `json:"data_dir,omitempty"`        // Directory for downloaded S3 files (default: "data")
-	DirWhitelist   []string `json:"dir_whitelist,omitempty"`   // Optional whitelist of directory names
-	IDPrefixFilter []string `json:"id_prefix_filter,omitempty"` // Optional ID_BB_GLOBAL prefix filter
-	ConfigFile     string   `json:"-"`                         // Path to the configuration file (not stored in JSON)
+	S3Bucket       string          `json:"s3_bucket,omitempty"`        // Optional S3 bucket name
+	S3Prefix       string          `json:"s3_prefix,omitempty"`        // Optional S3 prefix/path within the bucket
+	DataDir        string          `json:"data_dir,omitempty"`         // Directory for downloaded S3 files (default: "data")
+	DirWhitelist   []string        `json:"dir_whitelist,omitempty"`    // Optional whitelist of directory names
+	IDPrefixFilter []string        `json:"id_prefix_filter,omitempty"` // Optional ID_BB_GLOBAL prefix filter
+	ForceReload    []string        `json:"force_reload,omitempty"`     // Optional files to process on startup even if they have not changed
+	IngestWorkers  int             `json:"ingest_workers,omitempty"`   // Optional number of files loaded at once (default: number of CPUs)
+	CacheMemoryMB  int             `json:"cache_memory_mb,omitempty"`  // Optional memory for cached assets in megabytes (default: 256)
+	JoinMemoryMB   int             `json:"join_memory_mb,omitempty"`   // Optional memory for the rows a JOIN holds in megabytes (default: 512)
+	SyncPolicy     string          `json:"sync_policy,omitempty"`      // Optional when files are flushed to disk: none, batch or always (default: batch)
+	CSVDialects    []CSVDialect    `json:"csv_dialects,omitempty"`     // Optional dialects of CSV files by glob (default: detected from each file)
+	ColumnMappings []ColumnMapping `json:"column_mappings,omitempty"`  // Optional renaming, dropping and normalisation of header names by glob
+	Tables         []TableConfig   `json:"tables,omitempty"`           // Optional tables besides BB_ASSETS, each with its key columns, directories and root
+	ConfigFile     string          `json:"-"`                          // Path to the configuration file (not stored in JSON)
 }
 
 func NewDataMatrix(config *DataMatrixConfig) (*DataMatrix, error) {
@@ -55,20 +68,42 @@ func NewDataMatrix(config *DataMatrixConfig) (*DataMatrix, error) {
 		dataDir = config.DataDir
 	}
 	
-	// Create a new JSON asset manager
-	assetManager, err := NewJSONAssetManager(logger, progress, dataDir)
+	// Open the tables, each a JSON asset store of its own
+	var tableConfigs []TableConfig
+	if config != nil {
+		tableConfigs = config.Tables
+	}
+	tables, err := NewTableSet(logger, progress, dataDir, tableConfigs)
 	if err != nil {
-		logger.Error("Error creating JSON asset manager: %v", err)
+		logger.Error("Error opening tables: %v", err)
 		return nil, err
 	}
 	
-	// Set ID prefix filter if specified
+	// Set the memory for the rows a JOIN holds if specified
+	if config != nil && config.JoinMemoryMB > 0 {
+		tables.SetJoinMemory(int64(config.JoinMemoryMB) << 20)
+	}
+	
+	// Set ID prefix filter if specified. It applies to ID_BB_GLOBAL, the key of BB_ASSETS
 	if config != nil && len(config.IDPrefixFilter) > 0 {
-		assetManager.SetIDPrefixFilter(config.IDPrefixFilter)
+		tables.Default().SetIDPrefixFilter(config.IDPrefixFilter)
+	}
+	
+	// The remaining settings apply to every table
+	for _, assetManager := range tables.Managers() {
+		if err := configureAssetManager(assetManager, config, logger); err != nil {
+			tables.Close()
+			return nil, err
+		}
+	}
+	
+	// Report files to process again even if they have not changed
+	if config != nil && len(config.ForceReload) > 0 {
+		logger.Info("Forcing %d files to be processed again", len(config.ForceReload))
 	}
 
 	dm := &DataMatrix{
-		assetManager:   assetManager,
+		tables:         tables,
 		logger:         logger,
 		progress:       progress,
 		s3Bucket:       config.S3Bucket,
@@ -90,7 +125,52 @@ func NewDataMatrix(config *DataMatrixConfig) (*DataMatrix, error) {
 	return dm, nil
 }
 
-// findCSVFiles recursively finds CSV files up to maxDepth levels deep
+// configureAssetManager applies the settings of a configuration to a table
+func configureAssetManager(assetManager *JSONAssetManager, config *DataMatrixConfig, logger *Logger) error {
+	// Set the number of files loaded at once if specified
+	if config != nil && config.IngestWorkers > 0 {
+		assetManager.SetIngestWorkers(config.IngestWorkers)
+	}
+	
+	// Set the memory for cached assets if specified
+	if config != nil && config.CacheMemoryMB > 0 {
+		assetManager.SetCacheBudget(int64(config.CacheMemoryMB) << 20)
+	}
+	
+	// Set when files are flushed to stable storage if specified
+	if config != nil && config.SyncPolicy != "" {
+		policy, err := ParseSyncPolicy(config.SyncPolicy)
+		if err != nil {
+			logger.Error("Error in configuration: %v", err)
+			return err
+		}
+		assetManager.SetSyncPolicy(policy)
+	}
+	
+	// Set how CSV files are written if specified
+	if config != nil && len(config.CSVDialects) > 0 {
+		if err := assetManager.SetCSVDialects(config.CSVDialects); err != nil {
+			logger.Error("Error in configuration: %v", err)
+			return err
+		}
+	}
+	
+	// Set how the headers of files become column names if specified
+	if config != nil && len(config.ColumnMappings) > 0 {
+		if err := assetManager.SetColumnMappings(config.ColumnMappings); err != nil {
+			logger.Error("Error in configuration: %v", err)
+			return err
+		}
+	}
+	
+	// Set files to process again even if they have not changed
+	if config != nil && len(config.ForceReload) > 0 {
+		assetManager.SetForceReload(config.ForceReload)
+	}
+	return nil
+}
+
+// findCSVFiles recursively finds CSV and Bloomberg Data License files up to maxDepth levels deep
 func findCSVFiles(baseDir string, currentDepth, maxDepth int, logger *Logger) ([]string, error) {
 	if currentDepth > maxDepth {
 		return nil, nil
@@ -114,7 +194,7 @@ func findCSVFiles(baseDir string, currentDepth, maxDepth int, logger *Logger) ([
 				continue
 			}
 			csvFiles = append(csvFiles, subFiles...)
-		} else if strings.HasSuffix(strings.ToLower(file.Name()), ".csv") {
+		} else if strings.HasSuffix(strings.ToLower(file.Name()), ".csv") || isBloombergFileName(file.Name()) {
 			logger.Debug("Found CSV file: %s", path)
 			csvFiles = append(csvFiles, path)
 		}
@@ -166,40 +246,90 @@ func (dm *DataMatrix) loadData() error {
 	// Load the CSV files into our JSON asset store
 	dm.logger.Info("Loading CSV files into JSON asset store...")
 	
-	// Load all CSV files into the JSON asset store
-	err = dm.assetManager.LoadFiles(csvFiles)
+	// Load all new or changed CSV files into the JSON asset store
+	err = dm.tables.LoadFiles(csvFiles)
 	if err != nil {
 		return fmt.Errorf("error loading CSV files into JSON asset store: %v", err)
 	}
 	
 	// Success message - we don't need to check for empty data as files are stored on disk
 	dm.logger.Success("Loaded CSV files into JSON asset store with %d columns", 
-		len(dm.assetManager.GetColumns()))
+		len(dm.tables.Default().GetColumns()))
 	return nil
 }
 
 func (dm *DataMatrix) Close() error {
-	// Nothing special to close with our file-based implementation
+	// Wait for any load in progress, then write what is still held in memory
+	dm.Lock()
+	defer dm.Unlock()
+	
 	dm.logger.Info("Closing DataMatrix...")
+	if err := dm.tables.Close(); err != nil {
+		dm.logger.Error("Error closing asset store: %v", err)
+		return err
+	}
 	dm.logger.Success("DataMatrix closed successfully")
 	return nil
 }
 
+// requestTable returns the table a request names, BB_ASSETS if it names none.
+// An unknown table is answered with a 400 response.
+func (dm *DataMatrix) requestTable(w http.ResponseWriter, name string) (*JSONAssetManager, bool) {
+	table, err := dm.tables.Table(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return table, true
+}
+
+// TablesResponse defines the structure for the tables API response
+type TablesResponse struct {
+	Tables []TableInfo `json:"tables"` // BB_ASSETS first, then the configured tables
+	Count  int         `json:"count"`  // Number of tables
+}
+
+// @Summary List tables
+// @Description Returns the tables that can be queried: BB_ASSETS and those defined in the configuration,
+// @Description each with its key columns, the directories its files are loaded from, where it is stored and its size.
+// @Tags columns
+// @Produce json
+// @Success 200 {object} TablesResponse
+// @Router /api/tables [get]
+func (dm *DataMatrix) handleGetTables(w http.ResponseWriter, r *http.Request) {
+	dm.RLock()
+	defer dm.RUnlock()
+
+	tables := dm.tables.Info()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TablesResponse{Tables: tables, Count: len(tables)})
+}
+
 // @Summary Get all available columns
 // @Description Returns the list of all columns available in the data_matrix table
+// @Description along with the type inferred for each column (integer, decimal, boolean, date, datetime or string)
+// @Description Columns a column mapping renamed list the header names they were loaded from.
 // @Tags columns
 // @Produce json
+// @Param table query string false "Table to list the columns of, BB_ASSETS if omitted"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Unknown table"
 // @Router /api/columns [get]
 func (dm *DataMatrix) handleGetColumns(w http.ResponseWriter, r *http.Request) {
 	dm.RLock()
 	defer dm.RUnlock()
 
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	columns := dm.assetManager.GetColumns()
+	columns := table.GetColumns()
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"columns": columns,
 		"count":   len(columns),
+		"schema":  table.GetColumnDefinitions(),
 	})
 }
 
@@ -207,17 +337,43 @@ func (dm *DataMatrix) handleGetColumns(w http.ResponseWriter, r *http.Request) {
 // @Description Returns information about the asset index including effective dates
 // @Tags index
 // @Produce json
+// @Param table query string false "Table to describe the index of, BB_ASSETS if omitted"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Unknown table"
 // @Router /api/index [get]
 func (dm *DataMatrix) handleGetIndexInfo(w http.ResponseWriter, r *http.Request) {
 	dm.RLock()
 	defer dm.RUnlock()
 
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	indexInfo := dm.assetManager.GetIndexInfo()
+	indexInfo := table.GetIndexInfo()
 	json.NewEncoder(w).Encode(indexInfo)
 }
 
+// @Summary Get asset cache statistics
+// @Description Returns the number and estimated size of the assets held in memory, how many are waiting to be written,
+// @Description and how many lookups were answered from memory rather than by reading an asset file
+// @Tags index
+// @Produce json
+// @Param table query string false "Table to report the cache of, BB_ASSETS if omitted"
+// @Success 200 {object} CacheStats
+// @Failure 400 {string} string "Unknown table"
+// @Router /api/cache [get]
+func (dm *DataMatrix) handleGetCacheStats(w http.ResponseWriter, r *http.Request) {
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(table.GetCacheStats())
+}
+
 // @Summary Get progress information
 // @Description Returns the current progress status of file processing, row enumeration, and idle status
 // @Tags progress
@@ -264,20 +420,52 @@ type QueryRequest struct {
 	Columns []string `json:"columns" example:"[\"ID_BB_GLOBAL\",\"Company\",\"Revenue\"]"` 
 
 	// Optional SQL WHERE clause to filter results (e.g., "Revenue > 200 AND Industry = 'Technology'")
-	Where   string   `json:"where,omitempty" example:"Revenue > 200"`
+	// Values can be written as ? or :name placeholders and supplied in params instead of in the SQL text
+	Where   string   `json:"where,omitempty" example:"Revenue > ? AND ID_BB_GLOBAL IN (?)"`
 
-	// Optional limit for the number of results to return
+	// Optional values for placeholders: an array for ? placeholders, in the order they appear,
+	// or an object for :name placeholders. An array value expands to a list inside IN (?)
+	Params  interface{} `json:"params,omitempty"`
+
+	// Optional SQL GROUP BY terms (e.g., "Industry"). Columns may then include aggregates such as "COUNT(*)" or "AVG(MarketCap)"
+	GroupBy string   `json:"group_by,omitempty" example:"Industry"`
+
+	// Optional SQL HAVING condition applied to each group (e.g., "COUNT(*) > 5")
+	Having  string   `json:"having,omitempty" example:"COUNT(*) > 5"`
+
+	// Optional flag to return only distinct rows
+	Distinct bool    `json:"distinct,omitempty" example:"false"`
+
+	// Optional SQL ORDER BY terms (e.g., "MarketCap DESC NULLS LAST, Company")
+	OrderBy string   `json:"order_by,omitempty" example:"MarketCap DESC"`
+
+	// Optional limit for the number of results to return. Omitted or 0 returns all matching rows
 	Limit   int      `json:"limit,omitempty" example:"10"`
 
 	// Optional offset for pagination
 	Offset  int      `json:"offset,omitempty" example:"0"`
+
+	// Optional flag to also return the query plan with the files read, rows matched and time spent in each stage
+	Explain bool     `json:"explain,omitempty" example:"false"`
+
+	// Optional date, YYYYMMDD or YYYY-MM-DD, to query the values as they were on that date instead of the current ones
+	AsOf    string   `json:"as_of,omitempty" example:"20250401"`
+
+	// Optional table to query, as listed by /api/tables. Omitted queries BB_ASSETS
+	Table   string   `json:"table,omitempty" example:"BB_ASSETS"`
+
+	// Optional JOIN clauses adding other tables (e.g., "LEFT JOIN ISSUERS i ON ID_BB_ULT_PARENT = i.ID")
+	// The queried table is referred to by its name. Columns may then be qualified, as in "i.Rating"
+	Join    string   `json:"join,omitempty" example:"LEFT JOIN ISSUERS i ON ID_BB_ULT_PARENT = i.ID"`
 }
 
 // QueryResponse defines the structure for the query API response
 type QueryResponse struct {
-	Data  []map[string]interface{} `json:"data"`  // The query results
-	Count int                      `json:"count"` // Number of results returned
-	Total int64                    `json:"total"` // Total number of records in the database
+	Data     []map[string]interface{} `json:"data"`               // The query results
+	Count    int                      `json:"count"`              // Number of results returned
+	Total    int64                    `json:"total"`              // Total number of matching records before limit and offset
+	Warnings []string                 `json:"warnings,omitempty"` // Requested columns that do not exist and were left out
+	Plan     *QueryPlan               `json:"plan,omitempty"`     // How the query was executed, only when explain was requested
 }
 
 // @Summary Query the data_matrix table
@@ -288,84 +476,776 @@ type QueryResponse struct {
 // @Description 3) Explicitly use ["*"] as the columns value
 // @Description All three approaches will return all columns for the matching rows.
 // @Description Column names are case-insensitive, so you can use "revenue", "REVENUE", or "Revenue" interchangeably.
+// @Description Requested columns that do not exist are left out and listed in the warnings field of the response.
+// @Description Use order_by, limit and offset to sort and page through results; total is the number of matching rows before paging.
+// @Description Use group_by and having with aggregate columns such as COUNT(*) or AVG(MarketCap) to summarise groups of rows.
+// @Description Set explain to true to also get the query plan: the parsed query, access path, estimated rows and, per stage, where the time went.
+// @Description Use join to add other tables with JOIN or LEFT JOIN clauses; columns of every table can then be selected, filtered and sorted on.
 // @Tags query
 // @Accept json
 // @Produce json
 // @Param query body QueryRequest true "Query parameters"
 // @Success 200 {object} QueryResponse
-// @Failure 400 {string} string "Invalid request body"
+// @Failure 400 {string} string "Invalid request body, syntax error, unknown column or unknown table"
 // @Failure 500 {string} string "Query error"
 // @Router /api/query [post]
 func (dm *DataMatrix) handleQuery(w http.ResponseWriter, r *http.Request) {
 	var params QueryRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+	// Keep parameter numbers exact rather than converting them to float64
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&params); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	queryParams, err := NewQueryParams(params.Params)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	if params.Limit < 0 || params.Offset < 0 {
+		http.Error(w, "Invalid request body: limit and offset must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	// The date is checked here as it is written into the SQL text
+	asOf := strings.ReplaceAll(params.AsOf, "-", "")
+	if asOf != "" {
+		if _, err := time.Parse("20060102", asOf); err != nil {
+			http.Error(w, "Invalid request body: as_of must be a date written YYYYMMDD or YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
 	dm.RLock()
 	defer dm.RUnlock()
 
+	table, ok := dm.requestTable(w, params.Table)
+	if !ok {
+		return
+	}
+
 	// If no columns specified, return all columns
 	if len(params.Columns) == 0 {
 		params.Columns = []string{"*"}
 	}
 
-	// Construct SQL query with case-insensitive column handling
-	var columnList string
-	if len(params.Columns) == 1 && params.Columns[0] == "*" {
-		// If selecting all columns, just use *
-		columnList = "*"
-	} else {
-		// Otherwise, make each column name case-insensitive using ILIKE
-		columnParts := make([]string, len(params.Columns))
-		for i, col := range params.Columns {
-			// For each column, find the actual column name with correct case
-			columnParts[i] = fmt.Sprintf("CASE WHEN '%s' ILIKE 'id_bb_global' THEN ID_BB_GLOBAL ELSE "+
-				"(SELECT CASE WHEN COUNT(*) > 0 THEN MAX("+
-				"CASE WHEN LOWER(column_name) = LOWER('%s') THEN column_name END)"+
-				" ELSE '%s' END FROM pragma_table_info('data_matrix') WHERE LOWER(name) = LOWER('%s')) END", 
-				col, col, col, col)
+	// Resolve the requested columns against the column catalogs of the table
+	// and of any tables joined to it
+	scopes := []tableScope{{Name: table.Table(), Catalog: table.GetColumnCatalog()}}
+	if params.Join != "" {
+		scopes, err = dm.tables.JoinScopes(table.Table(), params.Join, queryParams)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if IsQueryInputError(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("Query error: %v", err), status)
+			return
 		}
-		columnList = strings.Join(columnParts, ", ")
+	}
+	columnList, warnings, err := buildSelectList(params.Columns, scopes)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Query error: %v", err), http.StatusBadRequest)
+		return
 	}
 
 	// Build a SQL query string for our custom implementation
-	sqlQuery := "SELECT " + columnList + " FROM BB_ASSETS"
+	sqlQuery := "SELECT "
+	if params.Explain {
+		// EXPLAIN ANALYZE still returns the rows, with the plan and its costs alongside
+		sqlQuery = "EXPLAIN ANALYZE " + sqlQuery
+	}
+	if params.Distinct {
+		sqlQuery += "DISTINCT "
+	}
+	sqlQuery += columnList + " FROM " + table.Table()
+	if asOf != "" {
+		sqlQuery += " AS OF '" + asOf + "'"
+	}
+	if params.Join != "" {
+		sqlQuery += " " + params.Join
+	}
 	if params.Where != "" {
 		sqlQuery += " WHERE " + params.Where
 	}
+	if params.GroupBy != "" {
+		sqlQuery += " GROUP BY " + params.GroupBy
+	}
+	if params.Having != "" {
+		sqlQuery += " HAVING " + params.Having
+	}
+	if params.OrderBy != "" {
+		sqlQuery += " ORDER BY " + params.OrderBy
+	}
+	if params.Limit > 0 {
+		sqlQuery += fmt.Sprintf(" LIMIT %d", params.Limit)
+	}
+	if params.Offset > 0 {
+		sqlQuery += fmt.Sprintf(" OFFSET %d", params.Offset)
+	}
 
-	// Execute the query against our JSON asset store
-	result, err := dm.assetManager.ExecuteSQLQuery(sqlQuery)
+	// Execute the query against the table's JSON asset store
+	result, err := dm.tables.ExecuteSQLQuery(sqlQuery, queryParams)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Query error: %v", err), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if IsQueryInputError(err) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, fmt.Sprintf("Query error: %v", err), status)
 		return
 	}
 
-	// For total count, we'll use the result count as an approximation
-	// since we don't keep a full list of IDs in memory anymore
-	total := int64(len(result))
+	// Values are already typed, so numbers and booleans encode as JSON numbers and booleans
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(QueryResponse{
+		Data:     result.Rows,
+		Count:    len(result.Rows),
+		Total:    result.Total,
+		Warnings: warnings,
+		Plan:     result.Plan,
+	})
+}
 
-	// Convert result from []map[string]string to []map[string]interface{}
-	interfaceResult := make([]map[string]interface{}, len(result))
-	for i, row := range result {
-		interfaceRow := make(map[string]interface{})
-		for k, v := range row {
-			interfaceRow[k] = v
+// buildSelectList turns the columns of a query request into a SQL select list.
+// Plain column names, optionally qualified with their table as in "i.Rating",
+// are resolved case-insensitively to their canonical spelling against the tables
+// the query reads, and other entries, such as "COUNT(*)" or "MarketCap / Revenue
+// AS ps_ratio", are parsed as expressions. Every entry is written back out with
+// its column names resolved and quoted, so a name is never read as SQL it was
+// not meant as, and entries that name a missing column, or one several tables
+// have, are left out and reported as warnings.
+func buildSelectList(columns []string, scopes []tableScope) (string, []string, error) {
+	// If selecting all columns, just use *
+	if len(columns) == 0 || (len(columns) == 1 && columns[0] == "*") {
+		return "*", nil, nil
+	}
+	
+	var parts []string
+	var warnings []string
+	for _, col := range columns {
+		// A name that matches a column is used as is, even if it contains spaces
+		ref := &ColumnRef{Name: col}
+		err := resolveColumn(ref, scopes)
+		if err == nil {
+			parts = append(parts, ref.String())
+			continue
 		}
-		interfaceResult[i] = interfaceRow
+		var unknownErr *UnknownColumnError
+		if !errors.As(err, &unknownErr) {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		
+		// Anything that is not a valid expression is a column that does not exist
+		item, parseErr := ParseSelectItem(col)
+		if parseErr != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		err = walkExpr(item.Expr, func(expr Expr) error {
+			if ref, ok := expr.(*ColumnRef); ok {
+				return resolveColumn(ref, scopes)
+			}
+			return nil
+		})
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		parts = append(parts, item.String())
 	}
+	
+	if len(parts) == 0 {
+		return "", warnings, fmt.Errorf("none of the requested columns exist: %s", strings.Join(warnings, "; "))
+	}
+	return strings.Join(parts, ", "), warnings, nil
+}
 
+// maxBatchGetIDs is the largest number of IDs a single batchGet request may ask for
+const maxBatchGetIDs = 10000
+
+// AssetResponse defines the structure for the single asset API response
+type AssetResponse struct {
+	Data     map[string]interface{} `json:"data"`               // The asset, with values converted to their column types
+	Warnings []string               `json:"warnings,omitempty"` // Requested columns that do not exist and were left out
+}
+
+// BatchGetRequest defines the structure for the batch asset API request
+type BatchGetRequest struct {
+	// IDs of the assets to return: ID_BB_GLOBAL values, or the key values of the table joined by "|"
+	IDs     []string `json:"ids" example:"BBG000B9XRY4,BBG000BPH459"`
+
+	// Optional list of columns to return, all columns if empty or omitted. The key columns are always returned. Column names are case-insensitive
+	Columns []string `json:"columns,omitempty" example:"Company,Revenue"`
+
+	// Optional table the assets are in, as listed by /api/tables. Omitted reads BB_ASSETS
+	Table   string   `json:"table,omitempty" example:"BB_ASSETS"`
+}
+
+// BatchGetResponse defines the structure for the batch asset API response
+type BatchGetResponse struct {
+	Data     []map[string]interface{} `json:"data"`               // The assets that were found, in the order requested
+	Count    int                      `json:"count"`              // Number of assets returned
+	Missing  []string                 `json:"missing,omitempty"`  // Requested IDs that have no asset
+	Warnings []string                 `json:"warnings,omitempty"` // Requested columns that do not exist and were left out
+}
+
+// @Summary Get an asset
+// @Description Returns a single asset by its ID_BB_GLOBAL, reading only its own file.
+// @Description Use the columns parameter to return only some columns; requested columns that do not exist are listed in warnings.
+// @Tags assets
+// @Produce json
+// @Param id path string true "ID_BB_GLOBAL of the asset"
+// @Param columns query string false "Comma-separated list of columns to return"
+// @Param table query string false "Table the asset is in, BB_ASSETS if omitted"
+// @Success 200 {object} AssetResponse
+// @Failure 400 {string} string "None of the requested columns exist, or unknown table"
+// @Failure 404 {string} string "Asset not found"
+// @Failure 500 {string} string "Error reading the asset"
+// @Router /api/assets/{id} [get]
+func (dm *DataMatrix) handleGetAsset(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	
+	var requested []string
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		requested = strings.Split(columns, ",")
+	}
+	
+	dm.RLock()
+	defer dm.RUnlock()
+	
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+	
+	columns, warnings, err := resolveColumns(requested, table.GetColumnCatalog())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	asset, err := table.GetAssetWithColumns(id, columns)
+	if errors.Is(err, ErrAssetNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(QueryResponse{
-		Data:  interfaceResult,
-		Count: len(result),
-		Total: total,
+	json.NewEncoder(w).Encode(AssetResponse{
+		Data:     NewTypedRow(asset, table.GetColumnTypes()).JSON(),
+		Warnings: warnings,
+	})
+}
+
+// AssetLineageResponse is the response of GET /api/assets/{id}/lineage
+type AssetLineageResponse struct {
+	ID       string                 `json:"id"`
+	Lineage  map[string]CellLineage `json:"lineage"`            // Lineage of each column's current value, keyed by column
+	Warnings []string               `json:"warnings,omitempty"` // Requested columns that do not exist and were left out
+}
+
+// @Summary Get the lineage of an asset
+// @Description Returns, for each column of an asset, where its current value came from: the effective date,
+// @Description the CSV file, the S3 bucket, key and version it was downloaded from, the line of the file and the load run.
+// @Description Values loaded before lineage was recorded only report their effective date.
+// @Tags assets
+// @Produce json
+// @Param id path string true "ID_BB_GLOBAL of the asset"
+// @Param columns query string false "Comma-separated list of columns to report"
+// @Param table query string false "Table the asset is in, BB_ASSETS if omitted"
+// @Success 200 {object} AssetLineageResponse
+// @Failure 400 {string} string "None of the requested columns exist, or unknown table"
+// @Failure 404 {string} string "Asset not found"
+// @Router /api/assets/{id}/lineage [get]
+func (dm *DataMatrix) handleGetAssetLineage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	
+	var requested []string
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		requested = strings.Split(columns, ",")
+	}
+	
+	dm.RLock()
+	defer dm.RUnlock()
+	
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+	
+	columns, warnings, err := resolveColumns(requested, table.GetColumnCatalog())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	lineage, err := table.GetAssetLineage(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	
+	// Keep only the requested columns
+	if columns[0] != "*" {
+		for column := range lineage {
+			if !containsString(columns, column) {
+				delete(lineage, column)
+			}
+		}
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AssetLineageResponse{
+		ID:       id,
+		Lineage:  lineage,
+		Warnings: warnings,
 	})
 }
 
+// HistoryPoint is a value a column of an asset has had, from its effective date on
+type HistoryPoint struct {
+	Value interface{} `json:"value"`
+	CellLineage
+}
+
+// AssetHistoryResponse is the response of GET /api/assets/{id}/history
+type AssetHistoryResponse struct {
+	ID      string         `json:"id"`
+	Column  string         `json:"column"`
+	History []HistoryPoint `json:"history"` // Every value the column has had, oldest effective date first
+}
+
+// @Summary Get the history of a column of an asset
+// @Description Returns every value a column of an asset has had, oldest effective date first, each with the lineage of the file it was loaded from.
+// @Description Assets stored before history was kept report their current value until it is first replaced.
+// @Tags assets
+// @Produce json
+// @Param id path string true "ID_BB_GLOBAL of the asset"
+// @Param column query string true "Column to return the history of"
+// @Param table query string false "Table the asset is in, BB_ASSETS if omitted"
+// @Success 200 {object} AssetHistoryResponse
+// @Failure 400 {string} string "Missing or unknown column, or unknown table"
+// @Failure 404 {string} string "Asset not found"
+// @Failure 500 {string} string "Error reading the history"
+// @Router /api/assets/{id}/history [get]
+func (dm *DataMatrix) handleGetAssetHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	
+	requested := r.URL.Query().Get("column")
+	if requested == "" {
+		http.Error(w, "the column query parameter is required", http.StatusBadRequest)
+		return
+	}
+	
+	dm.RLock()
+	defer dm.RUnlock()
+	
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+	
+	column, err := table.GetColumnCatalog().Resolve(requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	versions, err := table.GetAssetHistory(id, column)
+	if errors.Is(err, ErrAssetNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	
+	// Convert values to the column type, as in query results
+	columnType := table.GetColumnTypes()[column]
+	history := make([]HistoryPoint, len(versions))
+	for i, version := range versions {
+		history[i] = HistoryPoint{
+			Value:       typedValue(version.Value, columnType).JSON(),
+			CellLineage: version.CellLineage,
+		}
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AssetHistoryResponse{
+		ID:      id,
+		Column:  column,
+		History: history,
+	})
+}
+
+// maxDiffIDPatternLength caps the id_pattern of /api/diff, which is compiled as a regular expression
+const maxDiffIDPatternLength = 1000
+
+// diffFlushInterval is the number of assets written between flushes of a streamed diff
+const diffFlushInterval = 100
+
+// ValueChange is the old and new value of a changed column
+type ValueChange struct {
+	Old HistoryPoint `json:"old"`
+	New HistoryPoint `json:"new"`
+}
+
+// DiffEntry lists the columns of one asset that differ between the two points of a diff
+type DiffEntry struct {
+	ID      string                  `json:"id"`
+	Added   map[string]HistoryPoint `json:"added,omitempty"`   // Columns with a value only at the later point
+	Removed map[string]HistoryPoint `json:"removed,omitempty"` // Columns with a value only at the earlier point
+	Changed map[string]ValueChange  `json:"changed,omitempty"` // Columns whose value differs
+}
+
+// DiffResponse is the response of GET /api/diff. It is streamed, so an error
+// found after the first assets were sent is reported in error.
+type DiffResponse struct {
+	From  string      `json:"from"`
+	To    string      `json:"to"`
+	Diffs []DiffEntry `json:"diffs"`           // One entry per changed asset
+	Count int         `json:"count"`           // Number of entries in diffs
+	Error string      `json:"error,omitempty"` // Why the diff stopped early, if it did
+}
+
+// @Summary Compare two dates or load runs
+// @Description Lists, for every asset that differs, the columns added, removed and changed between two points,
+// @Description each a date (YYYYMMDD or YYYY-MM-DD, comparing the values as of that date) or a load run ID
+// @Description (comparing the values loaded by that run and earlier ones).
+// @Description The response is streamed as it is computed, so large diffs start arriving at once.
+// @Tags assets
+// @Produce json
+// @Param from query string true "Earlier date or load run"
+// @Param to query string true "Later date or load run"
+// @Param columns query string false "Comma-separated list of columns to compare"
+// @Param id_pattern query string false "Regular expression the ID_BB_GLOBAL of compared assets must match"
+// @Param table query string false "Table to compare, BB_ASSETS if omitted"
+// @Success 200 {object} DiffResponse
+// @Failure 400 {string} string "Missing or invalid parameter, unknown load run or unknown table"
+// @Router /api/diff [get]
+func (dm *DataMatrix) handleDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := ParseSnapshotPoint(query.Get("from"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("from: %v", err), http.StatusBadRequest)
+		return
+	}
+	to, err := ParseSnapshotPoint(query.Get("to"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("to: %v", err), http.StatusBadRequest)
+		return
+	}
+	
+	var filter DiffFilter
+	if pattern := query.Get("id_pattern"); pattern != "" {
+		if len(pattern) > maxDiffIDPatternLength {
+			http.Error(w, fmt.Sprintf("id_pattern must be at most %d characters", maxDiffIDPatternLength), http.StatusBadRequest)
+			return
+		}
+		filter.IDPattern, err = regexp.Compile(pattern)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid id_pattern: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	
+	dm.RLock()
+	defer dm.RUnlock()
+	
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+	
+	if columns := query.Get("columns"); columns != "" {
+		resolved, _, err := resolveColumns(strings.Split(columns, ","), table.GetColumnCatalog())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if resolved[0] != "*" {
+			filter.Columns = resolved
+		}
+	}
+	
+	// Unknown load runs are reported before anything is streamed
+	for _, point := range []SnapshotPoint{from, to} {
+		if point.LoadRun != "" && !table.index.HasLoadRun(point.LoadRun) {
+			http.Error(w, fmt.Sprintf("%v %s", ErrUnknownLoadRun, point.LoadRun), http.StatusBadRequest)
+			return
+		}
+	}
+	
+	// Write the envelope by hand so each asset is sent as soon as it is compared
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(http.Flusher)
+	header, _ := json.Marshal(map[string]string{"from": from.String(), "to": to.String()})
+	fmt.Fprintf(w, "%s,\"diffs\":[", header[:len(header)-1])
+	
+	types := table.GetColumnTypes()
+	count := 0
+	err = table.StreamDiff(from, to, filter, func(diff AssetDiff) error {
+		entry, err := json.Marshal(newDiffEntry(diff, types))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			w.Write([]byte(","))
+		}
+		if _, err := w.Write(entry); err != nil {
+			// The client went away
+			return err
+		}
+		count++
+		if flusher != nil && count%diffFlushInterval == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	
+	fmt.Fprintf(w, "],\"count\":%d", count)
+	if err != nil {
+		dm.logger.Warn("Diff from %s to %s stopped early: %v", from, to, err)
+		message, _ := json.Marshal(err.Error())
+		fmt.Fprintf(w, ",\"error\":%s", message)
+	}
+	w.Write([]byte("}\n"))
+}
+
+// newDiffEntry converts the changes of an asset to their response form, with
+// values converted to their column types
+func newDiffEntry(diff AssetDiff, types map[string]ColumnType) DiffEntry {
+	point := func(version *ValueVersion, column string) HistoryPoint {
+		return HistoryPoint{
+			Value:       typedValue(version.Value, types[column]).JSON(),
+			CellLineage: version.CellLineage,
+		}
+	}
+	
+	entry := DiffEntry{ID: diff.ID}
+	for _, change := range diff.Changes {
+		switch change.Type {
+		case ChangeAdded:
+			if entry.Added == nil {
+				entry.Added = make(map[string]HistoryPoint)
+			}
+			entry.Added[change.Column] = point(change.New, change.Column)
+		case ChangeRemoved:
+			if entry.Removed == nil {
+				entry.Removed = make(map[string]HistoryPoint)
+			}
+			entry.Removed[change.Column] = point(change.Old, change.Column)
+		case ChangeChanged:
+			if entry.Changed == nil {
+				entry.Changed = make(map[string]ValueChange)
+			}
+			entry.Changed[change.Column] = ValueChange{
+				Old: point(change.Old, change.Column),
+				New: point(change.New, change.Column),
+			}
+		}
+	}
+	return entry
+}
+
+// @Summary Get several assets
+// @Description Returns the assets with the given ID_BB_GLOBAL values in a single call, reading only their files.
+// @Description IDs without an asset are listed in missing. At most 10000 IDs can be requested at once.
+// @Tags assets
+// @Accept json
+// @Produce json
+// @Param request body BatchGetRequest true "IDs and columns to return"
+// @Success 200 {object} BatchGetResponse
+// @Failure 400 {string} string "Invalid request body, none of the requested columns exist or unknown table"
+// @Failure 500 {string} string "Error reading an asset"
+// @Router /api/assets:batchGet [post]
+func (dm *DataMatrix) handleBatchGetAssets(w http.ResponseWriter, r *http.Request) {
+	var request BatchGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	
+	if len(request.IDs) > maxBatchGetIDs {
+		http.Error(w, fmt.Sprintf("Invalid request body: at most %d ids can be requested at once", maxBatchGetIDs), http.StatusBadRequest)
+		return
+	}
+	
+	dm.RLock()
+	defer dm.RUnlock()
+	
+	table, ok := dm.requestTable(w, request.Table)
+	if !ok {
+		return
+	}
+	
+	columns, warnings, err := resolveColumns(request.Columns, table.GetColumnCatalog())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	// Always return the key columns so each asset can be told apart
+	if columns[0] != "*" {
+		for _, key := range table.KeyColumns() {
+			if !containsString(columns, key) {
+				columns = append(columns, key)
+			}
+		}
+	}
+	
+	// Snapshot the column types once for all assets
+	types := table.GetColumnTypes()
+	
+	response := BatchGetResponse{
+		Data:     []map[string]interface{}{},
+		Warnings: warnings,
+	}
+	for _, id := range uniqueStrings(request.IDs) {
+		asset, err := table.GetAssetWithColumns(id, columns)
+		if errors.Is(err, ErrAssetNotFound) {
+			response.Missing = append(response.Missing, id)
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Data = append(response.Data, NewTypedRow(asset, types).JSON())
+	}
+	response.Count = len(response.Data)
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// LoadsResponse defines the structure for the load runs API response
+type LoadsResponse struct {
+	Runs  []LoadRunSummary `json:"runs"`  // Load runs, newest first
+	Count int              `json:"count"` // Number of runs returned
+	Total int              `json:"total"` // Number of runs recorded
+}
+
+// @Summary List load runs
+// @Description Returns the recorded load runs, newest first, with the files each loaded and the rows read,
+// @Description updated and skipped. Files that had not changed since an earlier run are counted in files_unchanged.
+// @Tags loads
+// @Produce json
+// @Param limit query int false "Maximum number of runs to return"
+// @Param table query string false "Table to list the load runs of, BB_ASSETS if omitted"
+// @Success 200 {object} LoadsResponse
+// @Failure 400 {string} string "Invalid limit or unknown table"
+// @Router /api/loads [get]
+func (dm *DataMatrix) handleGetLoads(w http.ResponseWriter, r *http.Request) {
+	table, ok := dm.requestTable(w, r.URL.Query().Get("table"))
+	if !ok {
+		return
+	}
+	
+	runs := table.GetLoadRuns()
+	total := len(runs)
+	
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		if limit > 0 && limit < len(runs) {
+			runs = runs[:limit]
+		}
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoadsResponse{Runs: runs, Count: len(runs), Total: total})
+}
+
+// ReloadRequest defines the structure for the reload API request
+type ReloadRequest struct {
+	// Files to process again, as listed in the files of a load run
+	Files []string `json:"files" example:"[\"example-data/financial_data_20250410.csv\"]"`
+}
+
+// @Summary Reload files
+// @Description Processes files loaded by an earlier run again as a new load run, even if they have not changed.
+// @Description Only files recorded by an earlier run can be reloaded. Queries wait until the run finishes.
+// @Tags loads
+// @Accept json
+// @Produce json
+// @Param request body ReloadRequest true "Files to reload"
+// @Success 200 {object} LoadRunSummary
+// @Failure 400 {string} string "Invalid request body, a file that was never loaded or files of different tables"
+// @Router /api/loads:reload [post]
+func (dm *DataMatrix) handleReloadFiles(w http.ResponseWriter, r *http.Request) {
+	var request ReloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(request.Files) == 0 {
+		http.Error(w, "Invalid request body: files must name at least one file", http.StatusBadRequest)
+		return
+	}
+	
+	// Reloading writes to the store, so no query may run alongside it
+	dm.Lock()
+	defer dm.Unlock()
+	
+	run, err := dm.tables.ReloadFiles(uniqueStrings(request.Files))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+// containsString reports whether a list contains a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveColumns resolves requested column names case-insensitively to their
+// canonical spelling. No columns, or just "*", means all columns. Names that do
+// not match any column are left out and reported as warnings.
+func resolveColumns(requested []string, catalog *ColumnCatalog) ([]string, []string, error) {
+	if len(requested) == 0 || (len(requested) == 1 && requested[0] == "*") {
+		return []string{"*"}, nil, nil
+	}
+	
+	var columns []string
+	var warnings []string
+	for _, name := range requested {
+		resolved, err := catalog.Resolve(strings.TrimSpace(name))
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		columns = append(columns, resolved)
+	}
+	
+	if len(columns) == 0 {
+		return nil, warnings, fmt.Errorf("none of the requested columns exist: %s", strings.Join(warnings, "; "))
+	}
+	return columns, warnings, nil
+}
+
 // @title DataMatrix API
 // @version 1.0
 // @description A Go service that loads CSV files into a JSON-based file store and provides an HTTP API for querying the data using a minimal SQL dialect.
@@ -463,6 +1343,44 @@ func main() {
 			logger.Info("No configuration file found, using environment variables")
 			config = &DataMatrixConfig{}
 			
+			// Check for the memory for cached assets
+			if memory := os.Getenv("CACHE_MEMORY_MB"); memory != "" {
+				megabytes, err := strconv.Atoi(memory)
+				if err != nil {
+					logger.Error("Invalid CACHE_MEMORY_MB %q: %v", memory, err)
+					os.Exit(1)
+				}
+				config.CacheMemoryMB = megabytes
+			}
+			
+			// Check for the memory for the rows a JOIN holds
+			if memory := os.Getenv("JOIN_MEMORY_MB"); memory != "" {
+				megabytes, err := strconv.Atoi(memory)
+				if err != nil {
+					logger.Error("Invalid JOIN_MEMORY_MB %q: %v", memory, err)
+					os.Exit(1)
+				}
+				config.JoinMemoryMB = megabytes
+			}
+			
+			// Check for when files are flushed to stable storage
+			config.SyncPolicy = os.Getenv("SYNC_POLICY")
+			
+			// Check for the number of files to load at once
+			if workers := os.Getenv("INGEST_WORKERS"); workers != "" {
+				count, err := strconv.Atoi(workers)
+				if err != nil {
+					logger.Error("Invalid INGEST_WORKERS %q: %v", workers, err)
+					os.Exit(1)
+				}
+				config.IngestWorkers = count
+			}
+			
+			// Check for files to process again even if they have not changed
+			if forceReload := os.Getenv("FORCE_RELOAD"); forceReload != "" {
+				config.ForceReload = strings.Split(forceReload, ",")
+			}
+			
 			// Check if S3 bucket is specified as an environment variable
 			s3Path := os.Getenv("S3_BUCKET")
 			if s3Path != "" {
@@ -507,6 +1425,11 @@ func main() {
 		}
 	}
 	
+	// Check or rebuild the store instead of starting the server if a command is given
+	if len(os.Args) > 1 {
+		os.Exit(runStoreCommand(os.Args[1], config, logger))
+	}
+	
 	// Create the DataMatrix
 	dm, err := NewDataMatrix(config)
 	if err != nil {
@@ -514,13 +1437,33 @@ func main() {
 		os.Exit(1)
 	}
 	defer dm.Close()
+	
+	// Write cached assets before exiting when the server is stopped
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := dm.Close(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
 
 	r := mux.NewRouter()
 	
 	// API endpoints
+	r.HandleFunc("/api/tables", dm.handleGetTables).Methods("GET")
 	r.HandleFunc("/api/columns", dm.handleGetColumns).Methods("GET")
 	r.HandleFunc("/api/index", dm.handleGetIndexInfo).Methods("GET")
+	r.HandleFunc("/api/cache", dm.handleGetCacheStats).Methods("GET")
 	r.HandleFunc("/api/query", dm.handleQuery).Methods("POST")
+	r.HandleFunc("/api/assets:batchGet", dm.handleBatchGetAssets).Methods("POST")
+	r.HandleFunc("/api/assets/{id}", dm.handleGetAsset).Methods("GET")
+	r.HandleFunc("/api/assets/{id}/lineage", dm.handleGetAssetLineage).Methods("GET")
+	r.HandleFunc("/api/assets/{id}/history", dm.handleGetAssetHistory).Methods("GET")
+	r.HandleFunc("/api/diff", dm.handleDiff).Methods("GET")
+	r.HandleFunc("/api/loads", dm.handleGetLoads).Methods("GET")
+	r.HandleFunc("/api/loads:reload", dm.handleReloadFiles).Methods("POST")
 	r.HandleFunc("/api/progress", dm.handleGetProgress).Methods("GET")
 	
 	// Serve Swagger UI at root
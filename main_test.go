@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildSelectList(t *testing.T) {
+	assets := tableScope{Name: "BB_ASSETS", Catalog: NewColumnCatalog([]string{"ID_BB_GLOBAL", "Company", "MarketCap", "Revenue", "Market Value", "Name"})}
+	issuers := tableScope{Name: "i", Catalog: NewColumnCatalog([]string{"ID", "Rating", "Name"})}
+	single := []tableScope{assets}
+	joined := []tableScope{assets, issuers}
+
+	tests := []struct {
+		name     string
+		columns  []string
+		scopes   []tableScope
+		want     string
+		warnings []string
+		wantErr  bool
+	}{
+		{name: "star", columns: []string{"*"}, scopes: single, want: "*"},
+		{name: "names in any case", columns: []string{"company", "ID_BB_GLOBAL"}, scopes: single, want: "Company, ID_BB_GLOBAL"},
+		{name: "name with spaces", columns: []string{"market value"}, scopes: single, want: `"Market Value"`},
+		{name: "expressions", columns: []string{"COUNT(*)", "marketcap / revenue AS ps_ratio"}, scopes: single, want: `COUNT(*), (MarketCap / Revenue) AS ps_ratio`},
+		{
+			name:     "unknown name left out",
+			columns:  []string{"Company", "Industry"},
+			scopes:   single,
+			want:     "Company",
+			warnings: []string{"unknown column Industry"},
+		},
+		{
+			name:     "unknown name with spaces left out",
+			columns:  []string{"Company", "Market Cap"},
+			scopes:   single,
+			want:     "Company",
+			warnings: []string{"unknown column Market Cap, did you mean MarketCap?"},
+		},
+		{
+			name:     "expression with an unknown column left out",
+			columns:  []string{"Company", "MarketCp / Revenue"},
+			scopes:   single,
+			want:     "Company",
+			warnings: []string{"unknown column MarketCp, did you mean MarketCap?"},
+		},
+		{
+			name:     "SQL in a name is not passed through",
+			columns:  []string{"Company", "Company FROM BB_ASSETS; --", "Company, Revenue"},
+			scopes:   single,
+			want:     "Company",
+			warnings: []string{"unknown column Company FROM BB_ASSETS; --", "unknown column Company, Revenue"},
+		},
+		{name: "no known column", columns: []string{"Industry"}, scopes: single, wantErr: true},
+		{name: "joined names are qualified", columns: []string{"company", "rating"}, scopes: joined, want: "BB_ASSETS.Company, i.Rating"},
+		{name: "qualified names", columns: []string{"i.name", "BB_ASSETS.Name"}, scopes: joined, want: "i.Name, BB_ASSETS.Name"},
+		{
+			name:     "name of several tables left out",
+			columns:  []string{"Company", "Name"},
+			scopes:   joined,
+			want:     "BB_ASSETS.Company",
+			warnings: []string{(&AmbiguousColumnError{Name: "Name", Matches: []string{"BB_ASSETS.Name", "i.Name"}}).Error()},
+		},
+		{
+			name:     "unknown table left out",
+			columns:  []string{"Company", "x.Rating"},
+			scopes:   joined,
+			want:     "BB_ASSETS.Company",
+			warnings: []string{"unknown table or alias x in x.Rating"},
+		},
+		{
+			name:     "column of another table left out",
+			columns:  []string{"Company", "i.Company"},
+			scopes:   joined,
+			want:     "BB_ASSETS.Company",
+			warnings: []string{"unknown column Company"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings, err := buildSelectList(tt.columns, tt.scopes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("buildSelectList(%q) = %q, want an error", tt.columns, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("buildSelectList(%q) = %q, want %q", tt.columns, got, tt.want)
+			}
+			if strings.Join(warnings, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("warnings = %q, want %q", warnings, tt.warnings)
+			}
+		})
+	}
+}
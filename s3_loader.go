@@ -11,6 +11,7 @@ import (
 	"time"
 	"bytes"
 	"io"
+	"encoding/json"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -26,6 +27,19 @@ type S3File struct {
 	Directory    string // The directory path within the bucket
 }
 
+// s3MetadataSuffix is appended to the path of a downloaded file to name the file
+// that records which S3 object it was downloaded from
+const s3MetadataSuffix = ".s3meta.json"
+
+// S3ObjectMetadata identifies the S3 object a local file is a copy of
+type S3ObjectMetadata struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	VersionID    string    `json:"version_id,omitempty"` // Empty unless the bucket is versioned
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+}
+
 // S3Loader handles loading data from S3
 type S3Loader struct {
 	client          *s3.Client
@@ -111,13 +125,14 @@ func (s *S3Loader) ListBucketContents(bucketName string) ([]S3File, error) {
 				continue
 			}
 			
-			// Include CSV files (plain or gzipped) and any potentially gzipped files
+			// Include CSV and Bloomberg Data License files (plain or gzipped) and any potentially gzipped files
 			// We'll be more inclusive here and filter out non-CSV content when downloading
 			lowerKey := strings.ToLower(key)
 			if !strings.HasSuffix(lowerKey, ".csv") && 
 			   !strings.HasSuffix(lowerKey, ".csv.gz") && 
 			   !strings.HasSuffix(lowerKey, ".gz") && 
-			   !strings.Contains(lowerKey, "csv") {
+			   !strings.Contains(lowerKey, "csv") &&
+			   !isBloombergFileName(lowerKey) {
 				continue
 			}
 
@@ -237,6 +252,7 @@ func (s *S3Loader) DownloadNewestFiles(bucketName string, dirMap map[string][]S3
 		localFilePath := filepath.Join(s.dataDir, newestFile.Key)
 		
 		// Check if the file already exists locally
+		metadataPath := localFilePath + s3MetadataSuffix
 		fileInfo, err := os.Stat(localFilePath)
 		if err == nil {
 			// File exists, check if it's newer or same age as the S3 file
@@ -251,6 +267,16 @@ func (s *S3Loader) DownloadNewestFiles(bucketName string, dirMap map[string][]S3
 				
 				// Verify the file is a valid CSV or gzipped CSV
 				if isValidDataFile(localFilePath) {
+					// Files downloaded before object metadata was recorded get it now,
+					// as long as the object is still the one that was downloaded
+					if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
+						object, err := s.headObject(bucketName, newestFile.Key)
+						if err == nil && object.LastModified.Equal(newestFile.LastModified) {
+							if err := writeS3ObjectMetadata(localFilePath, object); err != nil {
+								s.logger.Warn("Failed to record S3 object metadata for %s: %v", localFilePath, err)
+							}
+						}
+					}
 					downloadedFiles = append(downloadedFiles, localFilePath)
 					continue
 				} else {
@@ -262,6 +288,21 @@ func (s *S3Loader) DownloadNewestFiles(bucketName string, dirMap map[string][]S3
 			}
 		}
 
+		// Find the current version of the object, so the version downloaded is
+		// exactly the one recorded as the file's origin
+		object, err := s.headObject(bucketName, newestFile.Key)
+		if err != nil {
+			s.logger.Error("Error reading metadata of %s: %v", newestFile.Key, err)
+			continue
+		}
+		getInput := &s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(newestFile.Key),
+		}
+		if object.VersionID != "" {
+			getInput.VersionId = aws.String(object.VersionID)
+		}
+
 		// Create the file
 		s.logger.Debug("Downloading %s to %s", newestFile.Key, localFilePath)
 		file, err := os.Create(localFilePath)
@@ -283,15 +324,13 @@ func (s *S3Loader) DownloadNewestFiles(bucketName string, dirMap map[string][]S3
 		}
 
 		// Download the file with modified options
-		_, err = downloader.Download(context.TODO(), file, &s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(newestFile.Key),
-		}, downloadOptions)
+		_, err = downloader.Download(context.TODO(), file, getInput, downloadOptions)
 		file.Close()
 
 		if err != nil {
 			s.logger.Error("Error downloading file %s: %v", newestFile.Key, err)
 			os.Remove(localFilePath) // Clean up partial download
+			os.Remove(metadataPath)
 			continue
 		}
 		
@@ -299,8 +338,14 @@ func (s *S3Loader) DownloadNewestFiles(bucketName string, dirMap map[string][]S3
 		if !isValidDataFile(localFilePath) {
 			s.logger.Warn("Skipping file %s: Not a valid CSV or gzipped CSV file", newestFile.Key)
 			os.Remove(localFilePath) // Clean up invalid file
+			os.Remove(metadataPath)
 			continue
 		}
+		
+		// Record the object the file came from for the lineage of its values
+		if err := writeS3ObjectMetadata(localFilePath, object); err != nil {
+			s.logger.Warn("Failed to record S3 object metadata for %s: %v", localFilePath, err)
+		}
 
 		// Set the file modification time to match the S3 file's LastModified time
 		if err := os.Chtimes(localFilePath, newestFile.LastModified, newestFile.LastModified); err != nil {
@@ -319,6 +364,53 @@ func (s *S3Loader) DownloadNewestFiles(bucketName string, dirMap map[string][]S3
 	return downloadedFiles, nil
 }
 
+// headObject reads the metadata of the current version of an S3 object
+func (s *S3Loader) headObject(bucketName, key string) (S3ObjectMetadata, error) {
+	output, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return S3ObjectMetadata{}, err
+	}
+	
+	object := S3ObjectMetadata{
+		Bucket:       bucketName,
+		Key:          key,
+		VersionID:    aws.ToString(output.VersionId),
+		ETag:         aws.ToString(output.ETag),
+		LastModified: aws.ToTime(output.LastModified),
+	}
+	// Unversioned buckets report the version as "null"
+	if object.VersionID == "null" {
+		object.VersionID = ""
+	}
+	return object, nil
+}
+
+// writeS3ObjectMetadata records the S3 object a local file was downloaded from
+func writeS3ObjectMetadata(localFilePath string, object S3ObjectMetadata) error {
+	data, err := json.MarshalIndent(object, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(localFilePath+s3MetadataSuffix, data, 0644)
+}
+
+// readS3ObjectMetadata returns the S3 object a local file was downloaded from.
+// The second result is false if the file did not come from S3.
+func readS3ObjectMetadata(localFilePath string) (S3ObjectMetadata, bool) {
+	data, err := os.ReadFile(localFilePath + s3MetadataSuffix)
+	if err != nil {
+		return S3ObjectMetadata{}, false
+	}
+	var object S3ObjectMetadata
+	if err := json.Unmarshal(data, &object); err != nil {
+		return S3ObjectMetadata{}, false
+	}
+	return object, true
+}
+
 // LoadFromS3 loads data from an S3 bucket, finding the newest file in each directory
 // and downloading it to the local data directory
 func (s *S3Loader) LoadFromS3(bucketName string) ([]string, error) {
@@ -343,7 +435,7 @@ func (s *S3Loader) LoadFromS3(bucketName string) ([]string, error) {
 func isValidDataFile(filePath string) bool {
 	// Check file extension first - accept any .csv or .gz file
 	lowerPath := strings.ToLower(filePath)
-	if strings.HasSuffix(lowerPath, ".csv") || strings.HasSuffix(lowerPath, ".gz") || strings.HasSuffix(lowerPath, ".csv.gz") {
+	if strings.HasSuffix(lowerPath, ".csv") || strings.HasSuffix(lowerPath, ".gz") || strings.HasSuffix(lowerPath, ".csv.gz") || isBloombergFileName(lowerPath) {
 		return true
 	}
 	
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aggregateFunctions is the set of functions that fold many rows into one value
+var aggregateFunctions = map[string]bool{
+	"COUNT":  true,
+	"SUM":    true,
+	"AVG":    true,
+	"MIN":    true,
+	"MAX":    true,
+	"MEDIAN": true,
+	"STDDEV": true,
+}
+
+// maxAggregateValues caps the values a single group may hold for aggregates
+// that cannot be computed in constant space, namely MEDIAN and DISTINCT
+const maxAggregateValues = 1000000
+
+// IsAggregate reports whether the query groups rows, either explicitly with
+// GROUP BY and HAVING or implicitly by using an aggregate function
+func (q *SQLQuery) IsAggregate() bool {
+	if len(q.GroupBy) > 0 || q.Having != nil {
+		return true
+	}
+	for _, item := range q.Select {
+		if findAggregate(item.Expr) != nil {
+			return true
+		}
+	}
+	for _, item := range q.OrderBy {
+		if findAggregate(item.Expr) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// findAggregate returns the first aggregate call within expr, or nil if there is none
+func findAggregate(expr Expr) *FuncCall {
+	var found *FuncCall
+	walkExpr(expr, func(e Expr) error {
+		if call, ok := e.(*FuncCall); ok && found == nil && call.IsAggregate() {
+			found = call
+		}
+		return nil
+	})
+	return found
+}
+
+// groupRef stands in for a GROUP BY expression or aggregate call once rows
+// have been grouped, reading its value from the group's output row
+type groupRef struct {
+	Key  string // Key of the value in the group row
+	Text string // SQL text of the expression it replaced
+}
+
+// Eval implements Expr
+func (g *groupRef) Eval(row Row) (Value, error) {
+	value, _ := row.Get(g.Key)
+	return value, nil
+}
+
+// String implements Expr
+func (g *groupRef) String() string {
+	return g.Text
+}
+
+// groupRow holds the grouping values and aggregate results of one group
+type groupRow map[string]Value
+
+// Get implements Row
+func (r groupRow) Get(column string) (Value, bool) {
+	value, exists := r[column]
+	return value, exists
+}
+
+// Columns implements Row
+func (r groupRow) Columns() []string {
+	columns := make([]string, 0, len(r))
+	for col := range r {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// groupState is the running state of a single group
+type groupState struct {
+	keys         []Value // Values of the GROUP BY expressions
+	accumulators []accumulator
+}
+
+// aggregator groups matching rows and folds each group through its aggregate
+// functions as rows stream past, so memory grows with the number of groups
+// rather than the number of rows. The finished groups are then filtered by
+// HAVING and handed to a resultCollector for ORDER BY, OFFSET and LIMIT.
+type aggregator struct {
+	groupBy []Expr
+	calls   []*FuncCall // Distinct aggregate calls across the query
+	output  *SQLQuery   // The query rewritten to read from group rows
+	groups  map[string]*groupState
+	order   []*groupState // Groups in the order they were first seen
+}
+
+// newAggregator plans a grouped query, replacing GROUP BY expressions and
+// aggregate calls in the select list, HAVING and ORDER BY with references to
+// the per-group values
+func newAggregator(query *SQLQuery) (*aggregator, error) {
+	if query.SelectsAll() {
+		return nil, queryErrorf("SELECT * cannot be combined with GROUP BY or aggregate functions")
+	}
+
+	a := &aggregator{
+		groupBy: query.GroupBy,
+		groups:  make(map[string]*groupState),
+	}
+
+	groupTexts := make([]string, len(query.GroupBy))
+	for i, expr := range query.GroupBy {
+		groupTexts[i] = expr.String()
+	}
+	callIndex := make(map[string]int)
+
+	rewrite := func(expr Expr) (Expr, error) {
+		rewritten, err := rewriteExpr(expr, func(e Expr) (Expr, bool, error) {
+			text := e.String()
+			for i, groupText := range groupTexts {
+				if text == groupText {
+					return &groupRef{Key: groupKey(i), Text: text}, true, nil
+				}
+			}
+
+			call, ok := e.(*FuncCall)
+			if !ok || !call.IsAggregate() {
+				return e, false, nil
+			}
+			for _, arg := range call.Args {
+				if nested := findAggregate(arg); nested != nil {
+					return nil, false, queryErrorf("aggregate function %s cannot be nested inside %s", nested.String(), call.Name)
+				}
+			}
+			index, exists := callIndex[text]
+			if !exists {
+				index = len(a.calls)
+				callIndex[text] = index
+				a.calls = append(a.calls, call)
+			}
+			return &groupRef{Key: aggregateKey(index), Text: text}, true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Anything still reading a column directly has no single value per group
+		err = walkExpr(rewritten, func(e Expr) error {
+			if ref, ok := e.(*ColumnRef); ok {
+				return queryErrorf("column %s must appear in the GROUP BY clause or be used in an aggregate function", ref.Name)
+			}
+			return nil
+		})
+		return rewritten, err
+	}
+
+	output := *query
+	output.Where = nil
+	output.GroupBy = nil
+	output.Select = make([]SelectItem, len(query.Select))
+	for i, item := range query.Select {
+		expr, err := rewrite(item.Expr)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the name the item had before rewriting
+		output.Select[i] = SelectItem{Expr: expr, Alias: item.Name()}
+	}
+
+	having, err := rewrite(query.Having)
+	if err != nil {
+		return nil, err
+	}
+	output.Having = having
+
+	output.OrderBy = make([]OrderItem, len(query.OrderBy))
+	for i, item := range query.OrderBy {
+		expr, err := rewrite(item.Expr)
+		if err != nil {
+			return nil, err
+		}
+		output.OrderBy[i] = item
+		output.OrderBy[i].Expr = expr
+	}
+
+	a.output = &output
+	return a, nil
+}
+
+// groupKey is the group row key of the i-th GROUP BY expression
+func groupKey(i int) string {
+	return "#group" + strconv.Itoa(i)
+}
+
+// aggregateKey is the group row key of the i-th aggregate call
+func aggregateKey(i int) string {
+	return "#aggregate" + strconv.Itoa(i)
+}
+
+// Add implements rowSink, folding a matching row into its group
+func (a *aggregator) Add(row Row) error {
+	keys := make([]Value, len(a.groupBy))
+	var groupID strings.Builder
+	for i, expr := range a.groupBy {
+		value, err := expr.Eval(row)
+		if err != nil {
+			return fmt.Errorf("error evaluating GROUP BY: %v", err)
+		}
+		keys[i] = value
+		writeKeyPart(&groupID, value)
+	}
+
+	group, exists := a.groups[groupID.String()]
+	if !exists {
+		group = a.newGroup(keys)
+		a.groups[groupID.String()] = group
+	}
+
+	for i, call := range a.calls {
+		// COUNT(*) counts rows, every other aggregate skips NULLs
+		value := BoolValue(true)
+		if !call.Star {
+			var err error
+			if value, err = call.Args[0].Eval(row); err != nil {
+				return fmt.Errorf("error evaluating %s: %v", call.String(), err)
+			}
+			if value.IsNull() {
+				continue
+			}
+		}
+		if err := group.accumulators[i].Add(value); err != nil {
+			return fmt.Errorf("error evaluating %s: %v", call.String(), err)
+		}
+	}
+	return nil
+}
+
+// newGroup creates the state for a group seen for the first time
+func (a *aggregator) newGroup(keys []Value) *groupState {
+	group := &groupState{
+		keys:         keys,
+		accumulators: make([]accumulator, len(a.calls)),
+	}
+	for i, call := range a.calls {
+		group.accumulators[i] = newAccumulator(call)
+	}
+	a.order = append(a.order, group)
+	return group
+}
+
+// Result implements rowSink, filtering the finished groups with HAVING and
+// ordering and paginating them like ordinary rows
+func (a *aggregator) Result() (*QueryResult, error) {
+	// Without GROUP BY the whole input is a single group, even when it is empty
+	if len(a.groupBy) == 0 && len(a.order) == 0 {
+		a.newGroup(nil)
+	}
+
+	collector := newResultCollector(a.output)
+	for _, group := range a.order {
+		row := make(groupRow, len(group.keys)+len(group.accumulators))
+		for i, value := range group.keys {
+			row[groupKey(i)] = value
+		}
+		for i, acc := range group.accumulators {
+			row[aggregateKey(i)] = acc.Result()
+		}
+
+		matches, err := EvalCondition(a.output.Having, row)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating HAVING clause: %v", err)
+		}
+		if !matches {
+			continue
+		}
+		if err := collector.Add(row); err != nil {
+			return nil, err
+		}
+	}
+	return collector.Result()
+}
+
+// writeKeyPart appends a value to a composite key so that equal values produce
+// equal keys regardless of how they were typed, e.g. 1 and 1.0
+func writeKeyPart(key *strings.Builder, value Value) {
+	var part string
+	switch {
+	case value.IsNull():
+		part = "0"
+	case value.Kind == ValueInt:
+		part = "n" + strconv.FormatInt(value.Int, 10)
+	case value.Kind == ValueFloat:
+		if value.Float == math.Trunc(value.Float) && math.Abs(value.Float) < 1e15 {
+			part = "n" + strconv.FormatInt(int64(value.Float), 10)
+		} else {
+			part = "n" + strconv.FormatFloat(value.Float, 'g', -1, 64)
+		}
+	case value.IsTemporal():
+		part = "t" + value.Time.Format(time.RFC3339Nano)
+	case value.Kind == ValueBool:
+		part = "b" + value.String()
+	default:
+		part = "s" + value.Str
+	}
+
+	// Length-prefix each part so no value can run into the next
+	key.WriteString(strconv.Itoa(len(part)))
+	key.WriteByte(':')
+	key.WriteString(part)
+}
+
+// accumulator folds the non-NULL values of one aggregate call within one group.
+// The numeric aggregates skip values that are not numbers, such as the "-" or
+// "#N/A" placeholders some vendors use, just like NULLs.
+type accumulator interface {
+	Add(value Value) error
+	Result() Value
+}
+
+// newAccumulator creates the running state for an aggregate call
+func newAccumulator(call *FuncCall) accumulator {
+	var acc accumulator
+	switch call.Name {
+	case "COUNT":
+		acc = &countAccumulator{}
+	case "SUM":
+		acc = &sumAccumulator{}
+	case "AVG":
+		acc = &avgAccumulator{}
+	case "MIN":
+		acc = &extremeAccumulator{want: -1}
+	case "MAX":
+		acc = &extremeAccumulator{want: 1}
+	case "MEDIAN":
+		acc = &medianAccumulator{}
+	case "STDDEV":
+		acc = &stddevAccumulator{}
+	}
+
+	if call.Distinct {
+		acc = &distinctAccumulator{inner: acc, seen: make(map[string]bool)}
+	}
+	return acc
+}
+
+// countAccumulator implements COUNT
+type countAccumulator struct {
+	n int64
+}
+
+// Add implements accumulator
+func (c *countAccumulator) Add(value Value) error {
+	c.n++
+	return nil
+}
+
+// Result implements accumulator
+func (c *countAccumulator) Result() Value {
+	return IntValue(c.n)
+}
+
+// sumAccumulator implements SUM, staying an integer until a decimal value or an overflow
+type sumAccumulator struct {
+	seen     bool
+	isFloat  bool
+	intSum   int64
+	floatSum float64
+}
+
+// Add implements accumulator
+func (s *sumAccumulator) Add(value Value) error {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil
+	}
+	s.seen = true
+
+	if !s.isFloat && n.Kind == ValueInt {
+		sum := s.intSum + n.Int
+		// Signed overflow flips the sign of the result
+		if (n.Int > 0 && sum < s.intSum) || (n.Int < 0 && sum > s.intSum) {
+			s.isFloat = true
+			s.floatSum = float64(s.intSum) + float64(n.Int)
+			return nil
+		}
+		s.intSum = sum
+		return nil
+	}
+
+	if !s.isFloat {
+		s.isFloat = true
+		s.floatSum = float64(s.intSum)
+	}
+	s.floatSum += n.AsFloat()
+	return nil
+}
+
+// Result implements accumulator
+func (s *sumAccumulator) Result() Value {
+	switch {
+	case !s.seen:
+		return NullValue()
+	case s.isFloat:
+		return FloatValue(s.floatSum)
+	default:
+		return IntValue(s.intSum)
+	}
+}
+
+// avgAccumulator implements AVG
+type avgAccumulator struct {
+	n   int64
+	sum float64
+}
+
+// Add implements accumulator
+func (a *avgAccumulator) Add(value Value) error {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil
+	}
+	a.n++
+	a.sum += n.AsFloat()
+	return nil
+}
+
+// Result implements accumulator
+func (a *avgAccumulator) Result() Value {
+	if a.n == 0 {
+		return NullValue()
+	}
+	return FloatValue(a.sum / float64(a.n))
+}
+
+// extremeAccumulator implements MIN (want -1) and MAX (want 1) over values of any type
+type extremeAccumulator struct {
+	want int
+	best Value
+}
+
+// Add implements accumulator
+func (e *extremeAccumulator) Add(value Value) error {
+	if e.best.IsNull() || compareValues(value, e.best) == e.want {
+		e.best = value
+	}
+	return nil
+}
+
+// Result implements accumulator
+func (e *extremeAccumulator) Result() Value {
+	return e.best
+}
+
+// medianAccumulator implements MEDIAN. An exact median needs every value, so
+// the group is capped at maxAggregateValues values.
+type medianAccumulator struct {
+	values []float64
+}
+
+// Add implements accumulator
+func (m *medianAccumulator) Add(value Value) error {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil
+	}
+	if len(m.values) >= maxAggregateValues {
+		return fmt.Errorf("group has more than %d values", maxAggregateValues)
+	}
+	m.values = append(m.values, n.AsFloat())
+	return nil
+}
+
+// Result implements accumulator
+func (m *medianAccumulator) Result() Value {
+	if len(m.values) == 0 {
+		return NullValue()
+	}
+	sort.Float64s(m.values)
+	middle := len(m.values) / 2
+	if len(m.values)%2 == 1 {
+		return FloatValue(m.values[middle])
+	}
+	return FloatValue((m.values[middle-1] + m.values[middle]) / 2)
+}
+
+// stddevAccumulator implements STDDEV, the sample standard deviation, using
+// Welford's online algorithm so it needs constant space
+type stddevAccumulator struct {
+	n    int64
+	mean float64
+	m2   float64
+}
+
+// Add implements accumulator
+func (s *stddevAccumulator) Add(value Value) error {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil
+	}
+	x := n.AsFloat()
+	s.n++
+	delta := x - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (x - s.mean)
+	return nil
+}
+
+// Result implements accumulator
+func (s *stddevAccumulator) Result() Value {
+	if s.n < 2 {
+		return NullValue()
+	}
+	return FloatValue(math.Sqrt(s.m2 / float64(s.n-1)))
+}
+
+// distinctAccumulator passes each distinct value to the wrapped accumulator
+// once, capped at maxAggregateValues distinct values per group
+type distinctAccumulator struct {
+	inner accumulator
+	seen  map[string]bool
+}
+
+// Add implements accumulator
+func (d *distinctAccumulator) Add(value Value) error {
+	var key strings.Builder
+	writeKeyPart(&key, value)
+	if d.seen[key.String()] {
+		return nil
+	}
+	if len(d.seen) >= maxAggregateValues {
+		return fmt.Errorf("group has more than %d distinct values", maxAggregateValues)
+	}
+	d.seen[key.String()] = true
+	return d.inner.Add(value)
+}
+
+// Result implements accumulator
+func (d *distinctAccumulator) Result() Value {
+	return d.inner.Result()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAggregates(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "assets_20250101.csv",
+		"ID_BB_GLOBAL,Sector,Revenue\nA1,Tech,10\nA2,Tech,30\nA3,Energy,5\nA4,Energy,\nA5,Retail,7.5\n")
+	if err := m.LoadCSVFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []string // Each row as fmt prints it
+		wantErr string
+	}{
+		{
+			name:  "aggregates skip NULL",
+			query: "SELECT COUNT(*), COUNT(Revenue), SUM(Revenue), AVG(Revenue), MIN(Revenue), MAX(Revenue) FROM BB_ASSETS",
+			want:  []string{"map[AVG(Revenue):13.125 COUNT(*):5 COUNT(Revenue):4 MAX(Revenue):30 MIN(Revenue):5 SUM(Revenue):52.5]"},
+		},
+		{
+			name:  "groups filtered by HAVING",
+			query: "SELECT Sector, COUNT(*) AS n, SUM(Revenue) FROM BB_ASSETS GROUP BY Sector HAVING COUNT(*) > 1 ORDER BY Sector",
+			want:  []string{"map[SUM(Revenue):5 Sector:Energy n:2]", "map[SUM(Revenue):40 Sector:Tech n:2]"},
+		},
+		{
+			name:  "no rows",
+			query: "SELECT COUNT(*), SUM(Revenue) FROM BB_ASSETS WHERE Sector = 'None'",
+			want:  []string{"map[COUNT(*):0 SUM(Revenue):<nil>]"},
+		},
+		{
+			name:  "distinct",
+			query: "SELECT DISTINCT Sector FROM BB_ASSETS ORDER BY Sector",
+			want:  []string{"map[Sector:Energy]", "map[Sector:Retail]", "map[Sector:Tech]"},
+		},
+		{
+			name:  "count distinct",
+			query: "SELECT COUNT(DISTINCT Sector) FROM BB_ASSETS",
+			want:  []string{"map[COUNT(DISTINCT Sector):3]"},
+		},
+		{
+			name:    "column outside GROUP BY",
+			query:   "SELECT Sector, COUNT(*) FROM BB_ASSETS",
+			wantErr: "column Sector must appear in the GROUP BY clause or be used in an aggregate function",
+		},
+		{
+			name:    "aggregate in WHERE",
+			query:   "SELECT Sector FROM BB_ASSETS WHERE COUNT(*) > 1",
+			wantErr: "aggregate function COUNT(*) is not allowed in WHERE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := m.ExecuteSQLQuery(tt.query, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !IsQueryInputError(err) {
+					t.Fatalf("error = %v, want a query input error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range result.Rows {
+				got = append(got, fmt.Sprint(row))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestAggregateSyntaxErrorPosition(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantPos int
+		wantMsg string
+	}{
+		{query: "SELECT SUM(Revenue FROM BB_ASSETS", wantPos: 20, wantMsg: "expected ) to close ( at position 11"},
+		{query: "SELECT COUNT( FROM BB_ASSETS", wantPos: 15, wantMsg: "expected expression"},
+		{query: "SELECT Sector FROM BB_ASSETS GROUP BY 3", wantPos: 39, wantMsg: "GROUP BY position 3 is not in the select list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseSQL(tt.query)
+			var syntaxErr *SQLSyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("ParseSQL error = %v, want a syntax error", err)
+			}
+			if syntaxErr.Pos != tt.wantPos || syntaxErr.Message != tt.wantMsg {
+				t.Errorf("error at position %d: %s, want position %d: %s", syntaxErr.Pos, syntaxErr.Message, tt.wantPos, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestAggregatesSkipValuesThatAreNotNumbers(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "assets_20250101.csv",
+		"ID_BB_GLOBAL,Industry,Revenue\nA1,Banks,10\nA2,Banks,-\nA3,Banks,30\nA4,Mining,#N/A\nA5,Mining,4\nA6,Mining,8\n")
+	if err := m.LoadCSVFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.ExecuteSQLQuery("SELECT Industry AS i, COUNT(Revenue) AS n, SUM(Revenue) AS total, AVG(Revenue) AS mean, "+
+		"MEDIAN(Revenue) AS median, STDDEV(Revenue) AS sd FROM BB_ASSETS GROUP BY i ORDER BY i", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, row := range result.Rows {
+		got = append(got, fmt.Sprint(row))
+	}
+	// COUNT still counts every non-NULL value, the numeric aggregates only the numbers
+	want := []string{
+		"map[i:Banks mean:20 median:20 n:3 sd:14.142135623730951 total:40]",
+		"map[i:Mining mean:6 median:6 n:3 sd:2.8284271247461903 total:12]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestGroupByAlias(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "assets_20250101.csv",
+		"ID_BB_GLOBAL,Industry,Sector\nA1,Banks,Finance\nA2,Banks,Finance\nA3,Mining,Materials\n")
+	if err := m.LoadCSVFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{
+			query: "SELECT Industry AS i, COUNT(*) FROM BB_ASSETS GROUP BY i ORDER BY i",
+			want:  []string{"map[COUNT(*):2 i:Banks]", "map[COUNT(*):1 i:Mining]"},
+		},
+		{
+			// A column of the same name wins over the alias
+			query: "SELECT Sector AS Industry, COUNT(*) FROM BB_ASSETS GROUP BY Sector, Industry ORDER BY 1",
+			want:  []string{"map[COUNT(*):2 Industry:Finance]", "map[COUNT(*):1 Industry:Materials]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			result, err := m.ExecuteSQLQuery(tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range result.Rows {
+				got = append(got, fmt.Sprint(row))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UnknownColumnError is returned when a query references a column that is not in the catalog
+type UnknownColumnError struct {
+	Name       string // Column name as written in the query
+	Suggestion string // Closest known column name, empty if nothing is close
+}
+
+// Error implements the error interface
+func (e *UnknownColumnError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("unknown column %s, did you mean %s?", e.Name, e.Suggestion)
+	}
+	return fmt.Sprintf("unknown column %s", e.Name)
+}
+
+// AmbiguousColumnError is returned when a column name matches several columns that differ only in case
+type AmbiguousColumnError struct {
+	Name    string   // Column name as written in the query
+	Matches []string // Canonical columns that match ignoring case
+}
+
+// Error implements the error interface
+func (e *AmbiguousColumnError) Error() string {
+	return fmt.Sprintf("column %s is ambiguous, it matches %s", e.Name, strings.Join(e.Matches, ", "))
+}
+
+// QueryError is returned for a query that parses but is not valid, such as
+// an aggregate function used in WHERE
+type QueryError struct {
+	Message string
+}
+
+// Error implements the error interface
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+// queryErrorf builds a QueryError from a format string
+func queryErrorf(format string, args ...interface{}) error {
+	return &QueryError{Message: fmt.Sprintf(format, args...)}
+}
+
+// IsQueryInputError reports whether err was caused by the query text rather than by the data store
+func IsQueryInputError(err error) bool {
+	var syntaxErr *SQLSyntaxError
+	var unknownErr *UnknownColumnError
+	var ambiguousErr *AmbiguousColumnError
+	var queryErr *QueryError
+	return errors.As(err, &syntaxErr) || errors.As(err, &unknownErr) || errors.As(err, &ambiguousErr) ||
+		errors.As(err, &queryErr)
+}
+
+// ColumnCatalog resolves column names written in a query to their canonical spelling
+type ColumnCatalog struct {
+	columns map[string]bool     // Canonical column names
+	byLower map[string][]string // Lower-cased name to the canonical names that share it
+}
+
+// NewColumnCatalog builds a catalog from the list of known column names
+func NewColumnCatalog(columns []string) *ColumnCatalog {
+	catalog := &ColumnCatalog{
+		columns: make(map[string]bool, len(columns)),
+		byLower: make(map[string][]string, len(columns)),
+	}
+	for _, col := range columns {
+		if catalog.columns[col] {
+			continue
+		}
+		catalog.columns[col] = true
+		lower := strings.ToLower(col)
+		catalog.byLower[lower] = append(catalog.byLower[lower], col)
+	}
+	return catalog
+}
+
+// Resolve returns the canonical name for a column, matching case-insensitively.
+// An exact match always wins; otherwise the name must match exactly one column
+// ignoring case.
+func (c *ColumnCatalog) Resolve(name string) (string, error) {
+	if c.columns[name] {
+		return name, nil
+	}
+
+	matches := c.byLower[strings.ToLower(name)]
+	switch len(matches) {
+	case 0:
+		return "", &UnknownColumnError{Name: name, Suggestion: c.suggest(name)}
+	case 1:
+		return matches[0], nil
+	default:
+		sorted := append([]string(nil), matches...)
+		sort.Strings(sorted)
+		return "", &AmbiguousColumnError{Name: name, Matches: sorted}
+	}
+}
+
+// suggest returns the known column closest to name by edit distance, if any is close enough
+func (c *ColumnCatalog) suggest(name string) string {
+	lowerName := strings.ToLower(name)
+
+	// Allow roughly one typo per three characters, and at least two
+	maxDistance := len(lowerName) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	best := ""
+	bestDistance := maxDistance + 1
+	for col := range c.columns {
+		distance := levenshtein(lowerName, strings.ToLower(col))
+		// Break ties alphabetically so suggestions are stable
+		if distance < bestDistance || (distance == bestDistance && col < best) {
+			best = col
+			bestDistance = distance
+		}
+	}
+
+	if bestDistance > maxDistance {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// tableScope is a table of the FROM clause that column names are resolved against
+type tableScope struct {
+	Name    string // Alias of the table, or its name if it has none
+	Catalog *ColumnCatalog
+}
+
+// TableNames returns the name each table of the FROM clause is referred to by,
+// its alias or else its name, in order
+func (q *SQLQuery) TableNames() []string {
+	names := []string{q.FromTable}
+	if q.FromAlias != "" {
+		names[0] = q.FromAlias
+	}
+	for _, join := range q.Joins {
+		name := join.Table
+		if join.Alias != "" {
+			name = join.Alias
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Bind resolves every column referenced by the query against the catalog,
+// rewriting the query to use canonical column names. Columns may be qualified
+// with the name or alias of the table.
+func (q *SQLQuery) Bind(catalog *ColumnCatalog) error {
+	if len(q.Joins) > 0 {
+		return queryErrorf("JOIN is only supported between the tables of the store")
+	}
+	return q.bind([]tableScope{{Name: q.TableNames()[0], Catalog: catalog}})
+}
+
+// BindJoin resolves the columns of a query joining tables against the catalog
+// of each table, in FROM clause order. Every column is qualified with the table
+// it belongs to; an unqualified name must be a column of exactly one table.
+func (q *SQLQuery) BindJoin(catalogs []*ColumnCatalog) error {
+	names := q.TableNames()
+	scopes := make([]tableScope, len(names))
+	for i, name := range names {
+		for _, other := range names[:i] {
+			if strings.EqualFold(name, other) {
+				return queryErrorf("table %s is named twice in the FROM clause, give one of them an alias", name)
+			}
+		}
+		scopes[i] = tableScope{Name: name, Catalog: catalogs[i]}
+	}
+	if err := q.bind(scopes); err != nil {
+		return err
+	}
+
+	for _, join := range q.Joins {
+		if call := findAggregate(join.On); call != nil {
+			return queryErrorf("aggregate function %s is not allowed in ON", call.String())
+		}
+	}
+
+	// Columns of the same name from different tables are returned under their
+	// qualified names, as they are by SELECT *
+	if q.SelectsAll() {
+		return nil
+	}
+	counts := make(map[string]int, len(q.Select))
+	for _, item := range q.Select {
+		counts[item.Name()]++
+	}
+	for i, item := range q.Select {
+		if ref, ok := item.Expr.(*ColumnRef); ok && item.Alias == "" && counts[item.Name()] > 1 {
+			q.Select[i].Alias = ref.Key()
+		}
+	}
+	return nil
+}
+
+// resolveColumn resolves a column reference against the tables of the FROM
+// clause. With several tables the reference is qualified with its table,
+// otherwise any qualifier is dropped.
+func resolveColumn(ref *ColumnRef, scopes []tableScope) error {
+	joined := len(scopes) > 1
+	if ref.Table != "" {
+		for _, scope := range scopes {
+			if !strings.EqualFold(scope.Name, ref.Table) {
+				continue
+			}
+			resolved, err := scope.Catalog.Resolve(ref.Name)
+			if err != nil {
+				return err
+			}
+			ref.Name, ref.Table = resolved, ""
+			if joined {
+				ref.Table = scope.Name
+			}
+			return nil
+		}
+		return queryErrorf("unknown table or alias %s in %s", ref.Table, ref.String())
+	}
+
+	var matches []string
+	var table, name string
+	var unknown *UnknownColumnError
+	for _, scope := range scopes {
+		resolved, err := scope.Catalog.Resolve(ref.Name)
+		var unknownErr *UnknownColumnError
+		switch {
+		case errors.As(err, &unknownErr):
+			// Suggest the closest column of the first table that has one
+			if unknown == nil || (unknown.Suggestion == "" && unknownErr.Suggestion != "") {
+				unknown = unknownErr
+				if joined && unknown.Suggestion != "" {
+					unknown = &UnknownColumnError{Name: ref.Name, Suggestion: scope.Name + "." + unknownErr.Suggestion}
+				}
+			}
+		case err != nil:
+			return err
+		case !joined:
+			ref.Name = resolved
+			return nil
+		default:
+			matches = append(matches, scope.Name+"."+resolved)
+			table, name = scope.Name, resolved
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return unknown
+	case 1:
+		ref.Table, ref.Name = table, name
+		return nil
+	default:
+		return &AmbiguousColumnError{Name: ref.Name, Matches: matches}
+	}
+}
+
+// bind resolves the columns of the query against the tables of its FROM clause
+func (q *SQLQuery) bind(scopes []tableScope) error {
+	resolve := func(expr Expr) error {
+		if ref, ok := expr.(*ColumnRef); ok {
+			return resolveColumn(ref, scopes)
+		}
+		return nil
+	}
+
+	// ORDER BY may name a select list alias instead of a column
+	for i, item := range q.OrderBy {
+		if ref, ok := item.Expr.(*ColumnRef); ok && ref.Table == "" {
+			if selected := q.selectItemByAlias(ref.Name); selected != nil {
+				q.OrderBy[i].Expr = selected.Expr
+			}
+		}
+	}
+
+	// GROUP BY may name a select list alias unless the name is also a column
+	for i, expr := range q.GroupBy {
+		if ref, ok := expr.(*ColumnRef); ok && ref.Table == "" {
+			column := *ref
+			if err := resolveColumn(&column, scopes); err != nil {
+				if selected := q.selectItemByAlias(ref.Name); selected != nil {
+					q.GroupBy[i] = selected.Expr
+				}
+			}
+		}
+	}
+
+	// HAVING may use an alias wherever the name is not also a column
+	having, err := rewriteExpr(q.Having, func(e Expr) (Expr, bool, error) {
+		if ref, ok := e.(*ColumnRef); ok && ref.Table == "" {
+			column := *ref
+			if err := resolveColumn(&column, scopes); err != nil {
+				if selected := q.selectItemByAlias(ref.Name); selected != nil {
+					return selected.Expr, true, nil
+				}
+			}
+		}
+		return e, false, nil
+	})
+	if err != nil {
+		return err
+	}
+	q.Having = having
+
+	exprs := []Expr{q.Where, q.Having}
+	for _, join := range q.Joins {
+		exprs = append(exprs, join.On)
+	}
+	for _, item := range q.Select {
+		exprs = append(exprs, item.Expr)
+	}
+	exprs = append(exprs, q.GroupBy...)
+	for _, item := range q.OrderBy {
+		exprs = append(exprs, item.Expr)
+	}
+
+	for _, expr := range exprs {
+		if err := walkExpr(expr, resolve); err != nil {
+			return err
+		}
+	}
+
+	// Aggregates are computed after filtering and grouping, so they cannot appear in either
+	if call := findAggregate(q.Where); call != nil {
+		return queryErrorf("aggregate function %s is not allowed in WHERE", call.String())
+	}
+	for _, expr := range q.GroupBy {
+		if call := findAggregate(expr); call != nil {
+			return queryErrorf("aggregate function %s is not allowed in GROUP BY", call.String())
+		}
+	}
+	return nil
+}
+
+// selectItemByAlias returns the select list item with the given alias, ignoring case
+func (q *SQLQuery) selectItemByAlias(alias string) *SelectItem {
+	for i, item := range q.Select {
+		if item.Alias != "" && strings.EqualFold(item.Alias, alias) {
+			return &q.Select[i]
+		}
+	}
+	return nil
+}
+
+// exprChildren returns the direct sub-expressions of an expression
+func exprChildren(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		return []Expr{e.Operand}
+	case *BinaryExpr:
+		return []Expr{e.Left, e.Right}
+	case *FuncCall:
+		return e.Args
+	case *InExpr:
+		return append([]Expr{e.Operand}, e.List...)
+	case *LikeExpr:
+		return []Expr{e.Operand, e.Pattern}
+	case *BetweenExpr:
+		return []Expr{e.Operand, e.Low, e.High}
+	case *IsNullExpr:
+		return []Expr{e.Operand}
+	case *CastExpr:
+		return []Expr{e.Operand}
+	case *LineageExpr:
+		return []Expr{e.Column}
+	case *CaseExpr:
+		var children []Expr
+		if e.Operand != nil {
+			children = append(children, e.Operand)
+		}
+		for _, when := range e.Whens {
+			children = append(children, when.Cond, when.Result)
+		}
+		if e.Else != nil {
+			children = append(children, e.Else)
+		}
+		return children
+	}
+	return nil
+}
+
+// withChildren returns a copy of expr with its sub-expressions replaced, in exprChildren order
+func withChildren(expr Expr, children []Expr) Expr {
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		copied := *e
+		copied.Operand = children[0]
+		return &copied
+	case *BinaryExpr:
+		copied := *e
+		copied.Left, copied.Right = children[0], children[1]
+		return &copied
+	case *FuncCall:
+		copied := *e
+		copied.Args = children
+		return &copied
+	case *InExpr:
+		return newInExpr(children[0], children[1:], e.Not)
+	case *LikeExpr:
+		copied := *e
+		copied.Operand, copied.Pattern = children[0], children[1]
+		return &copied
+	case *BetweenExpr:
+		copied := *e
+		copied.Operand, copied.Low, copied.High = children[0], children[1], children[2]
+		return &copied
+	case *IsNullExpr:
+		copied := *e
+		copied.Operand = children[0]
+		return &copied
+	case *CastExpr:
+		copied := *e
+		copied.Operand = children[0]
+		return &copied
+	case *LineageExpr:
+		return &LineageExpr{Column: children[0]}
+	case *CaseExpr:
+		copied := &CaseExpr{Whens: make([]CaseWhen, len(e.Whens))}
+		if e.Operand != nil {
+			copied.Operand, children = children[0], children[1:]
+		}
+		for i := range e.Whens {
+			copied.Whens[i] = CaseWhen{Cond: children[2*i], Result: children[2*i+1]}
+		}
+		if e.Else != nil {
+			copied.Else = children[len(children)-1]
+		}
+		return copied
+	}
+	return expr
+}
+
+// walkExpr calls fn for expr and each of its sub-expressions, depth first
+func walkExpr(expr Expr, fn func(Expr) error) error {
+	if expr == nil {
+		return nil
+	}
+	if err := fn(expr); err != nil {
+		return err
+	}
+
+	for _, child := range exprChildren(expr) {
+		if err := walkExpr(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteExpr returns a copy of expr in which fn has replaced sub-expressions.
+// fn is called top down; when it reports a replacement the sub-expression's
+// own children are not visited.
+func rewriteExpr(expr Expr, fn func(Expr) (Expr, bool, error)) (Expr, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	replacement, replaced, err := fn(expr)
+	if err != nil || replaced {
+		return replacement, err
+	}
+
+	children := exprChildren(expr)
+	if len(children) == 0 {
+		return expr, nil
+	}
+	rewritten := make([]Expr, len(children))
+	for i, child := range children {
+		if rewritten[i], err = rewriteExpr(child, fn); err != nil {
+			return nil, err
+		}
+	}
+	return withChildren(expr, rewritten), nil
+}
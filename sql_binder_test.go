@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestColumnCatalogResolve(t *testing.T) {
+	catalog := NewColumnCatalog([]string{"ID_BB_GLOBAL", "Company", "MarketCap", "Rating", "RATING"})
+
+	tests := []struct {
+		name       string
+		want       string
+		unknown    bool
+		suggestion string
+		ambiguous  []string
+	}{
+		{name: "Company", want: "Company"},
+		{name: "company", want: "Company"},
+		{name: "MARKETCAP", want: "MarketCap"},
+		{name: "id_bb_global", want: "ID_BB_GLOBAL"},
+		{name: "Rating", want: "Rating"},
+		{name: "RATING", want: "RATING"},
+		{name: "rating", ambiguous: []string{"RATING", "Rating"}},
+		{name: "Compny", unknown: true, suggestion: "Company"},
+		{name: "MarketCp", unknown: true, suggestion: "MarketCap"},
+		{name: "Industry", unknown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := catalog.Resolve(tt.name)
+			var unknownErr *UnknownColumnError
+			var ambiguousErr *AmbiguousColumnError
+			switch {
+			case tt.unknown:
+				if !errors.As(err, &unknownErr) {
+					t.Fatalf("Resolve(%q) error = %v, want an unknown column error", tt.name, err)
+				}
+				if unknownErr.Suggestion != tt.suggestion {
+					t.Errorf("suggestion = %q, want %q", unknownErr.Suggestion, tt.suggestion)
+				}
+			case tt.ambiguous != nil:
+				if !errors.As(err, &ambiguousErr) {
+					t.Fatalf("Resolve(%q) error = %v, want an ambiguous column error", tt.name, err)
+				}
+				if !reflect.DeepEqual(ambiguousErr.Matches, tt.ambiguous) {
+					t.Errorf("matches = %q, want %q", ambiguousErr.Matches, tt.ambiguous)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Resolve(%q) error = %v", tt.name, err)
+				}
+				if got != tt.want {
+					t.Errorf("Resolve(%q) = %q, want %q", tt.name, got, tt.want)
+				}
+			}
+			if err != nil && !IsQueryInputError(err) {
+				t.Errorf("IsQueryInputError(%v) = false, want true", err)
+			}
+		})
+	}
+}
+
+func TestBindRewritesColumnNames(t *testing.T) {
+	query, err := ParseSQL(`SELECT company, MARKETCAP FROM BB_ASSETS WHERE rating = 'A' AND NOT (id_bb_global = 'X')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := query.Bind(NewColumnCatalog([]string{"ID_BB_GLOBAL", "Company", "MarketCap", "Rating"})); err != nil {
+		t.Fatal(err)
+	}
+
+	var selects []string
+	for _, item := range query.Select {
+		selects = append(selects, item.Name())
+	}
+	if want := []string{"Company", "MarketCap"}; !reflect.DeepEqual(selects, want) {
+		t.Errorf("select list = %q, want %q", selects, want)
+	}
+	if got, want := query.Where.String(), "((Rating = 'A') AND (NOT (ID_BB_GLOBAL = 'X')))"; got != want {
+		t.Errorf("WHERE = %s, want %s", got, want)
+	}
+}
+
+func TestBindReportsUnknownColumnInWhere(t *testing.T) {
+	query, err := ParseSQL(`SELECT * FROM BB_ASSETS WHERE Ratng = 'A'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = query.Bind(NewColumnCatalog([]string{"ID_BB_GLOBAL", "Rating"}))
+	if got, want := errorString(err), "unknown column Ratng, did you mean Rating?"; got != want {
+		t.Errorf("Bind error = %q, want %q", got, want)
+	}
+}
+
+// errorString returns err's message, or an empty string for a nil error
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
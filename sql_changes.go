@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// changesTable is the table function that lists the changes between two snapshots,
+// CHANGES(from, to), where each point is a date or a load run ID
+const changesTable = "CHANGES"
+
+// Columns of the rows of CHANGES, one row per changed column of an asset
+const (
+	changesID         = defaultKeyColumn // The ID of the asset
+	changesColumnName = "column_name"
+	changesChange     = "change"
+	changesOldValue   = "old_value"
+	changesNewValue   = "new_value"
+	changesOldDate    = "old_effective_date"
+	changesNewDate    = "new_effective_date"
+	changesOldLoadRun = "old_load_run"
+	changesNewLoadRun = "new_load_run"
+)
+
+// changesArgumentCount is the number of arguments of CHANGES
+const changesArgumentCount = 2
+
+// changesColumns lists the columns of CHANGES in the order SELECT * returns them
+var changesColumns = []string{
+	changesID, changesColumnName, changesChange,
+	changesOldValue, changesNewValue,
+	changesOldDate, changesNewDate,
+	changesOldLoadRun, changesNewLoadRun,
+}
+
+// changeRow adapts a changed column of an asset to the Row interface. The old and
+// new values are converted to the type of the column they belong to.
+type changeRow struct {
+	id     string
+	change CellChange
+	types  map[string]ColumnType
+}
+
+// Get implements Row. Old values of added columns and new values of removed
+// columns are missing from the row.
+func (r changeRow) Get(column string) (Value, bool) {
+	oldVersion, newVersion := r.change.Old, r.change.New
+	switch column {
+	case changesID:
+		return StringValue(r.id), true
+	case changesColumnName:
+		return StringValue(r.change.Column), true
+	case changesChange:
+		return StringValue(r.change.Type), true
+	case changesOldValue:
+		if oldVersion != nil {
+			return typedValue(oldVersion.Value, r.types[r.change.Column]), true
+		}
+	case changesNewValue:
+		if newVersion != nil {
+			return typedValue(newVersion.Value, r.types[r.change.Column]), true
+		}
+	case changesOldDate:
+		if oldVersion != nil {
+			return StringValue(oldVersion.EffectiveDate), true
+		}
+	case changesNewDate:
+		if newVersion != nil {
+			return StringValue(newVersion.EffectiveDate), true
+		}
+	case changesOldLoadRun:
+		if oldVersion != nil && oldVersion.LoadRun != "" {
+			return StringValue(oldVersion.LoadRun), true
+		}
+	case changesNewLoadRun:
+		if newVersion != nil && newVersion.LoadRun != "" {
+			return StringValue(newVersion.LoadRun), true
+		}
+	}
+	return NullValue(), false
+}
+
+// Columns implements Row
+func (r changeRow) Columns() []string {
+	var columns []string
+	for _, column := range changesColumns {
+		if _, exists := r.Get(column); exists {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// parseTableArgs parses the arguments of a table function such as CHANGES, each
+// a string or a parameter, and returns them as text
+func (p *sqlParser) parseTableArgs(table Token) ([]string, error) {
+	open := p.advance()
+	var args []string
+	for {
+		tok := p.peek()
+		switch tok.Type {
+		case TokenString:
+			p.advance()
+			args = append(args, tok.Text)
+		case TokenParam:
+			exprs, err := p.parseParam(false)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, exprs[0].(*Literal).Value.String())
+		default:
+			return nil, p.errorf(tok, "expected a string argument to %s", table.Text)
+		}
+
+		if p.peek().Type != TokenComma {
+			break
+		}
+		p.advance()
+	}
+
+	if closing := p.peek(); closing.Type != TokenRParen {
+		return nil, p.errorf(closing, "expected ) to close ( at position %d", open.Pos)
+	}
+	p.advance()
+
+	if len(args) != changesArgumentCount {
+		return nil, p.errorf(table, "%s expects %d arguments, the dates or load runs to compare, got %d", changesTable, changesArgumentCount, len(args))
+	}
+	return args, nil
+}
+
+// changesPoints parses the two snapshot points of a CHANGES query
+func changesPoints(query *SQLQuery) (SnapshotPoint, SnapshotPoint, error) {
+	from, err := ParseSnapshotPoint(query.TableArgs[0])
+	if err != nil {
+		return SnapshotPoint{}, SnapshotPoint{}, queryErrorf("%s: %v", changesTable, err)
+	}
+	to, err := ParseSnapshotPoint(query.TableArgs[1])
+	if err != nil {
+		return SnapshotPoint{}, SnapshotPoint{}, queryErrorf("%s: %v", changesTable, err)
+	}
+	return from, to, nil
+}
+
+// executeChangesQuery executes a query over CHANGES, comparing only the assets
+// with the given IDs if ids is not nil
+func (j *JSONAssetManager) executeChangesQuery(query *SQLQuery, ids []string, stats *QueryStats) (*QueryResult, error) {
+	from, to, err := changesPoints(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Matching rows are grouped, counted, ordered and paginated as they arrive
+	sink, err := newRowSink(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot the column types so values compare as numbers, booleans and dates
+	types := j.schema.Types()
+
+	filter := DiffFilter{IDs: ids}
+	err = j.streamDiff(from, to, filter, stats, func(diff AssetDiff) error {
+		mark := time.Now()
+		for _, change := range diff.Changes {
+			row := changeRow{id: diff.ID, change: change, types: types}
+			stats.RowsExamined++
+			matches, err := EvalCondition(query.Where, row)
+			if err != nil {
+				return fmt.Errorf("error evaluating WHERE clause: %v", err)
+			}
+			if !matches {
+				continue
+			}
+			stats.RowsMatched++
+			if err := sink.Add(row); err != nil {
+				return err
+			}
+		}
+		stats.timeStage("filter", mark)
+		return nil
+	})
+	if errors.Is(err, ErrUnknownLoadRun) {
+		return nil, queryErrorf("%s: %v", changesTable, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mark := time.Now()
+	result, err := sink.Result()
+	if err != nil {
+		return nil, err
+	}
+	stats.timeStage("finalize", mark)
+	return result, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// QueryResult holds the rows returned by a query
+type QueryResult struct {
+	Rows  []map[string]interface{} // Projected rows after ORDER BY, OFFSET and LIMIT
+	Total int64                    // Number of rows that matched before OFFSET and LIMIT
+	Plan  *QueryPlan               // How the query was executed, set only for EXPLAIN
+}
+
+// rowSink receives the rows that matched the WHERE clause and produces the query result
+type rowSink interface {
+	// Add records a row that matched the WHERE clause
+	Add(row Row) error
+	// Result returns the final rows once every matching row has been added
+	Result() (*QueryResult, error)
+}
+
+// newRowSink returns the sink for a query: an aggregator for grouped queries,
+// otherwise a collector that orders and paginates rows directly
+func newRowSink(query *SQLQuery) (rowSink, error) {
+	if query.IsAggregate() {
+		return newAggregator(query)
+	}
+	return newResultCollector(query), nil
+}
+
+// collectedRow is a matching row waiting to be ordered and projected
+type collectedRow struct {
+	row  Row
+	keys []Value // Evaluated ORDER BY terms
+	seq  int     // Arrival order, used to keep the sort stable
+}
+
+// resultCollector receives matching rows during a scan and applies DISTINCT,
+// ORDER BY, OFFSET and LIMIT. With ORDER BY and LIMIT only the best OFFSET+LIMIT
+// rows are kept in a bounded heap; without ORDER BY rows outside the requested
+// window are counted and dropped immediately.
+type resultCollector struct {
+	query  *SQLQuery
+	keep   int // Rows needed to satisfy OFFSET+LIMIT, -1 if unbounded
+	rows   rowHeap
+	total  int64
+	window []Row           // Rows inside the OFFSET/LIMIT window when there is no ORDER BY
+	seen   map[string]bool // Projected rows already returned, for SELECT DISTINCT
+}
+
+// newResultCollector creates a collector for a query
+func newResultCollector(query *SQLQuery) *resultCollector {
+	keep := -1
+	if query.Limit >= 0 {
+		keep = query.Offset + query.Limit
+	}
+	collector := &resultCollector{
+		query: query,
+		keep:  keep,
+		rows:  rowHeap{order: query.OrderBy},
+	}
+	if query.Distinct {
+		collector.seen = make(map[string]bool)
+	}
+	return collector
+}
+
+// Add records a row that matched the WHERE clause
+func (c *resultCollector) Add(row Row) error {
+	// Duplicates of a row already seen do not count towards the total
+	if c.seen != nil {
+		key, err := c.query.distinctKey(row)
+		if err != nil {
+			return err
+		}
+		if c.seen[key] {
+			return nil
+		}
+		c.seen[key] = true
+	}
+
+	c.total++
+
+	// Without ORDER BY rows arrive in their final order, so only the window needs keeping
+	if len(c.query.OrderBy) == 0 {
+		position := int(c.total) - 1
+		if position >= c.query.Offset && (c.keep < 0 || position < c.keep) {
+			c.window = append(c.window, row)
+		}
+		return nil
+	}
+
+	if c.keep == 0 {
+		return nil
+	}
+
+	keys := make([]Value, len(c.query.OrderBy))
+	for i, item := range c.query.OrderBy {
+		value, err := item.Expr.Eval(row)
+		if err != nil {
+			return fmt.Errorf("error evaluating ORDER BY: %v", err)
+		}
+		keys[i] = value
+	}
+	entry := collectedRow{row: row, keys: keys, seq: int(c.total)}
+
+	// Unbounded sorts keep everything; bounded ones replace the worst kept row
+	if c.keep < 0 || c.rows.Len() < c.keep {
+		heap.Push(&c.rows, entry)
+		return nil
+	}
+	if c.rows.less(entry, c.rows.items[0]) {
+		c.rows.items[0] = entry
+		heap.Fix(&c.rows, 0)
+	}
+	return nil
+}
+
+// Result orders the kept rows, applies OFFSET and LIMIT and projects the select list
+func (c *resultCollector) Result() (*QueryResult, error) {
+	result := &QueryResult{
+		Rows:  []map[string]interface{}{},
+		Total: c.total,
+	}
+
+	var rows []Row
+	if len(c.query.OrderBy) == 0 {
+		rows = c.window
+	} else {
+		sorted := c.rows.items
+		sort.Slice(sorted, func(i, j int) bool {
+			return c.rows.less(sorted[i], sorted[j])
+		})
+		for i := c.query.Offset; i < len(sorted); i++ {
+			rows = append(rows, sorted[i].row)
+		}
+	}
+
+	for _, row := range rows {
+		projected, err := c.query.Project(row)
+		if err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, projected)
+	}
+	return result, nil
+}
+
+// rowHeap is a max-heap of collected rows: the row that sorts last is at the top,
+// so it is the one evicted when a better row arrives
+type rowHeap struct {
+	order []OrderItem
+	items []collectedRow
+}
+
+// less reports whether row a sorts before row b
+func (h *rowHeap) less(a, b collectedRow) bool {
+	for i, item := range h.order {
+		if c := compareOrderKeys(a.keys[i], b.keys[i], item); c != 0 {
+			return c < 0
+		}
+	}
+	return a.seq < b.seq
+}
+
+// compareOrderKeys orders two ORDER BY values according to direction and NULL placement
+func compareOrderKeys(a, b Value, item OrderItem) int {
+	switch {
+	case a.IsNull() && b.IsNull():
+		return 0
+	case a.IsNull():
+		if item.NullsFirst {
+			return -1
+		}
+		return 1
+	case b.IsNull():
+		if item.NullsFirst {
+			return 1
+		}
+		return -1
+	}
+
+	c := compareValues(a, b)
+	if item.Desc {
+		return -c
+	}
+	return c
+}
+
+// Len implements heap.Interface
+func (h *rowHeap) Len() int { return len(h.items) }
+
+// Less implements heap.Interface, inverted so the worst row is at the top
+func (h *rowHeap) Less(i, j int) bool { return h.less(h.items[j], h.items[i]) }
+
+// Swap implements heap.Interface
+func (h *rowHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+// Push implements heap.Interface
+func (h *rowHeap) Push(x interface{}) { h.items = append(h.items, x.(collectedRow)) }
+
+// Pop implements heap.Interface
+func (h *rowHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// collectRows runs the ORDER BY, OFFSET and LIMIT of query over rows and returns the IDs in result order
+func collectRows(t *testing.T, sql string, rows []map[string]string) ([]string, int64) {
+	t.Helper()
+	query, err := ParseSQL(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]ColumnType{"ID": ColumnTypeString, "Revenue": ColumnTypeInteger}
+
+	collector := newResultCollector(query)
+	for _, values := range rows {
+		if err := collector.Add(NewTypedRow(values, types)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := collector.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, row := range result.Rows {
+		ids = append(ids, fmt.Sprint(row["ID"]))
+	}
+	return ids, result.Total
+}
+
+func TestOrderByLimitOffset(t *testing.T) {
+	rows := []map[string]string{
+		{"ID": "A", "Revenue": "30"},
+		{"ID": "B", "Revenue": "100"},
+		{"ID": "C"},
+		{"ID": "D", "Revenue": "5"},
+		{"ID": "E", "Revenue": "30"},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT ID FROM BB_ASSETS", []string{"A", "B", "C", "D", "E"}},
+		{"SELECT ID FROM BB_ASSETS LIMIT 2 OFFSET 1", []string{"B", "C"}},
+		// Numbers sort numerically, ties keep arrival order and NULLs sort last ascending
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue", []string{"D", "A", "E", "B", "C"}},
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue DESC", []string{"C", "B", "A", "E", "D"}},
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue DESC NULLS LAST", []string{"B", "A", "E", "D", "C"}},
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue NULLS FIRST LIMIT 2", []string{"C", "D"}},
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue, ID DESC", []string{"D", "E", "A", "B", "C"}},
+		{"SELECT ID, Revenue FROM BB_ASSETS ORDER BY 2 DESC NULLS LAST LIMIT 3", []string{"B", "A", "E"}},
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue LIMIT 2 OFFSET 2", []string{"E", "B"}},
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue LIMIT 0", nil},
+		{"SELECT ID FROM BB_ASSETS ORDER BY Revenue OFFSET 10", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, total := collectRows(t, tt.query, rows)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+			if total != int64(len(rows)) {
+				t.Errorf("total = %d, want %d", total, len(rows))
+			}
+		})
+	}
+}
+
+func TestBoundedOrderByMatchesFullSort(t *testing.T) {
+	var rows []map[string]string
+	for i := 0; i < 200; i++ {
+		rows = append(rows, map[string]string{
+			"ID":      fmt.Sprintf("R%03d", i),
+			"Revenue": fmt.Sprint((i * 37) % 50),
+		})
+	}
+
+	all, _ := collectRows(t, "SELECT ID FROM BB_ASSETS ORDER BY Revenue DESC", rows)
+	page, _ := collectRows(t, "SELECT ID FROM BB_ASSETS ORDER BY Revenue DESC LIMIT 15 OFFSET 30", rows)
+	if want := all[30:45]; !reflect.DeepEqual(page, want) {
+		t.Errorf("bounded page = %q, want %q", page, want)
+	}
+}
+
+func TestOrderByParseErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"SELECT * FROM BB_ASSETS ORDER BY 1", "ORDER BY position requires an explicit column list"},
+		{"SELECT ID FROM BB_ASSETS ORDER BY 2", "ORDER BY position 2 is not in the select list"},
+		{"SELECT ID FROM BB_ASSETS ORDER Revenue", "expected BY"},
+		{"SELECT ID FROM BB_ASSETS ORDER BY ID NULLS MIDDLE", "expected FIRST or LAST"},
+		{"SELECT ID FROM BB_ASSETS LIMIT ten", "expected a number after LIMIT"},
+		{"SELECT ID FROM BB_ASSETS LIMIT 1.5", "LIMIT must be a non-negative integer"},
+		{"SELECT ID FROM BB_ASSETS LIMIT 5 OFFSET -1", "expected a number after OFFSET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseSQL(tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseSQL error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Access paths a query plan can use to find the assets it examines
+const (
+	AccessFullScan    = "full_scan"    // Every asset file under the JSON directory is read
+	AccessIDLookup    = "id_lookup"    // Only the files of the ID_BB_GLOBAL values named in the WHERE clause are read
+	AccessMemoryScan  = "memory_scan"  // Every row of an in-memory data dictionary is examined
+	AccessChangesScan = "changes_scan" // Assets are compared between two snapshots to list the changes of CHANGES
+	AccessHashJoin    = "hash_join"    // Joined tables are held in memory by their ON keys while another table is streamed past them
+)
+
+// QueryPlan describes how a query is executed. Stats is only filled in when the
+// query actually ran, as with EXPLAIN ANALYZE or the explain flag of /api/query.
+type QueryPlan struct {
+	SQL           string      `json:"sql"`            // Query text as given
+	AST           *PlanNode   `json:"ast"`            // Parsed query after column names were resolved
+	AccessPath    AccessPath  `json:"access_path"`    // How the assets to examine are found
+	EstimatedRows int64       `json:"estimated_rows"` // Assets the access path is expected to examine
+	Stats         *QueryStats `json:"stats,omitempty"`
+}
+
+// AccessPath is the strategy used to locate the assets a query examines
+type AccessPath struct {
+	Type   string `json:"type"`   // Kind of path, e.g. full_scan
+	Detail string `json:"detail"` // Human-readable description of the path
+}
+
+// PlanNode is a node of the parsed query tree as reported by EXPLAIN
+type PlanNode struct {
+	Type     string      `json:"type"`            // Kind of node, e.g. "where", "operator" or "column"
+	Value    string      `json:"value,omitempty"` // Operator, function, column or table name, or literal value
+	Text     string      `json:"text,omitempty"`  // The node as SQL text
+	Children []*PlanNode `json:"children,omitempty"`
+}
+
+// QueryStats records what executing a query cost
+type QueryStats struct {
+	FilesRead    int64        `json:"files_read"`    // Asset files opened and read
+	BytesParsed  int64        `json:"bytes_parsed"`  // Bytes of JSON decoded
+	RowsExamined int64        `json:"rows_examined"` // Rows tested against the WHERE clause
+	RowsMatched  int64        `json:"rows_matched"`  // Rows that passed the WHERE clause
+	RowsReturned int          `json:"rows_returned"` // Rows in the result after grouping and paging
+	Stages       []StageStats `json:"stages"`        // Time spent in each stage, in execution order
+	ElapsedMs    float64      `json:"elapsed_ms"`    // Total time from parsing to the final result
+}
+
+// StageStats is the time spent in one stage of query execution. Stages that run
+// once per asset, such as read and filter, report the sum over all assets.
+type StageStats struct {
+	Name      string  `json:"name"`
+	ElapsedMs float64 `json:"elapsed_ms"`
+
+	elapsed time.Duration // Exact total, so many small additions do not accumulate rounding errors
+}
+
+// addStage adds time spent in a stage, creating the stage on first use
+func (s *QueryStats) addStage(name string, elapsed time.Duration) {
+	i := 0
+	for i < len(s.Stages) && s.Stages[i].Name != name {
+		i++
+	}
+	if i == len(s.Stages) {
+		s.Stages = append(s.Stages, StageStats{Name: name})
+	}
+	s.Stages[i].elapsed += elapsed
+	s.Stages[i].ElapsedMs = durationMs(s.Stages[i].elapsed)
+}
+
+// durationMs converts a duration to milliseconds with microsecond precision
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// timeStage adds the time elapsed since start to a stage and returns the current
+// time, so consecutive stages can be timed without gaps
+func (s *QueryStats) timeStage(name string, start time.Time) time.Time {
+	now := time.Now()
+	s.addStage(name, now.Sub(start))
+	return now
+}
+
+// newQueryPlan describes a parsed and bound query
+func newQueryPlan(sqlQuery string, query *SQLQuery, access AccessPath, estimatedRows int64) *QueryPlan {
+	return &QueryPlan{
+		SQL:           sqlQuery,
+		AST:           explainQuery(query),
+		AccessPath:    access,
+		EstimatedRows: estimatedRows,
+	}
+}
+
+// explainQuery converts a parsed query into a tree with one child per clause
+func explainQuery(query *SQLQuery) *PlanNode {
+	root := &PlanNode{Type: "select"}
+
+	columns := &PlanNode{Type: "columns"}
+	if query.Distinct {
+		columns.Value = "DISTINCT"
+	}
+	for _, item := range query.Select {
+		if item.IsStar() {
+			columns.Children = append(columns.Children, &PlanNode{Type: "star", Text: "*"})
+			continue
+		}
+		node := explainExpr(item.Expr)
+		if item.Alias != "" {
+			node = &PlanNode{Type: "alias", Value: item.Alias, Children: []*PlanNode{node}}
+		}
+		columns.Children = append(columns.Children, node)
+	}
+	root.Children = append(root.Children, columns)
+
+	from := &PlanNode{Type: "from", Value: query.FromTable}
+	if query.FromAlias != "" {
+		from.Text = tableReference(query.FromTable, query.FromAlias)
+	}
+	for _, arg := range query.TableArgs {
+		from.Children = append(from.Children, &PlanNode{Type: "literal", Value: arg})
+	}
+	root.Children = append(root.Children, from)
+	if query.AsOf != "" {
+		root.Children = append(root.Children, &PlanNode{Type: "as_of", Value: query.AsOf})
+	}
+	for _, join := range query.Joins {
+		node := &PlanNode{Type: "join", Value: join.Table, Text: join.String()}
+		if join.Left {
+			node.Type = "left_join"
+		}
+		node.Children = append(node.Children, explainClause("on", join.On))
+		root.Children = append(root.Children, node)
+	}
+
+	if query.Where != nil {
+		root.Children = append(root.Children, explainClause("where", query.Where))
+	}
+	if len(query.GroupBy) > 0 {
+		groupBy := &PlanNode{Type: "group_by"}
+		for _, expr := range query.GroupBy {
+			groupBy.Children = append(groupBy.Children, explainExpr(expr))
+		}
+		root.Children = append(root.Children, groupBy)
+	}
+	if query.Having != nil {
+		root.Children = append(root.Children, explainClause("having", query.Having))
+	}
+	if len(query.OrderBy) > 0 {
+		orderBy := &PlanNode{Type: "order_by"}
+		for _, item := range query.OrderBy {
+			orderBy.Children = append(orderBy.Children, &PlanNode{
+				Type:     "order",
+				Text:     item.String(),
+				Children: []*PlanNode{explainExpr(item.Expr)},
+			})
+		}
+		root.Children = append(root.Children, orderBy)
+	}
+	if query.Limit >= 0 {
+		root.Children = append(root.Children, &PlanNode{Type: "limit", Value: fmt.Sprint(query.Limit)})
+	}
+	if query.Offset > 0 {
+		root.Children = append(root.Children, &PlanNode{Type: "offset", Value: fmt.Sprint(query.Offset)})
+	}
+	return root
+}
+
+// explainClause wraps the expression of a clause such as WHERE in a node named after it
+func explainClause(clause string, expr Expr) *PlanNode {
+	return &PlanNode{Type: clause, Children: []*PlanNode{explainExpr(expr)}}
+}
+
+// explainExpr converts an expression tree into plan nodes
+func explainExpr(expr Expr) *PlanNode {
+	node := &PlanNode{Text: trimParens(expr.String())}
+
+	switch e := expr.(type) {
+	case *ColumnRef:
+		// The name says it all; the text would only repeat it, possibly quoted
+		node.Type, node.Value, node.Text = "column", e.Key(), ""
+	case *Literal:
+		node.Type, node.Value = "literal", e.Value.String()
+	case *UnaryExpr:
+		node.Type, node.Value = "operator", e.Op
+	case *BinaryExpr:
+		node.Type, node.Value = "operator", e.Op
+	case *FuncCall:
+		node.Type, node.Value = "function", e.Name
+		if e.IsAggregate() {
+			node.Type = "aggregate"
+		}
+	case *InExpr:
+		node.Type, node.Value = "predicate", "IN"
+		if e.Not {
+			node.Value = "NOT IN"
+		}
+	case *LikeExpr:
+		node.Type, node.Value = "predicate", "LIKE"
+		if e.CaseInsensitive {
+			node.Value = "ILIKE"
+		}
+		if e.Not {
+			node.Value = "NOT " + node.Value
+		}
+	case *BetweenExpr:
+		node.Type, node.Value = "predicate", "BETWEEN"
+		if e.Not {
+			node.Value = "NOT BETWEEN"
+		}
+	case *IsNullExpr:
+		node.Type, node.Value = "predicate", "IS NULL"
+		if e.Not {
+			node.Value = "IS NOT NULL"
+		}
+	case *CaseExpr:
+		node.Type = "case"
+	case *CastExpr:
+		node.Type, node.Value = "cast", e.Type
+	case *LineageExpr:
+		node.Type, node.Value = "function", lineageFunction
+	default:
+		node.Type = "expression"
+	}
+
+	for _, child := range exprChildren(expr) {
+		node.Children = append(node.Children, explainExpr(child))
+	}
+	return node
+}
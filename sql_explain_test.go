@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExplainQueryTree(t *testing.T) {
+	query, err := ParseSQL("EXPLAIN SELECT DISTINCT Sector AS s, COUNT(*) FROM BB_ASSETS WHERE Revenue > 10 AND Sector IN ('A', 'B') " +
+		"GROUP BY Sector HAVING COUNT(*) > 1 ORDER BY s DESC LIMIT 5 OFFSET 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !query.Explain || query.Analyze {
+		t.Errorf("Explain = %v, Analyze = %v, want EXPLAIN without ANALYZE", query.Explain, query.Analyze)
+	}
+
+	var data strings.Builder
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(explainQuery(query)); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"select","children":[` +
+		`{"type":"columns","value":"DISTINCT","children":[` +
+		`{"type":"alias","value":"s","children":[{"type":"column","value":"Sector"}]},` +
+		`{"type":"aggregate","value":"COUNT","text":"COUNT(*)"}]},` +
+		`{"type":"from","value":"BB_ASSETS"},` +
+		`{"type":"where","children":[{"type":"operator","value":"AND","text":"(Revenue > 10) AND (Sector IN ('A', 'B'))","children":[` +
+		`{"type":"operator","value":">","text":"Revenue > 10","children":[{"type":"column","value":"Revenue"},{"type":"literal","value":"10","text":"10"}]},` +
+		`{"type":"predicate","value":"IN","text":"Sector IN ('A', 'B')","children":[{"type":"column","value":"Sector"},{"type":"literal","value":"A","text":"'A'"},{"type":"literal","value":"B","text":"'B'"}]}]}]},` +
+		`{"type":"group_by","children":[{"type":"column","value":"Sector"}]},` +
+		`{"type":"having","children":[{"type":"operator","value":">","text":"COUNT(*) > 1","children":[{"type":"aggregate","value":"COUNT","text":"COUNT(*)"},{"type":"literal","value":"1","text":"1"}]}]},` +
+		`{"type":"order_by","children":[{"type":"order","text":"s DESC NULLS FIRST","children":[{"type":"column","value":"s"}]}]},` +
+		`{"type":"limit","value":"5"},{"type":"offset","value":"2"}]}`
+	if got := strings.TrimSpace(data.String()); got != want {
+		t.Errorf("plan =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExplainAndExplainAnalyze(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "assets_20250101.csv",
+		"ID_BB_GLOBAL,Sector,Revenue\nA1,Tech,10\nA2,Tech,30\nA3,Energy,5\n")
+	if err := m.LoadCSVFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// EXPLAIN describes the plan without reading any asset
+	result, err := m.ExecuteSQLQuery("EXPLAIN SELECT * FROM BB_ASSETS WHERE Sector = 'Tech'", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 0 || result.Plan == nil || result.Plan.Stats != nil {
+		t.Fatalf("EXPLAIN returned %d rows and plan %+v, want only a plan without stats", len(result.Rows), result.Plan)
+	}
+	if result.Plan.AccessPath.Type != AccessFullScan || result.Plan.EstimatedRows != 3 {
+		t.Errorf("access path %s estimating %d rows, want %s estimating 3", result.Plan.AccessPath.Type, result.Plan.EstimatedRows, AccessFullScan)
+	}
+
+	// EXPLAIN ANALYZE runs the query and reports what it cost
+	result, err = m.ExecuteSQLQuery("EXPLAIN ANALYZE SELECT * FROM BB_ASSETS WHERE Sector = 'Tech' LIMIT 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 1 || result.Plan == nil || result.Plan.Stats == nil {
+		t.Fatalf("EXPLAIN ANALYZE returned %d rows and plan %+v, want 1 row and a plan with stats", len(result.Rows), result.Plan)
+	}
+	stats := result.Plan.Stats
+	if stats.FilesRead != 3 || stats.RowsExamined != 3 || stats.RowsMatched != 2 || stats.RowsReturned != 1 || stats.BytesParsed == 0 {
+		t.Errorf("stats = %+v, want 3 files read and examined, 2 matched and 1 returned", stats)
+	}
+	var stages []string
+	for _, stage := range stats.Stages {
+		stages = append(stages, stage.Name)
+	}
+	if got, want := strings.Join(stages, ","), "parse,bind,plan,walk,read,decode,filter,collect,finalize"; got != want {
+		t.Errorf("stages = %s, want %s", got, want)
+	}
+
+	// Without EXPLAIN there is no plan
+	result, err = m.ExecuteSQLQuery("SELECT * FROM BB_ASSETS", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Plan != nil {
+		t.Errorf("plain query returned plan %+v", result.Plan)
+	}
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueKind identifies the type of a value produced by the expression evaluator
+type ValueKind int
+
+const (
+	ValueNull ValueKind = iota
+	ValueString
+	ValueBool
+	ValueInt
+	ValueFloat
+	ValueDate
+	ValueDateTime
+)
+
+// Value is the result of evaluating an expression against a row
+type Value struct {
+	Kind  ValueKind
+	Str   string // String value, or the original text of a date or datetime
+	Bool  bool
+	Int   int64
+	Float float64
+	Time  time.Time
+}
+
+// NullValue returns the SQL NULL value
+func NullValue() Value {
+	return Value{Kind: ValueNull}
+}
+
+// StringValue wraps a string as a Value
+func StringValue(s string) Value {
+	return Value{Kind: ValueString, Str: s}
+}
+
+// BoolValue wraps a boolean as a Value
+func BoolValue(b bool) Value {
+	return Value{Kind: ValueBool, Bool: b}
+}
+
+// IntValue wraps an integer as a Value
+func IntValue(i int64) Value {
+	return Value{Kind: ValueInt, Int: i}
+}
+
+// FloatValue wraps a floating point number as a Value
+func FloatValue(f float64) Value {
+	return Value{Kind: ValueFloat, Float: f}
+}
+
+// TimeValue wraps a date or datetime as a Value, keeping the original text for output
+func TimeValue(kind ValueKind, t time.Time, original string) Value {
+	return Value{Kind: kind, Time: t, Str: original}
+}
+
+// IsNull reports whether the value is SQL NULL
+func (v Value) IsNull() bool {
+	return v.Kind == ValueNull
+}
+
+// IsNumeric reports whether the value is an integer or floating point number
+func (v Value) IsNumeric() bool {
+	return v.Kind == ValueInt || v.Kind == ValueFloat
+}
+
+// IsTemporal reports whether the value is a date or datetime
+func (v Value) IsTemporal() bool {
+	return v.Kind == ValueDate || v.Kind == ValueDateTime
+}
+
+// AsFloat returns a numeric value as a float64
+func (v Value) AsFloat() float64 {
+	if v.Kind == ValueInt {
+		return float64(v.Int)
+	}
+	return v.Float
+}
+
+// String returns the textual form of the value
+func (v Value) String() string {
+	switch v.Kind {
+	case ValueNull:
+		return "NULL"
+	case ValueBool:
+		if v.Bool {
+			return "true"
+		}
+		return "false"
+	case ValueInt:
+		return strconv.FormatInt(v.Int, 10)
+	case ValueFloat:
+		return strconv.FormatFloat(v.Float, 'g', -1, 64)
+	case ValueDate:
+		if v.Str != "" {
+			return v.Str
+		}
+		return v.Time.Format("2006-01-02")
+	case ValueDateTime:
+		if v.Str != "" {
+			return v.Str
+		}
+		return v.Time.Format(time.RFC3339)
+	default:
+		return v.Str
+	}
+}
+
+// JSON returns the value in the form it should take in an API response:
+// numbers and booleans as JSON numbers and booleans, NULL as null, and
+// everything else as a string
+func (v Value) JSON() interface{} {
+	switch v.Kind {
+	case ValueNull:
+		return nil
+	case ValueBool:
+		return v.Bool
+	case ValueInt:
+		return v.Int
+	case ValueFloat:
+		return v.Float
+	default:
+		return v.String()
+	}
+}
+
+// compareValues orders two non-NULL values, returning -1, 0 or 1.
+// Mixed types are coerced the way SQL would: a string compared with a number
+// or a date is parsed as one, and anything that cannot be coerced falls back
+// to comparing the textual forms.
+func compareValues(a, b Value) int {
+	switch {
+	case a.Kind == ValueInt && b.Kind == ValueInt:
+		return cmp.Compare(a.Int, b.Int)
+	case a.IsNumeric() && b.IsNumeric():
+		return cmp.Compare(a.AsFloat(), b.AsFloat())
+	case a.IsTemporal() && b.IsTemporal():
+		return a.Time.Compare(b.Time)
+	case a.Kind == ValueBool && b.Kind == ValueBool:
+		return cmp.Compare(boolRank(a.Bool), boolRank(b.Bool))
+	}
+
+	// Coerce a string operand to the type of the other operand
+	if a.Kind == ValueString && b.Kind != ValueString {
+		if coerced, ok := coerceString(a.Str, b); ok {
+			return compareValues(coerced, b)
+		}
+	}
+	if b.Kind == ValueString && a.Kind != ValueString {
+		if coerced, ok := coerceString(b.Str, a); ok {
+			return compareValues(a, coerced)
+		}
+	}
+
+	return strings.Compare(a.String(), b.String())
+}
+
+// coerceString parses s as the same kind of value as like
+func coerceString(s string, like Value) (Value, bool) {
+	switch {
+	case like.IsNumeric():
+		if i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			return IntValue(i), true
+		}
+		if f, ok := parseDecimal(s); ok {
+			return FloatValue(f), true
+		}
+	case like.IsTemporal():
+		if t, ok := parseDateTime(s); ok {
+			return TimeValue(ValueDateTime, t, s), true
+		}
+		if t, ok := parseDate(s); ok {
+			return TimeValue(ValueDate, t, s), true
+		}
+	case like.Kind == ValueBool:
+		if b, ok := parseBoolean(s); ok {
+			return BoolValue(b), true
+		}
+	}
+	return NullValue(), false
+}
+
+// boolRank orders false before true
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Row supplies column values to the expression evaluator
+type Row interface {
+	// Get returns the value of a column and whether the column is present in the row
+	Get(column string) (Value, bool)
+	// Columns returns the names of the columns present in the row
+	Columns() []string
+}
+
+// StringRow adapts an asset map to the Row interface without any type information
+type StringRow map[string]string
+
+// Get implements Row
+func (r StringRow) Get(column string) (Value, bool) {
+	value, exists := r[column]
+	if !exists {
+		return NullValue(), false
+	}
+	return StringValue(value), true
+}
+
+// Columns implements Row, returning the column names in sorted order
+func (r StringRow) Columns() []string {
+	columns := make([]string, 0, len(r))
+	for col := range r {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// size estimates the memory used by the row's values
+func (r StringRow) size() int64 {
+	return assetSize(r)
+}
+
+// Expr is a node in a parsed SQL expression tree
+type Expr interface {
+	// Eval evaluates the expression against a row
+	Eval(row Row) (Value, error)
+	// String returns the expression as SQL text
+	String() string
+}
+
+// ColumnRef is a reference to a column of the current row
+type ColumnRef struct {
+	Table string // Table or alias the column is qualified with, empty if it is not or the query reads one table
+	Name  string
+}
+
+// Eval implements Expr. Missing columns evaluate to NULL.
+func (c *ColumnRef) Eval(row Row) (Value, error) {
+	value, _ := row.Get(c.Key())
+	return value, nil
+}
+
+// Key returns the name the column has in a row: the column name, or
+// table.column for a column of a joined table
+func (c *ColumnRef) Key() string {
+	if c.Table == "" {
+		return c.Name
+	}
+	return c.Table + "." + c.Name
+}
+
+// String implements Expr
+func (c *ColumnRef) String() string {
+	if c.Table == "" {
+		return quoteIdentifier(c.Name)
+	}
+	return quoteIdentifier(c.Table) + "." + quoteIdentifier(c.Name)
+}
+
+// Literal is a constant value in the query text
+type Literal struct {
+	Value Value
+}
+
+// Eval implements Expr
+func (l *Literal) Eval(row Row) (Value, error) {
+	return l.Value, nil
+}
+
+// String implements Expr
+func (l *Literal) String() string {
+	if l.Value.Kind == ValueString {
+		return "'" + strings.ReplaceAll(l.Value.Str, "'", "''") + "'"
+	}
+	return strings.ToUpper(l.Value.String())
+}
+
+// UnaryExpr applies a prefix operator such as NOT to an operand
+type UnaryExpr struct {
+	Op      string
+	Operand Expr
+}
+
+// Eval implements Expr
+func (u *UnaryExpr) Eval(row Row) (Value, error) {
+	operand, err := u.Operand.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+
+	switch u.Op {
+	case "NOT":
+		b, err := toBool(operand)
+		if err != nil || b.IsNull() {
+			return b, err
+		}
+		return BoolValue(!b.Bool), nil
+	case "-":
+		if operand.IsNull() {
+			return operand, nil
+		}
+		n, err := toNumber(operand)
+		if err != nil {
+			return NullValue(), nil
+		}
+		if n.Kind == ValueInt && n.Int != math.MinInt64 {
+			return IntValue(-n.Int), nil
+		}
+		return FloatValue(-n.AsFloat()), nil
+	}
+	return NullValue(), fmt.Errorf("unsupported unary operator %s", u.Op)
+}
+
+// String implements Expr
+func (u *UnaryExpr) String() string {
+	if u.Op == "-" {
+		return fmt.Sprintf("(-%s)", u.Operand.String())
+	}
+	return fmt.Sprintf("(%s %s)", u.Op, u.Operand.String())
+}
+
+// BinaryExpr applies an infix logical, comparison, arithmetic or concatenation operator to two operands
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// Eval implements Expr using SQL three-valued logic
+func (b *BinaryExpr) Eval(row Row) (Value, error) {
+	switch b.Op {
+	case "AND", "OR":
+		return b.evalLogical(row)
+	}
+
+	left, err := b.Left.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	right, err := b.Right.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+
+	// Any comparison or arithmetic involving NULL is unknown
+	if left.IsNull() || right.IsNull() {
+		return NullValue(), nil
+	}
+
+	switch b.Op {
+	case "+", "-", "*", "/", "%":
+		return evalArithmetic(b.Op, left, right)
+	case "||":
+		return StringValue(left.String() + right.String()), nil
+	}
+
+	order := compareValues(left, right)
+	switch b.Op {
+	case "=":
+		return BoolValue(order == 0), nil
+	case "!=", "<>":
+		return BoolValue(order != 0), nil
+	case "<":
+		return BoolValue(order < 0), nil
+	case "<=":
+		return BoolValue(order <= 0), nil
+	case ">":
+		return BoolValue(order > 0), nil
+	case ">=":
+		return BoolValue(order >= 0), nil
+	}
+	return NullValue(), fmt.Errorf("unsupported operator %s", b.Op)
+}
+
+// evalArithmetic applies an arithmetic operator to two non-NULL values. Integer
+// arithmetic stays integral unless it overflows; division always produces a
+// decimal, and dividing by zero produces NULL. An operand that is not a number,
+// such as a "#N/A" cell, also produces NULL so one bad row does not fail the query.
+func evalArithmetic(op string, left, right Value) (Value, error) {
+	l, err := toNumber(left)
+	if err != nil {
+		return NullValue(), nil
+	}
+	r, err := toNumber(right)
+	if err != nil {
+		return NullValue(), nil
+	}
+
+	if l.Kind == ValueInt && r.Kind == ValueInt {
+		switch op {
+		case "+":
+			if sum := l.Int + r.Int; (r.Int >= 0) == (sum >= l.Int) {
+				return IntValue(sum), nil
+			}
+		case "-":
+			if diff := l.Int - r.Int; (r.Int >= 0) == (diff <= l.Int) {
+				return IntValue(diff), nil
+			}
+		case "*":
+			if product := l.Int * r.Int; l.Int == 0 || (product/l.Int == r.Int && !(l.Int == -1 && r.Int == math.MinInt64)) {
+				return IntValue(product), nil
+			}
+		case "%":
+			if r.Int == 0 {
+				return NullValue(), nil
+			}
+			return IntValue(l.Int % r.Int), nil
+		}
+	}
+
+	a, b := l.AsFloat(), r.AsFloat()
+	switch op {
+	case "+":
+		return FloatValue(a + b), nil
+	case "-":
+		return FloatValue(a - b), nil
+	case "*":
+		return FloatValue(a * b), nil
+	case "/":
+		if b == 0 {
+			return NullValue(), nil
+		}
+		return FloatValue(a / b), nil
+	case "%":
+		if b == 0 {
+			return NullValue(), nil
+		}
+		return FloatValue(math.Mod(a, b)), nil
+	}
+	return NullValue(), fmt.Errorf("unsupported operator %s", op)
+}
+
+// toNumber converts a value to a number, parsing strings from columns without a numeric type
+func toNumber(v Value) (Value, error) {
+	if v.IsNumeric() {
+		return v, nil
+	}
+	if v.Kind == ValueString {
+		if i, err := strconv.ParseInt(strings.TrimSpace(v.Str), 10, 64); err == nil {
+			return IntValue(i), nil
+		}
+		if f, ok := parseDecimal(v.Str); ok {
+			return FloatValue(f), nil
+		}
+	}
+	return NullValue(), fmt.Errorf("cannot use %q as a number", v.String())
+}
+
+// evalLogical evaluates AND and OR, short-circuiting where the result is already known
+func (b *BinaryExpr) evalLogical(row Row) (Value, error) {
+	leftRaw, err := b.Left.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	left, err := toBool(leftRaw)
+	if err != nil {
+		return NullValue(), err
+	}
+
+	// FALSE AND x is FALSE, TRUE OR x is TRUE
+	if !left.IsNull() {
+		if b.Op == "AND" && !left.Bool {
+			return BoolValue(false), nil
+		}
+		if b.Op == "OR" && left.Bool {
+			return BoolValue(true), nil
+		}
+	}
+
+	rightRaw, err := b.Right.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	right, err := toBool(rightRaw)
+	if err != nil {
+		return NullValue(), err
+	}
+
+	if b.Op == "AND" {
+		if !right.IsNull() && !right.Bool {
+			return BoolValue(false), nil
+		}
+		if left.IsNull() || right.IsNull() {
+			return NullValue(), nil
+		}
+		return BoolValue(true), nil
+	}
+
+	if !right.IsNull() && right.Bool {
+		return BoolValue(true), nil
+	}
+	if left.IsNull() || right.IsNull() {
+		return NullValue(), nil
+	}
+	return BoolValue(false), nil
+}
+
+// String implements Expr
+func (b *BinaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", b.Left.String(), b.Op, b.Right.String())
+}
+
+// FuncCall is a call to a named function such as COUNT(*) or AVG(MarketCap)
+type FuncCall struct {
+	Name     string // Upper-cased function name
+	Args     []Expr
+	Star     bool // COUNT(*)
+	Distinct bool // COUNT(DISTINCT x)
+}
+
+// IsAggregate reports whether the call is to an aggregate function
+func (f *FuncCall) IsAggregate() bool {
+	return aggregateFunctions[f.Name]
+}
+
+// Eval implements Expr. Aggregate calls are replaced by their per-group results
+// before evaluation, so reaching one here means it was used outside a grouping context.
+func (f *FuncCall) Eval(row Row) (Value, error) {
+	if f.IsAggregate() {
+		return NullValue(), fmt.Errorf("aggregate function %s is not allowed here", f.Name)
+	}
+	fn, exists := scalarFunctions[f.Name]
+	if !exists {
+		return NullValue(), fmt.Errorf("unknown function %s", f.Name)
+	}
+
+	args := make([]Value, len(f.Args))
+	for i, arg := range f.Args {
+		value, err := arg.Eval(row)
+		if err != nil {
+			return NullValue(), err
+		}
+		if value.IsNull() && !fn.handlesNull {
+			return NullValue(), nil
+		}
+		args[i] = value
+	}
+
+	result, err := fn.eval(args)
+	if err != nil {
+		return NullValue(), fmt.Errorf("%s: %v", f.Name, err)
+	}
+	return result, nil
+}
+
+// String implements Expr
+func (f *FuncCall) String() string {
+	if f.Star {
+		return f.Name + "(*)"
+	}
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = trimParens(arg.String())
+	}
+	prefix := ""
+	if f.Distinct {
+		prefix = "DISTINCT "
+	}
+	return f.Name + "(" + prefix + strings.Join(args, ", ") + ")"
+}
+
+// trimParens removes the parentheses String adds around a whole operator expression
+func trimParens(text string) string {
+	if len(text) < 2 || text[0] != '(' || text[len(text)-1] != ')' {
+		return text
+	}
+	// Only strip when the outer pair encloses the entire text, ignoring
+	// parentheses inside quoted strings and identifiers
+	depth := 0
+	var quote rune
+	for i, r := range text {
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch r {
+		case '\'', '"':
+			quote = r
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(text)-1 {
+				return text
+			}
+		}
+	}
+	return text[1 : len(text)-1]
+}
+
+// toBool converts a value to a boolean for use in a logical context
+func toBool(v Value) (Value, error) {
+	switch v.Kind {
+	case ValueNull, ValueBool:
+		return v, nil
+	case ValueString:
+		if b, ok := parseBoolean(v.Str); ok {
+			return BoolValue(b), nil
+		}
+	}
+	return NullValue(), fmt.Errorf("cannot use %s as a boolean condition", v.String())
+}
+
+// EvalCondition evaluates a WHERE-style condition; NULL results count as no match
+func EvalCondition(expr Expr, row Row) (bool, error) {
+	if expr == nil {
+		return true, nil
+	}
+	value, err := expr.Eval(row)
+	if err != nil {
+		return false, err
+	}
+	value, err = toBool(value)
+	if err != nil {
+		return false, err
+	}
+	return !value.IsNull() && value.Bool, nil
+}
+
+// quoteIdentifier returns the identifier, double-quoted if it is not a plain word
+func quoteIdentifier(name string) string {
+	plain := name != "" && isIdentStart([]rune(name)[0]) && !sqlKeywords[strings.ToUpper(name)]
+	for _, r := range name {
+		if !isIdentPart(r) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// scalarFunction describes a built-in function that computes one value per row
+type scalarFunction struct {
+	minArgs int
+	maxArgs int // -1 for any number of arguments
+	// handlesNull is set for functions that look at NULL arguments themselves;
+	// every other function returns NULL as soon as any argument is NULL
+	handlesNull bool
+	// eval computes the result from the evaluated arguments
+	eval func(args []Value) (Value, error)
+}
+
+// scalarFunctions is the registry of built-in row-level functions, by upper-cased name
+var scalarFunctions = map[string]scalarFunction{
+	"REGEXP_MATCHES": {minArgs: 2, maxArgs: 2, eval: evalRegexpMatches},
+	"UPPER":          {minArgs: 1, maxArgs: 1, eval: evalUpper},
+	"LOWER":          {minArgs: 1, maxArgs: 1, eval: evalLower},
+	"TRIM":           {minArgs: 1, maxArgs: 1, eval: evalTrim},
+	"LTRIM":          {minArgs: 1, maxArgs: 1, eval: evalLTrim},
+	"RTRIM":          {minArgs: 1, maxArgs: 1, eval: evalRTrim},
+	"SUBSTR":         {minArgs: 2, maxArgs: 3, eval: evalSubstr},
+	"SUBSTRING":      {minArgs: 2, maxArgs: 3, eval: evalSubstr},
+	"LENGTH":         {minArgs: 1, maxArgs: 1, eval: evalLength},
+	"COALESCE":       {minArgs: 1, maxArgs: -1, handlesNull: true, eval: evalCoalesce},
+	"NULLIF":         {minArgs: 2, maxArgs: 2, handlesNull: true, eval: evalNullIf},
+	"ROUND":          {minArgs: 1, maxArgs: 2, eval: evalRound},
+	"ABS":            {minArgs: 1, maxArgs: 1, eval: evalAbs},
+	"CONCAT":         {minArgs: 1, maxArgs: -1, handlesNull: true, eval: evalConcat},
+	"STRPTIME":       {minArgs: 2, maxArgs: 2, eval: evalStrptime},
+	"DATE_DIFF":      {minArgs: 3, maxArgs: 3, eval: evalDateDiff},
+	"DATEDIFF":       {minArgs: 3, maxArgs: 3, eval: evalDateDiff},
+}
+
+// checkArity reports whether a scalar function accepts n arguments
+func (f scalarFunction) checkArity(name string, n int) error {
+	switch {
+	case f.minArgs == f.maxArgs && n != f.minArgs:
+		return fmt.Errorf("%s expects %s, got %d", name, pluralArguments(f.minArgs), n)
+	case n < f.minArgs:
+		return fmt.Errorf("%s expects at least %s, got %d", name, pluralArguments(f.minArgs), n)
+	case f.maxArgs >= 0 && n > f.maxArgs:
+		return fmt.Errorf("%s expects at most %s, got %d", name, pluralArguments(f.maxArgs), n)
+	}
+	return nil
+}
+
+// pluralArguments formats an argument count for error messages
+func pluralArguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// evalRegexpMatches implements REGEXP_MATCHES(value, pattern), which is true when
+// the pattern matches anywhere in the value. Anchor with ^ and $ to match it whole.
+func evalRegexpMatches(args []Value) (Value, error) {
+	re, err := compileRegexp(args[1].String())
+	if err != nil {
+		return NullValue(), err
+	}
+	return BoolValue(re.MatchString(args[0].String())), nil
+}
+
+// evalUpper implements UPPER(value)
+func evalUpper(args []Value) (Value, error) {
+	return StringValue(strings.ToUpper(args[0].String())), nil
+}
+
+// evalLower implements LOWER(value)
+func evalLower(args []Value) (Value, error) {
+	return StringValue(strings.ToLower(args[0].String())), nil
+}
+
+// evalTrim implements TRIM(value), removing leading and trailing whitespace
+func evalTrim(args []Value) (Value, error) {
+	return StringValue(strings.TrimSpace(args[0].String())), nil
+}
+
+// evalLTrim implements LTRIM(value), removing leading whitespace
+func evalLTrim(args []Value) (Value, error) {
+	return StringValue(strings.TrimLeftFunc(args[0].String(), unicode.IsSpace)), nil
+}
+
+// evalRTrim implements RTRIM(value), removing trailing whitespace
+func evalRTrim(args []Value) (Value, error) {
+	return StringValue(strings.TrimRightFunc(args[0].String(), unicode.IsSpace)), nil
+}
+
+// evalSubstr implements SUBSTR(value, start [, length]) with a 1-based start.
+// A negative start counts back from the end of the value.
+func evalSubstr(args []Value) (Value, error) {
+	runes := []rune(args[0].String())
+	start, err := integerArgument(args[1], "start")
+	if err != nil {
+		return NullValue(), err
+	}
+	if start < 0 {
+		start = int64(len(runes)) + start + 1
+	}
+
+	begin := start - 1
+	end := int64(len(runes))
+	if len(args) == 3 {
+		length, err := integerArgument(args[2], "length")
+		if err != nil {
+			return NullValue(), err
+		}
+		if length < 0 {
+			return NullValue(), fmt.Errorf("length must not be negative")
+		}
+		end = min(begin+length, end)
+	}
+	begin = max(begin, 0)
+	if begin >= end {
+		return StringValue(""), nil
+	}
+	return StringValue(string(runes[begin:end])), nil
+}
+
+// evalLength implements LENGTH(value), counting characters rather than bytes
+func evalLength(args []Value) (Value, error) {
+	return IntValue(int64(utf8.RuneCountInString(args[0].String()))), nil
+}
+
+// evalCoalesce implements COALESCE(a, b, ...), returning the first non-NULL argument
+func evalCoalesce(args []Value) (Value, error) {
+	for _, arg := range args {
+		if !arg.IsNull() {
+			return arg, nil
+		}
+	}
+	return NullValue(), nil
+}
+
+// evalNullIf implements NULLIF(a, b), returning NULL when a equals b and a otherwise
+func evalNullIf(args []Value) (Value, error) {
+	if !args[0].IsNull() && !args[1].IsNull() && compareValues(args[0], args[1]) == 0 {
+		return NullValue(), nil
+	}
+	return args[0], nil
+}
+
+// evalRound implements ROUND(value [, digits]), rounding half away from zero.
+// Negative digits round to tens, hundreds and so on. A value that is not a number gives NULL.
+func evalRound(args []Value) (Value, error) {
+	n, err := toNumber(args[0])
+	if err != nil {
+		return NullValue(), nil
+	}
+	digits := int64(0)
+	if len(args) == 2 {
+		if digits, err = integerArgument(args[1], "digits"); err != nil {
+			return NullValue(), err
+		}
+	}
+
+	// Integers only change when rounding to the left of the decimal point
+	if n.Kind == ValueInt && digits >= 0 {
+		return n, nil
+	}
+
+	scale := math.Pow(10, float64(digits))
+	rounded := math.Round(n.AsFloat()*scale) / scale
+	if n.Kind == ValueInt {
+		return IntValue(int64(rounded)), nil
+	}
+	return FloatValue(rounded), nil
+}
+
+// evalAbs implements ABS(value), giving NULL for a value that is not a number
+func evalAbs(args []Value) (Value, error) {
+	n, err := toNumber(args[0])
+	if err != nil {
+		return NullValue(), nil
+	}
+	if n.Kind == ValueInt && n.Int != math.MinInt64 {
+		if n.Int < 0 {
+			return IntValue(-n.Int), nil
+		}
+		return n, nil
+	}
+	return FloatValue(math.Abs(n.AsFloat())), nil
+}
+
+// evalConcat implements CONCAT(a, b, ...), treating NULL arguments as empty strings
+func evalConcat(args []Value) (Value, error) {
+	var sb strings.Builder
+	for _, arg := range args {
+		if !arg.IsNull() {
+			sb.WriteString(arg.String())
+		}
+	}
+	return StringValue(sb.String()), nil
+}
+
+// evalStrptime implements STRPTIME(text, format), parsing text with a
+// strftime-style format such as '%Y-%m-%d %H:%M:%S' into a datetime
+func evalStrptime(args []Value) (Value, error) {
+	layout, err := strptimeLayout(args[1].String())
+	if err != nil {
+		return NullValue(), err
+	}
+	text := args[0].String()
+	t, err := time.Parse(layout, text)
+	if err != nil {
+		return NullValue(), fmt.Errorf("cannot parse %q with format %q", text, args[1].String())
+	}
+	return TimeValue(ValueDateTime, t, ""), nil
+}
+
+// strptimeDirectives maps strftime directives to Go time layout elements
+var strptimeDirectives = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'j': "002",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'f': "999999999",
+	'p': "PM",
+	'b': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// strptimeLayout converts a strftime-style format to a Go time layout
+func strptimeLayout(format string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		if i+1 >= len(format) {
+			return "", fmt.Errorf("format %q ends with a lone %%", format)
+		}
+		i++
+		element, ok := strptimeDirectives[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported format directive %%%c in %q", format[i], format)
+		}
+		layout.WriteString(element)
+	}
+	return layout.String(), nil
+}
+
+// evalDateDiff implements DATE_DIFF(part, start, end), the number of part
+// boundaries crossed between two dates or datetimes. Supported parts are year,
+// quarter, month, week, day, hour, minute and second.
+func evalDateDiff(args []Value) (Value, error) {
+	part := strings.ToLower(args[0].String())
+	start, err := timeArgument(args[1])
+	if err != nil {
+		return NullValue(), err
+	}
+	end, err := timeArgument(args[2])
+	if err != nil {
+		return NullValue(), err
+	}
+
+	switch strings.TrimSuffix(part, "s") {
+	case "year":
+		return IntValue(int64(end.Year() - start.Year())), nil
+	case "quarter":
+		startQuarter := start.Year()*4 + (int(start.Month())-1)/3
+		endQuarter := end.Year()*4 + (int(end.Month())-1)/3
+		return IntValue(int64(endQuarter - startQuarter)), nil
+	case "month":
+		startMonth := start.Year()*12 + int(start.Month())
+		endMonth := end.Year()*12 + int(end.Month())
+		return IntValue(int64(endMonth - startMonth)), nil
+	case "week":
+		return IntValue(calendarDays(start, end) / 7), nil
+	case "day":
+		return IntValue(calendarDays(start, end)), nil
+	case "hour":
+		return IntValue(int64(end.Truncate(time.Hour).Sub(start.Truncate(time.Hour)) / time.Hour)), nil
+	case "minute":
+		return IntValue(int64(end.Truncate(time.Minute).Sub(start.Truncate(time.Minute)) / time.Minute)), nil
+	case "second":
+		return IntValue(int64(end.Truncate(time.Second).Sub(start.Truncate(time.Second)) / time.Second)), nil
+	}
+	return NullValue(), fmt.Errorf("unsupported DATE_DIFF part %q", part)
+}
+
+// calendarDays returns the number of midnights between two times
+func calendarDays(start, end time.Time) int64 {
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	endDay := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	return int64(endDay.Sub(startDay) / (24 * time.Hour))
+}
+
+// integerArgument converts a function argument to an integer
+func integerArgument(v Value, what string) (int64, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", what, err)
+	}
+	if n.Kind == ValueFloat {
+		return int64(n.Float), nil
+	}
+	return n.Int, nil
+}
+
+// timeArgument converts a function argument to a time, parsing strings as dates or datetimes
+func timeArgument(v Value) (time.Time, error) {
+	if v.IsTemporal() {
+		return v.Time, nil
+	}
+	if t, ok := parseDateTime(v.String()); ok {
+		return t, nil
+	}
+	if t, ok := parseDate(v.String()); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot use %q as a date", v.String())
+}
+
+// CaseWhen is one WHEN ... THEN ... branch of a CASE expression
+type CaseWhen struct {
+	Cond   Expr
+	Result Expr
+}
+
+// CaseExpr is a searched CASE WHEN cond THEN ... END, or a simple
+// CASE operand WHEN value THEN ... END when Operand is set
+type CaseExpr struct {
+	Operand Expr // nil for a searched CASE
+	Whens   []CaseWhen
+	Else    Expr // nil when there is no ELSE, which yields NULL
+}
+
+// Eval implements Expr, returning the result of the first branch that matches
+func (c *CaseExpr) Eval(row Row) (Value, error) {
+	var operand Value
+	if c.Operand != nil {
+		var err error
+		if operand, err = c.Operand.Eval(row); err != nil {
+			return NullValue(), err
+		}
+	}
+
+	for _, when := range c.Whens {
+		var matched bool
+		if c.Operand == nil {
+			var err error
+			if matched, err = EvalCondition(when.Cond, row); err != nil {
+				return NullValue(), err
+			}
+		} else {
+			value, err := when.Cond.Eval(row)
+			if err != nil {
+				return NullValue(), err
+			}
+			matched = !operand.IsNull() && !value.IsNull() && compareValues(operand, value) == 0
+		}
+		if matched {
+			return when.Result.Eval(row)
+		}
+	}
+
+	if c.Else == nil {
+		return NullValue(), nil
+	}
+	return c.Else.Eval(row)
+}
+
+// String implements Expr
+func (c *CaseExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString("CASE")
+	if c.Operand != nil {
+		sb.WriteString(" " + trimParens(c.Operand.String()))
+	}
+	for _, when := range c.Whens {
+		sb.WriteString(" WHEN " + trimParens(when.Cond.String()) + " THEN " + trimParens(when.Result.String()))
+	}
+	if c.Else != nil {
+		sb.WriteString(" ELSE " + trimParens(c.Else.String()))
+	}
+	sb.WriteString(" END")
+	return sb.String()
+}
+
+// castTypes maps the type names accepted by CAST to the type they convert to
+var castTypes = map[string]string{
+	"DOUBLE":    "DOUBLE",
+	"FLOAT":     "DOUBLE",
+	"REAL":      "DOUBLE",
+	"DECIMAL":   "DOUBLE",
+	"NUMERIC":   "DOUBLE",
+	"INTEGER":   "BIGINT",
+	"INT":       "BIGINT",
+	"BIGINT":    "BIGINT",
+	"VARCHAR":   "VARCHAR",
+	"TEXT":      "VARCHAR",
+	"STRING":    "VARCHAR",
+	"BOOLEAN":   "BOOLEAN",
+	"BOOL":      "BOOLEAN",
+	"DATE":      "DATE",
+	"TIMESTAMP": "TIMESTAMP",
+	"DATETIME":  "TIMESTAMP",
+}
+
+// CastExpr converts a value to another type, as in CAST(MarketCap AS DOUBLE)
+type CastExpr struct {
+	Operand Expr
+	Type    string // One of the values of castTypes
+}
+
+// Eval implements Expr. Values that cannot be converted are an error.
+func (c *CastExpr) Eval(row Row) (Value, error) {
+	value, err := c.Operand.Eval(row)
+	if err != nil || value.IsNull() {
+		return value, err
+	}
+
+	switch c.Type {
+	case "DOUBLE":
+		if n, err := toNumber(value); err == nil {
+			return FloatValue(n.AsFloat()), nil
+		}
+	case "BIGINT":
+		if n, err := toNumber(value); err == nil {
+			if n.Kind == ValueInt {
+				return n, nil
+			}
+			return IntValue(int64(math.Round(n.Float))), nil
+		}
+	case "VARCHAR":
+		return StringValue(value.String()), nil
+	case "BOOLEAN":
+		if b, err := toBool(value); err == nil {
+			return b, nil
+		}
+	case "DATE":
+		if t, err := timeArgument(value); err == nil {
+			return TimeValue(ValueDate, time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), ""), nil
+		}
+	case "TIMESTAMP":
+		if t, err := timeArgument(value); err == nil {
+			return TimeValue(ValueDateTime, t, ""), nil
+		}
+	}
+	return NullValue(), fmt.Errorf("cannot cast %q to %s", value.String(), c.Type)
+}
+
+// String implements Expr
+func (c *CastExpr) String() string {
+	return fmt.Sprintf("CAST(%s AS %s)", trimParens(c.Operand.String()), c.Type)
+}
+
+// regexpCacheSize bounds the number of compiled patterns kept by compileRegexp
+const regexpCacheSize = 256
+
+// regexpCache holds compiled patterns so a pattern is compiled once per query rather than once per row
+var regexpCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// compileRegexp compiles a pattern, reusing a cached compilation when possible
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+
+	if re, exists := regexpCache.patterns[pattern]; exists {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %v", pattern, err)
+	}
+
+	// Start over rather than track recency; patterns are few and cheap to recompile
+	if len(regexpCache.patterns) >= regexpCacheSize {
+		regexpCache.patterns = make(map[string]*regexp.Regexp)
+	}
+	regexpCache.patterns[pattern] = re
+	return re, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScalarExpressions(t *testing.T) {
+	types := map[string]ColumnType{
+		"MarketCap": ColumnTypeInteger,
+		"Revenue":   ColumnTypeString,
+		"Company":   ColumnTypeString,
+		"Founded":   ColumnTypeDate,
+	}
+	row := NewTypedRow(map[string]string{
+		"MarketCap": "1200",
+		"Revenue":   "#N/A",
+		"Company":   "  Acme Corp ",
+		"Founded":   "1999-03-15",
+	}, types)
+
+	tests := []struct {
+		expr string
+		want string // Value as JSON prints it, or NULL
+	}{
+		{"MarketCap + 1", "1201"},
+		{"MarketCap / 400", "3"},
+		{"MarketCap % 7", "3"},
+		{"-MarketCap * 2", "-2400"},
+		{"9223372036854775807 + 1", "9.223372036854776e+18"},
+		{"MarketCap / 0", "NULL"},
+		// A value that is not a number makes only this row NULL
+		{"MarketCap / Revenue", "NULL"},
+		{"-Revenue", "NULL"},
+		{"ROUND(Revenue)", "NULL"},
+		{"ABS(Company)", "NULL"},
+		{"ROUND(2.345, 2)", "2.35"},
+		{"ROUND(1250, -2)", "1300"},
+		{"ABS(-7)", "7"},
+		{"UPPER(TRIM(Company))", "ACME CORP"},
+		{"SUBSTR(TRIM(Company), -4)", "Corp"},
+		{"LENGTH(Company)", "12"},
+		{"'x' || NULL", "NULL"},
+		{"CONCAT('x', NULL, 'y')", "xy"},
+		{"NULLIF(MarketCap, 1200)", "NULL"},
+		{"COALESCE(Missing, MarketCap)", "1200"},
+		{"CASE WHEN MarketCap > 1000 THEN 'large' ELSE 'small' END", "large"},
+		{"CASE MarketCap WHEN 5 THEN 'five' END", "NULL"},
+		{"CAST('42' AS INTEGER) + 1", "43"},
+		{"CAST(MarketCap AS VARCHAR) || '!'", "1200!"},
+		{"DATE_DIFF('year', Founded, '2024-01-01')", "25"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			query, err := ParseSQL("SELECT " + tt.expr + " FROM BB_ASSETS")
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := query.Select[0].Expr.Eval(row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := "NULL"
+			if !value.IsNull() {
+				got = value.String()
+			}
+			if got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScalarExpressionErrors(t *testing.T) {
+	row := StringRow{"Company": "Acme"}
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"CAST(Company AS DOUBLE)", "Acme"},
+		{"ROUND(1.5, 'x')", "digits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			query, err := ParseSQL("SELECT " + tt.expr + " FROM BB_ASSETS")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = query.Select[0].Expr.Eval(row)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultJoinMemory is the memory, in bytes, the rows a JOIN holds in its hash
+// tables may use unless configured otherwise
+const defaultJoinMemory = 512 << 20
+
+// joinEntryOverhead estimates the memory a held row needs beyond its values:
+// its entry, the slot of the hash table and the key
+const joinEntryOverhead = 96
+
+// joinRow is a row of joined tables: the row of each table of the FROM clause,
+// nil for the table of a LEFT JOIN without a match. Its columns are named
+// table.column after the alias, or name, of their table.
+type joinRow struct {
+	tables []string
+	rows   []Row
+}
+
+// column returns the row of the table a column of the join belongs to and the
+// name of the column in it
+func (r joinRow) column(column string) (Row, string, bool) {
+	for i, table := range r.tables {
+		if strings.HasPrefix(column, table+".") && r.rows[i] != nil {
+			return r.rows[i], column[len(table)+1:], true
+		}
+	}
+	return nil, "", false
+}
+
+// Get implements Row
+func (r joinRow) Get(column string) (Value, bool) {
+	row, name, ok := r.column(column)
+	if !ok {
+		return NullValue(), false
+	}
+	return row.Get(name)
+}
+
+// Columns implements Row, listing the columns of each table in turn
+func (r joinRow) Columns() []string {
+	var columns []string
+	for i, row := range r.rows {
+		if row == nil {
+			continue
+		}
+		for _, column := range row.Columns() {
+			columns = append(columns, r.tables[i]+"."+column)
+		}
+	}
+	return columns
+}
+
+// Lineage implements LineageRow
+func (r joinRow) Lineage(column string) (CellLineage, bool) {
+	row, name, ok := r.column(column)
+	if !ok {
+		return CellLineage{}, false
+	}
+	lineageRow, tracksLineage := row.(LineageRow)
+	if !tracksLineage {
+		return CellLineage{}, false
+	}
+	return lineageRow.Lineage(name)
+}
+
+// joinTable is a table of a join with the part of the WHERE clause that can be
+// applied while it is read
+type joinTable struct {
+	name     string // Alias of the table, or its name
+	position int    // Position in the FROM clause
+	manager  *JSONAssetManager
+	filter   Expr     // Terms of the WHERE clause only about this table, nil if none
+	ids      []string // Assets to read when the filter pins the key columns
+	lookup   bool
+}
+
+// String returns the table as written in the FROM clause
+func (t *joinTable) String() string {
+	if t.name == t.manager.Table() {
+		return t.name
+	}
+	return tableReference(t.manager.Table(), t.name)
+}
+
+// estimate returns the number of assets the table is expected to read
+func (t *joinTable) estimate() int64 {
+	if t.lookup {
+		return int64(len(t.ids))
+	}
+	return t.manager.estimateAssetCount()
+}
+
+// access describes how the table's assets are found, as in a query plan
+func (t *joinTable) access() string {
+	if t.lookup {
+		return fmt.Sprintf("read only the asset files of the %s values in the WHERE clause", strings.Join(t.manager.keyColumns, ", "))
+	}
+	return fmt.Sprintf("read every asset file under %s", t.manager.jsonDir)
+}
+
+// read passes the table's rows that pass its filter to add
+func (t *joinTable) read(query *SQLQuery, tables []string, add func(Row) error, stats *QueryStats) (time.Time, error) {
+	match := func(row Row) (bool, error) {
+		rows := make([]Row, len(tables))
+		rows[t.position] = row
+		return EvalCondition(t.filter, joinRow{tables: tables, rows: rows})
+	}
+	if t.lookup {
+		return t.manager.lookupRows(query, t.ids, match, add, stats)
+	}
+	return t.manager.scanRows(query, match, add, stats)
+}
+
+// joinEntry is a row held in the hash table of a join
+type joinEntry struct {
+	row     Row
+	matched bool // A row of the other side matched it, for a LEFT JOIN holding the rows to keep
+}
+
+// hashJoin is a JOIN executed by holding the rows of one side in a hash table
+// keyed by the values its ON condition compares with =
+type hashJoin struct {
+	clause  JoinClause
+	table   int    // Position of the joined table in the FROM clause
+	outer   []Expr // Compared values of the tables before it
+	inner   []Expr // Compared values of the joined table
+	entries map[string][]*joinEntry
+	held    []*joinEntry // Every held row in the order it was read
+}
+
+// keysText returns the compared values of one side of the join as SQL text
+func keysText(side []Expr) string {
+	keys := make([]string, len(side))
+	for i, key := range side {
+		keys[i] = key.String()
+	}
+	return strings.Join(keys, ", ")
+}
+
+// exprTables returns the tables whose columns an expression uses
+func exprTables(expr Expr) map[string]bool {
+	tables := make(map[string]bool)
+	walkExpr(expr, func(e Expr) error {
+		if ref, ok := e.(*ColumnRef); ok {
+			tables[ref.Table] = true
+		}
+		return nil
+	})
+	return tables
+}
+
+// onlyTables reports whether an expression uses columns, and only columns of the given tables
+func onlyTables(used map[string]bool, tables []string) bool {
+	if len(used) == 0 {
+		return false
+	}
+	for table := range used {
+		if !containsString(tables, table) {
+			return false
+		}
+	}
+	return true
+}
+
+// andAll joins terms with AND, returning nil for no terms
+func andAll(terms []Expr) Expr {
+	var joined Expr
+	for _, term := range terms {
+		if joined == nil {
+			joined = term
+		} else {
+			joined = &BinaryExpr{Op: "AND", Left: joined, Right: term}
+		}
+	}
+	return joined
+}
+
+// planJoins finds the = comparisons of the ON condition of each join that its
+// hash table is keyed by, and gives each table the terms of the WHERE clause
+// that can filter it while it is read. It returns the joins and
+// the terms of the WHERE clause left to apply to joined rows.
+func planJoins(query *SQLQuery, tables []*joinTable) ([]*hashJoin, Expr, error) {
+	names := query.TableNames()
+	joins := make([]*hashJoin, len(query.Joins))
+	for i, clause := range query.Joins {
+		join := &hashJoin{clause: clause, table: i + 1}
+		for _, term := range andTerms(clause.On) {
+			if e, ok := term.(*BinaryExpr); ok && e.Op == "=" {
+				left, right := exprTables(e.Left), exprTables(e.Right)
+				joined := names[i+1 : i+2]
+				if onlyTables(left, names[:i+1]) && onlyTables(right, joined) {
+					join.outer, join.inner = append(join.outer, e.Left), append(join.inner, e.Right)
+					continue
+				}
+				if onlyTables(right, names[:i+1]) && onlyTables(left, joined) {
+					join.outer, join.inner = append(join.outer, e.Right), append(join.inner, e.Left)
+					continue
+				}
+			}
+		}
+		if len(join.outer) == 0 {
+			return nil, nil, queryErrorf("JOIN %s needs an ON condition comparing its columns with = to columns of the tables before it", names[i+1])
+		}
+		joins[i] = join
+	}
+
+	// Rows of a LEFT JOIN's table cannot be filtered before the join, as
+	// rows without a match would then be kept with NULLs instead
+	filters := make([][]Expr, len(tables))
+	var where []Expr
+	for _, term := range andTerms(query.Where) {
+		used := exprTables(term)
+		if len(used) == 1 {
+			for i, name := range names {
+				if used[name] && (i == 0 || !query.Joins[i-1].Left) {
+					filters[i] = append(filters[i], term)
+					used = nil
+					break
+				}
+			}
+		}
+		if used != nil {
+			where = append(where, term)
+		}
+	}
+	for i, table := range tables {
+		table.filter = andAll(filters[i])
+		table.ids, table.lookup = keyLookupIDs(table.filter, table.manager.keyColumns)
+	}
+	return joins, andAll(where), nil
+}
+
+// joinKeys returns every key a row is held or looked up under in the hash
+// table of a join, none if a compared value is NULL, which matches nothing.
+// As = compares a string with a number, date or boolean by reading it as one,
+// a string is keyed both as itself and as each of these it can be read as, so
+// '101' finds 101. Rows found by a key are still checked against the whole ON
+// condition, as two strings compare as text even when both are numbers.
+func joinKeys(exprs []Expr, row Row) ([]string, error) {
+	keys := []string{""}
+	for _, expr := range exprs {
+		value, err := expr.Eval(row)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating ON clause: %v", err)
+		}
+		if value.IsNull() {
+			return nil, nil
+		}
+		parts := joinKeyParts(value)
+		next := make([]string, 0, len(keys)*len(parts))
+		for _, key := range keys {
+			for _, part := range parts {
+				next = append(next, key+part)
+			}
+		}
+		keys = next
+	}
+	return keys, nil
+}
+
+// joinKeyParts returns the key parts of a compared value: the value itself and,
+// for a string, each type = could read it as when compared with that type
+func joinKeyParts(value Value) []string {
+	forms := []Value{value}
+	if value.Kind == ValueString {
+		for _, like := range []Value{IntValue(0), {Kind: ValueDateTime}, BoolValue(false)} {
+			if coerced, ok := coerceString(value.Str, like); ok {
+				forms = append(forms, coerced)
+			}
+		}
+	}
+	parts := make([]string, len(forms))
+	for i, form := range forms {
+		// Dates compare as instants, whatever their time zone
+		if form.IsTemporal() {
+			form.Time = form.Time.UTC()
+		}
+		var part strings.Builder
+		writeKeyPart(&part, form)
+		parts[i] = part.String()
+	}
+	return parts
+}
+
+// candidates returns the held rows found by any of a row's keys, each once
+func (j *hashJoin) candidates(keys []string) []*joinEntry {
+	if len(keys) == 1 {
+		return j.entries[keys[0]]
+	}
+	var found []*joinEntry
+	seen := make(map[*joinEntry]bool)
+	for _, key := range keys {
+		for _, entry := range j.entries[key] {
+			if !seen[entry] {
+				seen[entry] = true
+				found = append(found, entry)
+			}
+		}
+	}
+	return found
+}
+
+// rowSize estimates the memory used by the values of a row. Rows without an
+// estimate of their own are measured by the text of each of their values.
+func rowSize(row Row) int64 {
+	if sized, ok := row.(interface{ size() int64 }); ok {
+		return sized.size()
+	}
+	size := int64(cacheEntryOverhead)
+	for _, column := range row.Columns() {
+		value, _ := row.Get(column)
+		size += int64(len(column) + len(value.String()) + cacheValueOverhead)
+	}
+	return size
+}
+
+// joinExecutor runs the hash joins of a query and passes the joined rows that
+// match the rest of its WHERE clause to a sink
+type joinExecutor struct {
+	query  *SQLQuery
+	names  []string
+	tables []*joinTable
+	joins  []*hashJoin
+	where  Expr
+	sink   rowSink
+	stats  *QueryStats
+	limit  int64 // Memory the held rows may use
+	used   int64
+}
+
+// hold adds a row to the hash table of a join under each of its keys, failing
+// once the held rows of every join need more memory than the limit
+func (e *joinExecutor) hold(join *hashJoin, keys []string, row Row, table *joinTable) error {
+	e.used += int64(joinEntryOverhead) + rowSize(row)
+	for _, key := range keys {
+		e.used += int64(len(key))
+	}
+	if e.used > e.limit {
+		return queryErrorf("JOIN needs more than %s of memory to hold the rows of %s; narrow the query down with WHERE or raise join_memory_mb",
+			FormatBytes(uint64(e.limit)), table)
+	}
+
+	entry := &joinEntry{row: row}
+	join.held = append(join.held, entry)
+	for _, key := range keys {
+		join.entries[key] = append(join.entries[key], entry)
+	}
+	return nil
+}
+
+// build reads a table into the hash table of a join, keyed by the given side of
+// its = comparisons. Rows with a NULL key are only held if keep is set.
+func (e *joinExecutor) build(join *hashJoin, table *joinTable, keys []Expr, keep bool) (time.Time, error) {
+	join.entries = make(map[string][]*joinEntry)
+	rows := make([]Row, len(e.names))
+	return table.read(e.query, e.names, func(row Row) error {
+		rows[table.position] = row
+		rowKeys, err := joinKeys(keys, joinRow{tables: e.names, rows: rows})
+		if err != nil || (len(rowKeys) == 0 && !keep) {
+			return err
+		}
+		return e.hold(join, rowKeys, row, table)
+	}, e.stats)
+}
+
+// emit passes a joined row to the sink if it matches the rest of the WHERE clause
+func (e *joinExecutor) emit(rows []Row) error {
+	row := joinRow{tables: e.names, rows: append([]Row(nil), rows...)}
+	matches, err := EvalCondition(e.where, row)
+	if err != nil {
+		return fmt.Errorf("error evaluating WHERE clause: %v", err)
+	}
+	if !matches {
+		return nil
+	}
+	return e.sink.Add(row)
+}
+
+// matches reports whether the rows of a join satisfy its ON condition
+func (e *joinExecutor) matches(join *hashJoin, rows []Row) (bool, error) {
+	matches, err := EvalCondition(join.clause.On, joinRow{tables: e.names, rows: rows})
+	if err != nil {
+		return false, fmt.Errorf("error evaluating ON clause: %v", err)
+	}
+	return matches, nil
+}
+
+// probe looks the rows of the tables before the k-th join up in its hash
+// table, going on to the next join with each match
+func (e *joinExecutor) probe(rows []Row, k int) error {
+	if k == len(e.joins) {
+		return e.emit(rows)
+	}
+	join := e.joins[k]
+	keys, err := joinKeys(join.outer, joinRow{tables: e.names, rows: rows})
+	if err != nil {
+		return err
+	}
+
+	matched := false
+	for _, entry := range join.candidates(keys) {
+		rows[join.table] = entry.row
+		ok, err := e.matches(join, rows)
+		if err != nil {
+			return err
+		}
+		if ok {
+			matched = true
+			if err := e.probe(rows, k+1); err != nil {
+				return err
+			}
+		}
+	}
+	rows[join.table] = nil
+	if !matched && join.clause.Left {
+		return e.probe(rows, k+1)
+	}
+	return nil
+}
+
+// run holds every joined table in memory and streams the first table of the
+// FROM clause through their hash tables
+func (e *joinExecutor) run() (time.Time, error) {
+	for _, join := range e.joins {
+		if _, err := e.build(join, e.tables[join.table], join.inner, false); err != nil {
+			return time.Now(), err
+		}
+	}
+
+	rows := make([]Row, len(e.names))
+	return e.tables[0].read(e.query, e.names, func(row Row) error {
+		rows[0] = row
+		return e.probe(rows, 0)
+	}, e.stats)
+}
+
+// runReversed holds the first table of a single join in memory and streams the
+// joined table through its hash table. The rows of a LEFT JOIN's first table
+// that nothing matched are added at the end.
+func (e *joinExecutor) runReversed() (time.Time, error) {
+	join := e.joins[0]
+	if _, err := e.build(join, e.tables[0], join.outer, join.clause.Left); err != nil {
+		return time.Now(), err
+	}
+
+	rows := make([]Row, len(e.names))
+	mark, err := e.tables[1].read(e.query, e.names, func(row Row) error {
+		rows[0], rows[1] = nil, row
+		keys, err := joinKeys(join.inner, joinRow{tables: e.names, rows: rows})
+		if err != nil {
+			return err
+		}
+		for _, entry := range join.candidates(keys) {
+			rows[0] = entry.row
+			ok, err := e.matches(join, rows)
+			if err != nil {
+				return err
+			}
+			if ok {
+				entry.matched = true
+				if err := e.emit(rows); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}, e.stats)
+	if err != nil || !join.clause.Left {
+		return mark, err
+	}
+
+	for _, entry := range join.held {
+		if !entry.matched {
+			if err := e.emit([]Row{entry.row, nil}); err != nil {
+				return mark, err
+			}
+		}
+	}
+	return e.stats.timeStage("collect", mark), nil
+}
+
+// joinedTables returns the table of each name of the FROM clause, in order
+func (s *TableSet) joinedTables(query *SQLQuery) ([]*JSONAssetManager, error) {
+	managers := make([]*JSONAssetManager, 0, len(query.Joins)+1)
+	tableNames := []string{query.FromTable}
+	for _, join := range query.Joins {
+		tableNames = append(tableNames, join.Table)
+	}
+	for _, tableName := range tableNames {
+		manager, err := s.Table(tableName)
+		if err != nil {
+			return nil, err
+		}
+		managers = append(managers, manager)
+	}
+	return managers, nil
+}
+
+// JoinScopes returns the tables a query of a table with JOIN clauses reads,
+// under the names the query refers to them by, so requested columns can be
+// resolved before the query is written. Parameters of the JOIN clauses are
+// taken from params; any left over belong to the rest of the query.
+func (s *TableSet) JoinScopes(table, join string, params *QueryParams) ([]tableScope, error) {
+	tokens, err := tokenizeSQL("SELECT * FROM " + table + " " + join)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{tokens: tokens, params: params}
+	query, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	managers, err := s.joinedTables(query)
+	if err != nil {
+		return nil, err
+	}
+	names := query.TableNames()
+	scopes := make([]tableScope, len(names))
+	for i, name := range names {
+		scopes[i] = tableScope{Name: name, Catalog: managers[i].GetColumnCatalog()}
+	}
+	return scopes, nil
+}
+
+// executeJoinQuery executes a query joining tables of the store. Each join is
+// a hash join: the rows of the joined table are held in memory and the first
+// table is streamed past them, except that a single join holds whichever of
+// its tables is expected to be smaller.
+func (s *TableSet) executeJoinQuery(sqlQuery string, query *SQLQuery, start time.Time) (*QueryResult, error) {
+	stats := &QueryStats{}
+	mark := stats.timeStage("parse", start)
+
+	names := query.TableNames()
+	managers, err := s.joinedTables(query)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]*joinTable, len(names))
+	catalogs := make([]*ColumnCatalog, len(names))
+	for i, name := range names {
+		tables[i] = &joinTable{name: name, position: i, manager: managers[i]}
+		catalogs[i] = managers[i].GetColumnCatalog()
+	}
+	if err := query.BindJoin(catalogs); err != nil {
+		return nil, err
+	}
+	mark = stats.timeStage("bind", mark)
+
+	joins, where, err := planJoins(query, tables)
+	if err != nil {
+		return nil, err
+	}
+	reversed := len(joins) == 1 && tables[0].estimate() < tables[1].estimate()
+	streamed := tables[0]
+	var steps []string
+	for _, join := range joins {
+		held, keys := tables[join.table], join.inner
+		if reversed {
+			held, keys, streamed = tables[0], join.outer, tables[1]
+		}
+		steps = append(steps, fmt.Sprintf("hold %s in memory keyed by %s, %s", held, keysText(keys), held.access()))
+	}
+	steps = append(steps, fmt.Sprintf("stream %s past the held rows, %s", streamed, streamed.access()))
+	plan := newQueryPlan(sqlQuery, query, AccessPath{Type: AccessHashJoin, Detail: strings.Join(steps, "; ")}, streamed.estimate())
+	if query.AsOf != "" {
+		plan.AccessPath.Detail += fmt.Sprintf(", then go back to %s using each asset's history file", query.AsOf)
+	}
+	stats.timeStage("plan", mark)
+
+	if query.Explain && !query.Analyze {
+		return &QueryResult{Rows: []map[string]interface{}{}, Plan: plan}, nil
+	}
+
+	// Joined rows are grouped, counted, ordered and paginated as they arrive
+	sink, err := newRowSink(query)
+	if err != nil {
+		return nil, err
+	}
+	executor := &joinExecutor{query: query, names: names, tables: tables, joins: joins, where: where, sink: sink, stats: stats, limit: s.joinMemory}
+	if reversed {
+		mark, err = executor.runReversed()
+	} else {
+		mark, err = executor.run()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Grouping, sorting and projection happen once every row has been seen
+	result, err := sink.Result()
+	if err != nil {
+		return nil, err
+	}
+	stats.timeStage("finalize", mark)
+
+	stats.RowsReturned = len(result.Rows)
+	stats.ElapsedMs = durationMs(time.Since(start))
+	if query.Explain {
+		plan.Stats = stats
+		result.Plan = plan
+	}
+	return result, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newJoinTestTables opens BB_ASSETS with three assets and an ISSUERS table with
+// the issuers of two of them, one issuer without assets and extra more
+func newJoinTestTables(t *testing.T, extra int) *TableSet {
+	t.Helper()
+	logger := NewLogger()
+	tables, err := NewTableSet(logger, NewProgressTracker(logger), t.TempDir(),
+		[]TableConfig{{Name: "ISSUERS", KeyColumns: []string{"ID"}, Directories: []string{"issuers"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tables.Close() })
+
+	update := func(table string, header, record []string) {
+		t.Helper()
+		manager, err := tables.Table(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := manager.UpdateAssetFromCSVWithDate(record[0], header, record, "20250101"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assetHeader := []string{"ID_BB_GLOBAL", "ID_BB_ULT_PARENT"}
+	update("BB_ASSETS", assetHeader, []string{"A1", "P1"})
+	update("BB_ASSETS", assetHeader, []string{"A2", "P2"})
+	update("BB_ASSETS", assetHeader, []string{"A3", "P9"})
+	issuerHeader := []string{"ID", "Rating"}
+	update("ISSUERS", issuerHeader, []string{"P1", "AA"})
+	update("ISSUERS", issuerHeader, []string{"P2", "B"})
+	update("ISSUERS", issuerHeader, []string{"P3", "C"})
+	for i := 0; i < extra; i++ {
+		update("ISSUERS", issuerHeader, []string{fmt.Sprintf("X%d", i), "D"})
+	}
+	return tables
+}
+
+func TestHashJoin(t *testing.T) {
+	tests := []struct {
+		name     string
+		join     string
+		extra    int    // Issuers added so that ISSUERS is the larger table
+		wantHeld string // Table the plan holds in memory
+		want     map[string]interface{}
+	}{
+		{
+			name: "inner join holding the joined table", join: "JOIN", wantHeld: "ISSUERS i",
+			want: map[string]interface{}{"A1": "AA", "A2": "B"},
+		},
+		{
+			name: "left join holding the joined table", join: "LEFT JOIN", wantHeld: "ISSUERS i",
+			want: map[string]interface{}{"A1": "AA", "A2": "B", "A3": nil},
+		},
+		{
+			name: "inner join holding the first table", join: "JOIN", extra: 5, wantHeld: "BB_ASSETS",
+			want: map[string]interface{}{"A1": "AA", "A2": "B"},
+		},
+		{
+			name: "left join holding the first table", join: "LEFT JOIN", extra: 5, wantHeld: "BB_ASSETS",
+			want: map[string]interface{}{"A1": "AA", "A2": "B", "A3": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := newJoinTestTables(t, tt.extra)
+			result, err := tables.ExecuteSQLQuery("EXPLAIN ANALYZE SELECT ID_BB_GLOBAL, i.Rating FROM BB_ASSETS "+
+				tt.join+" ISSUERS i ON ID_BB_ULT_PARENT = i.ID", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detail := result.Plan.AccessPath.Detail; !strings.HasPrefix(detail, "hold "+tt.wantHeld+" ") {
+				t.Errorf("plan %q does not hold %s", detail, tt.wantHeld)
+			}
+
+			got := make(map[string]interface{})
+			for _, row := range result.Rows {
+				got[fmt.Sprint(row["ID_BB_GLOBAL"])] = row["Rating"]
+			}
+			if len(result.Rows) != len(tt.want) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("join returned %v, want ratings %v", result.Rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashJoinCoercesKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		join    string
+		issuers string // Codes and ratings of the issuers
+		want    map[string]interface{}
+	}{
+		{
+			name: "string keys found by numbers", join: "JOIN",
+			issuers: "101,AA\n102,B\n103,C\n",
+			want:    map[string]interface{}{"A1": "AA", "A2": "B"},
+		},
+		{
+			name: "string keys found by numbers in a left join", join: "LEFT JOIN",
+			issuers: "101,AA\n102,B\n103,C\n",
+			want:    map[string]interface{}{"A1": "AA", "A2": "B", "A3": nil},
+		},
+		{
+			name: "strings compared as text", join: "LEFT JOIN",
+			issuers: "101.0,AA\n102.0,B\nABC,C\n",
+			want:    map[string]interface{}{"A1": nil, "A2": "B", "A3": nil},
+		},
+	}
+	for _, tt := range tests {
+		// Hold either table: ISSUERS is the larger table once extra issuers are added
+		for _, extra := range []int{0, 5} {
+			t.Run(fmt.Sprintf("%s with %d extra issuers", tt.name, extra), func(t *testing.T) {
+				logger := NewLogger()
+				tables, err := NewTableSet(logger, NewProgressTracker(logger), t.TempDir(),
+					[]TableConfig{{Name: "ISSUERS", KeyColumns: []string{"ID"}, Directories: []string{"issuers"}}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer tables.Close()
+
+				// IssuerCode is a string column, as not all of its values are numbers
+				issuers := "ID,Code,Rating\n"
+				for i, line := range strings.Split(strings.TrimSpace(tt.issuers), "\n") {
+					issuers += fmt.Sprintf("P%d,%s\n", i, line)
+				}
+				for i := 0; i < extra; i++ {
+					issuers += fmt.Sprintf("X%d,%d,D\n", i, 900+i)
+				}
+				load := func(table, name, contents string) {
+					t.Helper()
+					manager, err := tables.Table(table)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := manager.LoadCSVFile(writeCSV(t, t.TempDir(), name, contents)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				load("BB_ASSETS", "assets_20250101.csv", "ID_BB_GLOBAL,IssuerCode\nA1,101\nA2,102.0\nA3,N/A\n")
+				load("ISSUERS", "issuers_20250101.csv", issuers)
+
+				result, err := tables.ExecuteSQLQuery("SELECT ID_BB_GLOBAL, i.Rating FROM BB_ASSETS "+
+					tt.join+" ISSUERS i ON IssuerCode = i.Code", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := make(map[string]interface{})
+				for _, row := range result.Rows {
+					got[fmt.Sprint(row["ID_BB_GLOBAL"])] = row["Rating"]
+				}
+				if len(result.Rows) != len(tt.want) || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("join returned %v, want ratings %v", result.Rows, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestHashJoinMemoryLimit(t *testing.T) {
+	tables := newJoinTestTables(t, 0)
+	tables.SetJoinMemory(1)
+	_, err := tables.ExecuteSQLQuery("SELECT ID_BB_GLOBAL, i.Rating FROM BB_ASSETS JOIN ISSUERS i ON ID_BB_ULT_PARENT = i.ID", nil)
+	if err == nil || !IsQueryInputError(err) {
+		t.Fatalf("join over the memory limit returned %v, want a query input error", err)
+	}
+}
+
+// unsizedRow is a row without a size estimate of its own
+type unsizedRow struct {
+	values StringRow
+}
+
+func (r unsizedRow) Get(column string) (Value, bool) {
+	return r.values.Get(column)
+}
+
+func (r unsizedRow) Columns() []string {
+	return r.values.Columns()
+}
+
+func TestRowSize(t *testing.T) {
+	values := map[string]string{"PX_LAST": "101.5", "Company": "Example Corp"}
+	lineage := map[string]CellLineage{"PX_LAST": {EffectiveDate: "20250101", LoadSource: LoadSource{File: "prices_20250101.csv"}}}
+	typed := TypedRow{values: values}
+
+	tests := []struct {
+		name string
+		row  Row
+		min  int64 // The estimate is at least the size of the values
+	}{
+		{name: "typed row", row: typed, min: assetSize(values)},
+		{name: "string row", row: StringRow(values), min: assetSize(values)},
+		{name: "history row counts its lineage", row: historyRow{TypedRow: typed, lineage: lineage}, min: assetSize(values) + 1},
+		{name: "row measured by its values", row: unsizedRow{values: values}, min: assetSize(values)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if size := rowSize(tt.row); size < tt.min {
+				t.Errorf("rowSize = %d, want at least %d", size, tt.min)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TokenType identifies the kind of a lexical token in a SQL query
+type TokenType int
+
+const (
+	TokenEOF TokenType = iota
+	TokenKeyword
+	TokenIdent
+	TokenString
+	TokenNumber
+	TokenOperator
+	TokenComma
+	TokenLParen
+	TokenRParen
+	TokenStar
+	TokenSemicolon
+	TokenParam
+	TokenDot
+)
+
+// Token is a single lexical token with its position in the query
+type Token struct {
+	Type TokenType
+	Text string // Keyword text is upper-cased, identifiers and literals are kept verbatim, parameters are "?" or ":name"
+	Pos  int    // 1-based character position of the token in the query
+}
+
+// String returns a human-readable form of the token for error messages
+func (t Token) String() string {
+	switch t.Type {
+	case TokenEOF:
+		return ""
+	case TokenString:
+		return "'" + strings.ReplaceAll(t.Text, "'", "''") + "'"
+	default:
+		return t.Text
+	}
+}
+
+// sqlKeywords is the set of reserved words recognised by the lexer.
+// Reserved words must be double-quoted to be used as column names.
+var sqlKeywords = map[string]bool{
+	"SELECT":   true,
+	"FROM":     true,
+	"WHERE":    true,
+	"AND":      true,
+	"OR":       true,
+	"NOT":      true,
+	"NULL":     true,
+	"TRUE":     true,
+	"FALSE":    true,
+	"ORDER":    true,
+	"BY":       true,
+	"ASC":      true,
+	"DESC":     true,
+	"NULLS":    true,
+	"LIMIT":    true,
+	"OFFSET":   true,
+	"DISTINCT": true,
+	"GROUP":    true,
+	"HAVING":   true,
+	"AS":       true,
+	"IN":       true,
+	"LIKE":     true,
+	"ILIKE":    true,
+	"BETWEEN":  true,
+	"IS":       true,
+	"CASE":     true,
+	"WHEN":     true,
+	"THEN":     true,
+	"ELSE":     true,
+	"END":      true,
+	"CAST":     true,
+	"EXPLAIN":  true,
+	"ANALYZE":  true,
+	"OF":       true,
+	"JOIN":     true,
+	"INNER":    true,
+	"LEFT":     true,
+	"OUTER":    true,
+	"ON":       true,
+}
+
+// SQLSyntaxError describes a lexing or parsing failure at a position in the query
+type SQLSyntaxError struct {
+	Pos     int    // 1-based character position of the offending token
+	Token   string // The offending token as it appeared in the query, empty at end of input
+	Message string // Description of what went wrong
+}
+
+// Error implements the error interface
+func (e *SQLSyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("syntax error at position %d (end of input): %s", e.Pos, e.Message)
+	}
+	return fmt.Sprintf("syntax error at position %d near %q: %s", e.Pos, e.Token, e.Message)
+}
+
+// sqlLexer splits a SQL query into tokens
+type sqlLexer struct {
+	input []rune
+	pos   int
+}
+
+// tokenizeSQL converts a SQL query into a slice of tokens terminated by TokenEOF
+func tokenizeSQL(query string) ([]Token, error) {
+	lexer := &sqlLexer{input: []rune(query)}
+
+	var tokens []Token
+	for {
+		tok, err := lexer.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.Type == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// peekRune returns the rune at offset from the current position, or 0 past the end
+func (l *sqlLexer) peekRune(offset int) rune {
+	if l.pos+offset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+offset]
+}
+
+// skipWhitespaceAndComments advances past whitespace, -- line comments and /* */ block comments
+func (l *sqlLexer) skipWhitespaceAndComments() error {
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case unicode.IsSpace(r):
+			l.pos++
+		case r == '-' && l.peekRune(1) == '-':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		case r == '/' && l.peekRune(1) == '*':
+			start := l.pos
+			l.pos += 2
+			for l.pos < len(l.input) && !(l.input[l.pos] == '*' && l.peekRune(1) == '/') {
+				l.pos++
+			}
+			if l.pos >= len(l.input) {
+				return &SQLSyntaxError{Pos: start + 1, Token: "/*", Message: "unterminated comment"}
+			}
+			l.pos += 2
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// next returns the next token in the input
+func (l *sqlLexer) next() (Token, error) {
+	if err := l.skipWhitespaceAndComments(); err != nil {
+		return Token{}, err
+	}
+
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return Token{Type: TokenEOF, Pos: start + 1}, nil
+	}
+
+	r := l.input[l.pos]
+	switch {
+	case r == '\'':
+		return l.readQuoted('\'', TokenString)
+	case r == '"':
+		return l.readQuoted('"', TokenIdent)
+	case r == '`':
+		return l.readQuoted('`', TokenIdent)
+	case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(l.peekRune(1))):
+		return l.readNumber(), nil
+	case isIdentStart(r):
+		return l.readWord(), nil
+	case r == ',':
+		l.pos++
+		return Token{Type: TokenComma, Text: ",", Pos: start + 1}, nil
+	case r == '(':
+		l.pos++
+		return Token{Type: TokenLParen, Text: "(", Pos: start + 1}, nil
+	case r == ')':
+		l.pos++
+		return Token{Type: TokenRParen, Text: ")", Pos: start + 1}, nil
+	case r == '*':
+		l.pos++
+		return Token{Type: TokenStar, Text: "*", Pos: start + 1}, nil
+	case r == ';':
+		l.pos++
+		return Token{Type: TokenSemicolon, Text: ";", Pos: start + 1}, nil
+	case r == '.':
+		l.pos++
+		return Token{Type: TokenDot, Text: ".", Pos: start + 1}, nil
+	case r == '?':
+		l.pos++
+		return Token{Type: TokenParam, Text: "?", Pos: start + 1}, nil
+	case r == ':' && isIdentStart(l.peekRune(1)):
+		l.pos++
+		for l.pos < len(l.input) && isIdentPart(l.input[l.pos]) {
+			l.pos++
+		}
+		return Token{Type: TokenParam, Text: string(l.input[start:l.pos]), Pos: start + 1}, nil
+	}
+
+	// Two-character operators must be checked before their one-character prefixes
+	two := string(r) + string(l.peekRune(1))
+	switch two {
+	case "<=", ">=", "<>", "!=", "||":
+		l.pos += 2
+		return Token{Type: TokenOperator, Text: two, Pos: start + 1}, nil
+	}
+
+	switch r {
+	case '=', '<', '>', '+', '-', '/', '%':
+		l.pos++
+		return Token{Type: TokenOperator, Text: string(r), Pos: start + 1}, nil
+	}
+
+	return Token{}, &SQLSyntaxError{Pos: start + 1, Token: string(r), Message: "unexpected character"}
+}
+
+// readQuoted reads a quoted string or identifier; a doubled quote character escapes itself
+func (l *sqlLexer) readQuoted(quote rune, tokenType TokenType) (Token, error) {
+	start := l.pos
+	l.pos++ // Skip the opening quote
+
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.input) {
+			what := "string literal"
+			if tokenType == TokenIdent {
+				what = "quoted identifier"
+			}
+			return Token{}, &SQLSyntaxError{
+				Pos:     start + 1,
+				Token:   string(l.input[start:]),
+				Message: "unterminated " + what,
+			}
+		}
+
+		r := l.input[l.pos]
+		if r == quote {
+			if l.peekRune(1) == quote {
+				sb.WriteRune(quote)
+				l.pos += 2
+				continue
+			}
+			l.pos++
+			break
+		}
+		sb.WriteRune(r)
+		l.pos++
+	}
+
+	if tokenType == TokenIdent && sb.Len() == 0 {
+		return Token{}, &SQLSyntaxError{Pos: start + 1, Token: string(l.input[start:l.pos]), Message: "empty quoted identifier"}
+	}
+
+	return Token{Type: tokenType, Text: sb.String(), Pos: start + 1}, nil
+}
+
+// readNumber reads an integer or decimal literal with an optional exponent
+func (l *sqlLexer) readNumber() Token {
+	start := l.pos
+	for unicode.IsDigit(l.peekRune(0)) {
+		l.pos++
+	}
+	if l.peekRune(0) == '.' {
+		l.pos++
+		for unicode.IsDigit(l.peekRune(0)) {
+			l.pos++
+		}
+	}
+	if r := l.peekRune(0); r == 'e' || r == 'E' {
+		next := l.peekRune(1)
+		if unicode.IsDigit(next) || ((next == '+' || next == '-') && unicode.IsDigit(l.peekRune(2))) {
+			l.pos += 2
+			for unicode.IsDigit(l.peekRune(0)) {
+				l.pos++
+			}
+		}
+	}
+	return Token{Type: TokenNumber, Text: string(l.input[start:l.pos]), Pos: start + 1}
+}
+
+// readWord reads an unquoted identifier or keyword
+func (l *sqlLexer) readWord() Token {
+	start := l.pos
+	for l.pos < len(l.input) && isIdentPart(l.input[l.pos]) {
+		l.pos++
+	}
+	text := string(l.input[start:l.pos])
+	if upper := strings.ToUpper(text); sqlKeywords[upper] {
+		return Token{Type: TokenKeyword, Text: upper, Pos: start + 1}
+	}
+	return Token{Type: TokenIdent, Text: text, Pos: start + 1}
+}
+
+// isIdentStart reports whether r can begin an unquoted identifier
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// isIdentPart reports whether r can continue an unquoted identifier
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
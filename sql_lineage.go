@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// lineageFunction is the name of the pseudo-function that reports where a value came from
+const lineageFunction = "_LINEAGE"
+
+// LineageRow is a Row that can also report the lineage of its values
+type LineageRow interface {
+	Row
+	// Lineage returns the lineage of the value of a column and whether it is known
+	Lineage(column string) (CellLineage, bool)
+}
+
+// LineageExpr is the _lineage(column) pseudo-function. It evaluates to the lineage
+// of the column's value in the current row as a JSON object, or NULL for rows that
+// do not track lineage, such as those of an in-memory data dictionary or a group.
+type LineageExpr struct {
+	Column Expr // A ColumnRef until GROUP BY rewrites it
+}
+
+// Eval implements Expr
+func (l *LineageExpr) Eval(row Row) (Value, error) {
+	ref, isColumn := l.Column.(*ColumnRef)
+	lineageRow, tracksLineage := row.(LineageRow)
+	if !isColumn || !tracksLineage {
+		return NullValue(), nil
+	}
+
+	lineage, exists := lineageRow.Lineage(ref.Key())
+	if !exists {
+		return NullValue(), nil
+	}
+	encoded, err := json.Marshal(lineage)
+	if err != nil {
+		return NullValue(), fmt.Errorf("error encoding lineage of %s: %v", ref.Name, err)
+	}
+	return StringValue(string(encoded)), nil
+}
+
+// String implements Expr
+func (l *LineageExpr) String() string {
+	return "_lineage(" + l.Column.String() + ")"
+}
+
+// parseLineage parses the argument of _lineage, which must name a column
+func (p *sqlParser) parseLineage(name Token) (Expr, error) {
+	open := p.advance()
+	arg := p.peek()
+	if arg.Type != TokenIdent {
+		return nil, p.errorf(arg, "%s expects a column name", name.Text)
+	}
+	p.advance()
+	column, err := p.parseColumnRef(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if closing := p.peek(); closing.Type != TokenRParen {
+		return nil, p.errorf(closing, "expected ) to close ( at position %d", open.Pos)
+	}
+	p.advance()
+	return &LineageExpr{Column: column}, nil
+}
+
+// assetRow is a row of a stored asset that looks up the lineage of its values in
+// the effective date index
+type assetRow struct {
+	TypedRow
+	id    string
+	index *EffectiveDateIndex
+}
+
+// Lineage implements LineageRow
+func (r assetRow) Lineage(column string) (CellLineage, bool) {
+	return r.index.Lineage(r.id, column)
+}
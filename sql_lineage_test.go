@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// loadLineageFixture loads the same asset from an older and a newer file, the
+// newer file being loaded first so the older one must not take over its values.
+// The files are written to the working directory, as digits in the name of a
+// temporary directory would be taken for their effective date.
+func loadLineageFixture(t *testing.T) (*JSONAssetManager, string, string) {
+	t.Helper()
+	m := newTestManager(t, t.TempDir())
+	t.Chdir(t.TempDir())
+	newer := writeCSV(t, ".", "assets_20250102.csv", "ID_BB_GLOBAL,Price\nA0,1\nA1,11\n")
+	older := writeCSV(t, ".", "assets_20250101.csv", "ID_BB_GLOBAL,Price,Company\nA1,10,Acme\n")
+	if err := m.LoadFiles([]string{newer, older}); err != nil {
+		t.Fatal(err)
+	}
+	return m, newer, older
+}
+
+func TestLineageExpr(t *testing.T) {
+	m, newer, older := loadLineageFixture(t)
+
+	result, err := m.ExecuteSQLQuery("SELECT _lineage(Price) AS price, _lineage(Company) AS company "+
+		"FROM BB_ASSETS ORDER BY ID_BB_GLOBAL", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(result.Rows))
+	}
+	if company := result.Rows[0]["company"]; company != nil {
+		t.Errorf("lineage of a column never set for the asset = %v, want NULL", company)
+	}
+	row := result.Rows[1]
+
+	var price, company CellLineage
+	if err := json.Unmarshal([]byte(row["price"].(string)), &price); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(row["company"].(string)), &company); err != nil {
+		t.Fatal(err)
+	}
+	if price.File != newer || price.EffectiveDate != "20250102" || price.Row != 3 || price.LoadRun == "" {
+		t.Errorf("lineage of Price = %+v, want row 3 of %s", price, newer)
+	}
+	if company.File != older || company.EffectiveDate != "20250101" || company.Row != 2 {
+		t.Errorf("lineage of Company = %+v, want row 2 of %s", company, older)
+	}
+	if company.LoadRun != price.LoadRun {
+		t.Errorf("files loaded together have load runs %s and %s", price.LoadRun, company.LoadRun)
+	}
+
+	// Rows that do not track lineage, such as a group, give NULL
+	value, err := (&LineageExpr{Column: &ColumnRef{Name: "Price"}}).Eval(NewTypedRow(map[string]string{"Price": "1"}, nil))
+	if err != nil || !value.IsNull() {
+		t.Errorf("lineage of a row without lineage = %v, %v, want NULL", value, err)
+	}
+
+	if _, err := ParseSQL("SELECT _lineage(1) FROM BB_ASSETS"); err == nil {
+		t.Error("_lineage accepted an argument that is not a column")
+	}
+}
+
+func TestLineageReplacedWithValue(t *testing.T) {
+	m, _, older := loadLineageFixture(t)
+
+	// A newer file replaces the value and its lineage, an older one neither
+	newest := writeCSV(t, ".", "assets_20250103.csv", "ID_BB_GLOBAL,Price\nA1,12\n")
+	if err := m.LoadCSVFile(newest); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadCSVFile(older); err != nil {
+		t.Fatal(err)
+	}
+
+	asset, err := m.GetAsset("A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineage, err := m.GetAssetLineage("A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asset["Price"] != "12" || lineage["Price"].File != newest || lineage["Price"].EffectiveDate != "20250103" || lineage["Price"].Row != 2 {
+		t.Errorf("Price = %s with lineage %+v, want 12 from row 2 of %s", asset["Price"], lineage["Price"], newest)
+	}
+}
+
+func TestHandleGetAssetLineage(t *testing.T) {
+	m, newer, _ := loadLineageFixture(t)
+	tables := &TableSet{tables: []*storeTable{{config: TableConfig{Name: defaultTable}, manager: m}}}
+	dm := &DataMatrix{tables: tables, logger: m.logger}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/assets/{id}/lineage", dm.handleGetAssetLineage).Methods("GET")
+
+	tests := []struct {
+		name        string
+		url         string
+		wantStatus  int
+		wantColumns []string
+		wantWarning bool
+	}{
+		{name: "all columns", url: "/api/assets/A1/lineage", wantStatus: http.StatusOK, wantColumns: []string{"Company", "ID_BB_GLOBAL", "Price"}},
+		{name: "requested columns", url: "/api/assets/A1/lineage?columns=price,Industry", wantStatus: http.StatusOK, wantColumns: []string{"Price"}, wantWarning: true},
+		{name: "no known column", url: "/api/assets/A1/lineage?columns=Industry", wantStatus: http.StatusBadRequest},
+		{name: "unknown asset", url: "/api/assets/A9/lineage", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", tt.url, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response AssetLineageResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.ID != "A1" || len(response.Lineage) != len(tt.wantColumns) {
+				t.Errorf("response = %+v, want lineage of %v", response, tt.wantColumns)
+			}
+			for _, column := range tt.wantColumns {
+				if _, exists := response.Lineage[column]; !exists {
+					t.Errorf("no lineage for %s in %+v", column, response.Lineage)
+				}
+			}
+			if (len(response.Warnings) > 0) != tt.wantWarning {
+				t.Errorf("warnings = %v", response.Warnings)
+			}
+		})
+	}
+
+	// The lineage of a value loaded from the newer file names it
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/assets/A1/lineage?columns=Price", nil))
+	var response AssetLineageResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Lineage["Price"]; got.File != newer || got.Row != 3 {
+		t.Errorf("lineage of Price = %+v, want row 3 of %s", got, newer)
+	}
+}
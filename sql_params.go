@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// QueryParams holds the values bound to the placeholders of a query: either
+// positional values for ? placeholders or named values for :name placeholders
+type QueryParams struct {
+	Positional []interface{}
+	Named      map[string]interface{}
+}
+
+// NewQueryParams converts the params of a request, decoded from JSON, into query
+// parameters. An array supplies positional parameters and an object supplies
+// named parameters. Numbers should be decoded as json.Number to keep their precision.
+func NewQueryParams(raw interface{}) (*QueryParams, error) {
+	switch params := raw.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return &QueryParams{Positional: params}, nil
+	case map[string]interface{}:
+		return &QueryParams{Named: params}, nil
+	}
+	return nil, fmt.Errorf("params must be an array of positional values or an object of named values")
+}
+
+// paramValue converts a single JSON parameter value to a typed Value
+func paramValue(raw interface{}) (Value, error) {
+	switch v := raw.(type) {
+	case nil:
+		return NullValue(), nil
+	case string:
+		return StringValue(v), nil
+	case bool:
+		return BoolValue(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return IntValue(i), nil
+		}
+		if f, err := v.Float64(); err == nil {
+			return FloatValue(f), nil
+		}
+		return NullValue(), fmt.Errorf("invalid number %s", v.String())
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return IntValue(int64(v)), nil
+		}
+		return FloatValue(v), nil
+	case int:
+		return IntValue(int64(v)), nil
+	case int64:
+		return IntValue(v), nil
+	}
+	return NullValue(), fmt.Errorf("unsupported parameter value of type %T", raw)
+}
+
+// nextParam returns the raw value bound to a placeholder token, consuming the
+// next positional value for ?
+func (p *sqlParser) nextParam(tok Token) (interface{}, error) {
+	if p.params == nil {
+		return nil, p.errorf(tok, "query has a parameter placeholder but no params were given")
+	}
+
+	if tok.Text == "?" {
+		if p.params.Named != nil {
+			return nil, p.errorf(tok, "positional placeholder used with named params")
+		}
+		if p.paramsUsed >= len(p.params.Positional) {
+			return nil, p.errorf(tok, "no value for parameter %d, only %d given", p.paramsUsed+1, len(p.params.Positional))
+		}
+		raw := p.params.Positional[p.paramsUsed]
+		p.paramsUsed++
+		return raw, nil
+	}
+
+	name := tok.Text[1:]
+	if p.params.Positional != nil {
+		return nil, p.errorf(tok, "named placeholder used with positional params")
+	}
+	raw, exists := p.params.Named[name]
+	if !exists {
+		return nil, p.errorf(tok, "no value for parameter :%s", name)
+	}
+	return raw, nil
+}
+
+// parseParam binds a placeholder to a literal. Arrays are only accepted where a
+// list is expected, such as IN (?), and expand to one literal per element.
+func (p *sqlParser) parseParam(allowArray bool) ([]Expr, error) {
+	tok := p.advance()
+	raw, err := p.nextParam(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	values, isArray := raw.([]interface{})
+	if !isArray {
+		value, err := paramValue(raw)
+		if err != nil {
+			return nil, p.errorf(tok, "%v", err)
+		}
+		return []Expr{&Literal{Value: value}}, nil
+	}
+
+	if !allowArray {
+		return nil, p.errorf(tok, "array parameter can only be used as an IN list")
+	}
+	exprs := make([]Expr, len(values))
+	for i, element := range values {
+		value, err := paramValue(element)
+		if err != nil {
+			return nil, p.errorf(tok, "element %d: %v", i+1, err)
+		}
+		exprs[i] = &Literal{Value: value}
+	}
+	return exprs, nil
+}
+
+// checkParamsUsed reports positional params that no placeholder consumed
+func (p *sqlParser) checkParamsUsed() error {
+	if p.params != nil && p.params.Positional != nil && p.paramsUsed != len(p.params.Positional) {
+		return &SQLSyntaxError{
+			Pos:     p.peek().Pos,
+			Message: fmt.Sprintf("%d params given but only %d used", len(p.params.Positional), p.paramsUsed),
+		}
+	}
+	return nil
+}
+
+// paramInt binds a placeholder that must hold a non-negative integer, as in LIMIT ?
+func (p *sqlParser) paramInt(clause string) (int, error) {
+	tok := p.peek()
+	exprs, err := p.parseParam(false)
+	if err != nil {
+		return 0, err
+	}
+	value := exprs[0].(*Literal).Value
+	if value.Kind != ValueInt || value.Int < 0 || value.Int > math.MaxInt32 {
+		return 0, p.errorf(tok, "%s must be a non-negative integer, got %s", clause, strconv.Quote(value.String()))
+	}
+	return int(value.Int), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// decodeParams decodes the params of a request the way the query handler does
+func decodeParams(t *testing.T, text string) *QueryParams {
+	t.Helper()
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		t.Fatal(err)
+	}
+	params, err := NewQueryParams(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+func TestBindParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		params string
+		where  string
+		limit  int
+		offset int
+	}{
+		{
+			name:   "positional values keep their JSON types",
+			query:  "SELECT * FROM BB_ASSETS WHERE a = ? AND b = ? AND c = ? AND d = ? AND e = ?",
+			params: `["Tech", 42, 1.5, true, null]`,
+			where:  "(((((a = 'Tech') AND (b = 42)) AND (c = 1.5)) AND (d = TRUE)) AND (e = NULL))",
+			limit:  -1,
+		},
+		{
+			name:   "large integers keep their precision",
+			query:  "SELECT * FROM BB_ASSETS WHERE a = ?",
+			params: `[9007199254740993]`,
+			where:  "(a = 9007199254740993)",
+			limit:  -1,
+		},
+		{
+			name:   "named values may be used more than once",
+			query:  "SELECT * FROM BB_ASSETS WHERE a = :v OR b = :v",
+			params: `{"v": "x"}`,
+			where:  "((a = 'x') OR (b = 'x'))",
+			limit:  -1,
+		},
+		{
+			name:   "array expands an IN list",
+			query:  "SELECT * FROM BB_ASSETS WHERE a IN (?)",
+			params: `[["x", "y", 3]]`,
+			where:  "(a IN ('x', 'y', 3))",
+			limit:  -1,
+		},
+		{
+			name:   "LIMIT and OFFSET",
+			query:  "SELECT * FROM BB_ASSETS LIMIT :limit OFFSET :offset",
+			params: `{"limit": 10, "offset": 20}`,
+			limit:  10,
+			offset: 20,
+		},
+		{
+			// Values never become SQL text, so quotes in them cannot end the literal
+			name:   "injection in a string value",
+			query:  "SELECT * FROM BB_ASSETS WHERE Company = ?",
+			params: `["x' OR '1'='1"]`,
+			where:  "(Company = 'x'' OR ''1''=''1')",
+			limit:  -1,
+		},
+		{
+			name:   "injection in a named value",
+			query:  "SELECT * FROM BB_ASSETS WHERE Company = :name",
+			params: `{"name": "'; DELETE FROM BB_ASSETS; --"}`,
+			where:  "(Company = '''; DELETE FROM BB_ASSETS; --')",
+			limit:  -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ParseSQLWithParams(tt.query, decodeParams(t, tt.params))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var where string
+			if query.Where != nil {
+				where = query.Where.String()
+			}
+			if where != tt.where {
+				t.Errorf("WHERE = %s, want %s", where, tt.where)
+			}
+			if query.Limit != tt.limit || query.Offset != tt.offset {
+				t.Errorf("LIMIT %d OFFSET %d, want LIMIT %d OFFSET %d", query.Limit, query.Offset, tt.limit, tt.offset)
+			}
+		})
+	}
+}
+
+func TestBoundStringDoesNotMatchEveryRow(t *testing.T) {
+	query, err := ParseSQLWithParams("SELECT * FROM BB_ASSETS WHERE Company = ?", decodeParams(t, `["x' OR '1'='1"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched, err := EvalCondition(query.Where, StringRow{"Company": "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched {
+		t.Error("a quote in a bound value changed the condition")
+	}
+}
+
+func TestBindParamsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		params  string // JSON, empty for no params
+		wantErr string
+	}{
+		{"no params given", "SELECT * FROM BB_ASSETS WHERE a = ?", "", "query has a parameter placeholder but no params were given"},
+		{"missing positional", "SELECT * FROM BB_ASSETS WHERE a = ? AND b = ?", `[1]`, "no value for parameter 2, only 1 given"},
+		{"extra positional", "SELECT * FROM BB_ASSETS WHERE a = ?", `[1, 2]`, "2 params given but only 1 used"},
+		{"missing named", "SELECT * FROM BB_ASSETS WHERE a = :x", `{"y": 1}`, "no value for parameter :x"},
+		{"named placeholder with positional params", "SELECT * FROM BB_ASSETS WHERE a = :x", `[1]`, "named placeholder used with positional params"},
+		{"positional placeholder with named params", "SELECT * FROM BB_ASSETS WHERE a = ?", `{"x": 1}`, "positional placeholder used with named params"},
+		{"array outside IN", "SELECT * FROM BB_ASSETS WHERE a = ?", `[[1, 2]]`, "array parameter can only be used as an IN list"},
+		{"object value", "SELECT * FROM BB_ASSETS WHERE a = ?", `[{"b": 1}]`, "unsupported parameter value"},
+		{"negative LIMIT", "SELECT * FROM BB_ASSETS LIMIT ?", `[-1]`, `LIMIT must be a non-negative integer, got "-1"`},
+		{"string LIMIT", "SELECT * FROM BB_ASSETS LIMIT ?", `["10; DROP"]`, "LIMIT must be a non-negative integer"},
+		// A placeholder stands for a value, never for a column or keyword
+		{"placeholder as a column", "SELECT ? FROM BB_ASSETS WHERE ? = 1", `["Company", "Company"]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params *QueryParams
+			if tt.params != "" {
+				params = decodeParams(t, tt.params)
+			}
+			query, err := ParseSQLWithParams(tt.query, params)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, item := range query.Select {
+					if _, isLiteral := item.Expr.(*Literal); !isLiteral {
+						t.Errorf("select item %s is not a literal", item.String())
+					}
+				}
+				return
+			}
+			var syntaxErr *SQLSyntaxError
+			if !errors.As(err, &syntaxErr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want a syntax error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewQueryParams(t *testing.T) {
+	if params, err := NewQueryParams(nil); params != nil || err != nil {
+		t.Errorf("NewQueryParams(nil) = %v, %v, want no params", params, err)
+	}
+	if _, err := NewQueryParams("Tech"); err == nil {
+		t.Error("NewQueryParams accepted a string")
+	}
+}
@@ -1,153 +1,1192 @@
 package main
 
 import (
-	"errors"
 	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // SQLQuery represents a parsed SQL query
 type SQLQuery struct {
-	SelectColumns []string
-	FromTable     string
-	WhereColumn   string
-	WhereOperator string
-	WhereValue    string
-	HasWhere      bool
+	Select    []SelectItem // Select list, a single star item for SELECT *
+	Distinct  bool         // SELECT DISTINCT
+	FromTable string
+	FromAlias string       // Name given to the table with AS, empty if none
+	TableArgs []string     // Arguments of a table function such as CHANGES, nil for a plain table
+	Joins     []JoinClause // Tables joined to FromTable, in order
+	Where     Expr         // Parsed WHERE condition, nil if the query has none
+	HasWhere  bool
+	GroupBy   []Expr
+	Having    Expr // Parsed HAVING condition, nil if the query has none
+	OrderBy   []OrderItem
+	Limit     int    // Maximum number of rows to return, -1 for no limit
+	Offset    int    // Number of matching rows to skip
+	Explain   bool   // EXPLAIN: describe the plan instead of returning rows
+	Analyze   bool   // EXPLAIN ANALYZE: run the query and report what it cost
+	AsOf      string // AS OF date as YYYYMMDD: query the values as they were on that date, empty for the current values
 }
 
-// ParseSQL parses a simple SQL query and returns a SQLQuery struct
+// SelectItem is a single entry of the select list
+type SelectItem struct {
+	Expr  Expr   // Selected expression, nil for *
+	Alias string // Name given with AS, empty if none
+}
+
+// IsStar reports whether the item is the * wildcard
+func (s SelectItem) IsStar() bool {
+	return s.Expr == nil
+}
+
+// Name returns the key the item is returned under: its alias, the column name
+// for a plain column reference, or otherwise the expression text
+func (s SelectItem) Name() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	if ref, ok := s.Expr.(*ColumnRef); ok {
+		return ref.Name
+	}
+	return trimParens(s.Expr.String())
+}
+
+// String returns the item as select list SQL text
+func (s SelectItem) String() string {
+	if s.IsStar() {
+		return "*"
+	}
+	if s.Alias != "" {
+		return s.Expr.String() + " AS " + quoteIdentifier(s.Alias)
+	}
+	return s.Expr.String()
+}
+
+// SelectsAll reports whether the query is a SELECT *
+func (q *SQLQuery) SelectsAll() bool {
+	return len(q.Select) == 1 && q.Select[0].IsStar()
+}
+
+// JoinClause is a table joined to the tables before it
+type JoinClause struct {
+	Left  bool // LEFT JOIN: rows without a match are kept with NULLs for the joined table
+	Table string
+	Alias string // Name given to the table with AS, empty if none
+	On    Expr
+}
+
+// String returns the join as SQL text
+func (j JoinClause) String() string {
+	join := "JOIN "
+	if j.Left {
+		join = "LEFT JOIN "
+	}
+	return join + tableReference(j.Table, j.Alias) + " ON " + trimParens(j.On.String())
+}
+
+// tableReference returns a table of the FROM clause as SQL text
+func tableReference(table, alias string) string {
+	if alias == "" {
+		return table
+	}
+	return table + " " + quoteIdentifier(alias)
+}
+
+// OrderItem is a single ORDER BY term
+type OrderItem struct {
+	Expr       Expr
+	Desc       bool
+	NullsFirst bool // Defaults to NULLS LAST for ASC and NULLS FIRST for DESC
+}
+
+// String returns the ORDER BY term as SQL text
+func (o OrderItem) String() string {
+	direction := "ASC"
+	if o.Desc {
+		direction = "DESC"
+	}
+	nulls := "NULLS LAST"
+	if o.NullsFirst {
+		nulls = "NULLS FIRST"
+	}
+	return fmt.Sprintf("%s %s %s", o.Expr.String(), direction, nulls)
+}
+
+// sqlParser is a recursive-descent parser over the tokens of a single query
+type sqlParser struct {
+	tokens     []Token
+	pos        int
+	params     *QueryParams // Values for placeholders, nil if none were given
+	paramsUsed int          // Positional params consumed so far
+}
+
+// ParseSQL parses a SQL query and returns a SQLQuery struct
+//
+// Supported grammar:
+//
+//	query      := [EXPLAIN [ANALYZE]] SELECT [DISTINCT] columns FROM from [WHERE expr]
+//	              [GROUP BY group {',' group}] [HAVING expr]
+//	              [ORDER BY order {',' order}] [LIMIT integer] [OFFSET integer] [;]
+//	from       := table [AS OF date] [[AS] alias] {join}
+//	              | CHANGES '(' point ',' point ')' [[AS] alias]
+//	join       := [INNER | LEFT [OUTER]] JOIN table [[AS] alias] ON expr
+//	date       := string | '?' | ':' name
+//	point      := date, also accepting a load run ID
+//	columns    := '*' | item {',' item}
+//	item       := expr [[AS] identifier]
+//	group      := expr | position
+//	order      := (expr | position) [ASC | DESC] [NULLS (FIRST | LAST)]
+//	expr       := and_expr {OR and_expr}
+//	and_expr   := not_expr {AND not_expr}
+//	not_expr   := NOT not_expr | comparison
+//	comparison := sum [('=' | '!=' | '<>' | '<' | '<=' | '>' | '>=') sum
+//	              | [NOT] IN '(' expr {',' expr} ')'
+//	              | [NOT] (LIKE | ILIKE) sum
+//	              | [NOT] BETWEEN sum AND sum
+//	              | IS [NOT] NULL]
+//	sum        := product {('+' | '-' | '||') product}
+//	product    := unary {('*' | '/' | '%') unary}
+//	unary      := ('-' | '+') unary | operand
+//	operand    := '(' expr ')' | call | case | cast | column | string | number
+//	              | NULL | TRUE | FALSE | '?' | ':' name
+//	column     := [(table | alias) '.'] identifier
+//	call       := identifier '(' ('*' | [DISTINCT] expr {',' expr}) ')'
+//	case       := CASE [expr] WHEN expr THEN expr {WHEN expr THEN expr} [ELSE expr] END
+//	cast       := CAST '(' expr AS type ')'
+//
+// Identifiers may be double-quoted or back-quoted to include spaces or reserved
+// words. A doubled quote character inside a quoted identifier or string literal
+// escapes itself:
+//
+//	SELECT * FROM BB_ASSETS WHERE Company = 'O''Reilly Media'
+//
+// Values can instead be supplied as parameters, see ParseSQLWithParams.
 func ParseSQL(query string) (*SQLQuery, error) {
-	// Normalize the query
-	query = strings.TrimSpace(query)
-	query = regexp.MustCompile(`\s+`).ReplaceAllString(query, " ")
-	
-	// Basic validation
-	if !strings.HasPrefix(strings.ToUpper(query), "SELECT ") {
-		return nil, errors.New("query must start with SELECT")
-	}
-	
-	// Initialize the result
+	return ParseSQLWithParams(query, nil)
+}
+
+// ParseSQLWithParams parses a SQL query, binding ? and :name placeholders to
+// typed literals from params. Values never pass through the SQL text, so they
+// need no quoting or escaping. Positional values are consumed in the order the
+// placeholders appear in the query text.
+func ParseSQLWithParams(query string, params *QueryParams) (*SQLQuery, error) {
+	tokens, err := tokenizeSQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &sqlParser{tokens: tokens, params: params}
+	result, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkParamsUsed(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ParseSelectItem parses a single select list entry given on its own, such as
+// a column of an API request. Unlike in a query, an alias must be introduced
+// by AS, so that a column name containing a space is not read as a column
+// followed by an alias.
+func ParseSelectItem(text string) (SelectItem, error) {
+	tokens, err := tokenizeSQL(text)
+	if err != nil {
+		return SelectItem{}, err
+	}
+
+	p := &sqlParser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return SelectItem{}, err
+	}
+	item := SelectItem{Expr: expr}
+	if p.acceptKeyword("AS") {
+		if item.Alias, err = p.parseIdentifier("alias"); err != nil {
+			return SelectItem{}, err
+		}
+	}
+	if tok := p.peek(); tok.Type != TokenEOF {
+		return SelectItem{}, p.errorf(tok, "unexpected token after end of expression")
+	}
+	return item, nil
+}
+
+// peek returns the current token without consuming it
+func (p *sqlParser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+// advance consumes and returns the current token
+func (p *sqlParser) advance() Token {
+	tok := p.tokens[p.pos]
+	if tok.Type != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// isKeyword reports whether the current token is the given keyword
+func (p *sqlParser) isKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok.Type == TokenKeyword && tok.Text == keyword
+}
+
+// acceptKeyword consumes the current token if it is the given keyword
+func (p *sqlParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+// expectKeyword consumes the given keyword or returns a syntax error
+func (p *sqlParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.errorf(p.peek(), "expected %s", keyword)
+	}
+	return nil
+}
+
+// errorf builds a syntax error pointing at tok
+func (p *sqlParser) errorf(tok Token, format string, args ...interface{}) error {
+	return &SQLSyntaxError{Pos: tok.Pos, Token: tok.String(), Message: fmt.Sprintf(format, args...)}
+}
+
+// parseQuery parses a complete SELECT statement, optionally prefixed by EXPLAIN
+func (p *sqlParser) parseQuery() (*SQLQuery, error) {
 	result := &SQLQuery{
 		HasWhere: false,
+		Limit:    -1,
+	}
+
+	if p.acceptKeyword("EXPLAIN") {
+		result.Explain = true
+		result.Analyze = p.acceptKeyword("ANALYZE")
+	}
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	result.Distinct = p.acceptKeyword("DISTINCT")
+
+	items, err := p.parseSelectList()
+	if err != nil {
+		return nil, err
 	}
-	
-	// Extract the FROM part
-	fromParts := strings.Split(strings.ToUpper(query), " FROM ")
-	if len(fromParts) != 2 {
-		return nil, errors.New("query must contain FROM clause")
-	}
-	
-	// Extract the SELECT columns
-	selectPart := strings.TrimPrefix(fromParts[0], "SELECT ")
-	selectColumns := strings.Split(selectPart, ",")
-	for i, col := range selectColumns {
-		selectColumns[i] = strings.TrimSpace(col)
-	}
-	result.SelectColumns = selectColumns
-	
-	// Extract the table and WHERE clause
-	tableAndWhere := fromParts[1]
-	whereParts := strings.Split(tableAndWhere, " WHERE ")
-	
-	if len(whereParts) > 2 {
-		return nil, errors.New("query contains multiple WHERE clauses")
-	}
-	
-	result.FromTable = strings.TrimSpace(whereParts[0])
-	
-	// Process WHERE clause if it exists
-	if len(whereParts) == 2 {
+	result.Select = items
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if err := p.parseFrom(result); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		result.Where = where
 		result.HasWhere = true
-		whereClause := whereParts[1]
-		
-		// Parse the WHERE condition (only support simple equality for now)
-		// Look for =, >, <, >=, <=, != operators
-		operatorRegex := regexp.MustCompile(`\s*(=|>|<|>=|<=|!=)\s*`)
-		operatorMatches := operatorRegex.FindStringSubmatch(whereClause)
-		
-		if len(operatorMatches) < 2 {
-			return nil, errors.New("WHERE clause must contain a valid operator (=, >, <, >=, <=, !=)")
-		}
-		
-		operator := operatorMatches[1]
-		whereParts := operatorRegex.Split(whereClause, 2)
-		
-		if len(whereParts) != 2 {
-			return nil, errors.New("invalid WHERE clause format")
-		}
-		
-		result.WhereColumn = strings.TrimSpace(whereParts[0])
-		result.WhereOperator = operator
-		
-		// Handle string literals in WHERE clause
-		whereValue := strings.TrimSpace(whereParts[1])
-		if strings.HasPrefix(whereValue, "'") && strings.HasSuffix(whereValue, "'") {
-			// String literal
-			result.WhereValue = whereValue[1 : len(whereValue)-1]
-		} else {
-			// Numeric or other value
-			result.WhereValue = whereValue
+	}
+
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		groupBy, err := p.parseGroupBy(result.Select)
+		if err != nil {
+			return nil, err
+		}
+		result.GroupBy = groupBy
+	}
+
+	if p.acceptKeyword("HAVING") {
+		having, err := p.parseExpr()
+		if err != nil {
+			return nil, err
 		}
+		result.Having = having
 	}
-	
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		orderBy, err := p.parseOrderBy(result.Select)
+		if err != nil {
+			return nil, err
+		}
+		result.OrderBy = orderBy
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		limit, err := p.parseNonNegativeInt("LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		result.Limit = limit
+	}
+
+	if p.acceptKeyword("OFFSET") {
+		offset, err := p.parseNonNegativeInt("OFFSET")
+		if err != nil {
+			return nil, err
+		}
+		result.Offset = offset
+	}
+
+	// Allow a single trailing semicolon
+	if p.peek().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	if tok := p.peek(); tok.Type != TokenEOF {
+		return nil, p.errorf(tok, "unexpected token after end of query")
+	}
+
 	return result, nil
 }
 
-// ExecuteQuery executes a parsed SQL query against the data dictionary
-func ExecuteQuery(query *SQLQuery, dataDictionary map[string]map[string]string) ([]map[string]string, error) {
-	if query.FromTable != "BB_ASSETS" {
-		return nil, fmt.Errorf("unknown table: %s", query.FromTable)
+// parseFrom parses the tables of the FROM clause: a table or table function,
+// optionally followed by AS OF and an alias, and the tables joined to it
+func (p *sqlParser) parseFrom(result *SQLQuery) error {
+	table, err := p.parseIdentifier("table name")
+	if err != nil {
+		return err
 	}
-	
-	var results []map[string]string
-	
-	// Filter the data based on the WHERE clause
-	for _, record := range dataDictionary {
-		if query.HasWhere {
-			whereValue, exists := record[query.WhereColumn]
-			if !exists {
-				continue
+	// Table names are case-insensitive
+	result.FromTable = strings.ToUpper(table)
+
+	if p.peek().Type == TokenLParen {
+		tableTok := p.tokens[p.pos-1]
+		if result.FromTable != changesTable {
+			return p.errorf(tableTok, "unknown table function %s", table)
+		}
+		args, err := p.parseTableArgs(tableTok)
+		if err != nil {
+			return err
+		}
+		result.TableArgs = args
+	}
+
+	// AS OF may come before or after the alias
+	for i := 0; i < 2; i++ {
+		if p.isKeyword("AS") && p.tokens[p.pos+1].Type == TokenKeyword && p.tokens[p.pos+1].Text == "OF" {
+			if result.TableArgs != nil {
+				return p.errorf(p.peek(), "AS OF cannot be used with %s", changesTable)
 			}
-			
-			// Check the condition
-			matches := false
-			switch query.WhereOperator {
-			case "=":
-				matches = whereValue == query.WhereValue
-			case ">":
-				matches = whereValue > query.WhereValue
-			case "<":
-				matches = whereValue < query.WhereValue
-			case ">=":
-				matches = whereValue >= query.WhereValue
-			case "<=":
-				matches = whereValue <= query.WhereValue
-			case "!=":
-				matches = whereValue != query.WhereValue
+			if result.AsOf != "" {
+				return p.errorf(p.peek(), "AS OF can only be given once")
 			}
-			
-			if !matches {
-				continue
+			p.advance()
+			p.advance()
+			asOf, err := p.parseAsOfDate()
+			if err != nil {
+				return err
+			}
+			result.AsOf = asOf
+		} else if result.FromAlias == "" {
+			if result.FromAlias, err = p.parseTableAlias(); err != nil {
+				return err
 			}
 		}
-		
-		// Include the record in the results
-		if query.SelectColumns[0] == "*" {
-			// Select all columns
-			results = append(results, record)
+	}
+
+	for p.isKeyword("JOIN") || p.isKeyword("INNER") || p.isKeyword("LEFT") {
+		if result.TableArgs != nil {
+			return p.errorf(p.peek(), "%s cannot be joined", changesTable)
+		}
+		join, err := p.parseJoin()
+		if err != nil {
+			return err
+		}
+		result.Joins = append(result.Joins, join)
+	}
+	return nil
+}
+
+// parseTableAlias parses the optional alias of a table, with or without AS
+func (p *sqlParser) parseTableAlias() (string, error) {
+	if p.acceptKeyword("AS") {
+		return p.parseIdentifier("alias")
+	}
+	if p.peek().Type == TokenIdent {
+		return p.advance().Text, nil
+	}
+	return "", nil
+}
+
+// parseJoin parses [INNER | LEFT [OUTER]] JOIN table [[AS] alias] ON expr
+func (p *sqlParser) parseJoin() (JoinClause, error) {
+	var join JoinClause
+	if p.acceptKeyword("LEFT") {
+		join.Left = true
+		p.acceptKeyword("OUTER")
+	} else {
+		p.acceptKeyword("INNER")
+	}
+	if err := p.expectKeyword("JOIN"); err != nil {
+		return join, err
+	}
+
+	table, err := p.parseIdentifier("table name")
+	if err != nil {
+		return join, err
+	}
+	join.Table = strings.ToUpper(table)
+	if p.peek().Type == TokenLParen {
+		return join, p.errorf(p.peek(), "table functions cannot be joined")
+	}
+	if join.Alias, err = p.parseTableAlias(); err != nil {
+		return join, err
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return join, err
+	}
+	if join.On, err = p.parseExpr(); err != nil {
+		return join, err
+	}
+	return join, nil
+}
+
+// parseSelectList parses '*' or a comma-separated list of expressions with optional aliases
+func (p *sqlParser) parseSelectList() ([]SelectItem, error) {
+	if p.peek().Type == TokenStar {
+		p.advance()
+		return []SelectItem{{}}, nil
+	}
+
+	var items []SelectItem
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		item := SelectItem{Expr: expr}
+
+		// The AS keyword is optional before an alias
+		if p.acceptKeyword("AS") {
+			alias, err := p.parseIdentifier("alias")
+			if err != nil {
+				return nil, err
+			}
+			item.Alias = alias
+		} else if p.peek().Type == TokenIdent {
+			item.Alias = p.advance().Text
+		}
+		items = append(items, item)
+
+		if p.peek().Type != TokenComma {
+			return items, nil
+		}
+		p.advance()
+	}
+}
+
+// parseSelectPosition parses an expression, replacing an integer literal with
+// the select list item at that 1-based position
+func (p *sqlParser) parseSelectPosition(clause string, selectList []SelectItem) (Expr, error) {
+	tok := p.peek()
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	lit, ok := expr.(*Literal)
+	if !ok || lit.Value.Kind != ValueInt {
+		return expr, nil
+	}
+	if len(selectList) == 1 && selectList[0].IsStar() {
+		return nil, p.errorf(tok, "%s position requires an explicit column list", clause)
+	}
+	if lit.Value.Int < 1 || lit.Value.Int > int64(len(selectList)) {
+		return nil, p.errorf(tok, "%s position %d is not in the select list", clause, lit.Value.Int)
+	}
+	return selectList[lit.Value.Int-1].Expr, nil
+}
+
+// parseGroupBy parses the comma-separated terms of a GROUP BY clause.
+// An integer term refers to an item of the select list by its 1-based position.
+func (p *sqlParser) parseGroupBy(selectList []SelectItem) ([]Expr, error) {
+	var exprs []Expr
+	for {
+		expr, err := p.parseSelectPosition("GROUP BY", selectList)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if p.peek().Type != TokenComma {
+			return exprs, nil
+		}
+		p.advance()
+	}
+}
+
+// parseOrderBy parses the comma-separated terms of an ORDER BY clause.
+// An integer term refers to an item of the select list by its 1-based position.
+func (p *sqlParser) parseOrderBy(selectList []SelectItem) ([]OrderItem, error) {
+	var items []OrderItem
+	for {
+		expr, err := p.parseSelectPosition("ORDER BY", selectList)
+		if err != nil {
+			return nil, err
+		}
+
+		item := OrderItem{Expr: expr}
+		if p.acceptKeyword("DESC") {
+			item.Desc = true
 		} else {
-			// Select specific columns
-			selectedRecord := make(map[string]string)
-			for _, col := range query.SelectColumns {
-				if value, exists := record[col]; exists {
-					selectedRecord[col] = value
+			p.acceptKeyword("ASC")
+		}
+
+		// NULLs sort as if larger than any value unless told otherwise
+		item.NullsFirst = item.Desc
+		if p.acceptKeyword("NULLS") {
+			switch next := p.peek(); {
+			case next.Type == TokenIdent && strings.EqualFold(next.Text, "FIRST"):
+				item.NullsFirst = true
+			case next.Type == TokenIdent && strings.EqualFold(next.Text, "LAST"):
+				item.NullsFirst = false
+			default:
+				return nil, p.errorf(next, "expected FIRST or LAST")
+			}
+			p.advance()
+		}
+		items = append(items, item)
+
+		if p.peek().Type != TokenComma {
+			return items, nil
+		}
+		p.advance()
+	}
+}
+
+// parseNonNegativeInt consumes an integer literal or parameter for LIMIT or OFFSET
+func (p *sqlParser) parseNonNegativeInt(clause string) (int, error) {
+	tok := p.peek()
+	if tok.Type == TokenParam {
+		return p.paramInt(clause)
+	}
+	if tok.Type != TokenNumber {
+		return 0, p.errorf(tok, "expected a number after %s", clause)
+	}
+	n, err := strconv.Atoi(tok.Text)
+	if err != nil || n < 0 {
+		return 0, p.errorf(tok, "%s must be a non-negative integer", clause)
+	}
+	p.advance()
+	return n, nil
+}
+
+// parseAsOfDate parses the date of AS OF, written YYYYMMDD or YYYY-MM-DD, and
+// returns it as YYYYMMDD to compare with effective dates
+func (p *sqlParser) parseAsOfDate() (string, error) {
+	tok := p.peek()
+	var value Value
+	switch tok.Type {
+	case TokenParam:
+		exprs, err := p.parseParam(false)
+		if err != nil {
+			return "", err
+		}
+		value = exprs[0].(*Literal).Value
+	case TokenString:
+		p.advance()
+		value = StringValue(tok.Text)
+	default:
+		return "", p.errorf(tok, "expected a date after AS OF")
+	}
+
+	date := strings.ReplaceAll(value.String(), "-", "")
+	if _, err := time.Parse("20060102", date); err != nil {
+		return "", p.errorf(tok, "AS OF date must be YYYYMMDD or YYYY-MM-DD, got %s", strconv.Quote(value.String()))
+	}
+	return date, nil
+}
+
+// parseIdentifier consumes an identifier token and returns its name
+func (p *sqlParser) parseIdentifier(what string) (string, error) {
+	tok := p.peek()
+	if tok.Type != TokenIdent {
+		return "", p.errorf(tok, "expected %s", what)
+	}
+	p.advance()
+	return tok.Text, nil
+}
+
+// parseColumnRef parses a column name whose first identifier has been
+// consumed, qualified with a table or alias if a dot follows it
+func (p *sqlParser) parseColumnRef(first Token) (*ColumnRef, error) {
+	if p.peek().Type != TokenDot {
+		return &ColumnRef{Name: first.Text}, nil
+	}
+	p.advance()
+	name, err := p.parseIdentifier("column name after " + first.Text + ".")
+	if err != nil {
+		return nil, err
+	}
+	return &ColumnRef{Table: first.Text, Name: name}, nil
+}
+
+// parseExpr parses an OR expression, the lowest-precedence level
+func (p *sqlParser) parseExpr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses an AND expression
+func (p *sqlParser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses a possibly negated comparison
+func (p *sqlParser) parseNot() (Expr, error) {
+	if p.acceptKeyword("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "NOT", Operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses an operand optionally followed by a comparison operator
+// and operand, or by an IN, LIKE, ILIKE, BETWEEN or IS NULL predicate
+func (p *sqlParser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	if tok.Type == TokenKeyword {
+		return p.parsePredicate(left)
+	}
+	if tok.Type != TokenOperator {
+		return left, nil
+	}
+
+	switch tok.Text {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		p.advance()
+	default:
+		return nil, p.errorf(tok, "unsupported operator")
+	}
+
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryExpr{Op: tok.Text, Left: left, Right: right}, nil
+}
+
+// parsePredicate parses the keyword predicates that may follow an operand
+func (p *sqlParser) parsePredicate(left Expr) (Expr, error) {
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &IsNullExpr{Operand: left, Not: not}, nil
+	}
+
+	// NOT here negates the predicate that follows, as in x NOT IN (...)
+	not := false
+	if p.isKeyword("NOT") {
+		next := p.tokens[p.pos+1]
+		if next.Type != TokenKeyword || !(next.Text == "IN" || next.Text == "LIKE" || next.Text == "ILIKE" || next.Text == "BETWEEN") {
+			return nil, p.errorf(p.peek(), "expected IN, LIKE, ILIKE or BETWEEN after NOT")
+		}
+		p.advance()
+		not = true
+	}
+
+	tok := p.peek()
+	switch {
+	case p.acceptKeyword("IN"):
+		list, err := p.parseInList()
+		if err != nil {
+			return nil, err
+		}
+		return newInExpr(left, list, not), nil
+
+	case p.acceptKeyword("LIKE"), p.acceptKeyword("ILIKE"):
+		pattern, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &LikeExpr{Operand: left, Pattern: pattern, CaseInsensitive: tok.Text == "ILIKE", Not: not}, nil
+
+	case p.acceptKeyword("BETWEEN"):
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{Operand: left, Low: low, High: high, Not: not}, nil
+	}
+	return left, nil
+}
+
+// parseInList parses the parenthesised, comma-separated values of an IN predicate
+func (p *sqlParser) parseInList() ([]Expr, error) {
+	open := p.peek()
+	if open.Type != TokenLParen {
+		return nil, p.errorf(open, "expected ( after IN")
+	}
+	p.advance()
+
+	var list []Expr
+	for {
+		// An array parameter on its own expands to the whole list
+		if p.peek().Type == TokenParam {
+			if next := p.tokens[p.pos+1]; next.Type == TokenComma || next.Type == TokenRParen {
+				items, err := p.parseParam(true)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, items...)
+				if p.peek().Type != TokenComma {
+					break
 				}
+				p.advance()
+				continue
 			}
-			results = append(results, selectedRecord)
 		}
+
+		item, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+
+		if p.peek().Type != TokenComma {
+			break
+		}
+		p.advance()
+	}
+
+	if closing := p.peek(); closing.Type != TokenRParen {
+		return nil, p.errorf(closing, "expected ) to close ( at position %d", open.Pos)
+	}
+	p.advance()
+	return list, nil
+}
+
+// parseAdditive parses addition, subtraction and string concatenation
+func (p *sqlParser) parseAdditive() (Expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.Type != TokenOperator || (tok.Text != "+" && tok.Text != "-" && tok.Text != "||") {
+			return left, nil
+		}
+		p.advance()
+
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: tok.Text, Left: left, Right: right}
+	}
+}
+
+// parseMultiplicative parses multiplication, division and modulo
+func (p *sqlParser) parseMultiplicative() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.Type != TokenStar && !(tok.Type == TokenOperator && (tok.Text == "/" || tok.Text == "%")) {
+			return left, nil
+		}
+		p.advance()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: tok.Text, Left: left, Right: right}
+	}
+}
+
+// parseUnary parses a sign applied to an operand. A sign directly before a
+// number is folded into the literal.
+func (p *sqlParser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	if tok.Type != TokenOperator || (tok.Text != "-" && tok.Text != "+") {
+		return p.parseOperand()
+	}
+	p.advance()
+
+	if next := p.peek(); next.Type == TokenNumber {
+		p.advance()
+		text := next.Text
+		if tok.Text == "-" {
+			text = "-" + text
+		}
+		return p.numericLiteral(tok, text)
+	}
+
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if tok.Text == "+" {
+		return operand, nil
+	}
+	return &UnaryExpr{Op: "-", Operand: operand}, nil
+}
+
+// parseOperand parses a parenthesised expression, column reference or literal
+func (p *sqlParser) parseOperand() (Expr, error) {
+	tok := p.peek()
+
+	switch tok.Type {
+	case TokenLParen:
+		p.advance()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing.Type != TokenRParen {
+			return nil, p.errorf(closing, "expected ) to close ( at position %d", tok.Pos)
+		}
+		p.advance()
+		return expr, nil
+
+	case TokenIdent:
+		p.advance()
+		if p.peek().Type == TokenLParen {
+			return p.parseCall(tok)
+		}
+		return p.parseColumnRef(tok)
+
+	case TokenString:
+		p.advance()
+		return &Literal{Value: StringValue(tok.Text)}, nil
+
+	case TokenNumber:
+		p.advance()
+		return p.numericLiteral(tok, tok.Text)
+
+	case TokenParam:
+		exprs, err := p.parseParam(false)
+		if err != nil {
+			return nil, err
+		}
+		return exprs[0], nil
+
+	case TokenKeyword:
+		switch tok.Text {
+		case "CASE":
+			return p.parseCase()
+		case "CAST":
+			return p.parseCast()
+		case "NULL":
+			p.advance()
+			return &Literal{Value: NullValue()}, nil
+		case "TRUE":
+			p.advance()
+			return &Literal{Value: BoolValue(true)}, nil
+		case "FALSE":
+			p.advance()
+			return &Literal{Value: BoolValue(false)}, nil
+		}
+	}
+
+	return nil, p.errorf(tok, "expected expression")
+}
+
+// parseCase parses CASE [operand] WHEN expr THEN expr {WHEN expr THEN expr} [ELSE expr] END
+func (p *sqlParser) parseCase() (Expr, error) {
+	start := p.advance()
+	caseExpr := &CaseExpr{}
+
+	// A simple CASE compares an operand with each WHEN value
+	if !p.isKeyword("WHEN") {
+		operand, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		caseExpr.Operand = operand
+	}
+
+	for p.acceptKeyword("WHEN") {
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		result, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		caseExpr.Whens = append(caseExpr.Whens, CaseWhen{Cond: cond, Result: result})
+	}
+	if len(caseExpr.Whens) == 0 {
+		return nil, p.errorf(p.peek(), "expected WHEN")
+	}
+
+	if p.acceptKeyword("ELSE") {
+		elseExpr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		caseExpr.Else = elseExpr
+	}
+
+	if !p.acceptKeyword("END") {
+		return nil, p.errorf(p.peek(), "expected END to close CASE at position %d", start.Pos)
+	}
+	return caseExpr, nil
+}
+
+// parseCast parses CAST(expr AS type)
+func (p *sqlParser) parseCast() (Expr, error) {
+	p.advance()
+	open := p.peek()
+	if open.Type != TokenLParen {
+		return nil, p.errorf(open, "expected ( after CAST")
+	}
+	p.advance()
+
+	operand, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+
+	typeTok := p.peek()
+	if typeTok.Type != TokenIdent {
+		return nil, p.errorf(typeTok, "expected a type name")
+	}
+	target, ok := castTypes[strings.ToUpper(typeTok.Text)]
+	if !ok {
+		return nil, p.errorf(typeTok, "unsupported type %s", typeTok.Text)
+	}
+	p.advance()
+
+	if closing := p.peek(); closing.Type != TokenRParen {
+		return nil, p.errorf(closing, "expected ) to close ( at position %d", open.Pos)
+	}
+	p.advance()
+	return &CastExpr{Operand: operand, Type: target}, nil
+}
+
+// parseCall parses the argument list of a function call whose name has been consumed
+func (p *sqlParser) parseCall(name Token) (Expr, error) {
+	if strings.ToUpper(name.Text) == lineageFunction {
+		return p.parseLineage(name)
+	}
+
+	open := p.advance()
+	call := &FuncCall{Name: strings.ToUpper(name.Text)}
+	fn, isScalar := scalarFunctions[call.Name]
+	if !isScalar && !call.IsAggregate() {
+		return nil, p.errorf(name, "unknown function %s", name.Text)
+	}
+
+	switch {
+	case p.peek().Type == TokenStar:
+		if call.Name != "COUNT" {
+			return nil, p.errorf(p.peek(), "%s(*) is not supported, only COUNT(*)", call.Name)
+		}
+		p.advance()
+		call.Star = true
+	default:
+		if p.isKeyword("DISTINCT") && !call.IsAggregate() {
+			return nil, p.errorf(p.peek(), "DISTINCT is only allowed in aggregate functions")
+		}
+		call.Distinct = p.acceptKeyword("DISTINCT")
+		for p.peek().Type != TokenRParen {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			if p.peek().Type != TokenComma {
+				break
+			}
+			p.advance()
+		}
+
+		if isScalar {
+			if err := fn.checkArity(call.Name, len(call.Args)); err != nil {
+				return nil, p.errorf(name, "%v", err)
+			}
+		} else if len(call.Args) != 1 {
+			return nil, p.errorf(name, "%s expects exactly one argument", call.Name)
+		}
+	}
+
+	if closing := p.peek(); closing.Type != TokenRParen {
+		return nil, p.errorf(closing, "expected ) to close ( at position %d", open.Pos)
+	}
+	p.advance()
+
+	// Catch invalid regular expressions before the scan starts
+	if call.Name == "REGEXP_MATCHES" {
+		if lit, ok := call.Args[1].(*Literal); ok && lit.Value.Kind == ValueString {
+			if _, err := compileRegexp(lit.Value.Str); err != nil {
+				return nil, p.errorf(name, "%v", err)
+			}
+		}
+	}
+	return call, nil
+}
+
+// numericLiteral converts the text of a number token to an integer or decimal literal
+func (p *sqlParser) numericLiteral(tok Token, text string) (Expr, error) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return &Literal{Value: IntValue(i)}, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorf(tok, "invalid number %s", text)
+	}
+	return &Literal{Value: FloatValue(f)}, nil
+}
+
+// ExecuteQuery executes a parsed SQL query against the data dictionary,
+// recording the rows examined and matched and the time spent in stats
+func ExecuteQuery(query *SQLQuery, dataDictionary map[string]map[string]string, stats *QueryStats) (*QueryResult, error) {
+	if query.FromTable != "BB_ASSETS" {
+		return nil, fmt.Errorf("unknown table: %s", query.FromTable)
+	}
+
+	sink, err := newRowSink(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter the data based on the WHERE clause
+	mark := time.Now()
+	for _, record := range dataDictionary {
+		row := StringRow(record)
+		stats.RowsExamined++
+		matches, err := EvalCondition(query.Where, row)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating WHERE clause: %v", err)
+		}
+		if !matches {
+			continue
+		}
+		stats.RowsMatched++
+
+		// Include the record in the results
+		if err := sink.Add(row); err != nil {
+			return nil, err
+		}
+	}
+	mark = stats.timeStage("scan", mark)
+
+	result, err := sink.Result()
+	if err != nil {
+		return nil, err
+	}
+	stats.timeStage("finalize", mark)
+	return result, nil
+}
+
+// Project evaluates the select list against a row, converting values to their JSON representation
+func (q *SQLQuery) Project(row Row) (map[string]interface{}, error) {
+	if q.SelectsAll() {
+		// Select all columns
+		columns := row.Columns()
+		selected := make(map[string]interface{}, len(columns))
+		for _, col := range columns {
+			value, _ := row.Get(col)
+			selected[col] = value.JSON()
+		}
+		return selected, nil
+	}
+
+	selected := make(map[string]interface{}, len(q.Select))
+	for _, item := range q.Select {
+		// Columns missing from the row are left out rather than returned as null
+		if ref, ok := item.Expr.(*ColumnRef); ok {
+			if _, exists := row.Get(ref.Key()); !exists {
+				continue
+			}
+		}
+
+		value, err := item.Expr.Eval(row)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating %s: %v", item.Name(), err)
+		}
+		selected[item.Name()] = value.JSON()
+	}
+	return selected, nil
+}
+
+// distinctKey identifies the projected form of a row for SELECT DISTINCT
+func (q *SQLQuery) distinctKey(row Row) (string, error) {
+	var key strings.Builder
+	if q.SelectsAll() {
+		for _, col := range row.Columns() {
+			value, _ := row.Get(col)
+			writeKeyPart(&key, StringValue(col))
+			writeKeyPart(&key, value)
+		}
+		return key.String(), nil
+	}
+
+	for _, item := range q.Select {
+		value, err := item.Expr.Eval(row)
+		if err != nil {
+			return "", fmt.Errorf("error evaluating %s: %v", item.Name(), err)
+		}
+		writeKeyPart(&key, value)
 	}
-	
-	return results, nil
+	return key.String(), nil
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeSQL(t *testing.T) {
+	tokens, err := tokenizeSQL(`SELECT "Market Cap", 'it''s', 1.5, x>=2;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{
+		{Type: TokenKeyword, Text: "SELECT", Pos: 1},
+		{Type: TokenIdent, Text: "Market Cap", Pos: 8},
+		{Type: TokenComma, Text: ",", Pos: 20},
+		{Type: TokenString, Text: "it's", Pos: 22},
+		{Type: TokenComma, Text: ",", Pos: 29},
+		{Type: TokenNumber, Text: "1.5", Pos: 31},
+		{Type: TokenComma, Text: ",", Pos: 34},
+		{Type: TokenIdent, Text: "x", Pos: 36},
+		{Type: TokenOperator, Text: ">=", Pos: 37},
+		{Type: TokenNumber, Text: "2", Pos: 39},
+		{Type: TokenSemicolon, Text: ";", Pos: 40},
+		{Type: TokenEOF, Pos: 41},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("tokenizeSQL returned %d tokens, want %d: %v", len(tokens), len(want), tokens)
+	}
+	for i, tok := range tokens {
+		if tok.Type != want[i].Type || tok.Text != want[i].Text || tok.Pos != want[i].Pos {
+			t.Errorf("token %d = {%d %q %d}, want {%d %q %d}", i, tok.Type, tok.Text, tok.Pos, want[i].Type, want[i].Text, want[i].Pos)
+		}
+	}
+}
+
+func TestParseSQL(t *testing.T) {
+	tests := []struct {
+		query    string
+		selects  []string
+		where    string
+		groupBy  int
+		orderBy  []string
+		limit    int
+		offset   int
+		distinct bool
+	}{
+		{
+			query:   `SELECT Company, "Market Cap" AS cap FROM bb_assets WHERE Sector = 'Tech' AND Revenue > 1000 ORDER BY cap DESC, Company LIMIT 10 OFFSET 5`,
+			selects: []string{"Company", "cap"},
+			where:   "((Sector = 'Tech') AND (Revenue > 1000))",
+			orderBy: []string{"cap DESC NULLS FIRST", "Company ASC NULLS LAST"},
+			limit:   10,
+			offset:  5,
+		},
+		{
+			query:    `SELECT DISTINCT Sector FROM BB_ASSETS WHERE NOT (a = 'it''s' OR b <> 2);`,
+			selects:  []string{"Sector"},
+			where:    "(NOT ((a = 'it''s') OR (b <> 2)))",
+			limit:    -1,
+			distinct: true,
+		},
+		{
+			query:   `select count(*), avg(x) from BB_ASSETS group by Sector having count(*) > 2`,
+			selects: []string{"COUNT(*)", "AVG(x)"},
+			groupBy: 1,
+			limit:   -1,
+		},
+		{
+			query:   `SELECT -a + 2 * 3 FROM BB_ASSETS`,
+			selects: []string{"(-a) + (2 * 3)"},
+			limit:   -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := ParseSQL(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var selects, orderBy []string
+			for _, item := range query.Select {
+				selects = append(selects, item.Name())
+			}
+			for _, item := range query.OrderBy {
+				orderBy = append(orderBy, item.String())
+			}
+			var where string
+			if query.Where != nil {
+				where = query.Where.String()
+			}
+
+			if query.FromTable != "BB_ASSETS" {
+				t.Errorf("FromTable = %s, want BB_ASSETS", query.FromTable)
+			}
+			if !reflect.DeepEqual(selects, tt.selects) {
+				t.Errorf("select list = %q, want %q", selects, tt.selects)
+			}
+			if where != tt.where || query.HasWhere != (tt.where != "") {
+				t.Errorf("WHERE = %s (HasWhere %v), want %s", where, query.HasWhere, tt.where)
+			}
+			if len(query.GroupBy) != tt.groupBy {
+				t.Errorf("GROUP BY has %d expressions, want %d", len(query.GroupBy), tt.groupBy)
+			}
+			if !reflect.DeepEqual(orderBy, tt.orderBy) {
+				t.Errorf("ORDER BY = %q, want %q", orderBy, tt.orderBy)
+			}
+			if query.Limit != tt.limit || query.Offset != tt.offset || query.Distinct != tt.distinct {
+				t.Errorf("LIMIT %d OFFSET %d DISTINCT %v, want LIMIT %d OFFSET %d DISTINCT %v",
+					query.Limit, query.Offset, query.Distinct, tt.limit, tt.offset, tt.distinct)
+			}
+		})
+	}
+}
+
+func TestParseSQLErrorPosition(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantPos int
+	}{
+		{query: "SELECT FROM BB_ASSETS", wantPos: 8},
+		{query: "SELECT a, FROM BB_ASSETS", wantPos: 11},
+		{query: "SELECT a FROM BB_ASSETS WHERE", wantPos: 30},
+		{query: "SELECT a FROM BB_ASSETS WHERE a = 'open", wantPos: 35},
+		{query: "SELECT a FROM BB_ASSETS WHERE a # 1", wantPos: 33},
+		{query: "SELECT a FROM BB_ASSETS WHERE a = 1 1", wantPos: 37},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseSQL(tt.query)
+			var syntaxErr *SQLSyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("ParseSQL error = %v, want a syntax error", err)
+			}
+			if syntaxErr.Pos != tt.wantPos {
+				t.Errorf("error %q is at position %d, want %d", err, syntaxErr.Pos, tt.wantPos)
+			}
+		})
+	}
+}
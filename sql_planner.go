@@ -0,0 +1,163 @@
+package main
+
+// defaultKeyColumn is the column that identifies an asset of BB_ASSETS and names its JSON file
+const defaultKeyColumn = "ID_BB_GLOBAL"
+
+// maxKeyLookups is the largest number of IDs a composite key lookup may read.
+// A WHERE clause pinning the key columns to more combinations is a full scan.
+const maxKeyLookups = 10000
+
+// keyLookupIDs reports whether a WHERE clause can only match assets whose key
+// is one of a fixed set of values, and returns the IDs of those assets.
+// This holds for key = 'x' and key IN ('x', 'y') predicates, for an AND with at
+// least one such side and for an OR with only such sides. With a composite key,
+// an AND must pin every key column, and the IDs are every combination of their
+// values. The clause must still be evaluated on the assets that are read.
+func keyLookupIDs(where Expr, keyColumns []string) ([]string, bool) {
+	if e, ok := where.(*BinaryExpr); ok && e.Op == "OR" {
+		left, leftOK := keyLookupIDs(e.Left, keyColumns)
+		right, rightOK := keyLookupIDs(e.Right, keyColumns)
+		if leftOK && rightOK {
+			return uniqueStrings(append(left, right...)), true
+		}
+		return nil, false
+	}
+
+	// Collect the values each key column is pinned to by the terms of an AND,
+	// keeping the shortest list when a column is pinned more than once
+	pinned := make(map[string][]string)
+	var best []string
+	found := false
+	for _, term := range andTerms(where) {
+		if column, values, ok := keyPin(term, keyColumns); ok {
+			if current, exists := pinned[column]; !exists || len(values) < len(current) {
+				pinned[column] = values
+			}
+			continue
+		}
+		// An OR of lookups limits the assets on its own
+		if e, ok := term.(*BinaryExpr); ok && e.Op == "OR" {
+			if ids, ok := keyLookupIDs(e, keyColumns); ok && (!found || len(ids) < len(best)) {
+				best, found = ids, true
+			}
+		}
+	}
+
+	if len(pinned) == len(keyColumns) {
+		ids, ok := keyCombinations(keyColumns, pinned)
+		// Read the fewest files
+		if ok && (!found || len(ids) < len(best)) {
+			best, found = ids, true
+		}
+	}
+	return best, found
+}
+
+// andTerms splits an expression into the terms of its top-level ANDs
+func andTerms(expr Expr) []Expr {
+	if e, ok := expr.(*BinaryExpr); ok && e.Op == "AND" {
+		return append(andTerms(e.Left), andTerms(e.Right)...)
+	}
+	if expr == nil {
+		return nil
+	}
+	return []Expr{expr}
+}
+
+// keyPin reports whether a term pins a key column to a fixed set of string
+// values with = or IN, and returns the column and the values
+func keyPin(term Expr, keyColumns []string) (string, []string, bool) {
+	switch e := term.(type) {
+	case *BinaryExpr:
+		if e.Op != "=" {
+			return "", nil, false
+		}
+		if column, value, ok := keyEquality(e.Left, e.Right, keyColumns); ok {
+			return column, []string{value}, true
+		}
+		if column, value, ok := keyEquality(e.Right, e.Left, keyColumns); ok {
+			return column, []string{value}, true
+		}
+	case *InExpr:
+		column, ok := keyColumnRef(e.Operand, keyColumns)
+		if e.Not || !ok {
+			return "", nil, false
+		}
+		var values []string
+		for _, item := range e.List {
+			lit, ok := item.(*Literal)
+			if !ok {
+				return "", nil, false
+			}
+			switch lit.Value.Kind {
+			case ValueNull:
+				// NULL never equals anything, so it adds no assets
+			case ValueString:
+				values = append(values, lit.Value.Str)
+			default:
+				// Other literals compare after conversion, e.g. '0123' = 123
+				return "", nil, false
+			}
+		}
+		return column, uniqueStrings(values), true
+	}
+	return "", nil, false
+}
+
+// keyCombinations joins every combination of the values of the key columns into
+// an ID, failing if there are more than maxKeyLookups
+func keyCombinations(keyColumns []string, pinned map[string][]string) ([]string, bool) {
+	ids := []string{""}
+	for k, column := range keyColumns {
+		if len(ids)*len(pinned[column]) > maxKeyLookups {
+			return nil, false
+		}
+		next := make([]string, 0, len(ids)*len(pinned[column]))
+		for _, prefix := range ids {
+			for _, value := range pinned[column] {
+				if k > 0 {
+					value = prefix + keySeparator + value
+				}
+				next = append(next, value)
+			}
+		}
+		ids = next
+	}
+	return ids, true
+}
+
+// keyEquality reports whether column = value compares a key column with a
+// string literal, and returns the column and the value
+func keyEquality(column, value Expr, keyColumns []string) (string, string, bool) {
+	lit, ok := value.(*Literal)
+	if !ok || lit.Value.Kind != ValueString {
+		return "", "", false
+	}
+	name, ok := keyColumnRef(column, keyColumns)
+	if !ok {
+		return "", "", false
+	}
+	return name, lit.Value.Str, true
+}
+
+// keyColumnRef reports whether an expression is a reference to a key column and returns its name
+func keyColumnRef(expr Expr, keyColumns []string) (string, bool) {
+	ref, ok := expr.(*ColumnRef)
+	if !ok || !containsString(keyColumns, ref.Name) {
+		return "", false
+	}
+	return ref.Name, true
+}
+
+// uniqueStrings removes duplicates from a list, keeping the first occurrence of each value
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
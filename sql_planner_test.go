@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestKeyLookupIDs(t *testing.T) {
+	tests := []struct {
+		where string
+		keys  []string // Key columns, ID_BB_GLOBAL if none
+		ids   []string // nil when the clause needs a full scan
+	}{
+		{"ID_BB_GLOBAL = 'A'", nil, []string{"A"}},
+		{"'A' = ID_BB_GLOBAL", nil, []string{"A"}},
+		{"ID_BB_GLOBAL IN ('A', 'B', NULL)", nil, []string{"A", "B"}},
+		{"ID_BB_GLOBAL IN ('A', 'B') AND Revenue > 1", nil, []string{"A", "B"}},
+		{"ID_BB_GLOBAL IN ('A', 'B') AND ID_BB_GLOBAL = 'B'", nil, []string{"B"}},
+		{"ID_BB_GLOBAL = 'A' OR ID_BB_GLOBAL IN ('A', 'C')", nil, []string{"A", "C"}},
+		{"ID_BB_GLOBAL = 'A' OR Revenue > 1", nil, nil},
+		{"ID_BB_GLOBAL NOT IN ('A')", nil, nil},
+		{"ID_BB_GLOBAL = 123", nil, nil},
+		{"Revenue > 1", nil, nil},
+		{"Ticker = 'IBM' AND Exchange IN ('US', 'LN')", []string{"Ticker", "Exchange"}, []string{"IBM|LN", "IBM|US"}},
+		{"Ticker = 'IBM'", []string{"Ticker", "Exchange"}, nil},
+		{"(Ticker = 'IBM' AND Exchange = 'US') OR (Ticker = 'MSFT' AND Exchange = 'US')", []string{"Ticker", "Exchange"}, []string{"IBM|US", "MSFT|US"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			query, err := ParseSQL("SELECT * FROM BB_ASSETS WHERE " + tt.where)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := tt.keys
+			if keys == nil {
+				keys = []string{defaultKeyColumn}
+			}
+			ids, ok := keyLookupIDs(query.Where, keys)
+			sort.Strings(ids)
+			if ok != (tt.ids != nil) || (ok && !reflect.DeepEqual(ids, tt.ids)) {
+				t.Errorf("keyLookupIDs = %q, %v, want %q", ids, ok, tt.ids)
+			}
+		})
+	}
+}
+
+func TestIDLookupReadsOnlyNamedAssets(t *testing.T) {
+	m := newTestManager(t, t.TempDir())
+	filePath := writeCSV(t, t.TempDir(), "assets_20250101.csv",
+		"ID_BB_GLOBAL,Revenue\nA1,10\nA2,30\nA3,5\n")
+	if err := m.LoadCSVFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.ExecuteSQLQuery("EXPLAIN ANALYZE SELECT ID_BB_GLOBAL FROM BB_ASSETS WHERE ID_BB_GLOBAL IN ('A1', 'A3', 'MISSING') AND Revenue > 6", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0]["ID_BB_GLOBAL"] != "A1" {
+		t.Errorf("rows = %v, want only A1", result.Rows)
+	}
+	if result.Plan.AccessPath.Type != AccessIDLookup || result.Plan.Stats.FilesRead != 2 {
+		t.Errorf("access path %s read %d files, want %s reading 2", result.Plan.AccessPath.Type, result.Plan.Stats.FilesRead, AccessIDLookup)
+	}
+}
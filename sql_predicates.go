@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// InExpr tests whether an operand equals any value in a list
+type InExpr struct {
+	Operand Expr
+	List    []Expr
+	Not     bool
+
+	stringSet map[string]bool // The list as a set when it holds only string literals
+}
+
+// newInExpr builds an IN test, precomputing a lookup set for lists of string literals
+// such as long lists of ID_BB_GLOBAL values
+func newInExpr(operand Expr, list []Expr, not bool) *InExpr {
+	in := &InExpr{Operand: operand, List: list, Not: not}
+
+	set := make(map[string]bool, len(list))
+	for _, item := range list {
+		lit, ok := item.(*Literal)
+		if !ok || lit.Value.Kind != ValueString {
+			return in
+		}
+		set[lit.Value.Str] = true
+	}
+	in.stringSet = set
+	return in
+}
+
+// Eval implements Expr using SQL semantics: the result is NULL when the operand
+// is NULL, or when nothing matched and the list contains a NULL
+func (in *InExpr) Eval(row Row) (Value, error) {
+	operand, err := in.Operand.Eval(row)
+	if err != nil || operand.IsNull() {
+		return NullValue(), err
+	}
+
+	// Untyped strings can be looked up directly
+	if in.stringSet != nil && operand.Kind == ValueString {
+		return BoolValue(in.stringSet[operand.Str] != in.Not), nil
+	}
+
+	sawNull := false
+	for _, item := range in.List {
+		value, err := item.Eval(row)
+		if err != nil {
+			return NullValue(), err
+		}
+		if value.IsNull() {
+			sawNull = true
+			continue
+		}
+		if compareValues(operand, value) == 0 {
+			return BoolValue(!in.Not), nil
+		}
+	}
+
+	if sawNull {
+		return NullValue(), nil
+	}
+	return BoolValue(in.Not), nil
+}
+
+// String implements Expr
+func (in *InExpr) String() string {
+	items := make([]string, len(in.List))
+	for i, item := range in.List {
+		items[i] = trimParens(item.String())
+	}
+	op := "IN"
+	if in.Not {
+		op = "NOT IN"
+	}
+	return fmt.Sprintf("(%s %s (%s))", in.Operand.String(), op, strings.Join(items, ", "))
+}
+
+// LikeExpr matches an operand against a LIKE pattern, where % matches any run of
+// characters and _ matches a single character
+type LikeExpr struct {
+	Operand         Expr
+	Pattern         Expr
+	CaseInsensitive bool // ILIKE
+	Not             bool
+}
+
+// Eval implements Expr. Non-string operands are matched using their textual form.
+func (l *LikeExpr) Eval(row Row) (Value, error) {
+	operand, err := l.Operand.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	pattern, err := l.Pattern.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	if operand.IsNull() || pattern.IsNull() {
+		return NullValue(), nil
+	}
+
+	text, patternText := operand.String(), pattern.String()
+	if l.CaseInsensitive {
+		text, patternText = strings.ToLower(text), strings.ToLower(patternText)
+	}
+	return BoolValue(likeMatch(text, patternText) != l.Not), nil
+}
+
+// String implements Expr
+func (l *LikeExpr) String() string {
+	op := "LIKE"
+	if l.CaseInsensitive {
+		op = "ILIKE"
+	}
+	if l.Not {
+		op = "NOT " + op
+	}
+	return fmt.Sprintf("(%s %s %s)", l.Operand.String(), op, l.Pattern.String())
+}
+
+// likeMatch reports whether text matches a LIKE pattern in its entirety.
+// It backtracks only to the most recent %, so it runs in linear time for
+// typical patterns.
+func likeMatch(text, pattern string) bool {
+	t, p := 0, 0
+	starP, starT := -1, 0
+
+	for t < len(text) {
+		if p < len(pattern) {
+			pr, pSize := utf8.DecodeRuneInString(pattern[p:])
+			switch pr {
+			case '%':
+				// Remember the wildcard and first try matching it against nothing
+				starP, starT = p, t
+				p += pSize
+				continue
+			case '_':
+				_, tSize := utf8.DecodeRuneInString(text[t:])
+				t += tSize
+				p += pSize
+				continue
+			default:
+				tr, tSize := utf8.DecodeRuneInString(text[t:])
+				if tr == pr {
+					t += tSize
+					p += pSize
+					continue
+				}
+			}
+		}
+
+		// Mismatch: let the last % swallow one more character, or fail
+		if starP < 0 {
+			return false
+		}
+		_, tSize := utf8.DecodeRuneInString(text[starT:])
+		starT += tSize
+		t = starT
+		p = starP + 1
+	}
+
+	// Trailing %s match the empty remainder
+	for p < len(pattern) && pattern[p] == '%' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// BetweenExpr tests whether an operand lies within an inclusive range
+type BetweenExpr struct {
+	Operand Expr
+	Low     Expr
+	High    Expr
+	Not     bool
+}
+
+// Eval implements Expr as Low <= Operand AND Operand <= High, with the same
+// three-valued logic as the equivalent AND
+func (b *BetweenExpr) Eval(row Row) (Value, error) {
+	operand, err := b.Operand.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	low, err := b.Low.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	high, err := b.High.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	if operand.IsNull() {
+		return NullValue(), nil
+	}
+
+	// A known false bound decides the result even if the other bound is NULL
+	aboveLow, belowHigh := NullValue(), NullValue()
+	if !low.IsNull() {
+		aboveLow = BoolValue(compareValues(operand, low) >= 0)
+	}
+	if !high.IsNull() {
+		belowHigh = BoolValue(compareValues(operand, high) <= 0)
+	}
+
+	switch {
+	case (!aboveLow.IsNull() && !aboveLow.Bool) || (!belowHigh.IsNull() && !belowHigh.Bool):
+		return BoolValue(b.Not), nil
+	case aboveLow.IsNull() || belowHigh.IsNull():
+		return NullValue(), nil
+	}
+	return BoolValue(!b.Not), nil
+}
+
+// String implements Expr
+func (b *BetweenExpr) String() string {
+	op := "BETWEEN"
+	if b.Not {
+		op = "NOT BETWEEN"
+	}
+	return fmt.Sprintf("(%s %s %s AND %s)", b.Operand.String(), op, b.Low.String(), b.High.String())
+}
+
+// IsNullExpr tests whether an operand is NULL. A column the asset does not have
+// is NULL; a column present with an empty value is an empty string and is not.
+type IsNullExpr struct {
+	Operand Expr
+	Not     bool
+}
+
+// Eval implements Expr. The result is never NULL.
+func (i *IsNullExpr) Eval(row Row) (Value, error) {
+	operand, err := i.Operand.Eval(row)
+	if err != nil {
+		return NullValue(), err
+	}
+	return BoolValue(operand.IsNull() != i.Not), nil
+}
+
+// String implements Expr
+func (i *IsNullExpr) String() string {
+	if i.Not {
+		return fmt.Sprintf("(%s IS NOT NULL)", i.Operand.String())
+	}
+	return fmt.Sprintf("(%s IS NULL)", i.Operand.String())
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestNullLogic(t *testing.T) {
+	// a is missing from the row and so NULL; x is 5
+	row := StringRow{"x": "5", "name": "Example Corp"}
+	tests := []struct {
+		condition string
+		want      string // TRUE, FALSE or NULL
+	}{
+		{condition: "NULL AND FALSE", want: "FALSE"},
+		{condition: "NULL AND TRUE", want: "NULL"},
+		{condition: "NULL OR TRUE", want: "TRUE"},
+		{condition: "NULL OR FALSE", want: "NULL"},
+		{condition: "NOT NULL", want: "NULL"},
+		{condition: "NULL = NULL", want: "NULL"},
+		{condition: "a = 1", want: "NULL"},
+		{condition: "a <> 1", want: "NULL"},
+		{condition: "a IS NULL", want: "TRUE"},
+		{condition: "x IS NOT NULL", want: "TRUE"},
+		{condition: "a IN (1, 2)", want: "NULL"},
+		{condition: "1 IN (1, NULL)", want: "TRUE"},
+		{condition: "1 IN (2, NULL)", want: "NULL"},
+		{condition: "1 NOT IN (2, NULL)", want: "NULL"},
+		{condition: "a BETWEEN 1 AND 2", want: "NULL"},
+		{condition: "x BETWEEN 1 AND 10", want: "TRUE"},
+		{condition: "a LIKE 'x%'", want: "NULL"},
+		{condition: "name LIKE 'Example%'", want: "TRUE"},
+		{condition: "name ILIKE 'example%'", want: "TRUE"},
+		{condition: "x > 1 OR a > 1", want: "TRUE"},
+		{condition: "x < 1 OR a > 1", want: "NULL"},
+		{condition: "name NOT LIKE '%Corp'", want: "FALSE"},
+		{condition: "name LIKE 'Example_Corp'", want: "TRUE"},
+		{condition: "REGEXP_MATCHES(name, '^Ex.*p$')", want: "TRUE"},
+		{condition: "REGEXP_MATCHES(a, 'x')", want: "NULL"},
+		{condition: "COALESCE(a, 3) = 3", want: "TRUE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			query, err := ParseSQL("SELECT * FROM BB_ASSETS WHERE " + tt.condition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := query.Where.Eval(row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !value.IsNull() && value.Kind != ValueBool {
+				t.Fatalf("%s = %v, want a boolean", tt.condition, value)
+			}
+			got := "NULL"
+			if !value.IsNull() {
+				got = "FALSE"
+				if value.Bool {
+					got = "TRUE"
+				}
+			}
+			if got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.condition, got, tt.want)
+			}
+
+			// WHERE keeps a row only when its condition is TRUE
+			matched, err := EvalCondition(query.Where, row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != (tt.want == "TRUE") {
+				t.Errorf("WHERE %s matched = %v, want %v", tt.condition, matched, tt.want == "TRUE")
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxReportedProblems is the number of problems a store report lists. Every
+// problem is counted, however many there are.
+const maxReportedProblems = 1000
+
+// StoreProblemKind names a kind of inconsistency between the asset files and the index
+type StoreProblemKind string
+
+const (
+	ProblemUnparseableFile StoreProblemKind = "unparseable_file" // An asset file that cannot be read as an asset
+	ProblemMisplacedFile   StoreProblemKind = "misplaced_file"   // An asset file outside the trie path of its ID
+	ProblemMissingAsset    StoreProblemKind = "missing_asset"    // Index entries for an ID without a readable asset file
+	ProblemMissingValue    StoreProblemKind = "missing_value"    // An index entry for a column the asset does not hold
+	ProblemUnindexedAsset  StoreProblemKind = "unindexed_asset"  // An asset file without any index entries
+	ProblemUnindexedValue  StoreProblemKind = "unindexed_value"  // A value of an asset without an index entry
+)
+
+// StoreProblem is one inconsistency found in the store
+type StoreProblem struct {
+	Kind   StoreProblemKind
+	ID     string
+	Column string
+	Path   string
+	Detail string
+}
+
+// String describes a problem on one line
+func (p StoreProblem) String() string {
+	description := string(p.Kind)
+	if p.ID != "" {
+		description += " " + p.ID
+	}
+	if p.Column != "" {
+		description += "." + p.Column
+	}
+	if p.Detail != "" {
+		description += ": " + p.Detail
+	}
+	if p.Path != "" {
+		description += " (" + p.Path + ")"
+	}
+	return description
+}
+
+// StoreReport summarises a check or rebuild of the store
+type StoreReport struct {
+	AssetFiles    int                      // Asset files found in the trie
+	IndexedAssets int                      // Assets with entries in the index
+	IndexEntries  int                      // Entries in the index
+	ProblemCounts map[StoreProblemKind]int // Problems found of each kind
+	Problems      []StoreProblem           // The first maxReportedProblems problems found
+
+	// Entries of a rebuilt index
+	ValuesFromHistory int // Taken from the version in the asset's history holding its value
+	ValuesEstimated   int // Given the newest effective date loaded, for lack of a version
+	ValuesWithSource  int // Whose source file was identified from the load manifest
+
+	Elapsed time.Duration
+}
+
+// newStoreReport creates an empty report
+func newStoreReport() *StoreReport {
+	return &StoreReport{ProblemCounts: make(map[StoreProblemKind]int)}
+}
+
+// add records a problem
+func (r *StoreReport) add(problem StoreProblem) {
+	r.ProblemCounts[problem.Kind]++
+	if len(r.Problems) < maxReportedProblems {
+		r.Problems = append(r.Problems, problem)
+	}
+}
+
+// ProblemCount returns the number of problems found
+func (r *StoreReport) ProblemCount() int {
+	count := 0
+	for _, n := range r.ProblemCounts {
+		count += n
+	}
+	return count
+}
+
+// walkAssetFiles calls visit with the ID and contents of every asset file in the
+// trie. Files that cannot be parsed, or are not at the path of the ID they are
+// named after and hold, are added to the report instead.
+func (j *JSONAssetManager) walkAssetFiles(report *StoreReport, visit func(id string, asset map[string]string) error) error {
+	return filepath.Walk(j.jsonDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// History files and files left by an interrupted write are not assets
+		if info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		report.AssetFiles++
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			report.add(StoreProblem{Kind: ProblemUnparseableFile, ID: id, Path: path, Detail: err.Error()})
+			return nil
+		}
+		asset := make(map[string]string)
+		if err := json.Unmarshal(data, &asset); err != nil {
+			report.add(StoreProblem{Kind: ProblemUnparseableFile, ID: id, Path: path, Detail: err.Error()})
+			return nil
+		}
+
+		if expected := j.assetFilePath(id); path != expected {
+			report.add(StoreProblem{Kind: ProblemMisplacedFile, ID: id, Path: path, Detail: "expected at " + expected})
+			return nil
+		}
+		if stored, exists := j.storedAssetID(asset); exists && stored != id {
+			report.add(StoreProblem{Kind: ProblemMisplacedFile, ID: id, Path: path, Detail: "holds asset " + stored})
+			return nil
+		}
+		return visit(id, asset)
+	})
+}
+
+// sortedColumns returns the columns of an asset in name order
+func sortedColumns(asset map[string]string) []string {
+	columns := make([]string, 0, len(asset))
+	for column := range asset {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// VerifyStore checks that the asset files in the trie and the effective date
+// index agree: every file parses and sits at the path of its ID, every asset has
+// an entry for each of its values, and every entry belongs to a value.
+func (j *JSONAssetManager) VerifyStore() (*StoreReport, error) {
+	start := time.Now()
+	report := newStoreReport()
+
+	// Compare the files as they are meant to be, with every change written
+	if err := j.FlushAssets(); err != nil {
+		return nil, fmt.Errorf("error writing cached assets: %v", err)
+	}
+
+	assets := make(map[string]bool)
+	err := j.walkAssetFiles(report, func(id string, asset map[string]string) error {
+		assets[id] = true
+		lineage := j.index.AssetLineage(id)
+		if len(lineage) == 0 {
+			report.add(StoreProblem{Kind: ProblemUnindexedAsset, ID: id, Path: j.assetFilePath(id)})
+			return nil
+		}
+		for _, column := range sortedColumns(asset) {
+			// The key is stored in every asset, even one only ever saved directly
+			if _, exists := lineage[column]; !exists && !j.isKeyColumn(column) {
+				report.add(StoreProblem{Kind: ProblemUnindexedValue, ID: id, Column: column})
+			}
+		}
+		columns := make([]string, 0, len(lineage))
+		for column := range lineage {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		for _, column := range columns {
+			if _, exists := asset[column]; !exists {
+				report.add(StoreProblem{Kind: ProblemMissingValue, ID: id, Column: column,
+					Detail: "indexed with effective date " + lineage[column].EffectiveDate})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning JSON files: %v", err)
+	}
+
+	j.index.ForEachID(func(id string) {
+		report.IndexedAssets++
+		if !assets[id] {
+			report.add(StoreProblem{Kind: ProblemMissingAsset, ID: id, Path: j.assetFilePath(id)})
+		}
+	})
+	report.IndexEntries, _, _ = j.index.Stats()
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
+
+// ReindexStore rebuilds the effective date index from the asset files, their
+// history and the load manifest, and replaces the index file with it. The old
+// file is kept with a .bak suffix.
+//
+// Each value is given the effective date and row of the newest version in the
+// asset's history holding that value. Its source file is identified when the
+// manifest has exactly one file with that effective date. A value without such a
+// version, as in assets stored before history was kept, is given the newest
+// effective date of any file in the manifest, so no file already loaded can
+// overwrite it. Files reported as problems are left out of the index.
+func (j *JSONAssetManager) ReindexStore() (*StoreReport, error) {
+	start := time.Now()
+	report := newStoreReport()
+
+	if err := j.FlushAssets(); err != nil {
+		return nil, fmt.Errorf("error writing cached assets: %v", err)
+	}
+
+	// Group the loaded files by effective date to find the file of each version
+	loadsByDate := make(map[string][]FileLoad)
+	newest := ""
+	for _, load := range j.manifest.Files() {
+		loadsByDate[load.EffectiveDate] = append(loadsByDate[load.EffectiveDate], load)
+		if load.EffectiveDate > newest {
+			newest = load.EffectiveDate
+		}
+	}
+	if newest == "" {
+		newest = time.Now().Format("20060102")
+	}
+
+	index := NewEffectiveDateIndex()
+	sources := make(map[string]uint32)
+	sourceOf := func(date string) uint32 {
+		number, exists := sources[date]
+		if !exists {
+			number = unknownSource
+			if loads := loadsByDate[date]; len(loads) == 1 {
+				number = index.RegisterSource(loadSource(loads[0].Path, loads[0].LoadRun))
+			}
+			sources[date] = number
+		}
+		return number
+	}
+
+	err := j.walkAssetFiles(report, func(id string, asset map[string]string) error {
+		records, err := j.readHistory(id)
+		if err != nil {
+			j.logger.Warn("%v", err)
+		}
+
+		// The newest version of each column that holds its current value
+		versions := make(map[string]historyRecord)
+		for _, record := range records {
+			if asset[record.Column] != record.Value {
+				continue
+			}
+			if _, err := parseIndexDate(record.Date); err != nil {
+				continue
+			}
+			if version, exists := versions[record.Column]; !exists || record.Date > version.Date {
+				versions[record.Column] = record
+			}
+		}
+
+		for _, column := range sortedColumns(asset) {
+			j.addColumnIfNotExists(column)
+			date, source, row := newest, uint32(unknownSource), 0
+			if version, exists := versions[column]; exists {
+				date = version.Date
+				if version.Source != unknownSource {
+					source = sourceOf(version.Date)
+				}
+				if source != unknownSource {
+					row = version.Row
+					report.ValuesWithSource++
+				}
+				report.ValuesFromHistory++
+			} else {
+				report.ValuesEstimated++
+			}
+			if _, err := index.Update(id, column, date, source, row); err != nil {
+				return err
+			}
+		}
+		report.IndexedAssets++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning JSON files: %v", err)
+	}
+	report.IndexEntries, _, _ = index.Stats()
+
+	// Keep the old index in case it is still needed
+	if _, err := os.Stat(j.indexFilePath); err == nil {
+		if err := os.Rename(j.indexFilePath, j.indexFilePath+".bak"); err != nil {
+			return nil, fmt.Errorf("error keeping old index file: %v", err)
+		}
+	}
+	if err := index.Save(j.indexFilePath, j.getSyncPolicy() != SyncNone); err != nil {
+		return nil, err
+	}
+	j.index = index
+	j.wal.SetIndex(index)
+
+	if err := j.saveSchema(); err != nil {
+		return nil, fmt.Errorf("error saving schema file: %v", err)
+	}
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
+
+// runStoreCommand checks or rebuilds every table of the store in the configured
+// data directory instead of starting the server, logs a report for each and
+// returns the exit status: verify fails if it finds any problem.
+func runStoreCommand(command string, config *DataMatrixConfig, logger *Logger) int {
+	if command != "verify" && command != "reindex" {
+		logger.Error("Unknown command %q, expected verify or reindex", command)
+		return 2
+	}
+
+	dataDir := "data"
+	var tableConfigs []TableConfig
+	if config != nil {
+		if config.DataDir != "" {
+			dataDir = config.DataDir
+		}
+		tableConfigs = config.Tables
+	}
+
+	tables, err := NewTableSet(logger, NewProgressTracker(logger), dataDir, tableConfigs)
+	if err != nil {
+		logger.Error("Error opening tables: %v", err)
+		return 1
+	}
+	if config != nil && config.SyncPolicy != "" {
+		policy, err := ParseSyncPolicy(config.SyncPolicy)
+		if err != nil {
+			logger.Error("Error in configuration: %v", err)
+			tables.Close()
+			return 1
+		}
+		for _, manager := range tables.Managers() {
+			manager.SetSyncPolicy(policy)
+		}
+	}
+
+	status := 0
+	for _, manager := range tables.Managers() {
+		if tableStatus := runTableCommand(command, manager, logger); tableStatus > status {
+			status = tableStatus
+		}
+	}
+	if err := tables.Close(); err != nil {
+		logger.Error("Error closing asset store: %v", err)
+		return 1
+	}
+	return status
+}
+
+// runTableCommand checks or rebuilds one table and logs its report
+func runTableCommand(command string, manager *JSONAssetManager, logger *Logger) int {
+	var report *StoreReport
+	var err error
+	switch command {
+	case "verify":
+		logger.Info("Verifying table %s in %s", manager.Table(), filepath.Dir(manager.jsonDir))
+		report, err = manager.VerifyStore()
+	case "reindex":
+		logger.Info("Rebuilding the index of table %s in %s", manager.Table(), filepath.Dir(manager.jsonDir))
+		report, err = manager.ReindexStore()
+	}
+	if err != nil {
+		logger.Error("Error running %s on table %s: %v", command, manager.Table(), err)
+		return 1
+	}
+
+	for _, problem := range report.Problems {
+		logger.Warn("%s", problem)
+	}
+	if hidden := report.ProblemCount() - len(report.Problems); hidden > 0 {
+		logger.Warn("... and %d more problems", hidden)
+	}
+	logger.Info("Asset files: %d, indexed assets: %d, index entries: %d",
+		report.AssetFiles, report.IndexedAssets, report.IndexEntries)
+	if command == "reindex" {
+		logger.Info("Values dated from history: %d (with source file: %d), estimated: %d",
+			report.ValuesFromHistory, report.ValuesWithSource, report.ValuesEstimated)
+	}
+
+	kinds := make([]string, 0, len(report.ProblemCounts))
+	for kind, count := range report.ProblemCounts {
+		kinds = append(kinds, fmt.Sprintf("%s: %d", kind, count))
+	}
+	sort.Strings(kinds)
+	if len(kinds) > 0 {
+		logger.Warn("Problems found: %s", strings.Join(kinds, ", "))
+	}
+
+	if command == "verify" && report.ProblemCount() > 0 {
+		logger.Error("Verify found %d problems in table %s in %v", report.ProblemCount(), manager.Table(), report.Elapsed)
+		return 1
+	}
+	logger.Success("Finished %s of table %s in %v", command, manager.Table(), report.Elapsed)
+	return 0
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadStoreFixture loads two dated files, A1's Price changing between them and
+// B1 only in the first. The files are written to the working directory, as
+// digits in the name of a temporary directory would be taken for their
+// effective date.
+func loadStoreFixture(t *testing.T) *JSONAssetManager {
+	t.Helper()
+	m := newTestManager(t, t.TempDir())
+	t.Chdir(t.TempDir())
+	files := []string{
+		writeCSV(t, ".", "assets_20250101.csv", "ID_BB_GLOBAL,Price,Company\nA1,10,Acme\nB1,1,Beta\n"),
+		writeCSV(t, ".", "assets_20250102.csv", "ID_BB_GLOBAL,Price\nA1,11\n"),
+	}
+	if err := m.LoadFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// writeAssetFile writes the contents of an asset file, bypassing the manager
+func writeAssetFile(t *testing.T, filePath, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		plant    func(t *testing.T, m *JSONAssetManager)
+		wantKind StoreProblemKind
+		wantID   string
+	}{
+		{
+			name: "unparseable file",
+			plant: func(t *testing.T, m *JSONAssetManager) {
+				writeAssetFile(t, m.assetFilePath("C1"), "not json")
+			},
+			wantKind: ProblemUnparseableFile,
+			wantID:   "C1",
+		},
+		{
+			name: "file outside the trie path of its ID",
+			plant: func(t *testing.T, m *JSONAssetManager) {
+				writeAssetFile(t, filepath.Join(m.jsonDir, "D1.json"), `{"ID_BB_GLOBAL":"D1","Price":"4"}`)
+			},
+			wantKind: ProblemMisplacedFile,
+			wantID:   "D1",
+		},
+		{
+			name: "file holding another asset",
+			plant: func(t *testing.T, m *JSONAssetManager) {
+				writeAssetFile(t, m.assetFilePath("E1"), `{"ID_BB_GLOBAL":"B1","Price":"1"}`)
+			},
+			wantKind: ProblemMisplacedFile,
+			wantID:   "E1",
+		},
+		{
+			name: "index entry without an asset file",
+			plant: func(t *testing.T, m *JSONAssetManager) {
+				if _, err := m.index.Update("Z9", "Price", "20250101", unknownSource, 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantKind: ProblemMissingAsset,
+			wantID:   "Z9",
+		},
+		{
+			name: "index entry for a column the asset lacks",
+			plant: func(t *testing.T, m *JSONAssetManager) {
+				if _, err := m.index.Update("B1", "Volume", "20250101", unknownSource, 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantKind: ProblemMissingValue,
+			wantID:   "B1",
+		},
+		{
+			name: "asset file without index entries",
+			plant: func(t *testing.T, m *JSONAssetManager) {
+				writeAssetFile(t, m.assetFilePath("U1"), `{"ID_BB_GLOBAL":"U1","Price":"3"}`)
+			},
+			wantKind: ProblemUnindexedAsset,
+			wantID:   "U1",
+		},
+		{
+			name: "value without an index entry",
+			plant: func(t *testing.T, m *JSONAssetManager) {
+				writeAssetFile(t, m.assetFilePath("B1"), `{"ID_BB_GLOBAL":"B1","Price":"1","Company":"Beta","Extra":"x"}`)
+			},
+			wantKind: ProblemUnindexedValue,
+			wantID:   "B1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := loadStoreFixture(t)
+			if report, err := m.VerifyStore(); err != nil || report.ProblemCount() != 0 {
+				t.Fatalf("VerifyStore of a consistent store = %+v, %v, want no problems", report, err)
+			}
+
+			tt.plant(t, m)
+			report, err := m.VerifyStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.ProblemCount() != 1 || report.ProblemCounts[tt.wantKind] != 1 {
+				t.Fatalf("problems = %v, want one %s", report.Problems, tt.wantKind)
+			}
+			if problem := report.Problems[0]; problem.ID != tt.wantID {
+				t.Errorf("problem = %s, want it to name %s", problem, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestReindexStore(t *testing.T) {
+	m := loadStoreFixture(t)
+	dataDir := filepath.Dir(m.indexFilePath)
+	columns := map[string][]string{"A1": {"Company", "ID_BB_GLOBAL", "Price"}, "B1": {"Company", "ID_BB_GLOBAL", "Price"}}
+	before := make(map[string]CellLineage)
+	for id, names := range columns {
+		for _, column := range names {
+			lineage, exists := m.index.Lineage(id, column)
+			if !exists {
+				t.Fatalf("no index entry for %s.%s", id, column)
+			}
+			before[id+"."+column] = lineage
+		}
+	}
+
+	// B1 has no history, as if it was stored before history was kept
+	if err := os.Remove(m.historyFilePath("B1")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := m.ReindexStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ProblemCount() != 0 || report.IndexedAssets != 2 || report.ValuesFromHistory != 3 || report.ValuesEstimated != 3 {
+		t.Errorf("report = %+v, want 2 assets, 3 values from history and 3 estimated", report)
+	}
+	if _, err := os.Stat(m.indexFilePath + ".bak"); err != nil {
+		t.Errorf("old index was not kept: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The values with history get back their effective date, file and row; the
+	// others the newest effective date loaded
+	reopened := newTestManager(t, dataDir)
+	for key, want := range before {
+		id, column := key[:2], key[3:]
+		got, exists := reopened.index.Lineage(id, column)
+		if id == "B1" {
+			want = CellLineage{EffectiveDate: "20250102"}
+		}
+		if !exists || got.EffectiveDate != want.EffectiveDate || got.File != want.File || got.Row != want.Row {
+			t.Errorf("lineage of %s = %+v, want %+v", key, got, want)
+		}
+	}
+	if report, err := reopened.VerifyStore(); err != nil || report.ProblemCount() != 0 {
+		t.Errorf("VerifyStore after the rebuild = %v, %v, want no problems", report.Problems, err)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultTable is the table of files no configured table matches
+const defaultTable = "BB_ASSETS"
+
+// keySeparator joins the values of a composite key into the ID of an asset
+const keySeparator = "|"
+
+// ErrUnknownTable is returned for a table name no table has
+var ErrUnknownTable = errors.New("unknown table")
+
+// tableNamePattern is what a table name must look like to be used in FROM
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TableConfig defines a table of the store: the files loaded into it, the
+// columns identifying its rows and where its files are kept
+type TableConfig struct {
+	Name        string   `json:"name"`                  // Name used in FROM, case-insensitive
+	KeyColumns  []string `json:"key_columns,omitempty"` // Columns identifying a row; several make a composite key (default: ID_BB_GLOBAL)
+	Directories []string `json:"directories,omitempty"` // Globs of the files, or the directories, loaded into the table
+	Root        string   `json:"root,omitempty"`        // Directory of the table's trie, index and manifest (default: <data_dir>/tables/<name>)
+}
+
+// TableInfo describes a table for GET /api/tables
+type TableInfo struct {
+	Name        string   `json:"name"`
+	KeyColumns  []string `json:"key_columns"`
+	Directories []string `json:"directories,omitempty"` // Globs of the files loaded into the table; BB_ASSETS takes every other file
+	Root        string   `json:"root"`                  // Directory of the table's files
+	Columns     int      `json:"columns"`               // Number of columns
+	Rows        int      `json:"rows"`                  // Number of assets in the effective date index
+}
+
+// storeTable is a table with the files routed to it
+type storeTable struct {
+	config  TableConfig
+	manager *JSONAssetManager
+}
+
+// TableSet holds the tables of the store. Each table is a JSONAssetManager
+// with a trie, index, schema, manifest and write-ahead log of its own; files
+// are loaded into the first table whose directories match them, BB_ASSETS if none does.
+type TableSet struct {
+	tables     []*storeTable // BB_ASSETS first, then the configured tables in order
+	byName     map[string]*storeTable
+	joinMemory int64 // Memory, in bytes, the rows a JOIN holds in its hash tables may use
+}
+
+// Table returns the name of the table the manager's assets are queried as
+func (j *JSONAssetManager) Table() string {
+	return j.table
+}
+
+// KeyColumns returns the columns identifying the manager's assets
+func (j *JSONAssetManager) KeyColumns() []string {
+	return append([]string(nil), j.keyColumns...)
+}
+
+// isKeyColumn reports whether a column is one of the key columns
+func (j *JSONAssetManager) isKeyColumn(column string) bool {
+	return containsString(j.keyColumns, column)
+}
+
+// keyIndexes returns the position of each key column in a header, or the key
+// columns the header lacks
+func (j *JSONAssetManager) keyIndexes(header []string) ([]int, []string) {
+	indexes := make([]int, len(j.keyColumns))
+	var missing []string
+	for k, key := range j.keyColumns {
+		indexes[k] = -1
+		for i, col := range header {
+			if col == key {
+				indexes[k] = i
+				break
+			}
+		}
+		if indexes[k] == -1 {
+			missing = append(missing, key)
+		}
+	}
+	return indexes, missing
+}
+
+// recordID returns the ID of the asset a record belongs to, the values of its
+// key columns joined by keySeparator. It is empty if a key value is missing, and
+// an error if the values do not make an ID checkAssetID accepts.
+func (j *JSONAssetManager) recordID(record []string, keyIndexes []int) (string, error) {
+	values := make([]string, len(keyIndexes))
+	for k, i := range keyIndexes {
+		if i >= len(record) || record[i] == "" {
+			return "", nil
+		}
+		if len(keyIndexes) > 1 && strings.Contains(record[i], keySeparator) {
+			return "", fmt.Errorf("key value %q contains %q", record[i], keySeparator)
+		}
+		values[k] = record[i]
+	}
+	id := strings.Join(values, keySeparator)
+	if err := j.checkAssetID(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// checkAssetID returns why an ID cannot name an asset of the table, or nil if
+// it can. The ID must be a valid file name, and with a composite key hold one
+// value for each key column. Every ID is checked the same way whether an asset
+// is written, read or replayed from the write-ahead log.
+func (j *JSONAssetManager) checkAssetID(id string) error {
+	if !isValidAssetID(id) {
+		return fmt.Errorf("invalid ID %q: IDs cannot contain path separators or ..", id)
+	}
+	if len(j.keyColumns) > 1 {
+		values := strings.Split(id, keySeparator)
+		if len(values) != len(j.keyColumns) || containsString(values, "") {
+			return fmt.Errorf("invalid ID %q: expected a value for each of %s joined by %q",
+				id, strings.Join(j.keyColumns, ", "), keySeparator)
+		}
+	}
+	return nil
+}
+
+// assetID returns the ID of an asset from its key columns
+func (j *JSONAssetManager) assetID(asset map[string]string) string {
+	id, _ := j.storedAssetID(asset)
+	return id
+}
+
+// storedAssetID returns the ID of an asset from its key columns and whether it
+// has a value for every one of them
+func (j *JSONAssetManager) storedAssetID(asset map[string]string) (string, bool) {
+	values := make([]string, len(j.keyColumns))
+	complete := true
+	for k, key := range j.keyColumns {
+		values[k] = asset[key]
+		complete = complete && values[k] != ""
+	}
+	return strings.Join(values, keySeparator), complete
+}
+
+// setKeyValues stores the key values an ID is made of in an asset
+func (j *JSONAssetManager) setKeyValues(id string, asset map[string]string) {
+	if len(j.keyColumns) == 1 {
+		asset[j.keyColumns[0]] = id
+		return
+	}
+	values := strings.SplitN(id, keySeparator, len(j.keyColumns))
+	for k, value := range values {
+		asset[j.keyColumns[k]] = value
+	}
+}
+
+// normalizeTableConfig checks a table definition and fills in its defaults
+func normalizeTableConfig(config TableConfig, dataDir string) (TableConfig, error) {
+	if !tableNamePattern.MatchString(config.Name) {
+		return config, fmt.Errorf("invalid table name %q", config.Name)
+	}
+	config.Name = strings.ToUpper(config.Name)
+	if config.Name == changesTable {
+		return config, fmt.Errorf("%s is a table function and cannot be a table name", changesTable)
+	}
+
+	if len(config.KeyColumns) == 0 {
+		config.KeyColumns = []string{defaultKeyColumn}
+	}
+	seen := make(map[string]bool)
+	for _, key := range config.KeyColumns {
+		if key == "" || seen[key] {
+			return config, fmt.Errorf("table %s: key columns must be distinct and not empty", config.Name)
+		}
+		seen[key] = true
+	}
+
+	for _, glob := range config.Directories {
+		if _, err := path.Match(filepath.ToSlash(glob), ""); err != nil || glob == "" {
+			return config, fmt.Errorf("table %s: invalid directory glob %q", config.Name, glob)
+		}
+	}
+	if config.Name == defaultTable && len(config.Directories) > 0 {
+		return config, fmt.Errorf("%s takes every file no other table matches and cannot have directories", defaultTable)
+	}
+	if config.Name != defaultTable && len(config.Directories) == 0 {
+		return config, fmt.Errorf("table %s needs directories to load files from", config.Name)
+	}
+
+	if config.Root == "" {
+		config.Root = dataDir
+		if config.Name != defaultTable {
+			config.Root = filepath.Join(dataDir, "tables", strings.ToLower(config.Name))
+		}
+	}
+	config.Root = filepath.Clean(config.Root)
+	return config, nil
+}
+
+// NewTableSet opens the BB_ASSETS table, whose files are kept in dataDir, and
+// every configured table. A configuration for BB_ASSETS sets its key columns
+// and root.
+func NewTableSet(logger *Logger, progress *ProgressTracker, dataDir string, configs []TableConfig) (*TableSet, error) {
+	defaults := TableConfig{Name: defaultTable}
+	var others []TableConfig
+	configured := false
+	for _, config := range configs {
+		if strings.EqualFold(config.Name, defaultTable) {
+			if configured {
+				return nil, fmt.Errorf("table %s is defined twice", defaultTable)
+			}
+			defaults, configured = config, true
+		} else {
+			others = append(others, config)
+		}
+	}
+
+	set := &TableSet{byName: make(map[string]*storeTable), joinMemory: defaultJoinMemory}
+	roots := make(map[string]string)
+	for _, config := range append([]TableConfig{defaults}, others...) {
+		config, err := normalizeTableConfig(config, dataDir)
+		if err != nil {
+			set.Close()
+			return nil, err
+		}
+		if _, exists := set.byName[config.Name]; exists {
+			set.Close()
+			return nil, fmt.Errorf("table %s is defined twice", config.Name)
+		}
+		if other, exists := roots[config.Root]; exists {
+			set.Close()
+			return nil, fmt.Errorf("tables %s and %s have the same root %s", other, config.Name, config.Root)
+		}
+		roots[config.Root] = config.Name
+
+		manager, err := NewTableAssetManager(logger, progress, config.Root, config.Name, config.KeyColumns)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("error opening table %s: %v", config.Name, err)
+		}
+		if config.Name != defaultTable {
+			logger.Info("Opened table %s keyed by %s in %s", config.Name, strings.Join(config.KeyColumns, ", "), config.Root)
+		}
+		table := &storeTable{config: config, manager: manager}
+		set.tables = append(set.tables, table)
+		set.byName[config.Name] = table
+	}
+	return set, nil
+}
+
+// SetJoinMemory sets the memory, in bytes, the rows a JOIN holds in its hash
+// tables may use. A JOIN needing more fails rather than exhausting memory.
+func (s *TableSet) SetJoinMemory(limit int64) {
+	s.joinMemory = limit
+}
+
+// Default returns the BB_ASSETS table
+func (s *TableSet) Default() *JSONAssetManager {
+	return s.tables[0].manager
+}
+
+// Table returns a table by its case-insensitive name, BB_ASSETS for an empty name
+func (s *TableSet) Table(name string) (*JSONAssetManager, error) {
+	if name == "" {
+		return s.Default(), nil
+	}
+	table, exists := s.byName[strings.ToUpper(name)]
+	if !exists {
+		return nil, queryErrorf("%v: %s", ErrUnknownTable, name)
+	}
+	return table.manager, nil
+}
+
+// Managers returns every table, BB_ASSETS first
+func (s *TableSet) Managers() []*JSONAssetManager {
+	managers := make([]*JSONAssetManager, len(s.tables))
+	for i, table := range s.tables {
+		managers[i] = table.manager
+	}
+	return managers
+}
+
+// tableForFile returns the first configured table with a directory glob
+// matching a file, BB_ASSETS if there is none
+func (s *TableSet) tableForFile(filePath string) *storeTable {
+	for _, table := range s.tables[1:] {
+		for _, glob := range table.config.Directories {
+			if matchesFile(glob, filePath) {
+				return table
+			}
+		}
+	}
+	return s.tables[0]
+}
+
+// groupFiles splits files by the table they are loaded into, keeping their order
+func (s *TableSet) groupFiles(filePaths []string) map[*storeTable][]string {
+	groups := make(map[*storeTable][]string)
+	for _, filePath := range filePaths {
+		table := s.tableForFile(filePath)
+		groups[table] = append(groups[table], filePath)
+	}
+	return groups
+}
+
+// LoadFiles loads each file into its table, one load run per table. BB_ASSETS
+// always runs, so a start without files is still recorded. Every table is
+// loaded even if files of another failed; the error names the first table.
+func (s *TableSet) LoadFiles(filePaths []string) error {
+	groups := s.groupFiles(filePaths)
+	var firstErr error
+	for i, table := range s.tables {
+		files := groups[table]
+		if i > 0 && len(files) == 0 {
+			continue
+		}
+		if err := table.manager.LoadFiles(files); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error loading table %s: %v", table.config.Name, err)
+		}
+	}
+	return firstErr
+}
+
+// ReloadFiles processes files loaded by earlier runs again as a new load run of
+// their table. All the files must belong to the same table.
+func (s *TableSet) ReloadFiles(filePaths []string) (LoadRunSummary, error) {
+	groups := s.groupFiles(filePaths)
+	if len(groups) > 1 {
+		return LoadRunSummary{}, errors.New("files of different tables must be reloaded separately")
+	}
+	for table, files := range groups {
+		return table.manager.ReloadFiles(files)
+	}
+	return LoadRunSummary{}, errors.New("no files to reload")
+}
+
+// ExecuteSQLQuery executes a SQL query against the table named in its FROM
+// clause and the tables it joins. CHANGES compares snapshots of BB_ASSETS.
+func (s *TableSet) ExecuteSQLQuery(sqlQuery string, params *QueryParams) (*QueryResult, error) {
+	start := time.Now()
+	query, err := ParseSQLWithParams(sqlQuery, params)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SQL query: %w", err)
+	}
+
+	if len(query.Joins) > 0 {
+		return s.executeJoinQuery(sqlQuery, query, start)
+	}
+	manager := s.Default()
+	if query.FromTable != changesTable {
+		if manager, err = s.Table(query.FromTable); err != nil {
+			return nil, err
+		}
+	}
+	return manager.executeParsedQuery(sqlQuery, query, start)
+}
+
+// Info describes every table, BB_ASSETS first
+func (s *TableSet) Info() []TableInfo {
+	info := make([]TableInfo, len(s.tables))
+	for i, table := range s.tables {
+		info[i] = TableInfo{
+			Name:        table.config.Name,
+			KeyColumns:  table.config.KeyColumns,
+			Directories: table.config.Directories,
+			Root:        table.config.Root,
+			Columns:     len(table.manager.GetColumns()),
+			Rows:        table.manager.index.IDCount(),
+		}
+	}
+	return info
+}
+
+// Close closes every table, returning the first error
+func (s *TableSet) Close() error {
+	var first error
+	for _, table := range s.tables {
+		if err := table.manager.Close(); err != nil && first == nil {
+			first = fmt.Errorf("error closing table %s: %v", table.config.Name, err)
+		}
+	}
+	return first
+}
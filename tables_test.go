@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompositeKeyIDs(t *testing.T) {
+	root := t.TempDir()
+	dataDir := filepath.Join(root, "listings")
+	logger := NewLogger()
+	m, err := NewTableAssetManager(logger, NewProgressTracker(logger), dataDir, "LISTINGS", []string{"Ticker", "Exchange"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := writeCSV(t, t.TempDir(), "listings_20250101.csv", "Ticker,Exchange,Price\n"+
+		"IBM,US,181\n"+
+		"..,US,1\n"+ // Would name a file outside the trie
+		"A|B,US,2\n"+ // Contains the key separator
+		"../../x,US,3\n"+
+		",US,4\n") // Missing a key value
+	run := m.loadFiles([]string{filePath}, func(string) bool { return false })
+	if run.FilesLoaded != 1 {
+		t.Fatalf("run loaded %d files, want 1", run.FilesLoaded)
+	}
+	load, _ := m.manifest.File(filePath)
+	if load.RowsLoaded != 1 || load.RowsSkipped != 4 {
+		t.Errorf("manifest records %d rows loaded and %d skipped, want 1 and 4", load.RowsLoaded, load.RowsSkipped)
+	}
+
+	// Only the valid listing has a file, and nothing was written outside the table
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("loading created %d entries next to the table's directory, want none", len(entries)-1)
+	}
+	asset, err := m.GetAsset("IBM|US")
+	if err != nil || asset["Ticker"] != "IBM" || asset["Exchange"] != "US" || asset["Price"] != "181" {
+		t.Errorf("GetAsset(IBM|US) = %v, %v, want the listing with its key values", asset, err)
+	}
+
+	// Writing and reading reject the same IDs: those that are not file names and
+	// those without one value for each key column
+	header := []string{"Ticker", "Exchange", "Price"}
+	for _, id := range []string{"IBM", "IBM|US|X", "IBM|", "|US", "../IBM|US"} {
+		if _, err := m.UpdateAssetFromCSVWithDate(id, header, []string{"IBM", "US", "1"}, "20250102"); err == nil {
+			t.Errorf("update of %q succeeded", id)
+		}
+		if _, err := m.loadOrCreateAsset(id); err == nil {
+			t.Errorf("loadOrCreateAsset(%q) succeeded", id)
+		}
+		if _, err := m.GetAsset(id); !errors.Is(err, ErrAssetNotFound) {
+			t.Errorf("GetAsset(%q) error = %v, want ErrAssetNotFound", id, err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}